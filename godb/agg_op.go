@@ -1,5 +1,10 @@
 package godb
 
+import (
+	"fmt"
+	"reflect"
+)
+
 type Aggregator struct {
 	// Expressions that when applied to tuples from the child operators,
 	// respectively, return the value of the group by key tuple
@@ -72,6 +77,8 @@ func (a *Aggregator) Iterator(tid TransactionID) (func() (*Tuple, error), error)
 
 	// the map that stores the aggregation state of each group
 	aggState := make(map[any]*[]AggState)
+	// the memory of the groups
+	mem := newMemoryReservation(tid)
 	if a.groupByFields == nil {
 		var newAggState []AggState
 		for _, as := range a.newAggState {
@@ -99,6 +106,9 @@ func (a *Aggregator) Iterator(tid TransactionID) (func() (*Tuple, error), error)
 			if t == nil {
 				return nil, nil
 			}
+			if err := checkCancelled(tid); err != nil {
+				return nil, err
+			}
 
 			if a.groupByFields == nil { // adds tuple to the aggregation in the case of no group-by
 				for i := 0; i < len(a.newAggState); i++ {
@@ -112,6 +122,10 @@ func (a *Aggregator) Iterator(tid TransactionID) (func() (*Tuple, error), error)
 
 				key := keygenTup.tupleKey()
 				if aggState[key] == nil {
+					size := tupleKeyMemorySize(keygenTup) + keygenTup.memorySize() + int64(aggStateSize*len(a.newAggState))
+					if err := mem.grow(size); err != nil {
+						return nil, err
+					}
 					asNew := make([]AggState, len(a.newAggState))
 					aggState[key] = &asNew
 					groupByList = append(groupByList, keygenTup)
@@ -134,7 +148,11 @@ func (a *Aggregator) Iterator(tid TransactionID) (func() (*Tuple, error), error)
 				finalizedIter = getFinalizedTuplesIterator(a, groupByList, aggState)
 			}
 		}
-		return finalizedIter()
+		t, err := finalizedIter()
+		if t == nil {
+			mem.release()
+		}
+		return t, err
 	}, nil
 }
 
@@ -211,3 +229,210 @@ func getFinalizedTuplesIterator(a *Aggregator, groupByList []*Tuple, aggState ma
 		return nil, nil
 	}
 }
+
+// Returns an iterator over the results of the aggregate that reads the child a
+// batch at a time, returning groups in the same order as [Aggregator.Iterator].
+// The rows of each batch are split by group, and each group's rows are added
+// to its aggregation states as vectors. Aggregators with states that cannot
+// be added to a vector at a time fall back to reading tuples.
+func (a *Aggregator) BatchIterator(tid TransactionID) (func() (*Batch, error), error) {
+	for _, as := range a.newAggState {
+		if _, ok := as.(vectorAggState); !ok {
+			iter, err := a.Iterator(tid)
+			if err != nil {
+				return nil, err
+			}
+			return tupleBatches(iter), nil
+		}
+	}
+	childIter, err := batchIterator(a.child, tid)
+	if err != nil {
+		return nil, err
+	}
+
+	// the map that stores the aggregation state of each group, keyed by the
+	// tupleKey of its group key tuple as in Iterator
+	aggState := make(map[any]*[]AggState)
+	var groupByList []*Tuple
+	desc := a.Descriptor()
+	newStates := func() *[]AggState {
+		states := make([]AggState, len(a.newAggState))
+		for i, as := range a.newAggState {
+			states[i] = as.Copy()
+		}
+		return &states
+	}
+	if a.groupByFields == nil {
+		aggState[DefaultGroup] = newStates()
+	}
+	// the groups of single int or string group-by values, which avoids
+	// computing the tupleKey of every row
+	intGroups := make(map[int64]*[]AggState)
+	stringGroups := make(map[string]*[]AggState)
+	// the memory of the groups, as in Iterator, and of their entries in
+	// intGroups and stringGroups
+	mem := newMemoryReservation(tid)
+
+	// Return the aggregation states of the group of row r.
+	findGroup := func(keys []*Vector, b *Batch, r int) (*[]AggState, error) {
+		fields := make([]DBValue, len(keys))
+		for i, k := range keys {
+			fields[i] = k.Value(r)
+		}
+		keyTup := &Tuple{Desc: *desc, Fields: fields, Rid: b.Rids[r]}
+		key := keyTup.tupleKey()
+		if aggState[key] == nil {
+			size := tupleKeyMemorySize(keyTup) + keyTup.memorySize() + int64(aggStateSize*len(a.newAggState))
+			if err := mem.grow(size); err != nil {
+				return nil, err
+			}
+			aggState[key] = newStates()
+			groupByList = append(groupByList, keyTup)
+		}
+		return aggState[key], nil
+	}
+
+	// Add the rows of a batch of child tuples to the aggregation states.
+	addBatch := func(b *Batch) error {
+		values := make([]*Vector, len(a.newAggState))
+		for i, as := range a.newAggState {
+			v, err := evalVector(aggStateExpr(as), b)
+			if err != nil {
+				return err
+			}
+			values[i] = v
+		}
+		if a.groupByFields == nil {
+			for i, as := range *aggState[DefaultGroup] {
+				as.(vectorAggState).addVector(values[i])
+			}
+			return nil
+		}
+
+		keys := make([]*Vector, len(a.groupByFields))
+		for i, expr := range a.groupByFields {
+			k, err := evalVector(expr, b)
+			if err != nil {
+				return err
+			}
+			keys[i] = k
+		}
+		var single *Vector
+		if len(keys) == 1 {
+			single = keys[0]
+		}
+		var groups []*[]AggState
+		groupRows := make(map[*[]AggState][]int)
+		for r := 0; r < b.Len(); r++ {
+			var states *[]AggState
+			var err error
+			switch k := single; {
+			case k != nil && !k.boxed && k.Type == IntType:
+				if states = intGroups[k.Ints[r]]; states == nil {
+					if err := mem.grow(mapEntryOverhead + intSize); err != nil {
+						return err
+					}
+					if states, err = findGroup(keys, b, r); err != nil {
+						return err
+					}
+					intGroups[k.Ints[r]] = states
+				}
+			case k != nil && !k.boxed && k.Type == StringType:
+				if states = stringGroups[k.Strings[r]]; states == nil {
+					if err := mem.grow(int64(mapEntryOverhead + stringSize + len(k.Strings[r]))); err != nil {
+						return err
+					}
+					if states, err = findGroup(keys, b, r); err != nil {
+						return err
+					}
+					stringGroups[k.Strings[r]] = states
+				}
+			default:
+				if states, err = findGroup(keys, b, r); err != nil {
+					return err
+				}
+			}
+			if groupRows[states] == nil {
+				groups = append(groups, states)
+			}
+			groupRows[states] = append(groupRows[states], r)
+		}
+		for _, states := range groups {
+			rows := groupRows[states]
+			for i, as := range *states {
+				v := values[i]
+				if len(rows) < b.Len() {
+					v = v.take(rows)
+				}
+				as.(vectorAggState).addVector(v)
+			}
+		}
+		return nil
+	}
+
+	// the iterator for the finalized aggregation results, in batches
+	var finalizedIter func() (*Batch, error)
+
+	return func() (*Batch, error) {
+		if finalizedIter == nil {
+			for {
+				b, err := childIter()
+				if err != nil {
+					return nil, err
+				}
+				if b == nil {
+					break
+				}
+				if err := addBatch(b); err != nil {
+					return nil, err
+				}
+			}
+			if a.groupByFields == nil {
+				var tup *Tuple
+				for _, as := range *aggState[DefaultGroup] {
+					tup = joinTuples(tup, as.Finalize())
+				}
+				done := false
+				finalizedIter = func() (*Batch, error) {
+					if done || tup == nil {
+						return nil, nil
+					}
+					done = true
+					b := newBatch(&tup.Desc, 1)
+					b.appendTuple(tup)
+					return b, nil
+				}
+			} else {
+				finalizedIter = tupleBatches(getFinalizedTuplesIterator(a, groupByList, aggState))
+			}
+		}
+		b, err := finalizedIter()
+		if b == nil {
+			mem.release()
+		}
+		return b, err
+	}, nil
+}
+
+func (a *Aggregator) Explain() (string, string) {
+	aggStr := ""
+	for _, ex := range a.newAggState {
+		if named, ok := ex.(*namedAggState); ok {
+			aggStr += fmt.Sprintf("%s(%s),", named.name, ex.GetTupleDesc().HeaderString(false))
+			continue
+		}
+		aggStr += fmt.Sprintf("%s(%s),", reflect.TypeOf(ex), ex.GetTupleDesc().HeaderString(false))
+	}
+	if len(a.groupByFields) == 0 {
+		return "Aggregate", aggStr
+	}
+	gbyStr := ""
+	for _, ex := range a.groupByFields {
+		gbyStr += exprToStr(ex) + ","
+	}
+	return "Aggregate", aggStr + " Group By " + gbyStr
+}
+
+func (a *Aggregator) Children() []Operator {
+	return []Operator{a.child}
+}
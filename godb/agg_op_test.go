@@ -1,6 +1,7 @@
 package godb
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -373,3 +374,24 @@ func TestAggRepeatedIteration(t *testing.T) {
 		t.Errorf("count changed on repeated iteration")
 	}
 }
+
+// Aggregates skip NULLs, both a tuple at a time and vectorized; count(*)
+// counts every row.
+func TestAggSkipsNulls(t *testing.T) {
+	s := makeBatchTestSession(t, 100)
+	for _, c := range []struct{ query, want string }{
+		{"select count(*) as n, count(nullif(dept, 3)) as c, sum(nullif(dept, 3)) as s, min(nullif(dept, 0)) as lo, max(nullif(dept, 9)) as hi, avg(nullif(dept, 3)) as a from emp",
+			"100,90,420,1,8,4"},
+		{"select dept, count(*) as n, count(nullif(dept, 3)) as c, sum(nullif(dept, 3)) as s, min(nullif(dept, 3)) as lo, avg(nullif(dept, 3)) as a from emp where dept < 5 group by dept order by dept",
+			"0,10,10,0,0,0;1,10,10,10,1,1;2,10,10,20,2,2;3,10,0,,,;4,10,10,40,4,4"},
+		{"select count(n) as c, sum(n) as s, max(n) as hi from (select nullif(salary, salary) as n from emp) as x",
+			"0,,"},
+	} {
+		for _, vectorized := range []bool{false, true} {
+			got := strings.Join(rowStrings(runVectorized(t, s, c.query, vectorized)), ";")
+			if got != c.want {
+				t.Errorf("%s (vectorized %t): expected %s, got %s", c.query, vectorized, c.want, got)
+			}
+		}
+	}
+}
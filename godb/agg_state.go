@@ -20,6 +20,61 @@ type AggState interface {
 	GetTupleDesc() *TupleDesc
 }
 
+// The aggregates that can be used in queries, by name, each with a function
+// returning a new aggregation state.  See also [RegisterAggregate].
+var aggregates = map[string]func() AggState{
+	"count": func() AggState { return &CountAggState{} },
+	"sum":   func() AggState { return &SumAggState{} },
+	"avg":   func() AggState { return &AvgAggState{} },
+	"min":   func() AggState { return &MinAggState{} },
+	"max":   func() AggState { return &MaxAggState{} },
+}
+
+// An aggregation state that the vectorized aggregator can add the values of
+// its expression to directly, rather than a tuple at a time.
+type vectorAggState interface {
+	AggState
+
+	// Adds the value of the expression for one tuple.
+	addValue(v DBValue)
+
+	// Adds the values of the expression for a batch of tuples.
+	addVector(v *Vector)
+}
+
+// Add each value of v to the aggregation state.
+func addValues(a vectorAggState, v *Vector) {
+	for i := 0; i < v.Len(); i++ {
+		a.addValue(v.Value(i))
+	}
+}
+
+// Return the number of values of v that are not NULL.
+func countValues(v *Vector) int {
+	if !v.boxed {
+		return v.Len()
+	}
+	n := 0
+	for _, x := range v.Values {
+		if x != nil {
+			n++
+		}
+	}
+	return n
+}
+
+// Return the sum of an unboxed int vector, and whether v is one.
+func sumInts(v *Vector) (int64, bool) {
+	if v.boxed || v.Type != IntType {
+		return 0, false
+	}
+	var sum int64
+	for _, x := range v.Ints {
+		sum += x
+	}
+	return sum, true
+}
+
 // Implements the aggregation state for COUNT
 // We are supplying the implementation of CountAggState as an example. You need to
 // implement the rest of the aggregation states.
@@ -41,7 +96,19 @@ func (a *CountAggState) Init(alias string, expr Expr) error {
 }
 
 func (a *CountAggState) AddTuple(t *Tuple) {
-	a.count++
+	dbVal, _ := a.expr.EvalExpr(t)
+	a.addValue(dbVal)
+}
+
+// NULLs are not counted; count(*) counts a constant, see the parser.
+func (a *CountAggState) addValue(v DBValue) {
+	if v != nil {
+		a.count++
+	}
+}
+
+func (a *CountAggState) addVector(v *Vector) {
+	a.count += countValues(v)
 }
 
 func (a *CountAggState) Finalize() *Tuple {
@@ -102,6 +169,21 @@ func (a *SumAggState) Init(alias string, expr Expr) error {
 func (a *SumAggState) AddTuple(t *Tuple) {
 	// TODO: some code goes here
 	dbVal, _ := a.expr.EvalExpr(t)
+	a.addValue(dbVal)
+}
+
+func (a *SumAggState) addVector(v *Vector) {
+	if sum, ok := sumInts(v); ok && v.Len() > 0 {
+		a.addValue(IntField{sum})
+		return
+	}
+	addValues(a, v)
+}
+
+func (a *SumAggState) addValue(dbVal DBValue) {
+	if dbVal == nil {
+		return
+	}
 	intValue := intAggGetter(dbVal)
 	if intValue != nil {
 		if a.sum == nil {
@@ -129,17 +211,18 @@ func (a *SumAggState) Finalize() *Tuple {
 	// TODO: some code goes here
 	td := a.GetTupleDesc()
 	var f DBValue
-	if intValue, ok := a.sum.(int64); ok {
-		f = IntField{intValue}
-	} else {
-		f = StringField{a.sum.(string)}
+	switch sum := a.sum.(type) {
+	case int64:
+		f = IntField{sum}
+	case string:
+		f = StringField{sum}
 	}
+	// the sum of no values, or only NULLs, is NULL
 	return &Tuple{*td, []DBValue{f}, nil}
 }
 
 // Implements the aggregation state for AVG
-// Note that we always AddTuple() at least once before Finalize()
-// so no worries for divide-by-zero
+// NULLs are skipped, so a group whose values are all NULL averages to NULL
 type AvgAggState struct {
 	// TODO: some code goes here
 	alias     string
@@ -165,6 +248,19 @@ func (a *AvgAggState) Init(alias string, expr Expr) error {
 func (a *AvgAggState) AddTuple(t *Tuple) {
 	// TODO: some code goes here
 	dbVal, _ := a.expr.EvalExpr(t)
+	a.addValue(dbVal)
+}
+
+func (a *AvgAggState) addVector(v *Vector) {
+	if sum, ok := sumInts(v); ok {
+		a.sumOfVals += sum
+		a.numVals += int64(v.Len())
+		return
+	}
+	addValues(a, v)
+}
+
+func (a *AvgAggState) addValue(dbVal DBValue) {
 	if intAggGetter(dbVal) != nil {
 		a.sumOfVals += intAggGetter(dbVal).(int64)
 		a.numVals += 1
@@ -183,6 +279,10 @@ func (a *AvgAggState) GetTupleDesc() *TupleDesc {
 func (a *AvgAggState) Finalize() *Tuple {
 	// TODO: some code goes here
 	td := a.GetTupleDesc()
+	if a.numVals == 0 {
+		// every value was NULL
+		return &Tuple{*td, []DBValue{nil}, nil}
+	}
 	avg := IntField{a.sumOfVals / a.numVals}
 	return &Tuple{*td, []DBValue{avg}, nil}
 }
@@ -212,6 +312,17 @@ func (a *MaxAggState) Init(alias string, expr Expr) error {
 func (a *MaxAggState) AddTuple(t *Tuple) {
 	// TODO: some code goes here
 	dbVal, _ := a.expr.EvalExpr(t)
+	a.addValue(dbVal)
+}
+
+func (a *MaxAggState) addVector(v *Vector) {
+	addValues(a, v)
+}
+
+func (a *MaxAggState) addValue(dbVal DBValue) {
+	if dbVal == nil {
+		return
+	}
 	if a.maxVal == nil {
 		a.maxVal = dbVal
 	} else if dbVal.EvalPred(a.maxVal, OpGt) {
@@ -264,6 +375,17 @@ func (a *MinAggState) Init(alias string, expr Expr) error {
 func (a *MinAggState) AddTuple(t *Tuple) {
 	// TODO: some code goes here
 	dbVal, _ := a.expr.EvalExpr(t)
+	a.addValue(dbVal)
+}
+
+func (a *MinAggState) addVector(v *Vector) {
+	addValues(a, v)
+}
+
+func (a *MinAggState) addValue(dbVal DBValue) {
+	if dbVal == nil {
+		return
+	}
 	if a.minVal == nil {
 		a.minVal = dbVal
 	} else if dbVal.EvalPred(a.minVal, OpLt) {
@@ -0,0 +1,334 @@
+package godb
+
+// ALTER TABLE.  sqlparser accepts ALTER statements but throws away everything
+// after the table name, so they are parsed here instead:
+//
+//	ALTER TABLE name ADD [COLUMN] column type [DEFAULT value]
+//	ALTER TABLE name DROP [COLUMN] column
+//	ALTER TABLE name RENAME [COLUMN] column TO new_column
+//	ALTER TABLE name RENAME TO new_name
+//
+// Adding or dropping a column rewrites the table's heap file with the new
+// schema, in its own transaction.  Existing rows get the column's default
+// value, or NULL if it has none.
+// Renames only change the catalog and the name of the table's file.
+//
+// ALTER TABLE must not run while other transactions are using the table.
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+)
+
+var alterTableRegexp = regexp.MustCompile(`(?is)^\s*alter\s+table\s`)
+
+func parseAlterTable(c *Catalog, query string) (QueryType, error) {
+	toks := newSqlTokens(query)
+	toks.next() // alter
+	toks.next() // table
+	name, err := toks.ident()
+	if err != nil {
+		return UnknownQueryType, err
+	}
+	t, err := c.GetTableInfo(name)
+	if err != nil {
+		return UnknownQueryType, err
+	}
+	if t.view != nil {
+		return UnknownQueryType, GoDBError{IllegalOperationError, fmt.Sprintf("cannot alter materialized view %s", name)}
+	}
+	if toks.peek() != "add" {
+		if err := c.checkNoDependentViews(name, "alter"); err != nil {
+			return UnknownQueryType, err
+		}
+	}
+	if err := alterTable(c, t, toks); err != nil {
+		return UnknownQueryType, err
+	}
+	return AlterTableQueryType, nil
+}
+
+// Parse and apply the action of an ALTER TABLE statement on table t.
+func alterTable(c *Catalog, t *Table, toks *sqlTokens) error {
+	switch toks.next() {
+	case "add":
+		toks.accept("column")
+		col, err := toks.ident()
+		if err != nil {
+			return err
+		}
+		typ, err := toks.columnType()
+		if err != nil {
+			return err
+		}
+		var def DBValue
+		if toks.accept("default") {
+			if def, err = toks.constant(); err != nil {
+				return err
+			}
+			if def, err = coerceParam(&paramValue{typ: typ}, def); err != nil {
+				return GoDBError{TypeMismatchError, fmt.Sprintf("default value does not match type of column %s", col)}
+			}
+		}
+		if !toks.done() {
+			return toks.errorf("unexpected token")
+		}
+		return c.addColumn(t, FieldType{col, "", typ}, def)
+	case "drop":
+		toks.accept("column")
+		col, err := toks.ident()
+		if err != nil {
+			return err
+		}
+		if !toks.done() {
+			return toks.errorf("unexpected token")
+		}
+		return c.dropColumn(t, col)
+	case "rename":
+		if toks.accept("to") {
+			newName, err := toks.ident()
+			if err != nil {
+				return err
+			}
+			if !toks.done() {
+				return toks.errorf("unexpected token")
+			}
+			return c.renameTable(t, newName)
+		}
+		toks.accept("column")
+		col, err := toks.ident()
+		if err != nil {
+			return err
+		}
+		if err := toks.expect("to"); err != nil {
+			return err
+		}
+		newCol, err := toks.ident()
+		if err != nil {
+			return err
+		}
+		if !toks.done() {
+			return toks.errorf("unexpected token")
+		}
+		return c.renameColumn(t, col, newCol)
+	}
+	return toks.errorf("expected ADD, DROP, or RENAME")
+}
+
+// Return the index of the column named col in t, or -1.
+func (t *Table) columnIndex(col string) int {
+	for i, f := range t.desc.Fields {
+		if f.Fname == col {
+			return i
+		}
+	}
+	return -1
+}
+
+func (c *Catalog) addColumn(t *Table, field FieldType, def DBValue) error {
+	if t.columnIndex(field.Fname) >= 0 {
+		return GoDBError{ParseError, fmt.Sprintf("column %s already exists in table %s", field.Fname, t.name)}
+	}
+	desc := t.desc.copy()
+	desc.Fields = append(desc.Fields, field)
+	return c.rewriteTable(t, *desc, func(fields []DBValue) []DBValue {
+		return append(append([]DBValue{}, fields...), def)
+	})
+}
+
+func (c *Catalog) dropColumn(t *Table, col string) error {
+	i := t.columnIndex(col)
+	if i < 0 {
+		return GoDBError{ParseError, fmt.Sprintf("column %s does not exist in table %s", col, t.name)}
+	}
+	if len(t.desc.Fields) == 1 {
+		return GoDBError{IllegalOperationError, fmt.Sprintf("cannot drop the only column of table %s", t.name)}
+	}
+	if con := t.constraintUsing(col); con != nil {
+		return GoDBError{IllegalOperationError, fmt.Sprintf("cannot drop column %s of table %s because constraint %s depends on it", col, t.name, con.name)}
+	}
+	desc := t.desc.copy()
+	desc.Fields = append(desc.Fields[:i], desc.Fields[i+1:]...)
+	err := c.rewriteTable(t, *desc, func(fields []DBValue) []DBValue {
+		return append(append([]DBValue{}, fields[:i]...), fields[i+1:]...)
+	})
+	if err != nil {
+		return err
+	}
+	// the column's NOT NULL constraint goes with it
+	var cons []*constraint
+	for _, con := range t.constraints {
+		if con.kind != notNullConstraint || con.columns[0] != col {
+			cons = append(cons, con)
+		}
+	}
+	t.constraints = cons
+	return nil
+}
+
+func (c *Catalog) renameColumn(t *Table, col string, newCol string) error {
+	i := t.columnIndex(col)
+	if i < 0 {
+		return GoDBError{ParseError, fmt.Sprintf("column %s does not exist in table %s", col, t.name)}
+	}
+	if t.columnIndex(newCol) >= 0 {
+		return GoDBError{ParseError, fmt.Sprintf("column %s already exists in table %s", newCol, t.name)}
+	}
+	desc := t.desc.copy()
+	desc.Fields[i].Fname = newCol
+	cons := make([]*constraint, len(t.constraints))
+	for j, con := range t.constraints {
+		cons[j] = con.renameColumn(col, newCol)
+		if con.kind == checkConstraint {
+			if err := cons[j].compile(desc); err != nil {
+				return err
+			}
+		}
+	}
+	// the file format does not include column names, so the file is just
+	// reopened with the new descriptor
+	if err := c.reopenTable(t, t.name, *desc); err != nil {
+		return err
+	}
+	// update the constraints in place, since foreign keys refer to them
+	for j, con := range t.constraints {
+		*con = *cons[j]
+	}
+	for _, fk := range t.referencedBy {
+		for j, rc := range fk.refColumns {
+			if rc == col {
+				fk.refColumns[j] = newCol
+			}
+		}
+	}
+	t.resetIndexes()
+	return nil
+}
+
+func (c *Catalog) renameTable(t *Table, newName string) error {
+	if c.relationExists(newName) {
+		return GoDBError{DuplicateTableError, fmt.Sprintf("a table named '%s' already exists", newName)}
+	}
+	if err := c.checkNoDependentViews(t.name, "rename"); err != nil {
+		return err
+	}
+	return c.reopenTable(t, newName, t.desc)
+}
+
+// Reopen the file of table t as table name with descriptor desc, moving it
+// if the table is renamed.
+func (c *Catalog) reopenTable(t *Table, name string, desc TupleDesc) error {
+	hf, ok := t.file.(tableFile)
+	if !ok {
+		return GoDBError{IllegalOperationError, fmt.Sprintf("cannot alter table %s", t.name)}
+	}
+	c.bufferPool.discardPages(hf)
+	path := c.tableNameToFile(name)
+	if name != t.name {
+		if err := os.Rename(hf.BackingFile(), path); err != nil {
+			return err
+		}
+	}
+	file, err := c.openTableFile(path, &desc, t.options)
+	if err != nil {
+		return err
+	}
+	c.replaceTable(t, name, desc, file)
+	return nil
+}
+
+// Replace the file of table t with one with descriptor desc, whose rows are
+// the rows of t transformed by convert.
+func (c *Catalog) rewriteTable(t *Table, desc TupleDesc, convert func([]DBValue) []DBValue) error {
+	return c.refillTable(t, desc, func(tid TransactionID) (func() (*Tuple, error), error) {
+		iter, err := t.file.Iterator(tid)
+		if err != nil {
+			return nil, err
+		}
+		return func() (*Tuple, error) {
+			tup, err := iter()
+			if tup == nil || err != nil {
+				return nil, err
+			}
+			return &Tuple{Desc: desc, Fields: convert(tup.Fields)}, nil
+		}, nil
+	})
+}
+
+// Replace the file of table t with one with descriptor desc, holding the rows
+// returned by the iterator rows makes.  The rows are read and written in one
+// transaction, which rows is given.
+func (c *Catalog) refillTable(t *Table, desc TupleDesc, rows func(tid TransactionID) (func() (*Tuple, error), error)) error {
+	hf, ok := t.file.(tableFile)
+	if !ok {
+		return GoDBError{IllegalOperationError, fmt.Sprintf("cannot alter table %s", t.name)}
+	}
+	bp := c.bufferPool
+	path := hf.BackingFile()
+	newPath := path + ".new"
+	os.Remove(newPath)
+	newFile, err := c.openTableFile(newPath, &desc, t.options)
+	if err != nil {
+		return err
+	}
+	abandon := func(err error) error {
+		bp.discardPages(newFile)
+		os.Remove(newPath)
+		return err
+	}
+
+	tid := NewTID()
+	if err := bp.BeginTransaction(tid); err != nil {
+		return abandon(err)
+	}
+	iter, err := rows(tid)
+	if err != nil {
+		bp.AbortTransaction(tid)
+		return abandon(err)
+	}
+	for {
+		tup, err := iter()
+		if err != nil {
+			bp.AbortTransaction(tid)
+			return abandon(err)
+		}
+		if tup == nil {
+			break
+		}
+		if err := newFile.insertTuple(&Tuple{Desc: desc, Fields: tup.Fields}, tid); err != nil {
+			bp.AbortTransaction(tid)
+			return abandon(err)
+		}
+	}
+	if err := bp.CommitTransaction(tid); err != nil {
+		return abandon(err)
+	}
+
+	bp.discardPages(newFile)
+	bp.discardPages(hf)
+	if err := os.Rename(newPath, path); err != nil {
+		return abandon(err)
+	}
+	file, err := c.openTableFile(path, &desc, t.options)
+	if err != nil {
+		return err
+	}
+	c.replaceTable(t, t.name, desc, file)
+	return nil
+}
+
+// Update the catalog entry of table t, which keeps its id and statistics.
+func (c *Catalog) replaceTable(t *Table, name string, desc TupleDesc, file DBFile) {
+	c.removeTable(t.name)
+	t.name = name
+	t.desc = desc
+	t.file = file
+	t.resetIndexes()
+	c.tablesMutex.Lock()
+	defer c.tablesMutex.Unlock()
+	c.tableMap[name] = t
+	for _, f := range desc.Fields {
+		c.columnMap[f.Fname] = append(c.columnMap[f.Fname], t)
+	}
+}
@@ -0,0 +1,103 @@
+package godb
+
+import (
+	"testing"
+)
+
+// Make a session on a scratch catalog with a table t (name text, age int)
+// holding two rows.
+func makeAlterTestSession(t *testing.T) (*Session, string) {
+	t.Helper()
+	bp, err := NewBufferPool(100)
+	if err != nil {
+		t.Fatalf("failed to create buffer pool, %s", err.Error())
+	}
+	dir := t.TempDir()
+	s := NewSession(NewCatalog("catalog.txt", bp, dir), bp)
+	for _, q := range []string{
+		"create table t (name text, age int)",
+		"insert into t values ('sam', 25), ('amy', 40)",
+	} {
+		if _, err := s.Execute(q); err != nil {
+			t.Fatalf("%s failed, %s", q, err.Error())
+		}
+	}
+	return s, dir
+}
+
+func checkAlterQuery(t *testing.T, s *Session, q string, expected ...[]DBValue) {
+	t.Helper()
+	res, err := s.Execute(q)
+	if err != nil {
+		t.Fatalf("%s failed, %s", q, err.Error())
+	}
+	if len(res.Tuples) != len(expected) {
+		t.Fatalf("%s: expected %d rows, got %v", q, len(expected), res.Tuples)
+	}
+	for i, tup := range res.Tuples {
+		for j, f := range tup.Fields {
+			if f != expected[i][j] {
+				t.Errorf("%s: expected row %v, got %v", q, expected[i], tup.Fields)
+			}
+		}
+	}
+}
+
+func TestAlterTableAddDropColumn(t *testing.T) {
+	s, _ := makeAlterTestSession(t)
+	if _, err := s.Execute("alter table t add column city varchar(20) default 'boston'"); err != nil {
+		t.Fatalf("add column failed, %s", err.Error())
+	}
+	checkAlterQuery(t, s, "select name, city from t where age = 40", []DBValue{StringField{"amy"}, StringField{"boston"}})
+	if _, err := s.Execute("insert into t values ('bob', 7, 'nyc')"); err != nil {
+		t.Fatalf("insert failed, %s", err.Error())
+	}
+	checkAlterQuery(t, s, "select city from t where age = 7", []DBValue{StringField{"nyc"}})
+	if _, err := s.Execute("alter table t add column zip int"); err != nil {
+		t.Fatalf("add column failed, %s", err.Error())
+	}
+	checkAlterQuery(t, s, "select zip from t where age = 7", []DBValue{nil})
+
+	if _, err := s.Execute("alter table t drop column age"); err != nil {
+		t.Fatalf("drop column failed, %s", err.Error())
+	}
+	checkAlterQuery(t, s, "select name, city from t where name = 'sam'", []DBValue{StringField{"sam"}, StringField{"boston"}})
+	if _, err := s.Execute("select age from t"); err == nil {
+		t.Errorf("expected an error selecting a dropped column")
+	}
+
+	for _, q := range []string{
+		"alter table t add name int",
+		"alter table t drop column nosuchcolumn",
+		"alter table t add column x int default 'abc'",
+		"alter table nosuchtable drop column name",
+		"alter table t frobnicate",
+	} {
+		if _, err := s.Execute(q); err == nil {
+			t.Errorf("expected %s to fail", q)
+		}
+	}
+}
+
+func TestAlterTableRename(t *testing.T) {
+	s, dir := makeAlterTestSession(t)
+	if _, err := s.Execute("alter table t rename column age to years"); err != nil {
+		t.Fatalf("rename column failed, %s", err.Error())
+	}
+	checkAlterQuery(t, s, "select name from t where years = 25", []DBValue{StringField{"sam"}})
+	if _, err := s.Execute("alter table t rename to people"); err != nil {
+		t.Fatalf("rename table failed, %s", err.Error())
+	}
+	if _, err := s.Execute("select name from t"); err == nil {
+		t.Errorf("expected an error selecting from the old table name")
+	}
+	checkAlterQuery(t, s, "select name from people where years = 40", []DBValue{StringField{"amy"}})
+
+	// the catalog file reflects the changes
+	bp, _ := NewBufferPool(100)
+	c, err := NewCatalogFromFile("catalog.txt", bp, dir)
+	if err != nil {
+		t.Fatalf("failed to reload catalog, %s", err.Error())
+	}
+	checkAlterQuery(t, NewSession(c, bp), "select name, years from people where name = 'sam'", []DBValue{StringField{"sam"}, IntField{25}})
+}
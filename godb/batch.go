@@ -0,0 +1,357 @@
+package godb
+
+import (
+	"cmp"
+)
+
+// The number of rows operators put in each batch they return from a
+// BatchIterator.
+const BatchSize = 1024
+
+// When set, plans whose root operator supports batches are run a batch at a
+// time (see [BatchOperator]), rather than a tuple at a time.
+var EnableVectorizedExecution = true
+
+// A Vector holds the values of one column of a batch. Int and string columns
+// are stored unboxed, in Ints and Strings respectively; a column of any other
+// type, or one holding values not of its type, is stored in Values.
+type Vector struct {
+	Type    DBType
+	Ints    []int64
+	Strings []string
+	Values  []DBValue
+	boxed   bool
+}
+
+func newVector(t DBType, capacity int) *Vector {
+	v := &Vector{Type: t}
+	switch t {
+	case IntType:
+		v.Ints = make([]int64, 0, capacity)
+	case StringType:
+		v.Strings = make([]string, 0, capacity)
+	default:
+		v.Values = make([]DBValue, 0, capacity)
+		v.boxed = true
+	}
+	return v
+}
+
+// Return the number of values in the vector.
+func (v *Vector) Len() int {
+	switch {
+	case v.boxed:
+		return len(v.Values)
+	case v.Type == IntType:
+		return len(v.Ints)
+	default:
+		return len(v.Strings)
+	}
+}
+
+// Return the ith value of the vector.
+func (v *Vector) Value(i int) DBValue {
+	switch {
+	case v.boxed:
+		return v.Values[i]
+	case v.Type == IntType:
+		return IntField{v.Ints[i]}
+	default:
+		return StringField{v.Strings[i]}
+	}
+}
+
+func (v *Vector) append(x DBValue) {
+	if !v.boxed {
+		switch x := x.(type) {
+		case IntField:
+			if v.Type == IntType {
+				v.Ints = append(v.Ints, x.Value)
+				return
+			}
+		case StringField:
+			if v.Type == StringType {
+				v.Strings = append(v.Strings, x.Value)
+				return
+			}
+		}
+		v.box()
+	}
+	v.Values = append(v.Values, x)
+}
+
+// Append the ith value of src to the vector.
+func (v *Vector) appendFrom(src *Vector, i int) {
+	switch {
+	case v.boxed || src.boxed || v.Type != src.Type:
+		v.append(src.Value(i))
+	case v.Type == IntType:
+		v.Ints = append(v.Ints, src.Ints[i])
+	default:
+		v.Strings = append(v.Strings, src.Strings[i])
+	}
+}
+
+// Move the values of the vector to Values, so that it can hold values of
+// any type.
+func (v *Vector) box() {
+	values := make([]DBValue, v.Len(), v.Len()+1)
+	for i := range values {
+		values[i] = v.Value(i)
+	}
+	v.Values, v.Ints, v.Strings = values, nil, nil
+	v.boxed = true
+}
+
+// Return a vector of the values at the supplied positions of v.
+func (v *Vector) take(rows []int) *Vector {
+	out := &Vector{Type: v.Type, boxed: v.boxed}
+	switch {
+	case v.boxed:
+		out.Values = make([]DBValue, len(rows))
+		for i, r := range rows {
+			out.Values[i] = v.Values[r]
+		}
+	case v.Type == IntType:
+		out.Ints = make([]int64, len(rows))
+		for i, r := range rows {
+			out.Ints[i] = v.Ints[r]
+		}
+	default:
+		out.Strings = make([]string, len(rows))
+		for i, r := range rows {
+			out.Strings[i] = v.Strings[r]
+		}
+	}
+	return out
+}
+
+// A Batch holds up to [BatchSize] rows, one [Vector] per field of Desc, along
+// with the record id of each row (nil for rows not read from a table).
+//
+// Batches returned from a BatchIterator are not modified afterwards, so
+// operators may share vectors between their input and output batches.
+type Batch struct {
+	Desc    *TupleDesc
+	Columns []*Vector
+	Rids    []any
+}
+
+func newBatch(desc *TupleDesc, capacity int) *Batch {
+	b := &Batch{Desc: desc, Columns: make([]*Vector, len(desc.Fields)), Rids: make([]any, 0, capacity)}
+	for i, f := range desc.Fields {
+		b.Columns[i] = newVector(f.Ftype, capacity)
+	}
+	return b
+}
+
+// Return the number of rows in the batch.
+func (b *Batch) Len() int {
+	return len(b.Rids)
+}
+
+func (b *Batch) appendTuple(t *Tuple) {
+	b.appendRow(t.Fields, t.Rid)
+}
+
+// Append a row with the supplied fields and record id.
+func (b *Batch) appendRow(fields []DBValue, rid any) {
+	for i, f := range fields {
+		b.Columns[i].append(f)
+	}
+	b.Rids = append(b.Rids, rid)
+}
+
+// Return the ith row of the batch as a tuple.
+func (b *Batch) Tuple(i int) *Tuple {
+	fields := make([]DBValue, len(b.Columns))
+	for j, col := range b.Columns {
+		fields[j] = col.Value(i)
+	}
+	return &Tuple{Desc: *b.Desc, Fields: fields, Rid: b.Rids[i]}
+}
+
+// Return a batch of the rows at the supplied positions of b.
+func (b *Batch) take(rows []int) *Batch {
+	out := &Batch{Desc: b.Desc, Columns: make([]*Vector, len(b.Columns)), Rids: make([]any, len(rows))}
+	for i, col := range b.Columns {
+		out.Columns[i] = col.take(rows)
+	}
+	for i, r := range rows {
+		out.Rids[i] = b.Rids[r]
+	}
+	return out
+}
+
+// A BatchOperator is an operator that can also return its rows a batch at a
+// time. The batch iterator returns nil when there are no more rows, and
+// never returns an empty batch.
+type BatchOperator interface {
+	Operator
+	BatchIterator(tid TransactionID) (func() (*Batch, error), error)
+}
+
+// Return an iterator over the rows of op in batches. Operators that do not
+// implement [BatchOperator] are read a tuple at a time and their tuples
+// gathered into batches.
+func batchIterator(op Operator, tid TransactionID) (func() (*Batch, error), error) {
+	if bop, ok := op.(BatchOperator); ok {
+		return bop.BatchIterator(tid)
+	}
+	iter, err := op.Iterator(tid)
+	if err != nil {
+		return nil, err
+	}
+	return tupleBatches(iter), nil
+}
+
+// Gather the tuples returned by iter into batches. The descriptor of each
+// batch is that of its first tuple.
+func tupleBatches(iter func() (*Tuple, error)) func() (*Batch, error) {
+	return func() (*Batch, error) {
+		var b *Batch
+		for b == nil || b.Len() < BatchSize {
+			t, err := iter()
+			if err != nil {
+				return nil, err
+			}
+			if t == nil {
+				break
+			}
+			if b == nil {
+				b = newBatch(&t.Desc, BatchSize)
+			}
+			b.appendTuple(t)
+		}
+		return b, nil
+	}
+}
+
+// Return an iterator over the rows of the batches returned by iter.
+func batchTuples(iter func() (*Batch, error)) func() (*Tuple, error) {
+	var b *Batch
+	row := 0
+	return func() (*Tuple, error) {
+		for b == nil || row >= b.Len() {
+			next, err := iter()
+			if next == nil || err != nil {
+				return nil, err
+			}
+			b, row = next, 0
+		}
+		row++
+		return b.Tuple(row - 1), nil
+	}
+}
+
+// Return an iterator over the tuples of a plan, running it a batch at a time
+// if vectorized execution is enabled and the root of the plan supports it.
+func planIterator(op Operator, tid TransactionID) (func() (*Tuple, error), error) {
+	if !EnableVectorizedExecution || !isBatchOperator(op) {
+		return op.Iterator(tid)
+	}
+	iter, err := batchIterator(op, tid)
+	if err != nil {
+		return nil, err
+	}
+	return batchTuples(iter), nil
+}
+
+func isBatchOperator(op Operator) bool {
+	if card, ok := op.(*OperatorCard); ok {
+		return isBatchOperator(card.Op)
+	}
+	_, ok := op.(BatchOperator)
+	return ok
+}
+
+// Return the index of the field of desc that a [FieldExpr] for field reads,
+// matching [Tuple.project].
+func projectIndex(field FieldType, desc *TupleDesc) int {
+	index, matched := 0, false
+	for j, f := range desc.Fields {
+		if field.Fname == f.Fname && (field.TableQualifier == f.TableQualifier || !matched) {
+			index, matched = j, true
+		}
+	}
+	return index
+}
+
+// Evaluate e on each row of b. Field references return the column of b they
+// read, and constants are evaluated once; other expressions are evaluated a
+// row at a time.
+func evalVector(e Expr, b *Batch) (*Vector, error) {
+	switch e := e.(type) {
+	case *FieldExpr:
+		if len(b.Columns) > 0 {
+			return b.Columns[projectIndex(e.selectField, b.Desc)], nil
+		}
+	case *ConstExpr:
+		v, err := e.EvalExpr(nil)
+		if err != nil {
+			return nil, err
+		}
+		out := newVector(e.GetExprType().Ftype, b.Len())
+		for i := 0; i < b.Len(); i++ {
+			out.append(v)
+		}
+		return out, nil
+	}
+	out := newVector(e.GetExprType().Ftype, b.Len())
+	for i := 0; i < b.Len(); i++ {
+		v, err := e.EvalExpr(b.Tuple(i))
+		if err != nil {
+			return nil, err
+		}
+		out.append(v)
+	}
+	return out, nil
+}
+
+// Return the positions of the rows for which left op right holds, comparing
+// unboxed int and string vectors without boxing their values.  Rows where
+// either side is NULL never match.
+func selectRows(left *Vector, op BoolOp, right *Vector) []int {
+	n := left.Len()
+	rows := make([]int, 0, n)
+	switch {
+	case left.boxed || right.boxed || left.Type != right.Type || isMatchOp(op):
+		for i := 0; i < n; i++ {
+			if l, r := left.Value(i), right.Value(i); l != nil && r != nil && l.EvalPred(r, op) {
+				rows = append(rows, i)
+			}
+		}
+	case left.Type == IntType:
+		for i := 0; i < n; i++ {
+			if compareOrdered(left.Ints[i], right.Ints[i], op) {
+				rows = append(rows, i)
+			}
+		}
+	default:
+		for i := 0; i < n; i++ {
+			if compareOrdered(left.Strings[i], right.Strings[i], op) {
+				rows = append(rows, i)
+			}
+		}
+	}
+	return rows
+}
+
+func compareOrdered[T cmp.Ordered](x1, x2 T, op BoolOp) bool {
+	switch op {
+	case OpEq:
+		return x1 == x2
+	case OpNeq:
+		return x1 != x2
+	case OpGt:
+		return x1 > x2
+	case OpGe:
+		return x1 >= x2
+	case OpLt:
+		return x1 < x2
+	case OpLe:
+		return x1 <= x2
+	default:
+		return false
+	}
+}
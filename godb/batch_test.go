@@ -0,0 +1,149 @@
+package godb
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Make a session with an emp table of n rows and a dept table of 10 rows.
+func makeBatchTestSession(tb testing.TB, n int) *Session {
+	tb.Helper()
+	bp, err := NewBufferPool(1000)
+	if err != nil {
+		tb.Fatalf("failed to create buffer pool, %s", err.Error())
+	}
+	dir := tb.TempDir()
+	s := NewSession(NewCatalog("catalog.txt", bp, dir), bp)
+	var emp, dept strings.Builder
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&emp, "e%d,%d,%d\n", i%997, i%10, i*7%1000)
+	}
+	for i := 0; i < 10; i++ {
+		fmt.Fprintf(&dept, "%d,d%d\n", i, i)
+	}
+	for _, t := range []struct{ create, name, data string }{
+		{"create table emp (name varchar, dept int, salary int)", "emp", emp.String()},
+		{"create table dept (id int, dname varchar)", "dept", dept.String()},
+	} {
+		if _, err := s.Execute(t.create); err != nil {
+			tb.Fatalf("%s failed, %s", t.create, err.Error())
+		}
+		path := filepath.Join(dir, t.name+".csv")
+		if err := os.WriteFile(path, []byte(t.data), 0644); err != nil {
+			tb.Fatalf("write failed, %s", err.Error())
+		}
+		if _, err := s.Execute("copy " + t.name + " from '" + path + "'"); err != nil {
+			tb.Fatalf("copy failed, %s", err.Error())
+		}
+	}
+	return s
+}
+
+func runVectorized(tb testing.TB, s *Session, q string, vectorized bool) []*Tuple {
+	tb.Helper()
+	defer func(enabled bool) { EnableVectorizedExecution = enabled }(EnableVectorizedExecution)
+	EnableVectorizedExecution = vectorized
+	res, err := s.Execute(q)
+	if err != nil {
+		tb.Fatalf("%s failed, %s", q, err.Error())
+	}
+	return res.Tuples
+}
+
+func TestBatchMatchesTupleExecution(t *testing.T) {
+	s := makeBatchTestSession(t, 5000)
+	for _, q := range []string{
+		"select name, salary from emp where salary > 500",
+		"select name, dept from emp where name = 'e7'",
+		"select dept, sum(salary) as total, count(*) as n, max(name) as top, min(salary) as low, avg(salary) as mean from emp group by dept",
+		"select name, sum(salary) as total from emp group by name",
+		"select sum(salary) as total, count(*) as n from emp where dept = 3",
+		"select emp.name, dept.dname from emp join dept on emp.dept = dept.id where emp.salary < 20",
+		"select distinct dept from emp",
+		"select name, salary + 1 as raised from emp where salary <= 3 order by name",
+	} {
+		want := runVectorized(t, s, q, false)
+		got := runVectorized(t, s, q, true)
+		if len(got) != len(want) {
+			t.Fatalf("%s: expected %d rows, got %d", q, len(want), len(got))
+		}
+		if len(want) == 0 {
+			t.Fatalf("%s: expected some rows", q)
+		}
+		for i := range want {
+			if !got[i].equals(want[i]) || got[i].Rid != want[i].Rid {
+				t.Fatalf("%s: row %d is %v, expected %v", q, i, got[i], want[i])
+			}
+		}
+	}
+}
+
+func TestBatchIteratorBatches(t *testing.T) {
+	const n = 2500
+	s := makeBatchTestSession(t, n)
+	_, plan, err := Parse(s.catalog, "select name, salary from emp where dept < 5")
+	if err != nil {
+		t.Fatalf("parse failed, %s", err.Error())
+	}
+	tid := NewTID()
+	s.bp.BeginTransaction(tid)
+	defer s.bp.CommitTransaction(tid)
+	iter, err := batchIterator(plan, tid)
+	if err != nil {
+		t.Fatalf("batch iterator failed, %s", err.Error())
+	}
+	rows := 0
+	for {
+		b, err := iter()
+		if err != nil {
+			t.Fatalf("batch iterator failed, %s", err.Error())
+		}
+		if b == nil {
+			break
+		}
+		if b.Len() == 0 || b.Len() > BatchSize {
+			t.Fatalf("unexpected batch of %d rows", b.Len())
+		}
+		if len(b.Columns) != 2 || b.Columns[1].Len() != b.Len() {
+			t.Fatalf("unexpected columns %v", b.Columns)
+		}
+		for _, v := range b.Columns[1].Ints {
+			if v < 0 || v >= 1000 {
+				t.Fatalf("unexpected salary %d", v)
+			}
+		}
+		rows += b.Len()
+	}
+	if rows != n/2 {
+		t.Errorf("expected %d rows, got %d", n/2, rows)
+	}
+}
+
+func benchmarkVectorized(b *testing.B, q string) {
+	s := makeBatchTestSession(b, 50000)
+	for _, mode := range []struct {
+		name       string
+		vectorized bool
+	}{{"tuple", false}, {"batch", true}} {
+		b.Run(mode.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				runVectorized(b, s, q, mode.vectorized)
+			}
+		})
+	}
+}
+
+func BenchmarkVectorizedSum(b *testing.B) {
+	benchmarkVectorized(b, "select sum(salary) as total from emp")
+}
+
+func BenchmarkVectorizedGroupBy(b *testing.B) {
+	benchmarkVectorized(b, "select dept, sum(salary) as total, count(*) as n, max(salary) as top from emp group by dept")
+}
+
+func BenchmarkVectorizedFilter(b *testing.B) {
+	benchmarkVectorized(b, "select name from emp where salary > 900")
+}
@@ -31,6 +31,8 @@ type BufferPool struct {
 	sharedPages            map[TransactionID][]any
 	runningTransactions    map[TransactionID]TransactionPhase
 	concurrentAccessRecord map[TransactionID]map[TransactionID]map[any]RWPerm
+	// functions to call when a transaction ends, with whether it committed
+	endHooks map[TransactionID][]func(committed bool)
 }
 
 // Create a new BufferPool with the specified number of pages
@@ -45,14 +47,14 @@ func NewBufferPool(numPages int) (*BufferPool, error) {
 		sharedPages:            make(map[TransactionID][]any),
 		runningTransactions:    make(map[TransactionID]TransactionPhase),
 		concurrentAccessRecord: make(map[TransactionID]map[TransactionID]map[any]RWPerm),
+		endHooks:               make(map[TransactionID][]func(committed bool)),
 	}, nil
 }
 
 func (bp *BufferPool) FlushAllPages() {
 	// TODO: some code goes here
 	for _, page := range bp.pages {
-		dbfile := page.getFile()
-		dbfile.(*HeapFile).flushPage(page)
+		page.getFile().flushPage(page)
 		page.setDirty(0, false)
 	}
 	bp.pages = make(map[any]Page)
@@ -78,7 +80,6 @@ func (bp *BufferPool) ExistAccess(pageKey any, tid TransactionID, dirty bool) bo
 // Abort a transaction and clean up resources
 func (bp *BufferPool) AbortTransaction(tid TransactionID) {
 	bp.mutex.Lock()
-	defer bp.mutex.Unlock()
 	// aborted transaction no longer conflicts with other concurrent transactions
 	for conflictTid, accessMap := range bp.concurrentAccessRecord {
 		if conflictTid == tid {
@@ -96,10 +97,20 @@ func (bp *BufferPool) AbortTransaction(tid TransactionID) {
 	delete(bp.dirtyPages, tid)
 	delete(bp.sharedPages, tid)
 	delete(bp.runningTransactions, tid)
+	hooks := bp.endHooks[tid]
+	delete(bp.endHooks, tid)
+	bp.mutex.Unlock()
+	endTransactionState(tid)
+	for _, f := range hooks {
+		f(false)
+	}
 }
 
 // Commit a transaction with OCC validation
-func (bp *BufferPool) CommitTransaction(tid TransactionID) {
+//
+// Returns an error if validation fails, in which case the transaction has
+// been aborted instead.
+func (bp *BufferPool) CommitTransaction(tid TransactionID) error {
 	bp.mutex.Lock()
 
 	// Validation phase
@@ -114,13 +125,13 @@ func (bp *BufferPool) CommitTransaction(tid TransactionID) {
 			if accessPerm == WritePerm && bp.ExistAccess(pageKey, tid, false) {
 				bp.mutex.Unlock()
 				bp.AbortTransaction(tid)
-				return
+				return GoDBError{DeadlockError, "transaction aborted because of a conflict with a concurrent transaction"}
 			}
 			// W(Ti) ∩ (W(Tj) U R(Tj)) ≠ { }, and Tj overlaps with Ti validation or write phase
 			if accessPerm == WritePerm && bp.ExistAccess(pageKey, tid, true) {
 				bp.mutex.Unlock()
 				bp.AbortTransaction(tid)
-				return
+				return GoDBError{DeadlockError, "transaction aborted because of a conflict with a concurrent transaction"}
 			}
 		}
 	}
@@ -131,8 +142,7 @@ func (bp *BufferPool) CommitTransaction(tid TransactionID) {
 	for pageKey, pageCopy := range bp.transactionPages[tid] {
 		bp.pages[pageKey] = pageCopy
 		if pageCopy.isDirty() {
-			dbfile := pageCopy.getFile()
-			dbfile.(*HeapFile).flushPage(pageCopy)
+			pageCopy.getFile().flushPage(pageCopy)
 			pageCopy.setDirty(0, false)
 		}
 	}
@@ -156,7 +166,44 @@ func (bp *BufferPool) CommitTransaction(tid TransactionID) {
 	delete(bp.dirtyPages, tid)
 	delete(bp.sharedPages, tid)
 	delete(bp.runningTransactions, tid)
+	hooks := bp.endHooks[tid]
+	delete(bp.endHooks, tid)
 	bp.mutex.Unlock()
+	endTransactionState(tid)
+	for _, f := range hooks {
+		f(true)
+	}
+	return nil
+}
+
+// Arrange for f to be called when transaction tid commits or aborts, outside
+// of the buffer pool's lock.  Returns false, without registering f, if tid is
+// not a running transaction.
+func (bp *BufferPool) onTransactionEnd(tid TransactionID, f func(committed bool)) bool {
+	bp.mutex.Lock()
+	defer bp.mutex.Unlock()
+	if _, ok := bp.runningTransactions[tid]; !ok {
+		return false
+	}
+	bp.endHooks[tid] = append(bp.endHooks[tid], f)
+	return true
+}
+
+// Write out any dirty pages of file and remove all of its pages from the
+// buffer pool, e.g., before its backing file is replaced.  Must not be called
+// while transactions that have used the file are running.
+func (bp *BufferPool) discardPages(file DBFile) {
+	bp.mutex.Lock()
+	defer bp.mutex.Unlock()
+	for key, page := range bp.pages {
+		if page.getFile() != file {
+			continue
+		}
+		if page.isDirty() {
+			file.flushPage(page)
+		}
+		delete(bp.pages, key)
+	}
 }
 
 // Begin a new transaction. You do not need to implement this for lab 1.
@@ -173,10 +220,15 @@ func (bp *BufferPool) BeginTransaction(tid TransactionID) error {
 	bp.runningTransactions[tid] = ReadPhase
 	bp.concurrentAccessRecord[tid] = make(map[TransactionID]map[any]RWPerm)
 	bp.transactionPages[tid] = make(map[any]Page)
+	beginTransactionState(tid)
 	return nil
 }
 
 func (bp *BufferPool) GetPage(file DBFile, pageNo int, tid TransactionID, perm RWPerm) (Page, error) {
+	// a cancelled statement stops at the next page it reads
+	if err := checkCancelled(tid); err != nil {
+		return nil, err
+	}
 	bp.mutex.Lock()
 	defer bp.mutex.Unlock()
 
@@ -202,9 +254,9 @@ func (bp *BufferPool) GetPage(file DBFile, pageNo int, tid TransactionID, perm R
 				return nil, fmt.Errorf("could not read page")
 			}
 			if len(bp.pages) == bp.numPages {
-				for _, page := range bp.pages {
+				for key, page := range bp.pages {
 					if !page.isDirty() {
-						delete(bp.pages, page.getFile().pageKey(page.(*heapPage).PageNo))
+						delete(bp.pages, key)
 						break
 					}
 				}
@@ -215,18 +267,27 @@ func (bp *BufferPool) GetPage(file DBFile, pageNo int, tid TransactionID, perm R
 		originalPage := bp.pages[pageKey]
 
 		// Create a new Page and copy the contents of the original page for writes
-		hp := originalPage.(*heapPage)
-		tuplesCopy := make([]*Tuple, len(hp.tuples))
-		copy(tuplesCopy, hp.tuples)
-		newPage := &heapPage{
-			Desc:       hp.Desc,
-			PageNo:     hp.PageNo,
-			HeapF:      hp.HeapF,
-			tuples:     tuplesCopy,
-			IsDirty:    hp.IsDirty,
-			numUsed:    hp.numUsed,
-			numSlots:   hp.numSlots,
-			emptySlots: append([]int{}, hp.emptySlots...),
+		var newPage Page
+		switch p := originalPage.(type) {
+		case *heapPage:
+			tuplesCopy := make([]*Tuple, len(p.tuples))
+			copy(tuplesCopy, p.tuples)
+			newPage = &heapPage{
+				Desc:       p.Desc,
+				PageNo:     p.PageNo,
+				HeapF:      p.HeapF,
+				tuples:     tuplesCopy,
+				IsDirty:    p.IsDirty,
+				numUsed:    p.numUsed,
+				numSlots:   p.numSlots,
+				emptySlots: append([]int{}, p.emptySlots...),
+			}
+		case *columnarHeaderPage:
+			newPage = p.copy()
+		case *columnarPage:
+			newPage = p.copy()
+		default:
+			return nil, fmt.Errorf("cannot copy page %v", pageKey)
 		}
 		if _, exists := bp.transactionPages[tid]; !exists {
 			bp.transactionPages[tid] = make(map[any]Page)
@@ -0,0 +1,311 @@
+package godb
+
+// Bulk loading.  Rather than inserting rows one at a time, which searches the
+// heap file for a free slot and goes through the buffer pool for each row,
+// the bulk loader fills new pages in memory and appends each one to the end
+// of the heap file as soon as it is full.  Existing pages are not changed, so
+// the rows of a load never share a page with older rows.
+//
+// A load runs in its own transaction.  The new pages are not part of the
+// file (NumPages does not count them) until the transaction has committed,
+// and if the load or the commit fails the file is truncated back to its old
+// length, so other transactions see either none of the rows or all of them.
+//
+// Rows that cannot be parsed, or that violate one of the table's
+// constraints when loading with [Catalog.BulkLoad], fail the load with an
+// error giving their line number, or with SkipBadRows, are skipped and
+// reported in the result.
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+type BulkLoadOptions struct {
+	// the first line holds column names and is skipped
+	HasHeader bool
+	// the field separator, "," if empty
+	Separator string
+	// ignore the last field of each line (some TPC datasets include a
+	// trailing separator on each line)
+	SkipLastField bool
+	// skip rows that cannot be loaded instead of failing
+	SkipBadRows bool
+	// with SkipBadRows, the number of bad rows after which the load fails
+	// anyway; 0 means no limit
+	MaxBadRows int
+}
+
+// A row that could not be loaded.
+type BulkLoadError struct {
+	Line int
+	Err  error
+}
+
+func (e BulkLoadError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Err.Error())
+}
+
+func (e BulkLoadError) Unwrap() error {
+	return e.Err
+}
+
+type BulkLoadResult struct {
+	// the number of rows loaded
+	Rows int
+	// the rows skipped, with SkipBadRows
+	Skipped []BulkLoadError
+}
+
+// Load the rows of the delimited text in r into the heap file.  Constraints
+// are not checked; see [Catalog.BulkLoad].
+func (f *HeapFile) BulkLoad(r io.Reader, opts BulkLoadOptions) (*BulkLoadResult, error) {
+	return f.bulkLoadTransaction(newDelimitedReader(r, &f.Desc, opts), opts, nil)
+}
+
+// Load the rows of the delimited text in r into the named table, checking
+// its constraints.  Columnar tables are loaded by inserting the rows one at a
+// time, in one transaction.
+func (c *Catalog) BulkLoad(table string, r io.Reader, opts BulkLoadOptions) (*BulkLoadResult, error) {
+	t, err := c.writableTable(table)
+	if err != nil {
+		return nil, err
+	}
+	rows := newDelimitedReader(r, &t.desc, opts)
+	switch f := t.file.(type) {
+	case *HeapFile:
+		return f.bulkLoadTransaction(rows, opts, t.checkInsert)
+	case *ColumnarFile:
+		return insertRowsTransaction(c.bufferPool, f, rows, opts, t.checkInsert)
+	}
+	return nil, GoDBError{IllegalOperationError, fmt.Sprintf("cannot bulk load table %s", table)}
+}
+
+// A source of rows to load, which returns each row with its line number.  It
+// returns nil at the end of the input, and a BulkLoadError for a row it
+// cannot read, after which it can be called again for the next row.
+type rowReader func() ([]DBValue, int, error)
+
+// Run bulkLoad in a transaction of its own.
+func (f *HeapFile) bulkLoadTransaction(rows rowReader, opts BulkLoadOptions, check func(TransactionID, *Tuple) error) (*BulkLoadResult, error) {
+	bp := f.bufPool
+	tid := NewTID()
+	if err := bp.BeginTransaction(tid); err != nil {
+		return nil, err
+	}
+	// no other inserts may append pages until the new ones are published
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	res, numPages, err := f.bulkLoad(tid, rows, opts, check)
+	if err != nil {
+		bp.AbortTransaction(tid)
+		return nil, err
+	}
+	if err := bp.CommitTransaction(tid); err != nil {
+		os.Truncate(f.backingFile, int64(f.numPages*PageSize))
+		return nil, err
+	}
+	f.numPages = numPages
+	return res, nil
+}
+
+// Append the rows returned by rows to the file, on new pages, in transaction
+// tid, returning the number of pages of the file with them.  The new pages
+// are not part of the file until the caller sets numPages to that number;
+// f.mutex must be held until then.  check, if not nil, is called with each
+// row before it is added.
+func (f *HeapFile) bulkLoad(tid TransactionID, rows rowReader, opts BulkLoadOptions, check func(TransactionID, *Tuple) error) (*BulkLoadResult, int, error) {
+	file, err := os.OpenFile(f.backingFile, os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer file.Close()
+	oldPages := f.numPages
+	fail := func(err error) (*BulkLoadResult, int, error) {
+		file.Truncate(int64(oldPages * PageSize))
+		return nil, 0, err
+	}
+
+	res := &BulkLoadResult{}
+	pageNo := oldPages
+	var page *heapPage
+	write := func() error {
+		buf, err := page.toBuffer()
+		if err != nil {
+			return err
+		}
+		_, err = file.WriteAt(buf.Bytes(), int64(page.PageNo*PageSize))
+		return err
+	}
+	for {
+		fields, err := res.next(tid, rows, &f.Desc, opts, check)
+		if err != nil {
+			return fail(err)
+		}
+		if fields == nil {
+			break
+		}
+		if page == nil {
+			if page, err = newHeapPage(&f.Desc, pageNo, f); err != nil {
+				return fail(err)
+			}
+		}
+		t := &Tuple{Desc: f.Desc, Fields: fields}
+		if err := checkStorable(t); err != nil {
+			return fail(err)
+		}
+		if _, err := page.insertTuple(t); err != nil {
+			return fail(err)
+		}
+		res.Rows++
+		if page.numUsed == page.numSlots {
+			if err := write(); err != nil {
+				return fail(err)
+			}
+			page = nil
+			pageNo++
+		}
+	}
+	if page != nil {
+		if err := write(); err != nil {
+			return fail(err)
+		}
+		pageNo++
+	}
+	if err := file.Sync(); err != nil {
+		return fail(err)
+	}
+	return res, pageNo, nil
+}
+
+// Return the next row of rows that can be loaded, or nil at the end of the
+// input, recording skipped rows in res.  check, if not nil, is called with
+// each row.
+func (res *BulkLoadResult) next(tid TransactionID, rows rowReader, desc *TupleDesc, opts BulkLoadOptions, check func(TransactionID, *Tuple) error) ([]DBValue, error) {
+	for {
+		fields, line, err := rows()
+		if err == nil && fields != nil && check != nil {
+			if err = check(tid, &Tuple{Desc: *desc, Fields: fields}); err != nil {
+				err = BulkLoadError{line, err}
+			}
+		}
+		if err == nil {
+			return fields, nil
+		}
+		bad, ok := err.(BulkLoadError)
+		if !ok || !opts.SkipBadRows {
+			return nil, err
+		}
+		res.Skipped = append(res.Skipped, bad)
+		if opts.MaxBadRows > 0 && len(res.Skipped) >= opts.MaxBadRows {
+			return nil, GoDBError{MalformedDataError, fmt.Sprintf("too many bad rows, the last at %s", bad.Error())}
+		}
+	}
+}
+
+// Insert the rows returned by rows into file one at a time, in a transaction
+// of their own.
+func insertRowsTransaction(bp *BufferPool, file DBFile, rows rowReader, opts BulkLoadOptions, check func(TransactionID, *Tuple) error) (*BulkLoadResult, error) {
+	tid := NewTID()
+	if err := bp.BeginTransaction(tid); err != nil {
+		return nil, err
+	}
+	res := &BulkLoadResult{}
+	for {
+		fields, err := res.next(tid, rows, file.Descriptor(), opts, check)
+		if err == nil && fields != nil {
+			err = file.insertTuple(&Tuple{Desc: *file.Descriptor(), Fields: fields}, tid)
+		}
+		if err != nil {
+			bp.AbortTransaction(tid)
+			return nil, err
+		}
+		if fields == nil {
+			break
+		}
+		res.Rows++
+	}
+	if err := bp.CommitTransaction(tid); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Return a rowReader for the rows of delimited text in r, with columns desc.
+// Single character separators are read as CSV, so quoted fields may hold
+// separators and line breaks.
+func newDelimitedReader(r io.Reader, desc *TupleDesc, opts BulkLoadOptions) rowReader {
+	sep := opts.Separator
+	if sep == "" {
+		sep = ","
+	}
+	if utf8.RuneCountInString(sep) == 1 {
+		comma, _ := utf8.DecodeRuneInString(sep)
+		return newCSVReader(r, desc, comma, opts.HasHeader, opts.SkipLastField)
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	line := 0
+	return func() ([]DBValue, int, error) {
+		for scanner.Scan() {
+			line++
+			if line == 1 && opts.HasHeader {
+				continue
+			}
+			text := scanner.Text()
+			if text == "" {
+				continue
+			}
+			parts := strings.Split(text, sep)
+			if opts.SkipLastField && len(parts) > 0 {
+				parts = parts[:len(parts)-1]
+			}
+			fields, err := textRow(parts, desc)
+			if err != nil {
+				return nil, line, BulkLoadError{line, err}
+			}
+			return fields, line, nil
+		}
+		return nil, line, scanner.Err()
+	}
+}
+
+// Convert the text of each field of a row to the values of a row with
+// columns desc.
+func textRow(parts []string, desc *TupleDesc) ([]DBValue, error) {
+	if len(parts) != len(desc.Fields) {
+		return nil, GoDBError{MalformedDataError, fmt.Sprintf("expected %d fields, got %d", len(desc.Fields), len(parts))}
+	}
+	fields := make([]DBValue, len(parts))
+	for i, part := range parts {
+		switch desc.Fields[i].Ftype {
+		case IntType:
+			part = strings.TrimSpace(part)
+			v, err := strconv.ParseInt(part, 10, 64)
+			if err != nil {
+				// accept values such as 1.0, as LoadFromCSV always has
+				fv, ferr := strconv.ParseFloat(part, 64)
+				if ferr != nil {
+					return nil, GoDBError{TypeMismatchError, fmt.Sprintf("couldn't convert value %s of column %s to int", part, desc.Fields[i].Fname)}
+				}
+				v = int64(fv)
+			}
+			fields[i] = IntField{v}
+		case StringType:
+			fields[i] = StringField{truncateString(part)}
+		}
+	}
+	return fields, nil
+}
+
+func truncateString(s string) string {
+	if len(s) > StringLength {
+		return s[:StringLength]
+	}
+	return s
+}
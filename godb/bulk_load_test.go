@@ -0,0 +1,72 @@
+package godb
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestBulkLoad(t *testing.T) {
+	s, _ := makeConstraintTestSession(t, "create table t (id int, name text)")
+	var csv strings.Builder
+	csv.WriteString("id,name\n")
+	for i := 0; i < 20000; i++ {
+		fmt.Fprintf(&csv, "%d,name%d\n", i, i)
+	}
+	res, err := s.catalog.BulkLoad("t", strings.NewReader(csv.String()), BulkLoadOptions{HasHeader: true})
+	if err != nil {
+		t.Fatalf("bulk load failed, %s", err.Error())
+	}
+	if res.Rows != 20000 {
+		t.Errorf("expected 20000 rows loaded, got %d", res.Rows)
+	}
+	checkAlterQuery(t, s, "select count(*) from t", []DBValue{IntField{20000}})
+	checkAlterQuery(t, s, "select name from t where id = 12345", []DBValue{StringField{"name12345"}})
+
+	// later inserts and loads add to the table
+	if _, err := s.Execute("insert into t values (-1, 'x')"); err != nil {
+		t.Fatalf("insert failed, %s", err.Error())
+	}
+	if _, err := s.catalog.BulkLoad("t", strings.NewReader("-2|y\n"), BulkLoadOptions{Separator: "|"}); err != nil {
+		t.Fatalf("bulk load failed, %s", err.Error())
+	}
+	checkAlterQuery(t, s, "select count(*) from t", []DBValue{IntField{20002}})
+}
+
+func TestBulkLoadBadRows(t *testing.T) {
+	s, _ := makeConstraintTestSession(t, "create table t (id int primary key, name text)")
+	data := "1,a\n2,b\nx,c\n3\n1,d\n4,e\n"
+
+	// by default the first bad row fails the load, which has no effect
+	_, err := s.catalog.BulkLoad("t", strings.NewReader(data), BulkLoadOptions{})
+	var bad BulkLoadError
+	if !errors.As(err, &bad) || bad.Line != 3 {
+		t.Fatalf("expected an error at line 3, got %v", err)
+	}
+	checkAlterQuery(t, s, "select id from t")
+
+	res, err := s.catalog.BulkLoad("t", strings.NewReader(data), BulkLoadOptions{SkipBadRows: true})
+	if err != nil {
+		t.Fatalf("bulk load failed, %s", err.Error())
+	}
+	var lines []int
+	for _, e := range res.Skipped {
+		lines = append(lines, e.Line)
+	}
+	if res.Rows != 3 || fmt.Sprint(lines) != "[3 4 5]" {
+		t.Errorf("expected 3 rows loaded and lines 3, 4 and 5 skipped, got %d and %v", res.Rows, lines)
+	}
+	var gerr GoDBError
+	if !errors.As(res.Skipped[2], &gerr) || gerr.code != ConstraintViolationError {
+		t.Errorf("expected line 5 to violate the primary key, got %v", res.Skipped[2])
+	}
+	checkAlterQuery(t, s, "select id, name from t order by id",
+		[]DBValue{IntField{1}, StringField{"a"}}, []DBValue{IntField{2}, StringField{"b"}}, []DBValue{IntField{4}, StringField{"e"}})
+	expectViolation(t, s, "insert into t values (4, 'f')")
+
+	if _, err := s.catalog.BulkLoad("t", strings.NewReader(data), BulkLoadOptions{SkipBadRows: true, MaxBadRows: 2}); err == nil {
+		t.Errorf("expected the load to fail after 2 bad rows")
+	}
+	checkAlterQuery(t, s, "select count(*) from t", []DBValue{IntField{3}})
+}
@@ -0,0 +1,85 @@
+package godb
+
+// Statement cancellation.  A statement is run under a [context.Context],
+// which [BindContext] stores on the statement's transaction until the
+// statement finishes or the transaction commits or aborts; once the context
+// is cancelled or its deadline passes, the next page the statement reads
+// through the buffer pool, and the next row an operator that materializes its
+// input (such as [OrderBy] or [Aggregator]) or loops without reading pages
+// (such as the nested loops of [EqualityJoin]) processes, fails with a
+// QueryCancelledError.  Threading the context through the transaction keeps
+// [Operator.Iterator] unchanged.
+//
+// The session that runs the statement then aborts its transaction, as for
+// any other error; see [Session.ExecuteContext].
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+)
+
+// the number of contexts bound to transactions, so that checking for
+// cancellation costs almost nothing when no statement can be cancelled
+var boundContexts atomic.Int32
+
+// Run the statements of transaction tid, which must be running, under ctx
+// until the returned function is called, which must be done when the
+// statement finishes.  The context is also dropped when the transaction
+// commits or aborts.
+func BindContext(ctx context.Context, tid TransactionID) func() {
+	txn := runningTransaction(tid)
+	if txn == nil || ctx.Done() == nil {
+		// the context can never be cancelled
+		return func() {}
+	}
+	txn.setContext(ctx)
+	return func() {
+		txn.setContext(nil)
+	}
+}
+
+// Set the context of the statement the transaction runs, or drop it if ctx
+// is nil.
+func (t *transaction) setContext(ctx context.Context) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	switch {
+	case t.ctx == nil && ctx != nil:
+		boundContexts.Add(1)
+	case t.ctx != nil && ctx == nil:
+		boundContexts.Add(-1)
+	}
+	t.ctx = ctx
+}
+
+// Return a QueryCancelledError if the context bound to tid is done.
+func checkCancelled(tid TransactionID) error {
+	if boundContexts.Load() == 0 {
+		return nil
+	}
+	txn := runningTransaction(tid)
+	if txn == nil {
+		return nil
+	}
+	txn.mutex.Lock()
+	ctx := txn.ctx
+	txn.mutex.Unlock()
+	if ctx == nil {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return cancelledError(ctx.Err())
+	default:
+		return nil
+	}
+}
+
+// Return the error of a statement whose context ended with err.
+func cancelledError(err error) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return GoDBError{QueryCancelledError, "canceling statement due to statement timeout"}
+	}
+	return GoDBError{QueryCancelledError, "canceling statement due to user request"}
+}
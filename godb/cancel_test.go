@@ -0,0 +1,158 @@
+package godb
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A recursive query that runs for much longer than the timeouts below.
+const slowQuery = "with recursive n(i) as (select id from dept where id = 0 union all select i + 1 from n where i < 1000000) select count(*) from n"
+
+func isCancelled(err error) bool {
+	var gerr GoDBError
+	return errors.As(err, &gerr) && gerr.code == QueryCancelledError
+}
+
+func TestCancelStatement(t *testing.T) {
+	s := makeBatchTestSession(t, 30)
+	defer func(n int) { MaxRecursiveIterations = n }(MaxRecursiveIterations)
+	MaxRecursiveIterations = 2000000
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := s.ExecuteContext(ctx, "select name from emp order by name"); !isCancelled(err) {
+		t.Fatalf("expected a cancelled statement, got %v", err)
+	}
+
+	// cancelling a statement in an explicit transaction aborts the transaction
+	if _, err := s.Execute("begin"); err != nil {
+		t.Fatalf("begin failed, %s", err.Error())
+	}
+	if _, err := s.Execute("insert into dept values (10, 'd10')"); err != nil {
+		t.Fatalf("insert failed, %s", err.Error())
+	}
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(5*time.Millisecond, cancel)
+	_, err := s.ExecuteContext(ctx, slowQuery)
+	if !isCancelled(err) || !strings.Contains(err.Error(), "user request") {
+		t.Fatalf("expected a cancelled statement, got %v", err)
+	}
+	if !s.TransactionFailed() {
+		t.Errorf("expected the transaction to have failed")
+	}
+	// the failed transaction only accepts COMMIT or ROLLBACK, and COMMIT
+	// rolls it back
+	if _, err := s.Execute("select count(*) from dept"); err == nil {
+		t.Errorf("expected statements of the failed transaction to be rejected")
+	}
+	if res, err := s.Execute("commit"); err != nil || res.Tag != "ROLLBACK" {
+		t.Fatalf("expected commit to roll back, got %v", err)
+	}
+	res, err := s.Execute("select count(*) from dept")
+	if err != nil {
+		t.Fatalf("count failed, %s", err.Error())
+	}
+	if got := rowStrings(res.Tuples); got[0] != "10" {
+		t.Errorf("expected the insert to be rolled back, got %v rows", got)
+	}
+}
+
+func TestStatementTimeout(t *testing.T) {
+	s := makeBatchTestSession(t, 30)
+	defer func(n int) { MaxRecursiveIterations = n }(MaxRecursiveIterations)
+	MaxRecursiveIterations = 2000000
+
+	if _, err := s.Execute("set statement_timeout = 10"); err != nil {
+		t.Fatalf("set failed, %s", err.Error())
+	}
+	_, err := s.Execute(slowQuery)
+	if !isCancelled(err) || !strings.Contains(err.Error(), "statement timeout") {
+		t.Fatalf("expected the statement to time out, got %v", err)
+	}
+	// statements that finish in time are not affected
+	if _, err := s.Execute("set statement_timeout = '1min'"); err != nil {
+		t.Fatalf("set failed, %s", err.Error())
+	}
+	res, err := s.Execute("select dept, count(*) from emp group by dept order by dept")
+	if err != nil {
+		t.Fatalf("query failed, %s", err.Error())
+	}
+	if len(res.Tuples) != 10 {
+		t.Errorf("expected 10 groups, got %v", rowStrings(res.Tuples))
+	}
+
+	for _, v := range []string{"0", "default", "'250ms'", "'2s'"} {
+		if _, err := s.Execute("set statement_timeout = " + v); err != nil {
+			t.Errorf("set statement_timeout = %s failed, %s", v, err.Error())
+		}
+	}
+	for _, v := range []string{"-5", "'soon'", "'-1s'"} {
+		if _, err := s.Execute("set statement_timeout = " + v); err == nil {
+			t.Errorf("set statement_timeout = %s: expected an error", v)
+		}
+	}
+}
+
+func TestDriverQueryContext(t *testing.T) {
+	db := openTestDriverDB(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := db.QueryContext(ctx, "select name from t"); err == nil {
+		t.Errorf("expected an error for a cancelled context")
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	rows, err := db.QueryContext(ctx, "select name from t")
+	if err != nil {
+		t.Fatalf("query failed, %s", err.Error())
+	}
+	rows.Close()
+}
+
+// A context is held by its transaction, and dropped when the transaction
+// ends even if the statement did not unbind it.
+func TestContextEndsWithTransaction(t *testing.T) {
+	bp, err := NewBufferPool(10)
+	if err != nil {
+		t.Fatalf("failed to create buffer pool, %s", err.Error())
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// a transaction that is not running cannot be bound to
+	tid := NewTID()
+	BindContext(ctx, tid)
+	if err := checkCancelled(tid); err != nil {
+		t.Errorf("expected no context for a transaction that is not running, got %v", err)
+	}
+
+	for _, commit := range []bool{false, true} {
+		tid := NewTID()
+		if err := bp.BeginTransaction(tid); err != nil {
+			t.Fatalf("begin failed, %s", err.Error())
+		}
+		bound := boundContexts.Load()
+		unbind := BindContext(ctx, tid)
+		if err := checkCancelled(tid); !isCancelled(err) {
+			t.Errorf("expected a cancelled statement, got %v", err)
+		}
+		if commit {
+			bp.CommitTransaction(tid)
+		} else {
+			bp.AbortTransaction(tid)
+		}
+		if err := checkCancelled(tid); err != nil || runningTransaction(tid) != nil {
+			t.Errorf("expected the transaction's context to be dropped, got %v", err)
+		}
+		if boundContexts.Load() != bound {
+			t.Errorf("expected %d bound contexts, got %d", bound, boundContexts.Load())
+		}
+		unbind()
+		if boundContexts.Load() != bound {
+			t.Errorf("expected unbinding after the transaction ended to change nothing, got %d bound contexts", boundContexts.Load())
+		}
+	}
+}
@@ -1,12 +1,12 @@
 package godb
 
 import (
-	"bufio"
 	"fmt"
 	"log"
 	"os"
 	"sort"
 	"strings"
+	"sync"
 )
 
 type Table struct {
@@ -18,6 +18,15 @@ type Table struct {
 	stats *TableStats
 
 	file DBFile
+
+	// checked when rows are inserted; see constraints.go
+	constraints []*constraint
+	// the foreign keys of other tables (or this one) that reference this one
+	referencedBy []*constraint
+	// table options, saved in the catalog
+	options map[string]string
+	// the query of a materialized view; see view.go
+	view *view
 }
 
 type Catalog struct {
@@ -26,25 +35,73 @@ type Catalog struct {
 	bufferPool *BufferPool
 	rootPath   string
 	filePath   string
+
+	// serializes parsing and DDL when the catalog is shared by several
+	// sessions (e.g., connections to a [Server])
+	mutex sync.Mutex
+	// incremented whenever a table is added or dropped, so that prepared
+	// statements planned against an older schema can be replanned
+	version int
+	// the read-only tables describing the database; see system_tables.go
+	systemTables map[string]*Table
+	// views, which are not tables; see view.go
+	views map[string]*view
+	// guards tableMap, columnMap and views against scans of the system tables,
+	// which do not hold mutex
+	tablesMutex sync.RWMutex
 }
 
+// Save the catalog to a file, atomically replacing it; see catalog_file.go.
 func (c *Catalog) SaveToFile(catalogFile string, rootPath string) error {
-	f, err := os.OpenFile(rootPath+"/"+catalogFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	data, err := c.marshal()
 	if err != nil {
 		return err
 	}
-	f.WriteString(c.String())
-	f.Close()
-	return nil
+	return writeFileAtomic(rootPath+"/"+catalogFile, data)
+}
+
+// Save the catalog to the file it was read from.
+func (c *Catalog) save() error {
+	return c.SaveToFile(c.filePath, c.rootPath)
 }
 
 func (c *Catalog) dropTable(tableName string) error {
-	_, ok := c.tableMap[tableName]
+	t, ok := c.tableMap[tableName]
 	if !ok {
+		if _, ok := c.systemTables[tableName]; ok {
+			return GoDBError{IllegalOperationError, fmt.Sprintf("cannot drop system table %s", tableName)}
+		}
 		return GoDBError{NoSuchTableError, "couldn't find table to drop"}
 	}
+	if err := c.checkNoDependentViews(tableName, "drop"); err != nil {
+		return err
+	}
+	for _, fk := range t.referencedBy {
+		if fk.table != t {
+			return GoDBError{IllegalOperationError, fmt.Sprintf("cannot drop table %s because constraint %s on table %s depends on it", tableName, fk.name, fk.table.name)}
+		}
+	}
+	for _, con := range t.constraints {
+		if con.kind == foreignKeyConstraint {
+			refs := con.parent.referencedBy[:0]
+			for _, fk := range con.parent.referencedBy {
+				if fk != con {
+					refs = append(refs, fk)
+				}
+			}
+			con.parent.referencedBy = refs
+		}
+	}
+	c.removeTable(tableName)
+	return nil
+}
 
+// Remove a table from the catalog's maps.
+func (c *Catalog) removeTable(tableName string) {
+	c.tablesMutex.Lock()
+	defer c.tablesMutex.Unlock()
 	delete(c.tableMap, tableName)
+	c.version++
 	for cn, ts := range c.columnMap {
 		tsFiltered := make([]*Table, 0)
 		for _, t := range ts {
@@ -54,7 +111,6 @@ func (c *Catalog) dropTable(tableName string) error {
 		}
 		c.columnMap[cn] = tsFiltered
 	}
-	return nil
 }
 
 func ImportCatalogFromCSVs(
@@ -87,60 +143,24 @@ func ImportCatalogFromCSVs(
 }
 
 func (c *Catalog) parseCatalogFile() error {
-	f, err := os.Open(c.rootPath + "/" + c.filePath)
+	defs, views, err := readCatalogFile(c.rootPath + "/" + c.filePath)
 	if err != nil {
 		return err
 	}
-	scanner := bufio.NewScanner(f)
-
-	for scanner.Scan() {
-		// code to read each line
-		line := strings.ToLower(scanner.Text())
-		sep := strings.Split(line, "(")
-		if len(sep) != 2 {
-			return GoDBError{ParseError, fmt.Sprintf("expected one paren in catalog entry, got %d (%s)", len(sep), line)}
-		}
-		tableName := strings.TrimSpace(sep[0])
-		rest := strings.Trim(sep[1], "()")
-		fields := strings.Split(rest, ",")
-
-		var fieldArray []FieldType
-		for _, f := range fields {
-			f := strings.TrimSpace(f)
-			nameType := strings.Split(f, " ")
-			if len(nameType) < 2 || len(nameType) > 4 {
-				return GoDBError{ParseError, fmt.Sprintf("malformed catalog entry %s (line %s)", nameType, line)}
-			}
-
-			name := nameType[0]
-			fieldType := FieldType{name, "", IntType}
-			switch nameType[1] {
-			case "int":
-				fallthrough
-			case "integer":
-				fieldType.Ftype = IntType
-			case "string":
-				fallthrough
-			case "varchar":
-				fallthrough
-			case "text":
-				fieldType.Ftype = StringType
-			default:
-				return GoDBError{ParseError, fmt.Sprintf("unknown type %s (line %s)", nameType[1], line)}
-			}
-			fieldArray = append(fieldArray, fieldType)
-		}
-
-		_, err := c.addTable(tableName, TupleDesc{fieldArray})
-		if err != nil {
-			return err
-		}
-	}
-	return nil
+	return c.loadTables(defs, views)
 }
 
 func NewCatalog(catalogFile string, bp *BufferPool, rootPath string) *Catalog {
-	return &Catalog{make(map[string]*Table), make(map[string][]*Table), bp, rootPath, catalogFile}
+	c := &Catalog{
+		tableMap:   make(map[string]*Table),
+		columnMap:  make(map[string][]*Table),
+		bufferPool: bp,
+		rootPath:   rootPath,
+		filePath:   catalogFile,
+		views:      make(map[string]*view),
+	}
+	c.systemTables = c.makeSystemTables()
+	return c
 }
 
 func NewCatalogFromFile(catalogFile string, bp *BufferPool, rootPath string) (*Catalog, error) {
@@ -155,18 +175,30 @@ func NewCatalogFromFile(catalogFile string, bp *BufferPool, rootPath string) (*C
 //
 // Returns an error if the table already exists.
 func (c *Catalog) addTable(named string, desc TupleDesc) (DBFile, error) {
+	return c.addTableWithOptions(named, desc, nil)
+}
+
+// Add a new table with the given table options, e.g., its storage format, to
+// the catalog.
+func (c *Catalog) addTableWithOptions(named string, desc TupleDesc, options map[string]string) (DBFile, error) {
 	f, err := c.GetTable(named)
 	if err == nil {
 		return f, GoDBError{DuplicateTableError, fmt.Sprintf("a table named '%s' already exists", named)}
 	}
+	if _, ok := c.views[named]; ok {
+		return nil, GoDBError{DuplicateTableError, fmt.Sprintf("a view named '%s' already exists", named)}
+	}
 
-	hf, err := NewHeapFile(c.tableNameToFile(named), &desc, c.bufferPool)
+	hf, err := c.openTableFile(c.tableNameToFile(named), &desc, options)
 	if err != nil {
 		return nil, err
 	}
 
-	t := &Table{len(c.tableMap), named, desc, nil, hf}
+	t := &Table{id: len(c.tableMap), name: named, desc: desc, file: hf, options: options}
+	c.tablesMutex.Lock()
+	defer c.tablesMutex.Unlock()
 	c.tableMap[named] = t
+	c.version++
 	for _, f := range desc.Fields {
 		mapList := c.columnMap[f.Fname]
 		if mapList == nil {
@@ -178,17 +210,179 @@ func (c *Catalog) addTable(named string, desc TupleDesc) (DBFile, error) {
 	return hf, nil
 }
 
+// The storage formats of tables, chosen with the "storage" table option.
+const (
+	heapStorage     = "heap"
+	columnarStorage = "columnar"
+)
+
+// The file of a user table, which has a backing file of its own.
+type tableFile interface {
+	DBFile
+	BackingFile() string
+}
+
+// Check the options given to CREATE TABLE ... WITH (option = value, ...).
+func checkTableOptions(options map[string]string) error {
+	for key, value := range options {
+		switch key {
+		case "storage":
+			if value != heapStorage && value != columnarStorage {
+				return GoDBError{ParseError, fmt.Sprintf("unknown storage %s, expected heap or columnar", value)}
+			}
+		default:
+			return GoDBError{ParseError, fmt.Sprintf("unknown table option %s", key)}
+		}
+	}
+	return nil
+}
+
+// Open the file at path of a table with descriptor desc, in the storage
+// format given by options.
+func (c *Catalog) openTableFile(path string, desc *TupleDesc, options map[string]string) (tableFile, error) {
+	if options["storage"] == columnarStorage {
+		f, err := NewColumnarFile(path, desc, c.bufferPool)
+		if err != nil {
+			return nil, err
+		}
+		return f, nil
+	}
+	f, err := NewHeapFile(path, desc, c.bufferPool)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Return the storage format of table t.
+func (t *Table) storage() string {
+	if _, ok := t.file.(*ColumnarFile); ok {
+		return columnarStorage
+	}
+	return heapStorage
+}
+
+// Add a new table with the given constraints and table options to the
+// catalog.
+func (c *Catalog) createTable(named string, desc TupleDesc, cons []*constraint, options map[string]string) error {
+	if err := checkTableOptions(options); err != nil {
+		return err
+	}
+	if err := prepareConstraints(named, &desc, cons); err != nil {
+		return err
+	}
+	self := &Table{name: named, desc: desc, constraints: cons}
+	for _, con := range cons {
+		if con.kind == foreignKeyConstraint {
+			if err := c.resolveForeignKey(self, con); err != nil {
+				return err
+			}
+		}
+	}
+	if _, err := c.addTableWithOptions(named, desc, options); err != nil {
+		return err
+	}
+	t := c.tableMap[named]
+	t.constraints = cons
+	for _, con := range cons {
+		c.linkConstraint(t, con)
+	}
+	t.resetIndexes()
+	return nil
+}
+
+// Attach constraint con to table t, giving it an index if it needs one.
+func (c *Catalog) linkConstraint(t *Table, con *constraint) {
+	con.table = t
+	switch con.kind {
+	case primaryKeyConstraint, uniqueConstraint:
+		con.index = newKeyIndex(c.bufferPool)
+	case foreignKeyConstraint:
+		con.index = newKeyIndex(c.bufferPool)
+		if con.parent.name == t.name {
+			// resolved against the table before it was added
+			con.parent = t
+		}
+		con.parent.referencedBy = append(con.parent.referencedBy, con)
+	}
+}
+
+// Scan the tables of the catalog, counting their pages and tuples and building
+// histograms of their string columns for estimating the selectivity of LIKE
+// predicates.
 func (c *Catalog) ComputeTableStats() error {
-	// Dummy implementation, do not worry about it.
+	for _, t := range c.userTables() {
+		if t.file == nil {
+			continue
+		}
+		stats, err := c.scanTableStats(t)
+		if err != nil {
+			return err
+		}
+		c.tablesMutex.Lock()
+		t.stats = stats
+		c.tablesMutex.Unlock()
+	}
 	return nil
 }
 
+func (c *Catalog) scanTableStats(t *Table) (*TableStats, error) {
+	bp := c.bufferPool
+	tid := NewTID()
+	if err := bp.BeginTransaction(tid); err != nil {
+		return nil, err
+	}
+	iter, err := t.file.Iterator(tid)
+	if err != nil {
+		bp.AbortTransaction(tid)
+		return nil, err
+	}
+	samples := make([]*columnSample, len(t.desc.Fields))
+	for i, f := range t.desc.Fields {
+		if f.Ftype == StringType {
+			samples[i] = newColumnSample()
+		}
+	}
+	tuples := 0
+	for {
+		tup, err := iter()
+		if err != nil {
+			bp.AbortTransaction(tid)
+			return nil, err
+		}
+		if tup == nil {
+			break
+		}
+		tuples++
+		for i, s := range samples {
+			if v, ok := tup.Fields[i].(StringField); ok && s != nil {
+				s.add(v.Value)
+			}
+		}
+	}
+	if err := bp.CommitTransaction(tid); err != nil {
+		return nil, err
+	}
+	stats := &TableStats{basePages: t.file.NumPages(), baseTups: tuples, histograms: make(map[string]any), tupleDesc: &t.desc}
+	for i, s := range samples {
+		if s != nil {
+			if h := newStringHistogram(s.values); h != nil {
+				stats.histograms[t.desc.Fields[i].Fname] = h
+			}
+		}
+	}
+	return stats, nil
+}
+
 func (c *Catalog) tableNameToFile(tableName string) string {
 	return c.rootPath + "/" + tableName + ".dat"
 }
 
 func (c *Catalog) GetTableInfo(named string) (*Table, error) {
 	t, ok := c.tableMap[named]
+	if !ok {
+		t, ok = c.systemTables[named]
+	}
 	if !ok {
 		return nil, GoDBError{NoSuchTableError, fmt.Sprintf("no table '%s' found", named)}
 	}
@@ -221,19 +415,39 @@ func (c *Catalog) GetTableInfoDBFile(f DBFile) (*Table, error) {
 	return nil, GoDBError{NoSuchTableError, "table not found"}
 }
 
-// Get the statistics for a table.
+// Get the statistics for a table: those counted by [Catalog.ComputeTableStats]
+// or, if they have not been computed, an estimate that assumes the pages of
+// the table's file are full.
 //
 // Returns nil if the table does not exist.
 func (c *Catalog) GetTableStats(named string) *TableStats {
 	t, err := c.GetTableInfo(named)
-	if err != nil {
+	if err != nil || (t.stats == nil && t.file == nil) {
 		return nil
 	}
+	if t.stats == nil {
+		pages := t.file.NumPages()
+		return &TableStats{basePages: pages, baseTups: pages * tuplesPerPage(&t.desc), tupleDesc: &t.desc}
+	}
 	return t.stats
 }
 
+// Return the number of tuples with columns desc that fit on a heap page.
+func tuplesPerPage(desc *TupleDesc) int {
+	if len(desc.Fields) == 0 {
+		return 0
+	}
+	return (&heapPage{Desc: *desc}).getNumSlots()
+}
+
 func (c *Catalog) findTablesWithColumn(named string) []*Table {
-	return c.columnMap[named]
+	tables := c.columnMap[named]
+	for _, t := range c.systemTables {
+		if t.columnIndex(named) >= 0 {
+			tables = append(tables[:len(tables):len(tables)], t)
+		}
+	}
+	return tables
 }
 
 func (c *Catalog) NumTables() int {
@@ -251,6 +465,21 @@ func (t *Table) String() string {
 		buf.WriteString(f.Fname)
 		buf.WriteByte(' ')
 		buf.WriteString(f.Ftype.String())
+		for _, con := range t.constraints {
+			if con.kind == notNullConstraint && con.columns[0] == f.Fname {
+				if con.name != con.defaultName(t.name) {
+					buf.WriteString(" constraint ")
+					buf.WriteString(con.name)
+				}
+				buf.WriteString(" not null")
+			}
+		}
+	}
+	for _, con := range t.constraints {
+		if con.kind != notNullConstraint {
+			buf.WriteString(", ")
+			buf.WriteString(con.String())
+		}
 	}
 	buf.WriteString(")\n")
 	return buf.String()
@@ -0,0 +1,329 @@
+package godb
+
+// The catalog file.  The catalog is saved as a JSON document whose version
+// field says which revision of the format it uses:
+//
+//	{
+//	  "version": 1,
+//	  "tables": [
+//	    {
+//	      "name": "orders",
+//	      "columns": [{"name": "id", "type": "int"}, {"name": "customer", "type": "int"}],
+//	      "constraints": [
+//	        {"name": "orders_pkey", "type": "primary key", "columns": ["id"]},
+//	        {"name": "orders_customer_fkey", "type": "foreign key", "columns": ["customer"],
+//	         "references": "customers", "ref_columns": ["id"], "on_delete": "cascade"}
+//	      ]
+//	    },
+//	    {
+//	      "name": "order_counts",
+//	      "columns": [{"name": "customer", "type": "int"}, {"name": "count", "type": "int"}],
+//	      "query": "select customer, count(*) as count from orders group by customer"
+//	    }
+//	  ],
+//	  "views": [{"name": "big_orders", "query": "select * from orders where id > 1000"}]
+//	}
+//
+// Tables with a query are materialized views.  Table options, such as the
+// storage format of columnar tables, are saved as "options": {"storage":
+// "columnar"}.
+// Older catalogs are text files with one table per line, in CREATE TABLE
+// syntax without the CREATE TABLE, e.g., t(name string, age int).  They are
+// still read, and are rewritten as JSON the next time the catalog is saved.
+//
+// The catalog is saved by every DDL statement (see [Parse]).  It is written
+// to a temporary file that then replaces the old one, so a crash leaves
+// either the old or the new catalog, never a mix.
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// The revision of the catalog format written by SaveToFile.
+const catalogFormatVersion = 1
+
+type catalogFile struct {
+	Version int            `json:"version"`
+	Tables  []catalogTable `json:"tables"`
+	Views   []catalogView  `json:"views,omitempty"`
+}
+
+type catalogTable struct {
+	Name        string              `json:"name"`
+	Columns     []catalogColumn     `json:"columns"`
+	Constraints []catalogConstraint `json:"constraints,omitempty"`
+	Options     map[string]string   `json:"options,omitempty"`
+	Stats       *catalogStats       `json:"stats,omitempty"`
+	Query       string              `json:"query,omitempty"`
+}
+
+type catalogView struct {
+	Name  string `json:"name"`
+	Query string `json:"query"`
+}
+
+type catalogColumn struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+type catalogConstraint struct {
+	Name       string   `json:"name"`
+	Type       string   `json:"type"`
+	Columns    []string `json:"columns,omitempty"`
+	Check      string   `json:"check,omitempty"`
+	References string   `json:"references,omitempty"`
+	RefColumns []string `json:"ref_columns,omitempty"`
+	OnDelete   string   `json:"on_delete,omitempty"`
+}
+
+type catalogStats struct {
+	Pages  int `json:"pages"`
+	Tuples int `json:"tuples"`
+}
+
+var constraintTypes = map[constraintKind]string{
+	notNullConstraint:    "not null",
+	primaryKeyConstraint: "primary key",
+	uniqueConstraint:     "unique",
+	checkConstraint:      "check",
+	foreignKeyConstraint: "foreign key",
+}
+
+// A table read from the catalog file, not yet added to the catalog.
+type tableDef struct {
+	name    string
+	desc    TupleDesc
+	cons    []*constraint
+	options map[string]string
+	stats   *TableStats
+	query   string
+}
+
+func (c *Catalog) marshal() ([]byte, error) {
+	names := make([]string, 0, len(c.tableMap))
+	for name := range c.tableMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	file := catalogFile{Version: catalogFormatVersion, Tables: []catalogTable{}}
+	for _, name := range names {
+		t := c.tableMap[name]
+		ct := catalogTable{Name: t.name, Options: t.options}
+		for _, f := range t.desc.Fields {
+			ct.Columns = append(ct.Columns, catalogColumn{f.Fname, f.Ftype.String()})
+		}
+		for _, con := range t.constraints {
+			cc := catalogConstraint{Name: con.name, Type: constraintTypes[con.kind]}
+			switch con.kind {
+			case checkConstraint:
+				// the columns are found again when the check is compiled
+				cc.Check = con.check
+			case foreignKeyConstraint:
+				cc.Columns = con.columns
+				cc.References = con.parent.name
+				cc.RefColumns = con.refColumns
+				cc.OnDelete = con.onDelete.String()
+			default:
+				cc.Columns = con.columns
+			}
+			ct.Constraints = append(ct.Constraints, cc)
+		}
+		if t.stats != nil {
+			ct.Stats = &catalogStats{t.stats.basePages, t.stats.baseTups}
+		}
+		if t.view != nil {
+			ct.Query = t.view.query
+		}
+		file.Tables = append(file.Tables, ct)
+	}
+	names = names[:0]
+	for name := range c.views {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		file.Views = append(file.Views, catalogView{name, c.views[name].query})
+	}
+	return json.MarshalIndent(file, "", "  ")
+}
+
+// Write data to path by writing a temporary file in the same directory and
+// renaming it over path, so that path is replaced atomically.
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	f, err := os.CreateTemp(dir, filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	fail := func(err error) error {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		return fail(err)
+	}
+	if err := f.Sync(); err != nil {
+		return fail(err)
+	}
+	if err := f.Chmod(0644); err != nil {
+		return fail(err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	// make the rename durable
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// Read the table and view definitions in the catalog file, in either format.
+func readCatalogFile(path string) ([]*tableDef, []*view, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		return parseCatalogJSON(data)
+	}
+	defs, err := parseCatalogText(data)
+	return defs, nil, err
+}
+
+func parseCatalogJSON(data []byte) ([]*tableDef, []*view, error) {
+	var file catalogFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, nil, GoDBError{ParseError, fmt.Sprintf("malformed catalog: %s", err.Error())}
+	}
+	if file.Version < 1 || file.Version > catalogFormatVersion {
+		return nil, nil, GoDBError{ParseError, fmt.Sprintf("unsupported catalog version %d", file.Version)}
+	}
+	kinds := make(map[string]constraintKind)
+	for kind, name := range constraintTypes {
+		kinds[name] = kind
+	}
+	actions := map[string]fkAction{"restrict": fkRestrict, "cascade": fkCascade, "set null": fkSetNull}
+
+	var defs []*tableDef
+	for _, ct := range file.Tables {
+		def := &tableDef{name: ct.Name, options: ct.Options, query: ct.Query}
+		for _, col := range ct.Columns {
+			typ, err := newSqlTokens(col.Type).columnType()
+			if err != nil {
+				return nil, nil, GoDBError{ParseError, fmt.Sprintf("unknown type %s of column %s in table %s", col.Type, col.Name, ct.Name)}
+			}
+			def.desc.Fields = append(def.desc.Fields, FieldType{col.Name, "", typ})
+		}
+		for _, cc := range ct.Constraints {
+			kind, ok := kinds[cc.Type]
+			if !ok {
+				return nil, nil, GoDBError{ParseError, fmt.Sprintf("unknown constraint type %s in table %s", cc.Type, ct.Name)}
+			}
+			con := &constraint{name: cc.Name, kind: kind, columns: cc.Columns, check: cc.Check}
+			if kind == foreignKeyConstraint {
+				con.refTable = cc.References
+				con.refColumns = cc.RefColumns
+				if con.onDelete, ok = actions[cc.OnDelete]; !ok {
+					return nil, nil, GoDBError{ParseError, fmt.Sprintf("unknown ON DELETE action %s in table %s", cc.OnDelete, ct.Name)}
+				}
+			}
+			def.cons = append(def.cons, con)
+		}
+		if ct.Stats != nil {
+			def.stats = &TableStats{basePages: ct.Stats.Pages, baseTups: ct.Stats.Tuples}
+		}
+		defs = append(defs, def)
+	}
+	var views []*view
+	for _, cv := range file.Views {
+		v, _, err := newView(cv.Name, cv.Query)
+		if err != nil {
+			return nil, nil, err
+		}
+		views = append(views, v)
+	}
+	return defs, views, nil
+}
+
+func parseCatalogText(data []byte) ([]*tableDef, error) {
+	var defs []*tableDef
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		// each line is a table definition in CREATE TABLE syntax, e.g.,
+		// t(id int, name string, constraint t_pkey primary key (id))
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		name, desc, cons, options, err := parseTableDef(newSqlTokens(line))
+		if err != nil {
+			return nil, GoDBError{ParseError, fmt.Sprintf("malformed catalog entry %s: %s", line, err.Error())}
+		}
+		defs = append(defs, &tableDef{name: name, desc: desc, cons: cons, options: options})
+	}
+	return defs, scanner.Err()
+}
+
+// Add the tables and views read from the catalog file to the catalog.
+// Foreign keys are added once all of the tables they may reference are.
+func (c *Catalog) loadTables(defs []*tableDef, views []*view) error {
+	type foreignKey struct {
+		table *Table
+		con   *constraint
+	}
+	var fks []foreignKey
+	for _, def := range defs {
+		var others []*constraint
+		for _, con := range def.cons {
+			if con.kind != foreignKeyConstraint {
+				others = append(others, con)
+			}
+		}
+		if err := c.createTable(def.name, def.desc, others, def.options); err != nil {
+			return err
+		}
+		t := c.tableMap[def.name]
+		if def.query != "" {
+			v, _, err := newView(def.name, def.query)
+			if err != nil {
+				return err
+			}
+			t.view = v
+		}
+		if def.stats != nil {
+			def.stats.tupleDesc = &t.desc
+			t.stats = def.stats
+		}
+		for _, con := range def.cons {
+			if con.kind == foreignKeyConstraint {
+				fks = append(fks, foreignKey{t, con})
+			}
+		}
+	}
+	for _, fk := range fks {
+		if err := c.addForeignKey(fk.table, fk.con); err != nil {
+			return err
+		}
+	}
+	for _, v := range views {
+		c.views[v.name] = v
+	}
+	return nil
+}
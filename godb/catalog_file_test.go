@@ -0,0 +1,67 @@
+package godb
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCatalogFileMigration(t *testing.T) {
+	dir := t.TempDir()
+	text := "customers (id int, name string, constraint customers_pkey primary key (id))\n" +
+		"orders(id int, customer int, constraint orders_customer_fkey foreign key (customer) references customers (id) on delete cascade)\n"
+	if err := os.WriteFile(filepath.Join(dir, "catalog.txt"), []byte(text), 0644); err != nil {
+		t.Fatalf("failed to write catalog, %s", err.Error())
+	}
+	bp, _ := NewBufferPool(100)
+	c, err := NewCatalogFromFile("catalog.txt", bp, dir)
+	if err != nil {
+		t.Fatalf("failed to load text catalog, %s", err.Error())
+	}
+	if c.NumTables() != 2 {
+		t.Fatalf("expected 2 tables, got %d", c.NumTables())
+	}
+
+	// DDL saves the catalog, without a session, in the new format
+	if _, _, err := Parse(c, "create table items (sku text not null, qty int check (qty >= 0))"); err != nil {
+		t.Fatalf("create failed, %s", err.Error())
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "catalog.txt"))
+	if err != nil {
+		t.Fatalf("failed to read catalog, %s", err.Error())
+	}
+	if !strings.HasPrefix(string(data), "{") || !strings.Contains(string(data), `"version": 1`) {
+		t.Errorf("expected a versioned JSON catalog, got %s", data)
+	}
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		if strings.Contains(e.Name(), ".tmp") {
+			t.Errorf("temporary file %s left behind", e.Name())
+		}
+	}
+
+	bp, _ = NewBufferPool(100)
+	c2, err := NewCatalogFromFile("catalog.txt", bp, dir)
+	if err != nil {
+		t.Fatalf("failed to load JSON catalog, %s", err.Error())
+	}
+	if c2.String() != c.String() {
+		t.Errorf("catalog changed when reloaded:\n%s\nvs\n%s", c2.String(), c.String())
+	}
+	orders, _ := c2.GetTableInfo("orders")
+	if len(orders.constraints) != 1 || orders.constraints[0].onDelete != fkCascade || orders.constraints[0].parent.name != "customers" {
+		t.Errorf("foreign key not restored, got %v", orders.constraints)
+	}
+}
+
+func TestCatalogFileVersion(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "catalog.txt"), []byte(`{"version": 99, "tables": []}`), 0644); err != nil {
+		t.Fatalf("failed to write catalog, %s", err.Error())
+	}
+	bp, _ := NewBufferPool(100)
+	if _, err := NewCatalogFromFile("catalog.txt", bp, dir); err == nil {
+		t.Errorf("expected an error loading a catalog from a newer version")
+	}
+}
@@ -0,0 +1,971 @@
+package godb
+
+// Columnar storage, an alternative to heap files for tables that are mostly
+// scanned, selected with CREATE TABLE ... WITH (storage = columnar).
+//
+// A ColumnarFile stores its rows in row groups.  Each row group is a header
+// page followed by one page per column:
+//
+//	header page:  int32 number of rows
+//	              deletion bitmap, one bit per row
+//	              the zone map of each column: its min and max value
+//	column page:  byte encoding, uint32 number of values, encoded values
+//
+// The values of a column page are encoded with whichever of three encodings
+// is smallest:
+//
+//	plain:       each value in turn; ints as 8 bytes, strings as a uvarint
+//	             length and their bytes
+//	run-length:  (value, uvarint run length) pairs, with ints as varints
+//	dictionary:  a uvarint count of distinct values, the values, and then a
+//	             uvarint index into them for each row
+//
+// Rows are appended to the last row group until one of its column pages
+// cannot hold another value, or it has columnarMaxRows rows, and then a new
+// row group is started, so well compressed columns mean fewer, fuller pages.
+// Deleted rows are only marked in the bitmap; their space is reclaimed when
+// the table is rewritten, e.g., by ALTER TABLE.
+//
+// Scans read the header page of each row group and then only the column
+// pages of the columns the query uses (see pruneScanColumns), and skip row
+// groups whose zone maps show that no row can pass the filters above them.
+// Columns that are not read are returned as zero values.
+//
+// Pages go through the buffer pool like heap pages, so columnar tables take
+// part in transactions in the same way.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// the most rows in a row group, which bounds the size of the deletion bitmap
+const columnarMaxRows = 4096
+
+// the size of the encoding byte and value count that start a column page
+const columnPageHeaderSize = 5
+
+const (
+	plainEncoding byte = iota
+	rleEncoding
+	dictEncoding
+)
+
+type ColumnarFile struct {
+	bufPool     *BufferPool
+	backingFile string
+	Desc        TupleDesc
+	numPages    int
+	mutex       sync.Mutex
+}
+
+// Identifies a row of a ColumnarFile by its row group's header page and its
+// position in the row group.
+type columnarRecordID struct {
+	PageNumber int
+	Row        int
+}
+
+func (r columnarRecordID) GetPageNumber() int {
+	return r.PageNumber
+}
+
+func (r columnarRecordID) GetSlotNumber() int {
+	return r.Row
+}
+
+// Create a ColumnarFile backed by fromFile, which may be empty or a
+// previously created columnar file.  Returns an error if the header page of
+// a row group cannot hold the zone maps of td's columns.
+func NewColumnarFile(fromFile string, td *TupleDesc, bp *BufferPool) (*ColumnarFile, error) {
+	if len(td.Fields) == 0 {
+		return nil, GoDBError{MalformedDataError, "a columnar table must have at least one column"}
+	}
+	headerSize := 4 + columnarMaxRows/8
+	for _, f := range td.Fields {
+		headerSize += 2 * maxPlainSize(f.Ftype)
+	}
+	if headerSize > PageSize {
+		return nil, GoDBError{IllegalOperationError, fmt.Sprintf("too many columns (%d) for columnar storage", len(td.Fields))}
+	}
+	file, err := os.OpenFile(fromFile, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	f := &ColumnarFile{bufPool: bp, backingFile: fromFile, Desc: *td}
+	// ignore a partly written row group at the end of the file
+	f.numPages = int(info.Size()) / PageSize / f.groupPages() * f.groupPages()
+	return f, nil
+}
+
+// Return the name of the backing file
+func (f *ColumnarFile) BackingFile() string {
+	return f.backingFile
+}
+
+func (f *ColumnarFile) NumPages() int {
+	return f.numPages
+}
+
+// The number of pages in a row group.
+func (f *ColumnarFile) groupPages() int {
+	return len(f.Desc.Fields) + 1
+}
+
+func (f *ColumnarFile) numGroups() int {
+	return f.numPages / f.groupPages()
+}
+
+func (f *ColumnarFile) Descriptor() *TupleDesc {
+	return &f.Desc
+}
+
+// Return a function that iterates through all of the rows of the file.
+func (f *ColumnarFile) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	return newColumnarScan(f, nil, nil).Iterator(tid)
+}
+
+func (f *ColumnarFile) pageKey(pgNo int) any {
+	return heapHash{FileName: f.backingFile, PageNo: pgNo}
+}
+
+func (f *ColumnarFile) readPage(pageNo int) (Page, error) {
+	file, err := os.Open(f.backingFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	buf := make([]byte, PageSize)
+	if _, err := file.ReadAt(buf, int64(pageNo*PageSize)); err != nil {
+		return nil, err
+	}
+	col := pageNo%f.groupPages() - 1
+	if col < 0 {
+		p := &columnarHeaderPage{file: f, pageNo: pageNo}
+		return p, p.initFromBuffer(buf)
+	}
+	p := &columnarPage{file: f, pageNo: pageNo, ftype: f.Desc.Fields[col].Ftype}
+	return p, p.initFromBuffer(buf)
+}
+
+func (f *ColumnarFile) flushPage(p Page) error {
+	var buf []byte
+	var pageNo int
+	switch p := p.(type) {
+	case *columnarHeaderPage:
+		buf, pageNo = p.toBuffer(), p.pageNo
+	case *columnarPage:
+		buf, pageNo = encodeColumn(p.values, p.ftype), p.pageNo
+	default:
+		return fmt.Errorf("not a columnar page")
+	}
+	if len(buf) > PageSize {
+		return fmt.Errorf("page %d of %s overflows", pageNo, f.backingFile)
+	}
+	buf = append(buf, make([]byte, PageSize-len(buf))...)
+	file, err := os.OpenFile(f.backingFile, os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.WriteAt(buf, int64(pageNo*PageSize))
+	return err
+}
+
+// Append the tuple to the last row group, or to a new one if it is full.
+func (f *ColumnarFile) insertTuple(t *Tuple, tid TransactionID) error {
+	if len(t.Fields) != len(f.Desc.Fields) {
+		return GoDBError{TypeMismatchError, fmt.Sprintf("expected %d fields, got %d", len(f.Desc.Fields), len(t.Fields))}
+	}
+	if err := checkStorable(t); err != nil {
+		return err
+	}
+	fields := make([]DBValue, len(t.Fields))
+	for i, v := range t.Fields {
+		switch v := v.(type) {
+		case nil:
+			// stored as the value standing for NULL, which is read back
+			// as nil; see loadedValue
+			fields[i] = nullValue(f.Desc.Fields[i].Ftype)
+		case StringField:
+			fields[i] = StringField{truncateString(v.Value)}
+		default:
+			fields[i] = v
+		}
+		if fieldType(fields[i]) != f.Desc.Fields[i].Ftype {
+			return GoDBError{TypeMismatchError, fmt.Sprintf("wrong type for column %s", f.Desc.Fields[i].Fname)}
+		}
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if n := f.numGroups(); n > 0 {
+		ok, err := f.appendToGroup(n-1, t, fields, tid)
+		if ok || err != nil {
+			return err
+		}
+	}
+	// start a new row group by writing empty pages to the end of the file
+	group := f.numGroups()
+	for i := 0; i < f.groupPages(); i++ {
+		pageNo := group*f.groupPages() + i
+		var p Page = &columnarHeaderPage{file: f, pageNo: pageNo}
+		if i > 0 {
+			p = &columnarPage{file: f, pageNo: pageNo, ftype: f.Desc.Fields[i-1].Ftype}
+		}
+		if err := f.flushPage(p); err != nil {
+			return err
+		}
+	}
+	f.numPages += f.groupPages()
+	ok, err := f.appendToGroup(group, t, fields, tid)
+	if err == nil && !ok {
+		err = GoDBError{IllegalOperationError, "row does not fit in an empty row group"}
+	}
+	return err
+}
+
+// Append fields to row group group, returning false if it has no room.
+func (f *ColumnarFile) appendToGroup(group int, t *Tuple, fields []DBValue, tid TransactionID) (bool, error) {
+	first := group * f.groupPages()
+	pg, err := f.bufPool.GetPage(f, first, tid, ReadPerm)
+	if err != nil {
+		return false, err
+	}
+	if pg.(*columnarHeaderPage).numRows >= columnarMaxRows {
+		return false, nil
+	}
+	for i, v := range fields {
+		pg, err := f.bufPool.GetPage(f, first+1+i, tid, ReadPerm)
+		if err != nil {
+			return false, err
+		}
+		if !pg.(*columnarPage).fits(v) {
+			return false, nil
+		}
+	}
+
+	pg, err = f.bufPool.GetPage(f, first, tid, WritePerm)
+	if err != nil {
+		return false, err
+	}
+	header := pg.(*columnarHeaderPage)
+	for i, v := range fields {
+		pg, err := f.bufPool.GetPage(f, first+1+i, tid, WritePerm)
+		if err != nil {
+			return false, err
+		}
+		cp := pg.(*columnarPage)
+		cp.values = append(cp.values, v)
+		cp.plainSize += plainSize(v)
+		cp.setDirty(tid, true)
+	}
+	header.addRow(fields)
+	header.setDirty(tid, true)
+	t.Rid = columnarRecordID{first, header.numRows - 1}
+	return true, nil
+}
+
+// Mark the row t.Rid as deleted.
+func (f *ColumnarFile) deleteTuple(t *Tuple, tid TransactionID) error {
+	rid, ok := t.Rid.(columnarRecordID)
+	if !ok {
+		return fmt.Errorf("invalid record id")
+	}
+	pg, err := f.bufPool.GetPage(f, rid.PageNumber, tid, WritePerm)
+	if err != nil {
+		return err
+	}
+	header, ok := pg.(*columnarHeaderPage)
+	if !ok || rid.Row >= header.numRows || header.deleted[rid.Row] {
+		return fmt.Errorf("id is invalid")
+	}
+	header.deleted[rid.Row] = true
+	header.setDirty(tid, true)
+	return nil
+}
+
+// The header page of a row group.
+type columnarHeaderPage struct {
+	file    *ColumnarFile
+	pageNo  int
+	dirty   bool
+	numRows int
+	deleted []bool
+	// the zone maps of the columns, nil if there are no rows
+	min, max []DBValue
+}
+
+func (p *columnarHeaderPage) isDirty() bool {
+	return p.dirty
+}
+
+func (p *columnarHeaderPage) setDirty(tid TransactionID, dirty bool) {
+	p.dirty = dirty
+}
+
+func (p *columnarHeaderPage) getFile() DBFile {
+	return p.file
+}
+
+// Return a copy of the page for a transaction to change.
+func (p *columnarHeaderPage) copy() *columnarHeaderPage {
+	c := *p
+	c.deleted = slices.Clone(p.deleted)
+	c.min = slices.Clone(p.min)
+	c.max = slices.Clone(p.max)
+	return &c
+}
+
+func (p *columnarHeaderPage) addRow(fields []DBValue) {
+	if p.numRows == 0 {
+		p.min = slices.Clone(fields)
+		p.max = slices.Clone(fields)
+	}
+	for i, v := range fields {
+		if v.EvalPred(p.min[i], OpLt) {
+			p.min[i] = v
+		}
+		if v.EvalPred(p.max[i], OpGt) {
+			p.max[i] = v
+		}
+	}
+	p.numRows++
+	p.deleted = append(p.deleted, false)
+}
+
+func (p *columnarHeaderPage) toBuffer() []byte {
+	buf := binary.LittleEndian.AppendUint32(nil, uint32(p.numRows))
+	bitmap := make([]byte, (p.numRows+7)/8)
+	for i, d := range p.deleted {
+		if d {
+			bitmap[i/8] |= 1 << (i % 8)
+		}
+	}
+	buf = append(buf, bitmap...)
+	if p.numRows > 0 {
+		for i := range p.min {
+			buf = appendPlain(buf, p.min[i])
+			buf = appendPlain(buf, p.max[i])
+		}
+	}
+	return buf
+}
+
+func (p *columnarHeaderPage) initFromBuffer(buf []byte) error {
+	p.numRows = int(binary.LittleEndian.Uint32(buf))
+	if p.numRows > columnarMaxRows {
+		return fmt.Errorf("corrupt row group header")
+	}
+	r := bytes.NewReader(buf[4:])
+	bitmap := make([]byte, (p.numRows+7)/8)
+	r.Read(bitmap)
+	p.deleted = make([]bool, p.numRows)
+	for i := range p.deleted {
+		p.deleted[i] = bitmap[i/8]&(1<<(i%8)) != 0
+	}
+	if p.numRows == 0 {
+		return nil
+	}
+	p.min = make([]DBValue, len(p.file.Desc.Fields))
+	p.max = make([]DBValue, len(p.file.Desc.Fields))
+	for i, f := range p.file.Desc.Fields {
+		var err error
+		if p.min[i], err = readPlain(r, f.Ftype); err != nil {
+			return err
+		}
+		if p.max[i], err = readPlain(r, f.Ftype); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// A page holding the values of one column of a row group.
+type columnarPage struct {
+	file   *ColumnarFile
+	pageNo int
+	ftype  DBType
+	dirty  bool
+	values []DBValue
+	// the size of the values in plain encoding
+	plainSize int
+	// the number of values the page is known to have room for, without
+	// encoding it again
+	safeLen int
+}
+
+func (p *columnarPage) isDirty() bool {
+	return p.dirty
+}
+
+func (p *columnarPage) setDirty(tid TransactionID, dirty bool) {
+	p.dirty = dirty
+}
+
+func (p *columnarPage) getFile() DBFile {
+	return p.file
+}
+
+// Return a copy of the page for a transaction to change.
+func (p *columnarPage) copy() *columnarPage {
+	c := *p
+	c.values = slices.Clone(p.values)
+	return &c
+}
+
+// Return whether v can be appended to the page.
+func (p *columnarPage) fits(v DBValue) bool {
+	if columnPageHeaderSize+p.plainSize+plainSize(v) <= PageSize {
+		// the chosen encoding is never larger than plain encoding
+		return true
+	}
+	if len(p.values) < p.safeLen {
+		return true
+	}
+	enc := encodeColumn(append(slices.Clip(p.values), v), p.ftype)
+	if len(enc) > PageSize {
+		return false
+	}
+	// Appending a value grows the page's current encoding by at most the
+	// value, a varint run length or dictionary index, and a varint
+	// dictionary count, so the page has room for this many more values,
+	// unless its dictionary indices grow to two bytes.
+	more := (PageSize - len(enc)) / (maxPlainSize(p.ftype) + binary.MaxVarintLen64 + 3)
+	if enc[0] == dictEncoding {
+		size, _ := binary.Uvarint(enc[columnPageHeaderSize:])
+		if size < 128 {
+			more = min(more, 127-int(size))
+		}
+	}
+	p.safeLen = len(p.values) + 1 + more
+	return true
+}
+
+func (p *columnarPage) initFromBuffer(buf []byte) error {
+	values, err := decodeColumn(buf, p.ftype)
+	if err != nil {
+		return fmt.Errorf("page %d of %s: %s", p.pageNo, p.file.backingFile, err.Error())
+	}
+	p.values = values
+	p.plainSize = 0
+	for _, v := range values {
+		p.plainSize += plainSize(v)
+	}
+	return nil
+}
+
+func fieldType(v DBValue) DBType {
+	switch v.(type) {
+	case IntField:
+		return IntType
+	case StringField:
+		return StringType
+	}
+	return UnknownType
+}
+
+func maxPlainSize(t DBType) int {
+	if t == StringType {
+		return binary.MaxVarintLen64 + StringLength
+	}
+	return 8
+}
+
+func plainSize(v DBValue) int {
+	if s, ok := v.(StringField); ok {
+		return uvarintSize(uint64(len(s.Value))) + len(s.Value)
+	}
+	return 8
+}
+
+func uvarintSize(x uint64) int {
+	n := 1
+	for ; x >= 0x80; x >>= 7 {
+		n++
+	}
+	return n
+}
+
+func appendPlain(buf []byte, v DBValue) []byte {
+	switch v := v.(type) {
+	case IntField:
+		return binary.LittleEndian.AppendUint64(buf, uint64(v.Value))
+	case StringField:
+		buf = binary.AppendUvarint(buf, uint64(len(v.Value)))
+		return append(buf, v.Value...)
+	}
+	return buf
+}
+
+// Append v in the compact form used by run-length and dictionary encoding.
+func appendCompact(buf []byte, v DBValue) []byte {
+	if i, ok := v.(IntField); ok {
+		return binary.AppendVarint(buf, i.Value)
+	}
+	return appendPlain(buf, v)
+}
+
+func readPlain(r *bytes.Reader, t DBType) (DBValue, error) {
+	if t == IntType {
+		var b [8]byte
+		if _, err := r.Read(b[:]); err != nil {
+			return nil, err
+		}
+		return IntField{int64(binary.LittleEndian.Uint64(b[:]))}, nil
+	}
+	return readString(r)
+}
+
+func readCompact(r *bytes.Reader, t DBType) (DBValue, error) {
+	if t == IntType {
+		v, err := binary.ReadVarint(r)
+		return IntField{v}, err
+	}
+	return readString(r)
+}
+
+func readString(r *bytes.Reader) (DBValue, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(r.Len()) {
+		return nil, fmt.Errorf("string overflows page")
+	}
+	b := make([]byte, n)
+	r.Read(b)
+	return StringField{string(b)}, nil
+}
+
+// Encode a column page holding values, choosing the smallest encoding.
+func encodeColumn(values []DBValue, t DBType) []byte {
+	header := func(enc byte) []byte {
+		return binary.LittleEndian.AppendUint32([]byte{enc}, uint32(len(values)))
+	}
+	best := header(plainEncoding)
+	for _, v := range values {
+		best = appendPlain(best, v)
+	}
+	if len(values) < 2 {
+		return best
+	}
+
+	rle := header(rleEncoding)
+	for i := 0; i < len(values); {
+		j := i + 1
+		for j < len(values) && values[j] == values[i] {
+			j++
+		}
+		rle = appendCompact(rle, values[i])
+		rle = binary.AppendUvarint(rle, uint64(j-i))
+		i = j
+	}
+	if len(rle) < len(best) {
+		best = rle
+	}
+
+	index := make(map[DBValue]int)
+	var dict []DBValue
+	for _, v := range values {
+		if _, ok := index[v]; !ok {
+			index[v] = len(dict)
+			dict = append(dict, v)
+		}
+	}
+	if len(dict) < len(values)/2 {
+		enc := binary.AppendUvarint(header(dictEncoding), uint64(len(dict)))
+		for _, v := range dict {
+			enc = appendCompact(enc, v)
+		}
+		for _, v := range values {
+			enc = binary.AppendUvarint(enc, uint64(index[v]))
+		}
+		if len(enc) < len(best) {
+			best = enc
+		}
+	}
+	return best
+}
+
+// Decode a column page written by encodeColumn.  An all zero page is an empty
+// plain encoded page.
+func decodeColumn(buf []byte, t DBType) ([]DBValue, error) {
+	if len(buf) < columnPageHeaderSize {
+		return nil, fmt.Errorf("short column page")
+	}
+	enc := buf[0]
+	n := int(binary.LittleEndian.Uint32(buf[1:]))
+	if n > PageSize {
+		return nil, fmt.Errorf("corrupt column page")
+	}
+	r := bytes.NewReader(buf[columnPageHeaderSize:])
+	values := make([]DBValue, 0, n)
+	switch enc {
+	case plainEncoding:
+		for len(values) < n {
+			v, err := readPlain(r, t)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+		}
+	case rleEncoding:
+		for len(values) < n {
+			v, err := readCompact(r, t)
+			if err != nil {
+				return nil, err
+			}
+			run, err := binary.ReadUvarint(r)
+			if err != nil {
+				return nil, err
+			}
+			if run > uint64(n-len(values)) {
+				return nil, fmt.Errorf("corrupt run length")
+			}
+			for ; run > 0; run-- {
+				values = append(values, v)
+			}
+		}
+	case dictEncoding:
+		size, err := binary.ReadUvarint(r)
+		if err != nil || size > uint64(n) {
+			return nil, fmt.Errorf("corrupt dictionary")
+		}
+		dict := make([]DBValue, size)
+		for i := range dict {
+			if dict[i], err = readCompact(r, t); err != nil {
+				return nil, err
+			}
+		}
+		for len(values) < n {
+			i, err := binary.ReadUvarint(r)
+			if err != nil {
+				return nil, err
+			}
+			if i >= size {
+				return nil, fmt.Errorf("corrupt dictionary index")
+			}
+			values = append(values, dict[i])
+		}
+	default:
+		return nil, fmt.Errorf("unknown column encoding %d", enc)
+	}
+	return values, nil
+}
+
+// A filter on a column that a scan checks against zone maps.
+type zonePredicate struct {
+	col   int
+	op    BoolOp
+	value Expr
+}
+
+// Return whether no value between min and max can satisfy "value op v".
+func (z zonePredicate) excludes(min, max DBValue, v DBValue) bool {
+	if fieldType(v) != fieldType(min) {
+		return false
+	}
+	switch z.op {
+	case OpEq:
+		return v.EvalPred(min, OpLt) || v.EvalPred(max, OpGt)
+	case OpLt:
+		return min.EvalPred(v, OpGe)
+	case OpLe:
+		return min.EvalPred(v, OpGt)
+	case OpGt:
+		return max.EvalPred(v, OpLe)
+	case OpGe:
+		return max.EvalPred(v, OpLt)
+	}
+	return false
+}
+
+// A scan of a ColumnarFile that reads only some of its columns.
+type ColumnarScan struct {
+	file *ColumnarFile
+	// the columns read, or nil for all of them
+	columns []bool
+	// filters above the scan; row groups whose zone maps show that no row
+	// passes them are skipped
+	preds []zonePredicate
+}
+
+func newColumnarScan(file *ColumnarFile, columns []bool, preds []zonePredicate) *ColumnarScan {
+	return &ColumnarScan{file: file, columns: columns, preds: preds}
+}
+
+func (s *ColumnarScan) Descriptor() *TupleDesc {
+	return s.file.Descriptor()
+}
+
+func (s *ColumnarScan) reads(col int) bool {
+	return s.columns == nil || s.columns[col]
+}
+
+func (s *ColumnarScan) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	f := s.file
+	desc := *f.Descriptor()
+	consts := make([]DBValue, len(s.preds))
+	for i, z := range s.preds {
+		v, err := z.value.EvalExpr(nil)
+		if err != nil {
+			return nil, err
+		}
+		consts[i] = v
+	}
+	group, row := 0, 0
+	var header *columnarHeaderPage
+	values := make([][]DBValue, len(desc.Fields))
+
+	// Read the pages of the next row group that may have matching rows,
+	// returning false at the end of the file.
+	nextGroup := func() (bool, error) {
+	groups:
+		for ; group < f.numGroups(); group++ {
+			first := group * f.groupPages()
+			pg, err := f.bufPool.GetPage(f, first, tid, ReadPerm)
+			if err != nil {
+				return false, err
+			}
+			header = pg.(*columnarHeaderPage)
+			if header.numRows == 0 {
+				continue
+			}
+			for i, z := range s.preds {
+				if z.excludes(header.min[z.col], header.max[z.col], consts[i]) {
+					continue groups
+				}
+			}
+			for col := range values {
+				values[col] = nil
+				if !s.reads(col) {
+					continue
+				}
+				pg, err := f.bufPool.GetPage(f, first+1+col, tid, ReadPerm)
+				if err != nil {
+					return false, err
+				}
+				values[col] = pg.(*columnarPage).values
+				if len(values[col]) < header.numRows {
+					return false, fmt.Errorf("row group %d of %s is corrupt", group, f.backingFile)
+				}
+			}
+			group++
+			row = 0
+			return true, nil
+		}
+		return false, nil
+	}
+
+	return func() (*Tuple, error) {
+		for {
+			if header == nil || row >= header.numRows {
+				ok, err := nextGroup()
+				if !ok || err != nil {
+					header = nil
+					return nil, err
+				}
+			}
+			r := row
+			row++
+			if header.deleted[r] {
+				continue
+			}
+			fields := make([]DBValue, len(desc.Fields))
+			for col, f := range desc.Fields {
+				if values[col] != nil {
+					fields[col] = loadedValue(values[col][r])
+				} else {
+					fields[col] = zeroValue(f.Ftype)
+				}
+			}
+			return &Tuple{Desc: desc, Fields: fields, Rid: columnarRecordID{(group - 1) * s.file.groupPages(), r}}, nil
+		}
+	}, nil
+}
+
+// Replace the columnar tables scanned by plan with scans that read only the
+// columns that plan uses, and that skip row groups using the filters
+// directly above them, and the heap files scanned by its joins with scans
+// that return only those columns, so that joined rows are narrower.  Called
+// on every plan made by makePhysicalPlan.
+func pruneScanColumns(plan Operator) {
+	var used []FieldType
+	used = append(used, plan.Descriptor().Fields...)
+	pruneColumns(plan, used, nil, false)
+}
+
+// joined is whether the rows of op are joined with those of another input.
+func pruneColumns(op Operator, used []FieldType, preds []*Filter, joined bool) {
+	addExprs := func(exprs ...Expr) {
+		for _, e := range exprs {
+			used = append(used, exprFields(e)...)
+		}
+	}
+	switch op := op.(type) {
+	case *OperatorCard:
+		switch file := op.Op.(type) {
+		case *ColumnarFile:
+			op.Op = planColumnarScan(file, used, preds)
+			return
+		case *HeapFile:
+			if !joined {
+				return
+			}
+			if scan := planHeapColumnScan(file, used); scan != nil {
+				op.Op = scan
+			}
+			return
+		case *heapColumnScan:
+			// a table joined with itself may share one scan; read the
+			// columns both of its uses need
+			used = append(used, file.desc.Fields...)
+			op.Op = file.scan
+			if scan := planHeapColumnScan(file.scan.(*HeapFile), used); scan != nil {
+				op.Op = scan
+			}
+			return
+		}
+		pruneColumns(op.Op, used, preds, joined)
+	case *Filter:
+		addExprs(op.left, op.right)
+		pruneColumns(op.child, used, append(preds, op), joined)
+	case *Project:
+		addExprs(op.selectFields...)
+		pruneColumns(op.child, used, nil, false)
+	case *OrderBy:
+		addExprs(op.orderBy...)
+		pruneColumns(op.child, used, nil, false)
+	case *LimitOp:
+		pruneColumns(op.child, used, nil, false)
+	case *Aggregator:
+		addExprs(op.groupByFields...)
+		for _, as := range op.newAggState {
+			e := aggStateExpr(as)
+			if e == nil {
+				// an aggregate whose input is unknown may use any column
+				return
+			}
+			addExprs(e)
+		}
+		pruneColumns(op.child, used, nil, false)
+	case *Window:
+		for _, f := range op.funcs {
+			addExprs(f.exprs()...)
+		}
+		pruneColumns(op.child, used, nil, false)
+	case *EqualityJoin:
+		addExprs(op.leftField, op.rightField)
+		pruneColumns(*op.left, used, nil, true)
+		pruneColumns(*op.right, used, nil, true)
+	}
+	// other operators are left scanning all of the columns of their inputs
+}
+
+// Return which of the fields of desc are in used.  Fields are matched by name
+// only, since the scans of a table joined with itself share its descriptor,
+// whose qualifier is the alias of only one of them.
+func usedColumns(desc *TupleDesc, used []FieldType) []bool {
+	columns := make([]bool, len(desc.Fields))
+	for i, f := range desc.Fields {
+		for _, u := range used {
+			if u.Fname == f.Fname {
+				columns[i] = true
+				break
+			}
+		}
+	}
+	return columns
+}
+
+// Return a scan of file reading the columns in used, and checking the
+// filters in preds that compare one of its columns to a constant.
+func planColumnarScan(file *ColumnarFile, used []FieldType, preds []*Filter) *ColumnarScan {
+	desc := file.Descriptor()
+	columns := usedColumns(desc, used)
+	var zones []zonePredicate
+	for _, p := range preds {
+		field, ok := p.left.(*FieldExpr)
+		if _, isConst := p.right.(*ConstExpr); !ok || !isConst {
+			continue
+		}
+		if col, err := findFieldInTd(field.selectField, desc); err == nil {
+			zones = append(zones, zonePredicate{col, p.op, p.right})
+		}
+	}
+	return newColumnarScan(file, columns, zones)
+}
+
+// Return the fields that e refers to.
+func exprFields(e Expr) []FieldType {
+	switch e := e.(type) {
+	case *FieldExpr:
+		return []FieldType{e.selectField}
+	case *FuncExpr:
+		var fields []FieldType
+		for _, arg := range e.args {
+			fields = append(fields, exprFields(*arg)...)
+		}
+		return fields
+	}
+	return nil
+}
+
+// Return the expression an aggregate is computed over, or nil if it is not
+// known.
+func aggStateExpr(as AggState) Expr {
+	switch as := as.(type) {
+	case *CountAggState:
+		return as.expr
+	case *SumAggState:
+		return as.expr
+	case *AvgAggState:
+		return as.expr
+	case *MaxAggState:
+		return as.expr
+	case *MinAggState:
+		return as.expr
+	}
+	return nil
+}
+
+func (f *ColumnarFile) Explain() (string, string) {
+	return "Columnar Scan", f.BackingFile()
+}
+
+func (f *ColumnarFile) Children() []Operator {
+	return nil
+}
+
+func (s *ColumnarScan) Explain() (string, string) {
+	var cols []string
+	for i, f := range s.file.Desc.Fields {
+		if s.reads(i) {
+			cols = append(cols, f.Fname)
+		}
+	}
+	return "Columnar Scan", fmt.Sprintf("%s (%s)", s.file.BackingFile(), strings.Join(cols, ","))
+}
+
+func (s *ColumnarScan) Children() []Operator {
+	return nil
+}
+
+// The zero value of type t.
+func zeroValue(t DBType) DBValue {
+	if t == StringType {
+		return StringField{""}
+	}
+	return IntField{0}
+}
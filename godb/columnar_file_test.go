@@ -0,0 +1,182 @@
+package godb
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestColumnarEncodings(t *testing.T) {
+	var constant, lowCard, distinct, names []DBValue
+	for i := 0; i < 500; i++ {
+		constant = append(constant, IntField{7})
+		lowCard = append(lowCard, IntField{int64(i % 5 * 1000000)})
+		distinct = append(distinct, IntField{int64(i+1) << 50})
+		names = append(names, StringField{[]string{"red", "green", "blue"}[i%3]})
+	}
+	for _, c := range []struct {
+		name   string
+		values []DBValue
+		typ    DBType
+		enc    byte
+	}{
+		{"constant", constant, IntType, rleEncoding},
+		{"low cardinality", lowCard, IntType, dictEncoding},
+		{"distinct", distinct, IntType, plainEncoding},
+		{"strings", names, StringType, dictEncoding},
+		{"empty", nil, IntType, plainEncoding},
+	} {
+		buf := encodeColumn(c.values, c.typ)
+		if buf[0] != c.enc {
+			t.Errorf("%s: expected encoding %d, got %d", c.name, c.enc, buf[0])
+		}
+		values, err := decodeColumn(append(buf, make([]byte, 8)...), c.typ)
+		if err != nil {
+			t.Fatalf("%s: decode failed, %s", c.name, err.Error())
+		}
+		if len(values) != len(c.values) {
+			t.Fatalf("%s: expected %d values, got %d", c.name, len(c.values), len(values))
+		}
+		for i := range values {
+			if values[i] != c.values[i] {
+				t.Fatalf("%s: value %d is %v, expected %v", c.name, i, values[i], c.values[i])
+			}
+		}
+	}
+}
+
+func TestColumnarTable(t *testing.T) {
+	s, dir := makeConstraintTestSession(t, "create table events (id int primary key, kind varchar, amount int) with (storage = columnar)")
+	if _, err := s.Execute("insert into events values (1, 'click', 5), (2, 'view', 7), (3, 'click', 1)"); err != nil {
+		t.Fatalf("insert failed, %s", err.Error())
+	}
+	checkAlterQuery(t, s, "select kind, sum(amount) as total from events group by kind order by kind",
+		[]DBValue{StringField{"click"}, IntField{6}}, []DBValue{StringField{"view"}, IntField{7}})
+	checkAlterQuery(t, s, "select storage from godb_tables where table_name = 'events'", []DBValue{StringField{"columnar"}})
+	expectViolation(t, s, "insert into events values (1, 'view', 2)")
+	if _, err := s.Execute("insert into events values (4, nullif('a', 'a'), nullif(1, 1))"); err != nil {
+		t.Fatalf("insert failed, %s", err.Error())
+	}
+	checkAlterQuery(t, s, "select kind, amount from events where id = 4", []DBValue{nil, nil})
+	if _, err := s.Execute("delete from events where id = 4"); err != nil {
+		t.Fatalf("delete failed, %s", err.Error())
+	}
+
+	if _, err := s.Execute("delete from events where kind = 'view'"); err != nil {
+		t.Fatalf("delete failed, %s", err.Error())
+	}
+	if _, err := s.Execute("alter table events add column score int default 3"); err != nil {
+		t.Fatalf("alter table failed, %s", err.Error())
+	}
+	checkAlterQuery(t, s, "select id, score from events order by id",
+		[]DBValue{IntField{1}, IntField{3}}, []DBValue{IntField{3}, IntField{3}})
+
+	// the table is still columnar, with its rows, when the catalog is read again
+	bp, err := NewBufferPool(100)
+	if err != nil {
+		t.Fatalf("failed to create buffer pool, %s", err.Error())
+	}
+	c, err := NewCatalogFromFile("catalog.txt", bp, dir)
+	if err != nil {
+		t.Fatalf("failed to read catalog, %s", err.Error())
+	}
+	s2 := NewSession(c, bp)
+	checkAlterQuery(t, s2, "select id, amount from events order by id",
+		[]DBValue{IntField{1}, IntField{5}}, []DBValue{IntField{3}, IntField{1}})
+	checkAlterQuery(t, s2, "select storage from godb_tables where table_name = 'events'", []DBValue{StringField{"columnar"}})
+
+	for _, q := range []string{
+		"create table bad (a int) with (storage = rows)",
+		"create table bad (a int) with (fillfactor = 10)",
+	} {
+		if _, err := s.Execute(q); err == nil {
+			t.Errorf("expected %s to fail", q)
+		}
+	}
+}
+
+func TestColumnarScanPruning(t *testing.T) {
+	s, dir := makeConstraintTestSession(t, "create table wide (id int, a varchar, b varchar, c int) with (storage = columnar)")
+	var b strings.Builder
+	const n = 20000
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, "%d,a%d,b%d,%d\n", i, i%10, i, i%3)
+	}
+	path := filepath.Join(dir, "wide.csv")
+	if err := os.WriteFile(path, []byte(b.String()), 0644); err != nil {
+		t.Fatalf("write failed, %s", err.Error())
+	}
+	if _, err := s.Execute("copy wide from '" + path + "'"); err != nil {
+		t.Fatalf("copy failed, %s", err.Error())
+	}
+	checkAlterQuery(t, s, "select count(*) as n, sum(c) as total from wide", []DBValue{IntField{n}, IntField{19999}})
+
+	_, op, err := Parse(s.catalog, "select id from wide where id >= 19990")
+	if err != nil {
+		t.Fatalf("parse failed, %s", err.Error())
+	}
+	var scan *ColumnarScan
+	var find func(Operator)
+	find = func(op Operator) {
+		switch op := op.(type) {
+		case *OperatorCard:
+			if cs, ok := op.Op.(*ColumnarScan); ok {
+				scan = cs
+			}
+			find(op.Op)
+		case *Project:
+			find(op.child)
+		case *Filter:
+			find(op.child)
+		}
+	}
+	find(op)
+	if scan == nil {
+		t.Fatalf("expected a columnar scan in the plan")
+	}
+	if !scan.reads(0) || scan.reads(1) || scan.reads(2) || scan.reads(3) {
+		t.Errorf("expected the scan to read only column id, got %v", scan.columns)
+	}
+
+	bp := s.catalog.bufferPool
+	tid := NewTID()
+	if err := bp.BeginTransaction(tid); err != nil {
+		t.Fatalf("begin failed, %s", err.Error())
+	}
+	iter, err := op.Iterator(tid)
+	if err != nil {
+		t.Fatalf("iterator failed, %s", err.Error())
+	}
+	rows := 0
+	for {
+		tup, err := iter()
+		if err != nil {
+			t.Fatalf("iterator failed, %s", err.Error())
+		}
+		if tup == nil {
+			break
+		}
+		rows++
+	}
+	if rows != 10 {
+		t.Errorf("expected 10 rows, got %d", rows)
+	}
+	// only the header pages, and the id pages of the row groups whose zone
+	// maps allow ids >= 19990, are read
+	file := scan.file
+	read := 0
+	for _, key := range bp.sharedPages[tid] {
+		pageNo := key.(heapHash).PageNo
+		if col := pageNo%file.groupPages() - 1; col > 0 {
+			t.Errorf("page %d of column %d was read", pageNo, col)
+		} else if col == 0 {
+			read++
+		}
+	}
+	if read == 0 || read > 2 {
+		t.Errorf("expected the id pages of one or two row groups to be read, got %d of %d groups", read, file.numGroups())
+	}
+	bp.CommitTransaction(tid)
+}
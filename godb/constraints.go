@@ -0,0 +1,643 @@
+package godb
+
+// Table constraints: NOT NULL, PRIMARY KEY, UNIQUE, and CHECK.  Constraints
+// are declared in CREATE TABLE (see ddl_parser.go), stored in the catalog,
+// and checked by [InsertOp] before each row is inserted.
+//
+// PRIMARY KEY and UNIQUE constraints are checked with an in-memory hash index
+// of the table's keys, built by scanning the table the first time it is
+// needed.  Keys inserted or deleted by a transaction are kept separately
+// until it commits, and a key that another running transaction has inserted
+// or deleted is treated as a duplicate, so two transactions cannot both
+// insert the same key.  Rows written to a table's file directly, rather than
+// through InsertOp and DeleteOp, are not checked and may leave the index out
+// of date.
+//
+// GoDB has no NULL literal, so NOT NULL (which PRIMARY KEY implies) only
+// rejects rows with missing (nil) values.  Following SQL, a CHECK expression
+// that compares a missing value is not violated, and rows with a missing
+// value in a UNIQUE key never conflict.
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/xwb1989/sqlparser"
+)
+
+type constraintKind int
+
+const (
+	notNullConstraint constraintKind = iota
+	primaryKeyConstraint
+	uniqueConstraint
+	checkConstraint
+	foreignKeyConstraint
+)
+
+type constraint struct {
+	name    string
+	kind    constraintKind
+	columns []string // the constrained columns, or the columns a CHECK uses
+	check   string   // the source of a CHECK expression
+	pred    func(t *Tuple) (truth, error)
+	index   *keyIndex // for PRIMARY KEY, UNIQUE, and FOREIGN KEY
+	table   *Table    // the table the constraint belongs to
+
+	// for FOREIGN KEY; see foreign_keys.go
+	refTable   string      // the name of the referenced table, until resolved
+	parent     *Table      // the referenced table
+	refColumns []string    // the referenced columns of parent, matching columns
+	refKey     *constraint // parent's PRIMARY KEY or UNIQUE constraint on refColumns
+	onDelete   fkAction
+}
+
+// Give unnamed constraints the names postgres would, check that they refer
+// to columns of desc, and compile CHECK expressions.
+func prepareConstraints(table string, desc *TupleDesc, cons []*constraint) error {
+	used := make(map[string]bool)
+	hasKey := false
+	for _, con := range cons {
+		if con.kind == primaryKeyConstraint {
+			if hasKey {
+				return GoDBError{ParseError, fmt.Sprintf("multiple primary keys for table %s are not allowed", table)}
+			}
+			hasKey = true
+		}
+		if con.kind == checkConstraint {
+			if err := con.compile(desc); err != nil {
+				return err
+			}
+		}
+		for _, col := range con.columns {
+			if _, err := findFieldInTd(FieldType{col, "", UnknownType}, desc); err != nil {
+				return GoDBError{ParseError, fmt.Sprintf("column %s named in constraint does not exist in table %s", col, table)}
+			}
+		}
+		if con.name != "" {
+			if used[con.name] {
+				return GoDBError{ParseError, fmt.Sprintf("constraint %s specified more than once", con.name)}
+			}
+			used[con.name] = true
+		}
+	}
+	for _, con := range cons {
+		if con.name != "" {
+			continue
+		}
+		base := con.defaultName(table)
+		con.name = base
+		for i := 1; used[con.name]; i++ {
+			con.name = fmt.Sprintf("%s%d", base, i)
+		}
+		used[con.name] = true
+	}
+	return nil
+}
+
+func (con *constraint) defaultName(table string) string {
+	parts := append([]string{table}, con.columns...)
+	switch con.kind {
+	case notNullConstraint:
+		parts = append(parts, "not", "null")
+	case primaryKeyConstraint:
+		parts = []string{table, "pkey"}
+	case uniqueConstraint:
+		parts = append(parts, "key")
+	case checkConstraint:
+		parts = append(parts, "check")
+	case foreignKeyConstraint:
+		parts = append(parts, "fkey")
+	}
+	return strings.Join(parts, "_")
+}
+
+// Return a copy of the constraint with column col renamed to newCol.
+func (con *constraint) renameColumn(col string, newCol string) *constraint {
+	renamed := *con
+	renamed.columns = make([]string, len(con.columns))
+	for i, c := range con.columns {
+		if c == col {
+			c = newCol
+		}
+		renamed.columns[i] = c
+	}
+	if con.kind == checkConstraint {
+		toks := newSqlTokens(con.check).toks
+		for i, tok := range toks {
+			if tok == col {
+				toks[i] = newCol
+			}
+		}
+		renamed.check = strings.Join(toks, " ")
+	}
+	return &renamed
+}
+
+// The catalog syntax of a table constraint.
+func (con *constraint) String() string {
+	return fmt.Sprintf("constraint %s %s", con.name, con.definition())
+}
+
+// The constraint in the syntax of a table constraint, without its name.
+func (con *constraint) definition() string {
+	var def string
+	switch con.kind {
+	case notNullConstraint:
+		def = "not null"
+	case primaryKeyConstraint:
+		def = fmt.Sprintf("primary key (%s)", strings.Join(con.columns, ", "))
+	case uniqueConstraint:
+		def = fmt.Sprintf("unique (%s)", strings.Join(con.columns, ", "))
+	case checkConstraint:
+		def = fmt.Sprintf("check (%s)", con.check)
+	case foreignKeyConstraint:
+		def = fmt.Sprintf("foreign key (%s) references %s (%s)", strings.Join(con.columns, ", "), con.parent.name, strings.Join(con.refColumns, ", "))
+		if con.onDelete != fkRestrict {
+			def += " on delete " + con.onDelete.String()
+		}
+	}
+	return def
+}
+
+// The result of a CHECK expression, which is unknown if it compares a
+// missing value.  AND is the minimum of its arguments and OR the maximum.
+type truth int8
+
+const (
+	truthFalse   truth = -1
+	truthUnknown truth = 0
+	truthTrue    truth = 1
+)
+
+// Compile the constraint's CHECK expression against the columns of desc,
+// recording the columns it uses.
+func (con *constraint) compile(desc *TupleDesc) error {
+	stmt, err := parseSQL("select * from t where " + con.check)
+	if err != nil {
+		return GoDBError{ParseError, fmt.Sprintf("invalid CHECK expression %s", con.check)}
+	}
+	var cols []string
+	pred, err := compileCheck(stmt.(*sqlparser.Select).Where.Expr, desc, &cols)
+	if err != nil {
+		return err
+	}
+	con.pred = pred
+	con.columns = cols
+	return nil
+}
+
+func compileCheck(expr sqlparser.Expr, desc *TupleDesc, cols *[]string) (func(*Tuple) (truth, error), error) {
+	switch expr := expr.(type) {
+	case *sqlparser.ParenExpr:
+		return compileCheck(expr.Expr, desc, cols)
+	case *sqlparser.AndExpr, *sqlparser.OrExpr:
+		var l, r sqlparser.Expr
+		isAnd := false
+		if and, ok := expr.(*sqlparser.AndExpr); ok {
+			l, r, isAnd = and.Left, and.Right, true
+		} else {
+			or := expr.(*sqlparser.OrExpr)
+			l, r = or.Left, or.Right
+		}
+		left, err := compileCheck(l, desc, cols)
+		if err != nil {
+			return nil, err
+		}
+		right, err := compileCheck(r, desc, cols)
+		if err != nil {
+			return nil, err
+		}
+		return func(t *Tuple) (truth, error) {
+			lv, err := left(t)
+			if err != nil {
+				return truthUnknown, err
+			}
+			rv, err := right(t)
+			if isAnd {
+				return min(lv, rv), err
+			}
+			return max(lv, rv), err
+		}, nil
+	case *sqlparser.NotExpr:
+		inner, err := compileCheck(expr.Expr, desc, cols)
+		if err != nil {
+			return nil, err
+		}
+		return func(t *Tuple) (truth, error) {
+			v, err := inner(t)
+			return -v, err
+		}, nil
+	case *sqlparser.ComparisonExpr:
+		op, left, escape, err := comparisonOp(expr)
+		if err != nil {
+			return nil, GoDBError{ParseError, fmt.Sprintf("unsupported operator %s in CHECK expression", expr.Operator)}
+		}
+		return compileComparison(left, op, escape, expr.Right, desc, cols)
+	case *sqlparser.RangeCond:
+		lower, err := compileComparison(expr.Left, OpGe, 0, expr.From, desc, cols)
+		if err != nil {
+			return nil, err
+		}
+		upper, err := compileComparison(expr.Left, OpLe, 0, expr.To, desc, cols)
+		if err != nil {
+			return nil, err
+		}
+		sign := truth(1)
+		if expr.Operator == sqlparser.NotBetweenStr {
+			sign = -1
+		}
+		return func(t *Tuple) (truth, error) {
+			lv, err := lower(t)
+			if err != nil {
+				return truthUnknown, err
+			}
+			uv, err := upper(t)
+			return sign * min(lv, uv), err
+		}, nil
+	case *sqlparser.IsExpr:
+		operand, err := compileOperand(expr.Expr, desc, cols)
+		if err != nil {
+			return nil, err
+		}
+		var want bool
+		switch expr.Operator {
+		case sqlparser.IsNullStr:
+			want = true
+		case sqlparser.IsNotNullStr:
+			want = false
+		default:
+			return nil, GoDBError{ParseError, fmt.Sprintf("unsupported operator %s in CHECK expression", expr.Operator)}
+		}
+		return func(t *Tuple) (truth, error) {
+			v, err := operand.EvalExpr(t)
+			if err != nil {
+				return truthUnknown, err
+			}
+			if (v == nil) == want {
+				return truthTrue, nil
+			}
+			return truthFalse, nil
+		}, nil
+	}
+	return nil, GoDBError{ParseError, fmt.Sprintf("unsupported expression %s in CHECK constraint", sqlparser.String(expr))}
+}
+
+// Compile "l op r", where escape is the escape character of a LIKE pattern.
+func compileComparison(l sqlparser.Expr, op BoolOp, escape rune, r sqlparser.Expr, desc *TupleDesc, cols *[]string) (func(*Tuple) (truth, error), error) {
+	left, err := compileOperand(l, desc, cols)
+	if err != nil {
+		return nil, err
+	}
+	right, err := compileOperand(r, desc, cols)
+	if err != nil {
+		return nil, err
+	}
+	lt, rt := left.GetExprType().Ftype, right.GetExprType().Ftype
+	if lt != rt && lt != UnknownType && rt != UnknownType {
+		return nil, GoDBError{TypeMismatchError, fmt.Sprintf("cannot compare %s and %s in CHECK expression", sqlparser.String(l), sqlparser.String(r))}
+	}
+	matcher := newPatternMatcher(op, escape)
+	return func(t *Tuple) (truth, error) {
+		lv, err := left.EvalExpr(t)
+		if err != nil {
+			return truthUnknown, err
+		}
+		rv, err := right.EvalExpr(t)
+		if err != nil {
+			return truthUnknown, err
+		}
+		if lv == nil || rv == nil {
+			return truthUnknown, nil
+		}
+		holds := false
+		if matcher != nil {
+			if holds, err = matcher.match(lv, rv); err != nil {
+				return truthUnknown, err
+			}
+		} else {
+			holds = lv.EvalPred(rv, op)
+		}
+		if holds {
+			return truthTrue, nil
+		}
+		return truthFalse, nil
+	}, nil
+}
+
+// Compile a value in a CHECK expression: a column, a constant, or a function
+// of them.
+func compileOperand(e sqlparser.Expr, desc *TupleDesc, cols *[]string) (Expr, error) {
+	node, err := parseExpr(nil, e, "")
+	if err != nil {
+		return nil, err
+	}
+	expr, _, err := node.generateExpr(nil, desc, make(map[string]*PlanNode))
+	if err != nil {
+		return nil, err
+	}
+	if len(exprParams(nil, expr)) > 0 {
+		return nil, GoDBError{ParseError, "CHECK expressions cannot have parameters"}
+	}
+	*cols = exprColumns(expr, *cols)
+	return expr, nil
+}
+
+// Append the names of the columns e uses that are not already in cols.
+func exprColumns(e Expr, cols []string) []string {
+	switch e := e.(type) {
+	case *FieldExpr:
+		for _, c := range cols {
+			if c == e.selectField.Fname {
+				return cols
+			}
+		}
+		return append(cols, e.selectField.Fname)
+	case *FuncExpr:
+		for _, arg := range e.args {
+			cols = exprColumns(*arg, cols)
+		}
+	}
+	return cols
+}
+
+// A hash index of the keys of a PRIMARY KEY, UNIQUE, or FOREIGN KEY
+// constraint, counting the rows with each key.
+type keyIndex struct {
+	mutex sync.Mutex
+	bp    *BufferPool
+	cols  []int // positions of the key columns in the table
+	built bool
+	// number of committed rows with each key
+	keys map[any]int
+	// changes to keys by running transactions, applied when they commit
+	pending map[TransactionID]map[any]int
+}
+
+func newKeyIndex(bp *BufferPool) *keyIndex {
+	return &keyIndex{bp: bp}
+}
+
+// Forget the index's contents, e.g., because the table has been rewritten,
+// so that it is built again from the table the next time it is used.
+func (idx *keyIndex) reset(cols []int) {
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+	idx.cols = cols
+	idx.built = false
+	idx.keys = nil
+	idx.pending = nil
+}
+
+// Return the key of row t, or false if one of its key fields is missing.
+func (idx *keyIndex) key(t *Tuple) (any, bool) {
+	fields := make([]DBValue, len(idx.cols))
+	for i, col := range idx.cols {
+		if col >= len(t.Fields) || t.Fields[col] == nil {
+			return nil, false
+		}
+		fields[i] = t.Fields[col]
+	}
+	return (&Tuple{Fields: fields}).tupleKey(), true
+}
+
+// Build the index from the committed rows of file.  Must be called with the
+// index's mutex held.
+func (idx *keyIndex) build(file DBFile) error {
+	if idx.built {
+		return nil
+	}
+	keys := make(map[any]int)
+	tid := NewTID()
+	if err := idx.bp.BeginTransaction(tid); err != nil {
+		return err
+	}
+	iter, err := file.Iterator(tid)
+	if err != nil {
+		idx.bp.AbortTransaction(tid)
+		return err
+	}
+	for {
+		t, err := iter()
+		if err != nil {
+			idx.bp.AbortTransaction(tid)
+			return err
+		}
+		if t == nil {
+			break
+		}
+		if k, ok := idx.key(t); ok {
+			keys[k]++
+		}
+	}
+	if err := idx.bp.CommitTransaction(tid); err != nil {
+		return err
+	}
+	idx.keys = keys
+	idx.pending = make(map[TransactionID]map[any]int)
+	idx.built = true
+	return nil
+}
+
+// Record that transaction tid inserts a row with key k.  If unique, don't,
+// and return false, if that would duplicate a key.
+func (idx *keyIndex) insert(file DBFile, tid TransactionID, k any, unique bool) (bool, error) {
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+	if err := idx.build(file); err != nil {
+		return false, err
+	}
+	if unique {
+		if n, busy := idx.count(tid, k); n > 0 || busy {
+			return false, nil
+		}
+	}
+	idx.change(tid, k, 1)
+	return true, nil
+}
+
+// Return the number of rows with key k that tid can see, and whether
+// another running transaction has inserted or deleted rows with key k.
+func (idx *keyIndex) lookup(file DBFile, tid TransactionID, k any) (int, bool, error) {
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+	if err := idx.build(file); err != nil {
+		return 0, false, err
+	}
+	n, busy := idx.count(tid, k)
+	return n, busy, nil
+}
+
+// Must be called with the index's mutex held.
+func (idx *keyIndex) count(tid TransactionID, k any) (int, bool) {
+	for other, changes := range idx.pending {
+		if other != tid && changes[k] != 0 {
+			return idx.keys[k] + idx.pending[tid][k], true
+		}
+	}
+	return idx.keys[k] + idx.pending[tid][k], false
+}
+
+// Record that transaction tid deletes a row with key k.
+func (idx *keyIndex) delete(file DBFile, tid TransactionID, k any) error {
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+	if err := idx.build(file); err != nil {
+		return err
+	}
+	idx.change(tid, k, -1)
+	return nil
+}
+
+// Must be called with the index's mutex held.
+func (idx *keyIndex) change(tid TransactionID, k any, delta int) {
+	changes, ok := idx.pending[tid]
+	if !ok {
+		if !idx.bp.onTransactionEnd(tid, func(committed bool) { idx.finish(tid, committed) }) {
+			// not in a transaction, so the change is permanent
+			idx.keys[k] += delta
+			return
+		}
+		changes = make(map[any]int)
+		idx.pending[tid] = changes
+	}
+	changes[k] += delta
+}
+
+func (idx *keyIndex) finish(tid TransactionID, committed bool) {
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+	changes, ok := idx.pending[tid]
+	if !ok {
+		return
+	}
+	delete(idx.pending, tid)
+	if committed {
+		for k, delta := range changes {
+			idx.keys[k] += delta
+		}
+	}
+}
+
+// Return an error if inserting row t into the table would violate one of its
+// constraints.  Otherwise, record the row's keys as inserted by tid.
+func (t *Table) checkInsert(tid TransactionID, row *Tuple) error {
+	for _, con := range t.constraints {
+		switch con.kind {
+		case notNullConstraint, primaryKeyConstraint:
+			for _, col := range con.columns {
+				if i := t.columnIndex(col); i >= 0 && (i >= len(row.Fields) || row.Fields[i] == nil) {
+					return GoDBError{ConstraintViolationError, fmt.Sprintf("null value in column %s of table %s violates not-null constraint", col, t.name)}
+				}
+			}
+		case checkConstraint:
+			v, err := con.pred(row)
+			if err != nil {
+				return err
+			}
+			if v == truthFalse {
+				return GoDBError{ConstraintViolationError, fmt.Sprintf("new row for table %s violates check constraint %s", t.name, con.name)}
+			}
+		}
+	}
+
+	// add the row's keys to the indexes, then check its foreign keys, which
+	// may reference the row itself
+	type inserted struct {
+		idx *keyIndex
+		key any
+	}
+	var done []inserted
+	fail := func(err error) error {
+		for _, d := range done {
+			d.idx.delete(t.file, tid, d.key)
+		}
+		return err
+	}
+	for _, con := range t.constraints {
+		if con.index == nil {
+			continue
+		}
+		k, ok := con.index.key(row)
+		if !ok {
+			continue
+		}
+		ok, err := con.index.insert(t.file, tid, k, con.kind != foreignKeyConstraint)
+		if err != nil {
+			return fail(err)
+		}
+		if !ok {
+			return fail(GoDBError{ConstraintViolationError, fmt.Sprintf("duplicate key value violates unique constraint %s", con.name)})
+		}
+		done = append(done, inserted{con.index, k})
+	}
+	for _, con := range t.constraints {
+		if con.kind == foreignKeyConstraint {
+			if err := con.checkReference(tid, row); err != nil {
+				return fail(err)
+			}
+		}
+	}
+	return nil
+}
+
+// Record that tid has deleted row t from the table, and carry out the ON
+// DELETE actions of the foreign keys that reference it.
+func (t *Table) noteDelete(tid TransactionID, row *Tuple) error {
+	if err := t.removeKeys(tid, row); err != nil {
+		return err
+	}
+	for _, fk := range t.referencedBy {
+		if err := fk.deleteReferences(tid, row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Remove the keys of row, which tid has deleted, from the table's indexes.
+func (t *Table) removeKeys(tid TransactionID, row *Tuple) error {
+	for _, con := range t.constraints {
+		if con.index == nil {
+			continue
+		}
+		if k, ok := con.index.key(row); ok {
+			if err := con.index.delete(t.file, tid, k); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Rebuild the table's indexes the next time they are used, after its file
+// or columns have changed.
+func (t *Table) resetIndexes() {
+	for _, con := range t.constraints {
+		if con.index == nil {
+			continue
+		}
+		cols := make([]int, len(con.columns))
+		for i, col := range con.columns {
+			cols[i] = t.columnIndex(col)
+		}
+		con.index.reset(cols)
+	}
+}
+
+// Return the first constraint of t other than NOT NULL that uses column col,
+// or nil.
+func (t *Table) constraintUsing(col string) *constraint {
+	for _, con := range t.constraints {
+		if con.kind == notNullConstraint {
+			continue
+		}
+		for _, c := range con.columns {
+			if c == col {
+				return con
+			}
+		}
+	}
+	return nil
+}
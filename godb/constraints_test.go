@@ -0,0 +1,123 @@
+package godb
+
+import (
+	"errors"
+	"testing"
+)
+
+// Make a session on a scratch catalog with a table created by create.
+func makeConstraintTestSession(t *testing.T, create string) (*Session, string) {
+	t.Helper()
+	bp, err := NewBufferPool(100)
+	if err != nil {
+		t.Fatalf("failed to create buffer pool, %s", err.Error())
+	}
+	dir := t.TempDir()
+	s := NewSession(NewCatalog("catalog.txt", bp, dir), bp)
+	if _, err := s.Execute(create); err != nil {
+		t.Fatalf("%s failed, %s", create, err.Error())
+	}
+	return s, dir
+}
+
+func expectViolation(t *testing.T, s *Session, q string) {
+	t.Helper()
+	_, err := s.Execute(q)
+	var gerr GoDBError
+	if !errors.As(err, &gerr) || gerr.code != ConstraintViolationError {
+		t.Errorf("expected %s to violate a constraint, got %v", q, err)
+	}
+}
+
+func TestConstraintsPrimaryKeyUnique(t *testing.T) {
+	s, _ := makeConstraintTestSession(t, "create table people (id int primary key, email text, age int, unique (email))")
+	if _, err := s.Execute("insert into people values (1, 'a@x', 30), (2, 'b@x', 40)"); err != nil {
+		t.Fatalf("insert failed, %s", err.Error())
+	}
+	expectViolation(t, s, "insert into people values (1, 'c@x', 50)")
+	expectViolation(t, s, "insert into people values (3, 'a@x', 50)")
+	// duplicates within one statement are caught too, and the statement
+	// has no effect
+	expectViolation(t, s, "insert into people values (3, 'c@x', 50), (3, 'd@x', 60)")
+	checkAlterQuery(t, s, "select id from people where id = 3")
+
+	// a deleted key can be reused
+	if _, err := s.Execute("delete from people where id = 1"); err != nil {
+		t.Fatalf("delete failed, %s", err.Error())
+	}
+	if _, err := s.Execute("insert into people values (1, 'a@x', 35)"); err != nil {
+		t.Fatalf("insert of a deleted key failed, %s", err.Error())
+	}
+	checkAlterQuery(t, s, "select age from people where id = 1", []DBValue{IntField{35}})
+}
+
+func TestConstraintsTransactions(t *testing.T) {
+	s, _ := makeConstraintTestSession(t, "create table t (id int, constraint t_id primary key (id))")
+	other := NewSession(s.catalog, s.bp)
+	for _, q := range []string{"begin", "insert into t values (1)"} {
+		if _, err := s.Execute(q); err != nil {
+			t.Fatalf("%s failed, %s", q, err.Error())
+		}
+	}
+	// the key is taken by the uncommitted insert
+	expectViolation(t, other, "insert into t values (1)")
+	if _, err := s.Execute("rollback"); err != nil {
+		t.Fatalf("rollback failed, %s", err.Error())
+	}
+	if _, err := other.Execute("insert into t values (1)"); err != nil {
+		t.Fatalf("insert after rollback failed, %s", err.Error())
+	}
+	expectViolation(t, s, "insert into t values (1)")
+}
+
+func TestConstraintsCheck(t *testing.T) {
+	s, _ := makeConstraintTestSession(t, "create table t (name text not null, age int check (age >= 0 and age < 150), check (name <> 'root' or age > 20))")
+	if _, err := s.Execute("insert into t values ('sam', 25), ('root', 30)"); err != nil {
+		t.Fatalf("insert failed, %s", err.Error())
+	}
+	expectViolation(t, s, "insert into t values ('amy', -1)")
+	expectViolation(t, s, "insert into t values ('root', 10)")
+
+	tab, _ := s.catalog.GetTableInfo("t")
+	if err := tab.checkInsert(NewTID(), &Tuple{Desc: tab.desc, Fields: []DBValue{nil, IntField{3}}}); err == nil {
+		t.Errorf("expected a missing value to violate NOT NULL")
+	}
+
+	for _, q := range []string{
+		"create table bad (a int, check (b > 0))",
+		"create table bad (a int primary key, b int primary key)",
+		"create table bad (a int, unique (b))",
+		"create table bad (a int, check (a > 'x'))",
+	} {
+		if _, err := s.Execute(q); err == nil {
+			t.Errorf("expected %s to fail", q)
+		}
+	}
+}
+
+func TestConstraintsCatalog(t *testing.T) {
+	s, dir := makeConstraintTestSession(t, "create table t (id int primary key, name text not null unique, age int check (age > 0))")
+	if _, err := s.Execute("alter table t drop column id"); err == nil {
+		t.Errorf("expected an error dropping a primary key column")
+	}
+	if _, err := s.Execute("alter table t rename column age to years"); err != nil {
+		t.Fatalf("rename failed, %s", err.Error())
+	}
+	if _, err := s.Execute("insert into t values (1, 'sam', 25)"); err != nil {
+		t.Fatalf("insert failed, %s", err.Error())
+	}
+
+	// the constraints survive reloading the catalog
+	bp, _ := NewBufferPool(100)
+	c, err := NewCatalogFromFile("catalog.txt", bp, dir)
+	if err != nil {
+		t.Fatalf("failed to reload catalog, %s", err.Error())
+	}
+	s = NewSession(c, bp)
+	expectViolation(t, s, "insert into t values (1, 'amy', 30)")
+	expectViolation(t, s, "insert into t values (2, 'sam', 30)")
+	expectViolation(t, s, "insert into t values (2, 'amy', 0)")
+	if _, err := s.Execute("insert into t values (2, 'amy', 30)"); err != nil {
+		t.Fatalf("insert failed, %s", err.Error())
+	}
+}
@@ -0,0 +1,603 @@
+package godb
+
+// COPY statements, which copy rows between tables and files:
+//
+//	COPY table FROM 'file' [[WITH] (option, ...)]
+//	COPY table TO 'file' [[WITH] (option, ...)]
+//	COPY (SELECT ...) TO 'file' [[WITH] (option, ...)]
+//
+// where the options are
+//
+//	FORMAT csv | tsv | jsonl   the file format, csv by default
+//	HEADER [true | false]      the first line holds column names (csv and tsv)
+//	DELIMITER 'c'              the field separator (csv only)
+//
+// CSV files follow RFC 4180: fields holding the delimiter, quotes or line
+// breaks are quoted.  TSV files are postgres text format: fields are
+// separated by tabs, and tabs, line breaks and backslashes in fields are
+// escaped with backslashes.  JSON Lines files have one object per row,
+// mapping column names to values.
+//
+// COPY FROM inserts the rows with an [InsertOp], so constraints are checked
+// and the rows are inserted in the statement's transaction.  COPY TO writes
+// the rows of the table or query's plan.  Relative file names are relative
+// to the working directory of the process, unless the session is restricted
+// to a copy directory with [Session.RestrictCopy], as the sessions of a
+// [Server] are.
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/xwb1989/sqlparser"
+)
+
+var copyRegexp = regexp.MustCompile(`(?is)^\s*copy\b`)
+var copyQueryRegexp = regexp.MustCompile(`(?is)^\s*copy\s*\((.*)\)\s*to\s+'((?:[^']|'')*)'(.*)$`)
+var copyTableRegexp = regexp.MustCompile(`(?is)^\s*copy\s+([A-Za-z_][A-Za-z0-9_]*)\s+(from|to)\s+'((?:[^']|'')*)'(.*)$`)
+
+type CopyOptions struct {
+	// "csv", "tsv" or "jsonl"
+	Format string
+	Header bool
+	// the field separator of csv files
+	Delimiter rune
+}
+
+// An operator that reads the rows of a file.
+type CopyFromOp struct {
+	desc *TupleDesc
+	path string
+	opts CopyOptions
+}
+
+// Construct an operator that returns the rows with columns desc read from the
+// file at path.
+func NewCopyFromOp(desc *TupleDesc, path string, opts CopyOptions) *CopyFromOp {
+	return &CopyFromOp{desc: desc, path: path, opts: opts}
+}
+
+func (op *CopyFromOp) Descriptor() *TupleDesc {
+	return op.desc
+}
+
+func (op *CopyFromOp) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	f, err := os.Open(op.path)
+	if err != nil {
+		return nil, err
+	}
+	rows := newFormatReader(f, op.desc, op.opts)
+	return func() (*Tuple, error) {
+		if f == nil {
+			return nil, nil
+		}
+		if err := checkCancelled(tid); err != nil {
+			f.Close()
+			f = nil
+			return nil, err
+		}
+		fields, _, err := rows()
+		if fields == nil || err != nil {
+			f.Close()
+			f = nil
+			return nil, err
+		}
+		return &Tuple{Desc: *op.desc, Fields: fields}, nil
+	}, nil
+}
+
+// An operator that writes the rows of its child to a file, returning a
+// single tuple with the number of rows written.
+type CopyToOp struct {
+	child Operator
+	path  string
+	opts  CopyOptions
+}
+
+func NewCopyToOp(child Operator, path string, opts CopyOptions) *CopyToOp {
+	return &CopyToOp{child: child, path: path, opts: opts}
+}
+
+// The copy TupleDesc is a one column descriptor with an integer field named
+// "count".
+func (op *CopyToOp) Descriptor() *TupleDesc {
+	return &TupleDesc{Fields: []FieldType{{Fname: "count", Ftype: IntType}}}
+}
+
+func (op *CopyToOp) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	done := false
+	return func() (*Tuple, error) {
+		if done {
+			return nil, nil
+		}
+		done = true
+		n, err := op.write(tid)
+		if err != nil {
+			return nil, err
+		}
+		return &Tuple{Desc: *op.Descriptor(), Fields: []DBValue{IntField{n}}}, nil
+	}, nil
+}
+
+func (op *CopyToOp) write(tid TransactionID) (int64, error) {
+	iter, err := op.child.Iterator(tid)
+	if err != nil {
+		return 0, err
+	}
+	f, err := os.Create(op.path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	desc := op.child.Descriptor()
+	write, flush := newFormatWriter(w, desc, op.opts)
+	if op.opts.Header {
+		names := make([]DBValue, len(desc.Fields))
+		for i, field := range desc.Fields {
+			names[i] = StringField{field.Fname}
+		}
+		if err := write(names); err != nil {
+			return 0, err
+		}
+	}
+	n := int64(0)
+	for {
+		tup, err := iter()
+		if err != nil {
+			return 0, err
+		}
+		if tup == nil {
+			break
+		}
+		if err := write(tup.Fields); err != nil {
+			return 0, err
+		}
+		n++
+	}
+	if err := flush(); err != nil {
+		return 0, err
+	}
+	if err := w.Flush(); err != nil {
+		return 0, err
+	}
+	return n, f.Close()
+}
+
+func parseCopy(c *Catalog, query string) (QueryType, Operator, error) {
+	if m := copyQueryRegexp.FindStringSubmatch(query); m != nil {
+		opts, err := parseCopyOptions(m[3])
+		if err != nil {
+			return UnknownQueryType, nil, err
+		}
+		op, err := planCopySource(c, m[1])
+		if err != nil {
+			return UnknownQueryType, nil, err
+		}
+		return IteratorType, NewCopyToOp(op, unquote(m[2]), opts), nil
+	}
+	m := copyTableRegexp.FindStringSubmatch(query)
+	if m == nil {
+		return UnknownQueryType, nil, GoDBError{ParseError, "expected COPY table FROM 'file', COPY table TO 'file', or COPY (query) TO 'file'"}
+	}
+	name, path := strings.ToLower(m[1]), unquote(m[3])
+	opts, err := parseCopyOptions(m[4])
+	if err != nil {
+		return UnknownQueryType, nil, err
+	}
+	if strings.ToLower(m[2]) == "to" {
+		op, err := planCopySource(c, "select * from "+name)
+		if err != nil {
+			return UnknownQueryType, nil, err
+		}
+		return IteratorType, NewCopyToOp(op, path, opts), nil
+	}
+	t, err := c.writableTable(name)
+	if err != nil {
+		return UnknownQueryType, nil, err
+	}
+	insertOp := NewInsertOp(t.file, NewCopyFromOp(&t.desc, path, opts))
+	insertOp.table = t
+	return IteratorType, insertOp, nil
+}
+
+// Plan the query whose rows COPY TO writes.
+func planCopySource(c *Catalog, query string) (Operator, error) {
+	stmt, err := parseSQL(query)
+	if err != nil {
+		return nil, err
+	}
+	sel, ok := stmt.(sqlparser.SelectStatement)
+	if !ok {
+		return nil, GoDBError{ParseError, "COPY requires a SELECT"}
+	}
+	plan, err := parseQuery(c, sel)
+	if err != nil {
+		return nil, err
+	}
+	return makePhysicalPlan(c, plan)
+}
+
+// Return the absolute path of dir, with symbolic links resolved, for
+// [Session.RestrictCopy].
+func resolveCopyDir(dir string) (string, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(abs)
+}
+
+// Restrict the files the COPY statements of the session read and write to
+// those in dir, relative to which their names are then resolved, or forbid
+// COPY of files altogether if dir is empty.
+func (s *Session) RestrictCopy(dir string) error {
+	if dir != "" {
+		var err error
+		if dir, err = resolveCopyDir(dir); err != nil {
+			return err
+		}
+	}
+	s.copyRestricted, s.copyDir = true, dir
+	return nil
+}
+
+// Check that the session may read and write the files of the COPY operators
+// in plan, replacing their names with the paths they resolve to in the
+// session's copy directory.
+func (s *Session) checkCopyFiles(plan Operator) error {
+	if !s.copyRestricted {
+		return nil
+	}
+	var err error
+	switch op := plan.(type) {
+	case *CopyFromOp:
+		op.path, err = s.copyPath(op.path)
+	case *CopyToOp:
+		op.path, err = s.copyPath(op.path)
+	}
+	if err != nil {
+		return err
+	}
+	if e, ok := plan.(Explainable); ok {
+		for _, child := range e.Children() {
+			if err := s.checkCopyFiles(child); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Resolve the name of a file COPY reads or writes in the session's copy
+// directory, returning an error if it is outside of it, e.g., because the
+// name holds ".." or a symbolic link leads elsewhere.
+func (s *Session) copyPath(path string) (string, error) {
+	if s.copyDir == "" {
+		return "", GoDBError{IllegalOperationError, "COPY to or from a file is not allowed in this session"}
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(s.copyDir, path)
+	}
+	// COPY TO may create the file, so only its directory must exist
+	dir, err := filepath.EvalSymlinks(filepath.Dir(filepath.Clean(path)))
+	if err != nil {
+		return "", GoDBError{IllegalOperationError, fmt.Sprintf("COPY file %s is not in the copy directory", path)}
+	}
+	resolved := filepath.Join(dir, filepath.Base(path))
+	if target, err := filepath.EvalSymlinks(resolved); err == nil {
+		resolved = target
+	}
+	rel, err := filepath.Rel(s.copyDir, resolved)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", GoDBError{IllegalOperationError, fmt.Sprintf("COPY file %s is not in the copy directory", path)}
+	}
+	return resolved, nil
+}
+
+// Return the contents of a quoted string, without its quotes.
+func unquote(s string) string {
+	return strings.ReplaceAll(s, "''", "'")
+}
+
+// Parse the options following the file name of a COPY statement.
+func parseCopyOptions(text string) (CopyOptions, error) {
+	opts := CopyOptions{Format: "csv", Delimiter: ','}
+	toks := newSqlTokens(text)
+	toks.accept("with")
+	paren := toks.accept("(")
+	delimiter := false
+	for !toks.done() && toks.peek() != ")" {
+		switch opt := toks.next(); opt {
+		case "format":
+			opts.Format = toks.next()
+		case "csv", "tsv", "jsonl":
+			opts.Format = opt
+		case "header":
+			opts.Header = true
+			switch toks.peek() {
+			case "true", "on":
+				toks.next()
+			case "false", "off":
+				toks.next()
+				opts.Header = false
+			}
+		case "delimiter":
+			v, err := toks.constant()
+			s, ok := v.(StringField)
+			if err != nil || !ok || utf8.RuneCountInString(s.Value) != 1 {
+				return opts, toks.errorf("the delimiter must be a single character")
+			}
+			opts.Delimiter, _ = utf8.DecodeRuneInString(s.Value)
+			delimiter = true
+		default:
+			return opts, toks.errorf("expected FORMAT, HEADER, or DELIMITER")
+		}
+		toks.accept(",")
+	}
+	if paren {
+		if err := toks.expect(")"); err != nil {
+			return opts, err
+		}
+	}
+	if !toks.done() {
+		return opts, toks.errorf("unexpected token")
+	}
+	switch opts.Format {
+	case "csv":
+	case "tsv", "jsonl":
+		if delimiter {
+			return opts, GoDBError{ParseError, fmt.Sprintf("cannot specify DELIMITER in %s format", opts.Format)}
+		}
+		if opts.Format == "jsonl" && opts.Header {
+			return opts, GoDBError{ParseError, "cannot specify HEADER in jsonl format"}
+		}
+	default:
+		return opts, GoDBError{ParseError, fmt.Sprintf("unsupported COPY format %s", opts.Format)}
+	}
+	return opts, nil
+}
+
+// Return a rowReader for the rows of r, with columns desc, in the format of
+// opts.
+func newFormatReader(r io.Reader, desc *TupleDesc, opts CopyOptions) rowReader {
+	switch opts.Format {
+	case "tsv":
+		return newTSVReader(r, desc, opts.Header)
+	case "jsonl":
+		return newJSONLReader(r, desc)
+	}
+	return newCSVReader(r, desc, opts.Delimiter, opts.Header, false)
+}
+
+// Return functions that write a row to w in the format of opts, and that
+// finish writing.
+func newFormatWriter(w io.Writer, desc *TupleDesc, opts CopyOptions) (func([]DBValue) error, func() error) {
+	switch opts.Format {
+	case "tsv":
+		return func(fields []DBValue) error {
+			parts := make([]string, len(fields))
+			for i, f := range fields {
+				parts[i] = tsvEscaper.Replace(valueText(f))
+			}
+			_, err := io.WriteString(w, strings.Join(parts, "\t")+"\n")
+			return err
+		}, func() error { return nil }
+	case "jsonl":
+		return func(fields []DBValue) error {
+			var b strings.Builder
+			b.WriteByte('{')
+			for i, f := range fields {
+				if i > 0 {
+					b.WriteByte(',')
+				}
+				name, _ := json.Marshal(desc.Fields[i].Fname)
+				b.Write(name)
+				b.WriteByte(':')
+				var v []byte
+				switch f := f.(type) {
+				case IntField:
+					v = []byte(strconv.FormatInt(f.Value, 10))
+				default:
+					v, _ = json.Marshal(valueText(f))
+				}
+				b.Write(v)
+			}
+			b.WriteString("}\n")
+			_, err := io.WriteString(w, b.String())
+			return err
+		}, func() error { return nil }
+	}
+	cw := csv.NewWriter(w)
+	cw.Comma = opts.Delimiter
+	return func(fields []DBValue) error {
+			record := make([]string, len(fields))
+			for i, f := range fields {
+				record[i] = valueText(f)
+			}
+			return cw.Write(record)
+		}, func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+}
+
+func valueText(v DBValue) string {
+	switch v := v.(type) {
+	case IntField:
+		return strconv.FormatInt(v.Value, 10)
+	case StringField:
+		return v.Value
+	}
+	return ""
+}
+
+// Return a rowReader for CSV with the given delimiter.
+func newCSVReader(r io.Reader, desc *TupleDesc, delimiter rune, header bool, skipLastField bool) rowReader {
+	cr := csv.NewReader(r)
+	cr.Comma = delimiter
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+	first := true
+	return func() ([]DBValue, int, error) {
+		for {
+			record, err := cr.Read()
+			if err == io.EOF {
+				return nil, 0, nil
+			}
+			if perr, ok := err.(*csv.ParseError); ok {
+				return nil, perr.StartLine, BulkLoadError{perr.StartLine, GoDBError{MalformedDataError, perr.Err.Error()}}
+			} else if err != nil {
+				return nil, 0, err
+			}
+			line, _ := cr.FieldPos(0)
+			if first && header {
+				first = false
+				continue
+			}
+			first = false
+			if skipLastField && len(record) > 0 {
+				record = record[:len(record)-1]
+			}
+			fields, err := textRow(record, desc)
+			if err != nil {
+				return nil, line, BulkLoadError{line, err}
+			}
+			return fields, line, nil
+		}
+	}
+}
+
+var tsvEscaper = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`)
+var tsvUnescaper = strings.NewReplacer(`\\`, `\`, `\t`, "\t", `\n`, "\n", `\r`, "\r")
+
+// Return a rowReader for postgres text format.
+func newTSVReader(r io.Reader, desc *TupleDesc, header bool) rowReader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	line := 0
+	return func() ([]DBValue, int, error) {
+		for scanner.Scan() {
+			line++
+			if line == 1 && header {
+				continue
+			}
+			text := scanner.Text()
+			if text == "" {
+				continue
+			}
+			parts := strings.Split(text, "\t")
+			for i, p := range parts {
+				parts[i] = tsvUnescaper.Replace(p)
+			}
+			fields, err := textRow(parts, desc)
+			if err != nil {
+				return nil, line, BulkLoadError{line, err}
+			}
+			return fields, line, nil
+		}
+		return nil, line, scanner.Err()
+	}
+}
+
+// Return a rowReader for JSON Lines.
+func newJSONLReader(r io.Reader, desc *TupleDesc) rowReader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	line := 0
+	return func() ([]DBValue, int, error) {
+		for scanner.Scan() {
+			line++
+			text := strings.TrimSpace(scanner.Text())
+			if text == "" {
+				continue
+			}
+			fields, err := jsonRow(text, desc)
+			if err != nil {
+				return nil, line, BulkLoadError{line, err}
+			}
+			return fields, line, nil
+		}
+		return nil, line, scanner.Err()
+	}
+}
+
+// Convert a JSON object to the values of a row with columns desc.
+func jsonRow(text string, desc *TupleDesc) ([]DBValue, error) {
+	dec := json.NewDecoder(strings.NewReader(text))
+	dec.UseNumber()
+	var obj map[string]any
+	if err := dec.Decode(&obj); err != nil {
+		return nil, GoDBError{MalformedDataError, fmt.Sprintf("invalid JSON object: %s", err.Error())}
+	}
+	if len(obj) != len(desc.Fields) {
+		for name := range obj {
+			if findFieldByName(desc, name) < 0 {
+				return nil, GoDBError{MalformedDataError, fmt.Sprintf("unknown column %s", name)}
+			}
+		}
+	}
+	fields := make([]DBValue, len(desc.Fields))
+	for i, f := range desc.Fields {
+		v, ok := obj[f.Fname]
+		if !ok {
+			return nil, GoDBError{MalformedDataError, fmt.Sprintf("missing column %s", f.Fname)}
+		}
+		switch v := v.(type) {
+		case json.Number:
+			if f.Ftype == StringType {
+				fields[i] = StringField{truncateString(v.String())}
+				continue
+			}
+			n, err := v.Int64()
+			if err != nil {
+				return nil, GoDBError{TypeMismatchError, fmt.Sprintf("couldn't convert value %s of column %s to int", v, f.Fname)}
+			}
+			fields[i] = IntField{n}
+		case string:
+			if f.Ftype == IntType {
+				return nil, GoDBError{TypeMismatchError, fmt.Sprintf("couldn't convert value %q of column %s to int", v, f.Fname)}
+			}
+			fields[i] = StringField{truncateString(v)}
+		case nil:
+			fields[i] = nil
+		default:
+			return nil, GoDBError{TypeMismatchError, fmt.Sprintf("unsupported value for column %s", f.Fname)}
+		}
+	}
+	return fields, nil
+}
+
+func findFieldByName(desc *TupleDesc, name string) int {
+	for i, f := range desc.Fields {
+		if f.Fname == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (op *CopyFromOp) Explain() (string, string) {
+	return "Copy From", op.path
+}
+
+func (op *CopyFromOp) Children() []Operator {
+	return nil
+}
+
+func (op *CopyToOp) Explain() (string, string) {
+	return "Copy To", op.path
+}
+
+func (op *CopyToOp) Children() []Operator {
+	return []Operator{op.child}
+}
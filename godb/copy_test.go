@@ -0,0 +1,115 @@
+package godb
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCopyRoundTrip(t *testing.T) {
+	s, dir := makeConstraintTestSession(t, "create table people (id int primary key, name varchar)")
+	if _, err := s.Execute("insert into people values (1, 'smith, john'), (2, 'say \"hi\"'), (3, 'tab\there')"); err != nil {
+		t.Fatalf("insert failed, %s", err.Error())
+	}
+	for _, format := range []string{"csv", "tsv", "jsonl"} {
+		path := filepath.Join(dir, "people."+format)
+		header := ", header"
+		if format == "jsonl" {
+			header = ""
+		}
+		res, err := s.Execute("copy people to '" + path + "' with (format " + format + header + ")")
+		if err != nil {
+			t.Fatalf("copy to %s failed, %s", format, err.Error())
+		}
+		if res.Tag != "COPY 3" {
+			t.Errorf("expected tag COPY 3, got %s", res.Tag)
+		}
+		if _, err := s.Execute("create table copied_" + format + " (id int, name varchar)"); err != nil {
+			t.Fatalf("create failed, %s", err.Error())
+		}
+		res, err = s.Execute("copy copied_" + format + " from '" + path + "' with (format " + format + header + ")")
+		if err != nil {
+			t.Fatalf("copy from %s failed, %s", format, err.Error())
+		}
+		if res.Tag != "COPY 3" {
+			t.Errorf("expected tag COPY 3, got %s", res.Tag)
+		}
+		checkAlterQuery(t, s, "select id, name from copied_"+format+" order by id",
+			[]DBValue{IntField{1}, StringField{"smith, john"}},
+			[]DBValue{IntField{2}, StringField{`say "hi"`}},
+			[]DBValue{IntField{3}, StringField{"tab\there"}})
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "people.csv"))
+	if err != nil {
+		t.Fatalf("read failed, %s", err.Error())
+	}
+	if !strings.HasPrefix(string(data), "id,name\n") {
+		t.Errorf("expected a header, got %q", string(data))
+	}
+	for _, line := range []string{"1,\"smith, john\"\n", "2,\"say \"\"hi\"\"\"\n", "3,tab\there\n"} {
+		if !strings.Contains(string(data), line) {
+			t.Errorf("expected csv line %q in %q", line, string(data))
+		}
+	}
+	data, err = os.ReadFile(filepath.Join(dir, "people.jsonl"))
+	if err != nil {
+		t.Fatalf("read failed, %s", err.Error())
+	}
+	if !strings.Contains(string(data), `{"id":1,"name":"smith, john"}`+"\n") {
+		t.Errorf("unexpected jsonl %q", string(data))
+	}
+}
+
+func TestCopyQueryAndDelimiter(t *testing.T) {
+	s, dir := makeConstraintTestSession(t, "create table nums (a int, b int)")
+	if _, err := s.Execute("insert into nums values (1, 2), (3, 4), (5, 6)"); err != nil {
+		t.Fatalf("insert failed, %s", err.Error())
+	}
+	path := filepath.Join(dir, "sums.txt")
+	res, err := s.Execute("copy (select a + b as total from nums where a > 1) to '" + path + "' delimiter '|'")
+	if err != nil {
+		t.Fatalf("copy failed, %s", err.Error())
+	}
+	if res.Tag != "COPY 2" {
+		t.Errorf("expected tag COPY 2, got %s", res.Tag)
+	}
+	data, _ := os.ReadFile(path)
+	if lines := strings.Fields(string(data)); len(lines) != 2 {
+		t.Errorf("expected 2 lines, got %q", string(data))
+	}
+
+	path = filepath.Join(dir, "pairs.txt")
+	if err := os.WriteFile(path, []byte("7|8\n9|10\n"), 0644); err != nil {
+		t.Fatalf("write failed, %s", err.Error())
+	}
+	if _, err := s.Execute("copy nums from '" + path + "' (delimiter '|')"); err != nil {
+		t.Fatalf("copy from failed, %s", err.Error())
+	}
+	checkAlterQuery(t, s, "select b from nums where a > 5 order by b", []DBValue{IntField{8}}, []DBValue{IntField{10}})
+}
+
+func TestCopyErrors(t *testing.T) {
+	s, dir := makeConstraintTestSession(t, "create table people (id int primary key, name varchar)")
+	bad := filepath.Join(dir, "bad.csv")
+	if err := os.WriteFile(bad, []byte("1,a\n1,b\n"), 0644); err != nil {
+		t.Fatalf("write failed, %s", err.Error())
+	}
+	for _, q := range []string{
+		"copy people from '" + bad + "'",
+		"copy people from '" + filepath.Join(dir, "missing.csv") + "'",
+		"copy people to '" + bad + "' (format xml)",
+		"copy people to '" + bad + "' (format jsonl, header)",
+		"copy people to '" + bad + "' (format tsv, delimiter ',')",
+		"copy people to '" + bad + "' (delimiter '||')",
+		"copy nope to '" + bad + "'",
+		"copy (delete from people) to '" + bad + "'",
+	} {
+		if _, err := s.Execute(q); err == nil {
+			t.Errorf("expected %s to fail", q)
+		}
+	}
+	// the duplicate key aborted the whole copy
+	checkAlterQuery(t, s, "select id from people")
+}
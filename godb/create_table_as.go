@@ -0,0 +1,110 @@
+package godb
+
+// CREATE TABLE AS.
+//
+//	CREATE TABLE [IF NOT EXISTS] name [(column, ...)] [WITH (option = value, ...)] AS SELECT ...
+//
+// creates a table whose columns are the columns of the query's result,
+// renamed to the given column names if there are any, and fills it with the
+// result.  Like other DDL, it is run when it is parsed, and the rows are
+// inserted in one transaction of their own, so the new table is either
+// complete or, if the query fails, not created at all.  Since a ROLLBACK
+// could not undo it, it cannot be run inside an explicit transaction.
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/xwb1989/sqlparser"
+)
+
+var createTableAsRegexp = regexp.MustCompile(`(?is)^\s*create\s+table\s+(if\s+not\s+exists\s+)?([A-Za-z_][A-Za-z0-9_]*)\s*(?:\(([^()]*)\))?\s*(?:with\s*(\([^()]*\))\s*)?as\s+(select\b.*?)[\s;]*$`)
+
+func parseCreateTableAs(c *Catalog, m []string) (QueryType, error) {
+	name := strings.ToLower(m[2])
+	if c.relationExists(name) {
+		if m[1] != "" {
+			return CreateTableQueryType, nil
+		}
+		return UnknownQueryType, GoDBError{ParseError, fmt.Sprintf("table %s already exists", name)}
+	}
+	var columns []string
+	if m[3] != "" {
+		var err error
+		if columns, err = newSqlTokens("(" + m[3] + ")").identList(); err != nil {
+			return UnknownQueryType, err
+		}
+	}
+	var options map[string]string
+	if m[4] != "" {
+		var err error
+		if options, err = newSqlTokens(m[4]).tableOptions(); err != nil {
+			return UnknownQueryType, err
+		}
+		if err := checkTableOptions(options); err != nil {
+			return UnknownQueryType, err
+		}
+	}
+	stmt, err := parseSQL(m[5])
+	if err != nil {
+		return UnknownQueryType, err
+	}
+	sel, ok := stmt.(sqlparser.SelectStatement)
+	if !ok {
+		return UnknownQueryType, GoDBError{ParseError, "CREATE TABLE AS requires a SELECT"}
+	}
+	plan, err := parseQuery(c, sel)
+	if err != nil {
+		return UnknownQueryType, err
+	}
+	op, err := makePhysicalPlan(c, plan)
+	if err != nil {
+		return UnknownQueryType, err
+	}
+	desc, err := queryColumns(name, op, columns)
+	if err != nil {
+		return UnknownQueryType, err
+	}
+	if _, err := c.addTableWithOptions(name, desc, options); err != nil {
+		return UnknownQueryType, err
+	}
+	if err := c.fillTable(c.tableMap[name], op); err != nil {
+		c.dropTable(name)
+		return UnknownQueryType, err
+	}
+	return CreateTableQueryType, nil
+}
+
+// Return the descriptor of a table named name holding the result of op, with
+// its columns renamed to columns if it is not nil.
+func queryColumns(name string, op Operator, columns []string) (TupleDesc, error) {
+	fields := op.Descriptor().Fields
+	if columns != nil && len(columns) != len(fields) {
+		return TupleDesc{}, GoDBError{ParseError, fmt.Sprintf("%d column names given for %s, but the query returns %d columns", len(columns), name, len(fields))}
+	}
+	var desc TupleDesc
+	for i, f := range fields {
+		col := f.Fname
+		if columns != nil {
+			col = columns[i]
+		}
+		if f.Ftype == UnknownType {
+			return TupleDesc{}, GoDBError{TypeMismatchError, fmt.Sprintf("could not determine the type of column %s of %s", col, name)}
+		}
+		for _, other := range desc.Fields {
+			if other.Fname == col {
+				return TupleDesc{}, GoDBError{ParseError, fmt.Sprintf("column %s specified more than once in %s", col, name)}
+			}
+		}
+		desc.Fields = append(desc.Fields, FieldType{col, "", f.Ftype})
+	}
+	return desc, nil
+}
+
+// Replace the rows of table t with the result of op.
+func (c *Catalog) fillTable(t *Table, op Operator) error {
+	return c.refillTable(t, t.desc, func(tid TransactionID) (func() (*Tuple, error), error) {
+		return op.Iterator(tid)
+	})
+}
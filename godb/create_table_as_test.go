@@ -0,0 +1,80 @@
+package godb
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCreateTableAs(t *testing.T) {
+	s, dir := makeViewTestSession(t)
+	if _, err := s.Execute("create table customers (id int, name text)"); err != nil {
+		t.Fatalf("create failed, %s", err.Error())
+	}
+	if _, err := s.Execute("insert into customers values (10, 'ann'), (20, 'bo')"); err != nil {
+		t.Fatalf("insert failed, %s", err.Error())
+	}
+	if _, err := s.Execute("create table spend (who, total) as select c.name, sum(o.amount) from orders o join customers c on o.customer = c.id group by c.name"); err != nil {
+		t.Fatalf("create table as failed, %s", err.Error())
+	}
+	checkAlterQuery(t, s, "select who, total from spend order by who",
+		[]DBValue{StringField{"ann"}, IntField{12}}, []DBValue{StringField{"bo"}, IntField{1}})
+	if _, err := s.Execute("create table big as select id, amount from orders where amount > 4"); err != nil {
+		t.Fatalf("create table as failed, %s", err.Error())
+	}
+
+	// the new table is an ordinary table, and is in the catalog
+	if _, err := s.Execute("insert into big select id, customer from orders where amount < 4"); err != nil {
+		t.Fatalf("insert select failed, %s", err.Error())
+	}
+	checkAlterQuery(t, s, "select amount from big order by amount", []DBValue{IntField{5}}, []DBValue{IntField{7}}, []DBValue{IntField{20}})
+	s.bp.FlushAllPages()
+	bp, _ := NewBufferPool(100)
+	c, err := NewCatalogFromFile("catalog.txt", bp, dir)
+	if err != nil {
+		t.Fatalf("failed to reload catalog, %s", err.Error())
+	}
+	checkAlterQuery(t, NewSession(c, bp), "select total from spend where who = 'bo'", []DBValue{IntField{1}})
+
+	// NULLs are stored, not replaced with 0
+	if _, err := s.Execute("create table maybe as select nullif(id, 10) as a from customers"); err != nil {
+		t.Fatalf("create table as failed, %s", err.Error())
+	}
+	checkAlterQuery(t, s, "select a from maybe order by a", []DBValue{IntField{20}}, []DBValue{nil})
+
+	for _, q := range []string{
+		"create table big as select id from orders",
+		"create table bad (a) as select id, amount from orders",
+		"create table bad as select o.id, c.id from orders o join customers c on o.customer = c.id",
+		"create table bad as select nope from orders",
+		"insert into big select id from orders",
+		"insert into big select name, id from customers",
+	} {
+		if _, err := s.Execute(q); err == nil {
+			t.Errorf("expected %s to fail", q)
+		}
+	}
+	if _, err := s.Execute("create table if not exists big as select id from orders"); err != nil {
+		t.Errorf("create table if not exists failed, %s", err.Error())
+	}
+	if _, err := s.Execute("select * from bad"); err == nil {
+		t.Errorf("expected the failed tables not to be created")
+	}
+
+	// a ROLLBACK could not drop the table, so it is not created in an
+	// explicit transaction, which is aborted
+	if _, err := s.Execute("begin"); err != nil {
+		t.Fatalf("begin failed, %s", err.Error())
+	}
+	if _, err := s.Execute("create table txn as select id from orders"); err == nil || !strings.Contains(err.Error(), "transaction block") {
+		t.Errorf("expected create table as to be rejected in a transaction, got %v", err)
+	}
+	if !s.TransactionFailed() {
+		t.Errorf("expected the transaction to have failed")
+	}
+	if _, err := s.Execute("rollback"); err != nil {
+		t.Fatalf("rollback failed, %s", err.Error())
+	}
+	if _, err := s.Execute("select * from txn"); err == nil {
+		t.Errorf("expected txn not to be created")
+	}
+}
@@ -0,0 +1,261 @@
+package godb
+
+// Common table expressions: queries named by a WITH clause, which the query
+// following the clause can use like tables.
+//
+// sqlparser does not know WITH, so parseSQL parses the clause itself: each
+// CTE's query is parsed separately, and the statement is a [withQuery]
+// wrapping the query that follows the clause.  A non-recursive CTE is planned
+// as a subquery wherever it is named, like a view.  A CTE of a WITH RECURSIVE
+// clause that names itself must be a UNION [ALL] of a query that does not,
+// and one that does; it is planned as a [RecursiveCTE], with the references
+// to itself reading the rows of the previous iteration from a [workTable].
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/xwb1989/sqlparser"
+)
+
+var withRegexp = regexp.MustCompile(`(?is)^\s*with\s+(recursive\s+)?`)
+
+// the start of a CTE, up to the parenthesis before its query
+var cteRegexp = regexp.MustCompile(`(?is)^\s*([a-z_][a-z0-9_]*)\s*(?:\(([^()]*)\))?\s+as\s*\(`)
+
+// A query named by a WITH clause.
+type commonTableExpr struct {
+	name      string
+	columns   []string // the names of its columns, if given
+	query     sqlparser.SelectStatement
+	recursive bool
+	// the CTEs that query may name, other than itself
+	scope cteScope
+	// while the recursive part of a recursive CTE is parsed, the table its
+	// references to itself read
+	work *workTable
+}
+
+// A statement with a WITH clause.  Embedding the statement that follows the
+// clause makes a withQuery a sqlparser.SelectStatement.
+type withQuery struct {
+	sqlparser.SelectStatement
+	ctes []*commonTableExpr
+}
+
+// Parse a statement that starts with a WITH clause.
+func parseWith(query string) (sqlparser.Statement, error) {
+	m := withRegexp.FindStringSubmatchIndex(query)
+	recursive := m[2] >= 0
+	rest := query[m[1]:]
+	var ctes []*commonTableExpr
+	for {
+		m := cteRegexp.FindStringSubmatch(rest)
+		if m == nil {
+			return nil, GoDBError{ParseError, "expected a name AS (query) in WITH clause"}
+		}
+		cte := &commonTableExpr{name: strings.ToLower(m[1])}
+		if m[2] != "" {
+			for _, col := range strings.Split(m[2], ",") {
+				cte.columns = append(cte.columns, strings.ToLower(strings.TrimSpace(col)))
+			}
+		}
+		for _, other := range ctes {
+			if other.name == cte.name {
+				return nil, GoDBError{ParseError, fmt.Sprintf("WITH query name %s specified more than once", cte.name)}
+			}
+		}
+		end := closingParen(rest, len(m[0]))
+		if end == len(rest) {
+			return nil, GoDBError{ParseError, fmt.Sprintf("missing ) after the query of %s", cte.name)}
+		}
+		stmt, err := parseSQL(rest[len(m[0]):end])
+		if err != nil {
+			return nil, err
+		}
+		var ok bool
+		if cte.query, ok = stmt.(sqlparser.SelectStatement); !ok {
+			return nil, GoDBError{ParseError, fmt.Sprintf("the query of %s must be a SELECT", cte.name)}
+		}
+		cte.recursive = recursive && namesTable(cte.query, cte.name)
+		ctes = append(ctes, cte)
+
+		rest = strings.TrimSpace(rest[end+1:])
+		if !strings.HasPrefix(rest, ",") {
+			break
+		}
+		rest = rest[1:]
+	}
+	stmt, err := parseSQL(rest)
+	if err != nil {
+		return nil, err
+	}
+	sel, ok := stmt.(sqlparser.SelectStatement)
+	if _, with := stmt.(*withQuery); !ok || with {
+		return nil, GoDBError{ParseError, "WITH must be followed by a SELECT"}
+	}
+	return &withQuery{sel, ctes}, nil
+}
+
+// Return the nodes of a statement to walk with sqlparser.Walk, which does not
+// know the queries of a WITH clause.
+func statementNodes(stmt sqlparser.SQLNode) []sqlparser.SQLNode {
+	w, ok := stmt.(*withQuery)
+	if !ok {
+		return []sqlparser.SQLNode{stmt}
+	}
+	nodes := statementNodes(w.SelectStatement)
+	for _, cte := range w.ctes {
+		nodes = append(nodes, statementNodes(cte.query)...)
+	}
+	return nodes
+}
+
+// Return true if stmt names the table name.
+func namesTable(stmt sqlparser.SelectStatement, name string) bool {
+	found := false
+	sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		if t, ok := node.(sqlparser.TableName); ok && strings.ToLower(t.Name.CompliantName()) == name {
+			found = true
+		}
+		return !found, nil
+	}, statementNodes(stmt)...)
+	return found
+}
+
+// The CTEs in scope while a query is parsed, those of the innermost WITH
+// clause last.  The scope is passed down the parser with the query, from
+// parseQueryIn to parseFrom, which plans the CTEs that the query names.
+type cteScope []*commonTableExpr
+
+// Return a scope with the CTEs of s, and cte.
+func (s cteScope) with(cte *commonTableExpr) cteScope {
+	return append(s[:len(s):len(s)], cte)
+}
+
+// Return the CTE in scope named name, or nil if there is none.
+func (s cteScope) lookup(name string) *commonTableExpr {
+	for i := len(s) - 1; i >= 0; i-- {
+		if s[i].name == name {
+			return s[i]
+		}
+	}
+	return nil
+}
+
+// Parse the query of a statement with a WITH clause, with its CTEs in scope
+// as well as ctes.
+func parseWithQuery(c *Catalog, ctes cteScope, w *withQuery) (*LogicalPlan, error) {
+	for _, cte := range w.ctes {
+		cte.scope = ctes
+		ctes = ctes.with(cte)
+	}
+	return parseQueryIn(c, ctes, w.SelectStatement)
+}
+
+// Plan a CTE, as a subquery of a query that names it.  Like a view, a CTE is
+// parsed again each time it is named.
+func (c *Catalog) planCTE(cte *commonTableExpr) (*LogicalPlan, error) {
+	if cte.work != nil {
+		return cte.work.plan(), nil
+	}
+	if cte.recursive {
+		return c.planRecursiveCTE(cte)
+	}
+	plan, err := parseQueryIn(c, cte.scope, cte.query)
+	if err != nil {
+		return nil, err
+	}
+	if cte.columns != nil {
+		if len(cte.columns) != len(plan.selects) {
+			return nil, GoDBError{ParseError, fmt.Sprintf("%s has %d columns but %d names", cte.name, len(plan.selects), len(cte.columns))}
+		}
+		for i, s := range plan.selects {
+			if s.exprType == ExprStar {
+				return nil, GoDBError{ParseError, fmt.Sprintf("the columns of %s cannot be named when its query selects *", cte.name)}
+			}
+			s.alias = cte.columns[i]
+		}
+	}
+	return plan, nil
+}
+
+// A recursive CTE of a logical plan.
+type LogicalRecursiveCTE struct {
+	anchor, step *LogicalPlan
+	all          bool
+	work         *workTable
+}
+
+// Plan a recursive CTE.  Its query must be a UNION [ALL] of an anchor query,
+// which does not name the CTE, and a recursive step, which does.
+func (c *Catalog) planRecursiveCTE(cte *commonTableExpr) (*LogicalPlan, error) {
+	u, ok := cte.query.(*sqlparser.Union)
+	if ok {
+		kind, _ := setOpOf(u)
+		ok = kind == UnionOp
+	}
+	if !ok {
+		return nil, GoDBError{ParseError, fmt.Sprintf("recursive query %s must be of the form anchor UNION [ALL] recursive step", cte.name)}
+	}
+	if len(u.OrderBy) > 0 || u.Limit != nil {
+		return nil, GoDBError{ParseError, fmt.Sprintf("ORDER BY and LIMIT are not supported in recursive query %s", cte.name)}
+	}
+	if namesTable(u.Left, cte.name) {
+		return nil, GoDBError{ParseError, fmt.Sprintf("the anchor of recursive query %s must not refer to %s", cte.name, cte.name)}
+	}
+	anchor, err := parseQueryIn(c, cte.scope, u.Left)
+	if err != nil {
+		return nil, err
+	}
+	columns := cte.columns
+	if columns == nil {
+		for _, f := range anchor.getSubplanFields(c) {
+			if f.Fname == "*" {
+				return nil, GoDBError{ParseError, fmt.Sprintf("the anchor of recursive query %s must not select *", cte.name)}
+			}
+			columns = append(columns, f.Fname)
+		}
+	} else if len(columns) != len(anchor.selects) {
+		return nil, GoDBError{ParseError, fmt.Sprintf("%s has %d columns but %d names", cte.name, len(anchor.selects), len(columns))}
+	}
+
+	work := newWorkTable(cte.name, columns)
+	cte.work = work
+	step, err := parseQueryIn(c, cte.scope.with(cte), u.Right)
+	cte.work = nil
+	if err != nil {
+		return nil, err
+	}
+	plan := work.plan()
+	_, all := setOpOf(u)
+	plan.recursiveCTE = &LogicalRecursiveCTE{anchor, step, all, work}
+	return plan, nil
+}
+
+// Make the physical plan of a recursive CTE.  The types of the columns of the
+// work table are those of the anchor's, which are only known once it is
+// planned.
+func makeRecursiveCTEPlan(c *Catalog, plan *LogicalPlan) (*OperatorCard, error) {
+	r := plan.recursiveCTE
+	anchor, err := makePhysicalPlan(c, r.anchor)
+	if err != nil {
+		return nil, err
+	}
+	if len(anchor.Descriptor().Fields) != len(r.work.desc.Fields) {
+		return nil, GoDBError{TypeMismatchError, fmt.Sprintf("recursive query %s has %d columns but %d names", r.work.name, len(anchor.Descriptor().Fields), len(r.work.desc.Fields))}
+	}
+	for i, f := range anchor.Descriptor().Fields {
+		r.work.desc.Fields[i].Ftype = f.Ftype
+	}
+	step, err := makePhysicalPlan(c, r.step)
+	if err != nil {
+		return nil, err
+	}
+	op, err := NewRecursiveCTE(anchor, step, r.all, r.work)
+	if err != nil {
+		return nil, err
+	}
+	return NewOperatorCard(op, anchor.Cardinality), nil
+}
@@ -0,0 +1,151 @@
+package godb
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Return a session with the tables of makeBatchTestSession and an org chart,
+// in which ceo manages cto and cfo, cto manages dev1 and dev2, and dev1
+// manages intern; a and b manage each other.
+func makeCTETestSession(t *testing.T) *Session {
+	s := makeBatchTestSession(t, 30)
+	for _, q := range []string{
+		"create table org (id int, manager int, name varchar)",
+		"insert into org values (1, 0, 'ceo'), (2, 1, 'cto'), (3, 1, 'cfo'), (4, 2, 'dev1'), (5, 2, 'dev2'), (6, 4, 'intern'), (7, 8, 'a'), (8, 7, 'b')",
+	} {
+		if _, err := s.Execute(q); err != nil {
+			t.Fatalf("%s failed, %s", q, err.Error())
+		}
+	}
+	return s
+}
+
+func TestCommonTableExpressions(t *testing.T) {
+	s := makeCTETestSession(t)
+	for _, c := range []struct {
+		query string
+		want  []string
+	}{
+		{"with d as (select id, dname from dept where id < 3) select dname from d",
+			[]string{"d0", "d1", "d2"}},
+		{"WITH a AS (select dept from emp where salary < 50), b (x) AS (select dept from a) select x from b where x > 3",
+			[]string{"4", "5", "6", "7"}},
+		{"with d as (select id from dept where id < 3) select d1.id, d2.id from d d1 join d as d2 on d1.id = d2.id",
+			[]string{"0,0", "1,1", "2,2"}},
+		// a CTE hides a table with the same name
+		{"with emp as (select dname from dept where id = 4) select dname from emp",
+			[]string{"d4"}},
+		{"with d as (select id from dept where id < 2) select id from d union select id from dept where id = 5",
+			[]string{"0", "1", "5"}},
+		{"with recursive reports (id, name, depth) as (select id, name, 0 from org where id = 2 union all select org.id, org.name, reports.depth + 1 from org join reports on org.manager = reports.id) select name, depth from reports",
+			[]string{"cto,0", "dev1,1", "dev2,1", "intern,2"}},
+		{"with recursive n(i) as (select id from dept where id = 1 union all select i + 1 from n where i < 5) select i from n",
+			[]string{"1", "2", "3", "4", "5"}},
+		// the cycle between a and b ends once no new rows are found
+		{"with recursive chain as (select id, manager from org where id = 7 union select org.id, org.manager from org join chain on org.id = chain.manager) select id from chain",
+			[]string{"7", "8"}},
+		{"with recursive up as (select manager from org where name = 'intern' union all select org.manager from org join up on org.id = up.manager), names as (select org.name from org join up on org.id = up.manager) select name from names",
+			[]string{"ceo", "cto", "dev1"}},
+	} {
+		res, err := s.Execute(c.query)
+		if err != nil {
+			t.Fatalf("%s failed, %s", c.query, err.Error())
+		}
+		got := rowStrings(res.Tuples)
+		sort.Strings(got)
+		sort.Strings(c.want)
+		if strings.Join(got, ";") != strings.Join(c.want, ";") {
+			t.Errorf("%s: expected %v, got %v", c.query, c.want, got)
+		}
+	}
+
+	defer func(n int) { MaxRecursiveIterations = n }(MaxRecursiveIterations)
+	MaxRecursiveIterations = 10
+	for _, q := range []string{
+		"with recursive chain as (select id, manager from org where id = 7 union all select org.id, org.manager from org join chain on org.id = chain.manager) select id from chain",
+		"with recursive n as (select id from dept where id = 1 intersect select id + 1 from n) select id from n",
+		"with recursive n as (select id from n union select id from dept) select id from n",
+		"with d as (select id from dept), d as (select id from dept) select id from d",
+		"with d (a, b) as (select id from dept) select a from d",
+		"with d as (select id from dept) delete from dept",
+		"with d as select id from dept select id from d",
+		"with a as (select id from b), b as (select id from dept) select id from a",
+	} {
+		if _, err := s.Execute(q); err == nil {
+			t.Errorf("%s: expected an error", q)
+		}
+	}
+}
+
+func TestCTEPlans(t *testing.T) {
+	s := makeCTETestSession(t)
+	stmt, err := s.Prepare("with recursive n(i) as (select id from dept where id = $1 union all select i + 1 from n where i < $2) select i from n")
+	if err != nil {
+		t.Fatalf("prepare failed, %s", err.Error())
+	}
+	if stmt.NumParams() != 2 {
+		t.Errorf("expected 2 parameters, got %d", stmt.NumParams())
+	}
+	res, err := s.ExecuteStmt(stmt, IntField{3}, IntField{6})
+	if err != nil {
+		t.Fatalf("execute failed, %s", err.Error())
+	}
+	got := rowStrings(res.Tuples)
+	sort.Strings(got)
+	if strings.Join(got, ";") != "3;4;5;6" {
+		t.Errorf("expected 3 to 6, got %v", got)
+	}
+
+	if _, err := s.Execute("set parallel_workers = 4"); err != nil {
+		t.Fatalf("set failed, %s", err.Error())
+	}
+	res, err = s.Execute("with recursive reports as (select id from org where id = 1 union select org.id from org join reports on org.manager = reports.id) select id from reports")
+	if err != nil {
+		t.Fatalf("query failed, %s", err.Error())
+	}
+	if len(res.Tuples) != 6 {
+		t.Errorf("expected the 6 people reporting to the ceo, got %v", rowStrings(res.Tuples))
+	}
+
+	if _, err := s.Execute("create view managers as with m as (select manager from org) select name from org join m on org.id = m.manager"); err != nil {
+		t.Fatalf("create view failed, %s", err.Error())
+	}
+	res, err = s.Execute("select distinct name from managers")
+	if err != nil {
+		t.Fatalf("query failed, %s", err.Error())
+	}
+	got = rowStrings(res.Tuples)
+	sort.Strings(got)
+	if strings.Join(got, ";") != "a;b;ceo;cto;dev1" {
+		t.Errorf("expected the managers, got %v", got)
+	}
+}
+
+// The CTEs of a query are in scope only while that query is parsed, not in
+// the queries parsed at the same time, which do not share the scope through
+// the catalog.
+func TestCTEScopeNotShared(t *testing.T) {
+	s := makeCTETestSession(t)
+	var wg sync.WaitGroup
+	parse := func(query, want string) {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			_, op, err := Parse(s.catalog, query)
+			if err != nil {
+				t.Errorf("%s failed, %s", query, err.Error())
+				return
+			}
+			if got := op.Descriptor().Fields[0].Fname; got != want {
+				t.Errorf("%s: expected a column %s, got %s", query, want, got)
+				return
+			}
+		}
+	}
+	wg.Add(2)
+	go parse("with emp as (select id from org where id < 3) select emp.id from emp", "id")
+	go parse("select emp.name from emp", "name")
+	wg.Wait()
+}
@@ -0,0 +1,424 @@
+package godb
+
+// A small hand-written parser for the DDL that sqlparser cannot handle:
+// ALTER TABLE (see alter_table.go) and CREATE TABLE with constraints.
+//
+//	CREATE TABLE [IF NOT EXISTS] name (element, ...) [WITH (option = value, ...)]
+//
+// where each element is either a column definition
+//
+//	column type [[CONSTRAINT name] column_constraint ...]
+//	column_constraint: NOT NULL | NULL | PRIMARY KEY | UNIQUE | CHECK (expr) |
+//	    REFERENCES table [(column)] [ON DELETE action]
+//
+// or a table constraint
+//
+//	[CONSTRAINT name] PRIMARY KEY (column, ...)
+//	[CONSTRAINT name] UNIQUE (column, ...)
+//	[CONSTRAINT name] CHECK (expr)
+//	[CONSTRAINT name] FOREIGN KEY (column, ...) REFERENCES table [(column, ...)]
+//	    [ON DELETE action]
+//
+// The only table option is storage, which is heap (the default) or columnar;
+// see columnar_file.go.  CREATE TABLE ... AS SELECT is handled in
+// create_table_as.go.
+//
+// The catalog file stores tables in the same syntax, without the leading
+// CREATE TABLE, so the catalog is read with this parser too.
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var createTableRegexp = regexp.MustCompile(`(?is)^\s*create\s+table\s`)
+
+// string literals, integers, identifiers, two character comparison
+// operators, or any other single character
+var sqlTokenRegexp = regexp.MustCompile(`'(?:[^']|'')*'|-?[0-9]+|[A-Za-z_][A-Za-z0-9_]*|<=|>=|<>|!=|!?~\*?|\S`)
+
+// A statement split into tokens; identifiers and keywords are lower cased.
+type sqlTokens struct {
+	toks []string
+	pos  int
+}
+
+func newSqlTokens(query string) *sqlTokens {
+	toks := sqlTokenRegexp.FindAllString(strings.TrimSpace(query), -1)
+	for i, tok := range toks {
+		if tok[0] != '\'' {
+			toks[i] = strings.ToLower(tok)
+		}
+	}
+	if n := len(toks); n > 0 && toks[n-1] == ";" {
+		toks = toks[:n-1]
+	}
+	return &sqlTokens{toks: toks}
+}
+
+func (t *sqlTokens) done() bool {
+	return t.pos >= len(t.toks)
+}
+
+func (t *sqlTokens) peek() string {
+	if t.done() {
+		return ""
+	}
+	return t.toks[t.pos]
+}
+
+func (t *sqlTokens) next() string {
+	tok := t.peek()
+	t.pos++
+	return tok
+}
+
+// Consume the next token if it is kw.
+func (t *sqlTokens) accept(kw string) bool {
+	if t.peek() == kw {
+		t.pos++
+		return true
+	}
+	return false
+}
+
+func (t *sqlTokens) expect(kw string) error {
+	if !t.accept(kw) {
+		return t.errorf("expected %s", strings.ToUpper(kw))
+	}
+	return nil
+}
+
+func (t *sqlTokens) ident() (string, error) {
+	tok := t.next()
+	if !isIdent(tok) {
+		return "", t.errorf("expected a name")
+	}
+	return tok, nil
+}
+
+func isIdent(tok string) bool {
+	return tok != "" && (tok[0] == '_' || tok[0] >= 'a' && tok[0] <= 'z')
+}
+
+// Parse a parenthesized, comma separated list of names.
+func (t *sqlTokens) identList() ([]string, error) {
+	if err := t.expect("("); err != nil {
+		return nil, err
+	}
+	var names []string
+	for {
+		name, err := t.ident()
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+		if t.accept(")") {
+			return names, nil
+		}
+		if err := t.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Return the text between a pair of matching parentheses, with its tokens
+// separated by spaces.
+func (t *sqlTokens) parenthesized() (string, error) {
+	if err := t.expect("("); err != nil {
+		return "", err
+	}
+	start := t.pos
+	for depth := 1; ; {
+		switch t.next() {
+		case "(":
+			depth++
+		case ")":
+			depth--
+		case "":
+			return "", t.errorf("expected )")
+		}
+		if depth == 0 {
+			break
+		}
+	}
+	if t.pos-1 == start {
+		return "", t.errorf("expected an expression")
+	}
+	return strings.Join(t.toks[start:t.pos-1], " "), nil
+}
+
+// Parse a constant: an integer or a quoted string.
+func (t *sqlTokens) constant() (DBValue, error) {
+	tok := t.next()
+	if tok != "" && tok[0] == '\'' {
+		return StringField{strings.ReplaceAll(tok[1:len(tok)-1], "''", "'")}, nil
+	}
+	v, err := strconv.ParseInt(tok, 10, 64)
+	if err != nil {
+		return nil, t.errorf("expected a constant")
+	}
+	return IntField{v}, nil
+}
+
+// Parse a column type, e.g., int or varchar(20).
+func (t *sqlTokens) columnType() (DBType, error) {
+	var typ DBType
+	switch t.next() {
+	case "int", "integer":
+		typ = IntType
+	case "string", "text", "varchar":
+		typ = StringType
+	default:
+		return UnknownType, t.errorf("unsupported column type")
+	}
+	if t.accept("(") {
+		t.next()
+		if err := t.expect(")"); err != nil {
+			return UnknownType, err
+		}
+	}
+	return typ, nil
+}
+
+func (t *sqlTokens) errorf(format string, args ...any) error {
+	near := "end of statement"
+	if pos := t.pos - 1; pos >= 0 && pos < len(t.toks) {
+		near = fmt.Sprintf("'%s'", t.toks[pos])
+	}
+	return GoDBError{ParseError, fmt.Sprintf(format, args...) + " near " + near}
+}
+
+func parseCreateTable(c *Catalog, query string) (QueryType, error) {
+	if m := createTableAsRegexp.FindStringSubmatch(query); m != nil {
+		return parseCreateTableAs(c, m)
+	}
+	toks := newSqlTokens(query)
+	toks.next() // create
+	toks.next() // table
+	ifNotExists := false
+	if toks.accept("if") {
+		if err := toks.expect("not"); err != nil {
+			return UnknownQueryType, err
+		}
+		if err := toks.expect("exists"); err != nil {
+			return UnknownQueryType, err
+		}
+		ifNotExists = true
+	}
+	name, desc, cons, options, err := parseTableDef(toks)
+	if err != nil {
+		return UnknownQueryType, err
+	}
+	if _, err := c.GetTableInfo(name); err == nil {
+		if ifNotExists {
+			return CreateTableQueryType, nil
+		}
+		return UnknownQueryType, GoDBError{ParseError, fmt.Sprintf("table %s already exists", name)}
+	}
+	if err := c.createTable(name, desc, cons, options); err != nil {
+		return UnknownQueryType, err
+	}
+	return CreateTableQueryType, nil
+}
+
+// Parse a table name followed by a parenthesized list of column definitions
+// and table constraints, and then any table options.
+func parseTableDef(toks *sqlTokens) (string, TupleDesc, []*constraint, map[string]string, error) {
+	var desc TupleDesc
+	var cons []*constraint
+	var options map[string]string
+	fail := func(err error) (string, TupleDesc, []*constraint, map[string]string, error) {
+		return "", TupleDesc{}, nil, nil, err
+	}
+	name, err := toks.ident()
+	if err != nil {
+		return fail(err)
+	}
+	if err := toks.expect("("); err != nil {
+		return fail(err)
+	}
+	for {
+		conName := ""
+		if toks.accept("constraint") {
+			if conName, err = toks.ident(); err != nil {
+				return fail(err)
+			}
+		}
+		switch toks.peek() {
+		case "primary", "unique", "check", "foreign":
+			con, err := toks.keyOrCheck("")
+			if err != nil {
+				return fail(err)
+			}
+			con.name = conName
+			cons = append(cons, con)
+		default:
+			if conName != "" {
+				return fail(toks.errorf("expected PRIMARY KEY, UNIQUE, CHECK, or FOREIGN KEY"))
+			}
+			col, err := toks.ident()
+			if err != nil {
+				return fail(err)
+			}
+			for _, f := range desc.Fields {
+				if f.Fname == col {
+					return fail(GoDBError{ParseError, fmt.Sprintf("column %s specified more than once", col)})
+				}
+			}
+			typ, err := toks.columnType()
+			if err != nil {
+				return fail(err)
+			}
+			desc.Fields = append(desc.Fields, FieldType{col, "", typ})
+			colCons, err := toks.columnConstraints(col)
+			if err != nil {
+				return fail(err)
+			}
+			cons = append(cons, colCons...)
+		}
+		if toks.accept(")") {
+			break
+		}
+		if err := toks.expect(","); err != nil {
+			return fail(err)
+		}
+	}
+	if toks.accept("with") {
+		if options, err = toks.tableOptions(); err != nil {
+			return fail(err)
+		}
+	}
+	if !toks.done() {
+		return fail(toks.errorf("unexpected token"))
+	}
+	if len(desc.Fields) == 0 {
+		return fail(GoDBError{ParseError, fmt.Sprintf("table %s must have at least one column", name)})
+	}
+	return name, desc, cons, options, nil
+}
+
+// Parse a parenthesized list of table options, option = value.
+func (t *sqlTokens) tableOptions() (map[string]string, error) {
+	if err := t.expect("("); err != nil {
+		return nil, err
+	}
+	options := make(map[string]string)
+	for {
+		key, err := t.ident()
+		if err != nil {
+			return nil, err
+		}
+		if err := t.expect("="); err != nil {
+			return nil, err
+		}
+		value := t.next()
+		if value != "" && value[0] == '\'' {
+			value = strings.ReplaceAll(value[1:len(value)-1], "''", "'")
+		} else if !isIdent(value) {
+			return nil, t.errorf("expected a value")
+		}
+		options[key] = strings.ToLower(value)
+		if t.accept(")") {
+			return options, nil
+		}
+		if err := t.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Parse the constraints following the type in the definition of column col.
+func (t *sqlTokens) columnConstraints(col string) ([]*constraint, error) {
+	var cons []*constraint
+	for {
+		conName := ""
+		if t.accept("constraint") {
+			var err error
+			if conName, err = t.ident(); err != nil {
+				return nil, err
+			}
+		}
+		switch t.peek() {
+		case "not":
+			t.next()
+			if err := t.expect("null"); err != nil {
+				return nil, err
+			}
+			cons = append(cons, &constraint{name: conName, kind: notNullConstraint, columns: []string{col}})
+		case "primary", "unique", "check", "references":
+			con, err := t.keyOrCheck(col)
+			if err != nil {
+				return nil, err
+			}
+			con.name = conName
+			cons = append(cons, con)
+		case "null":
+			if conName == "" {
+				t.next()
+				continue
+			}
+			fallthrough
+		default:
+			if conName != "" {
+				return nil, t.errorf("expected NOT NULL, PRIMARY KEY, UNIQUE, CHECK, or REFERENCES")
+			}
+			return cons, nil
+		}
+	}
+}
+
+// Parse a PRIMARY KEY, UNIQUE, CHECK, or FOREIGN KEY constraint.  Key
+// constraints on column col have no column list; table constraints
+// (col == "") do.
+func (t *sqlTokens) keyOrCheck(col string) (*constraint, error) {
+	con := &constraint{}
+	switch t.peek() {
+	case "references":
+		con.kind = foreignKeyConstraint
+		con.columns = []string{col}
+		return con, t.references(con)
+	case "foreign":
+		t.next()
+		if err := t.expect("key"); err != nil {
+			return nil, err
+		}
+		cols, err := t.identList()
+		if err != nil {
+			return nil, err
+		}
+		con.kind = foreignKeyConstraint
+		con.columns = cols
+		return con, t.references(con)
+	}
+	switch t.next() {
+	case "primary":
+		if err := t.expect("key"); err != nil {
+			return nil, err
+		}
+		con.kind = primaryKeyConstraint
+	case "unique":
+		t.accept("key")
+		con.kind = uniqueConstraint
+	case "check":
+		src, err := t.parenthesized()
+		if err != nil {
+			return nil, err
+		}
+		con.kind = checkConstraint
+		con.check = src
+		return con, nil
+	}
+	if col != "" {
+		con.columns = []string{col}
+		return con, nil
+	}
+	cols, err := t.identList()
+	if err != nil {
+		return nil, err
+	}
+	con.columns = cols
+	return con, nil
+}
@@ -4,6 +4,9 @@ type DeleteOp struct {
 	// TODO: some code goes here
 	deleteFile DBFile
 	child      Operator
+	// the catalog entry of deleteFile, whose unique indexes are updated after
+	// each delete, or nil
+	table *Table
 }
 
 // Construct a delete operator. The delete operator deletes the records in the
@@ -31,7 +34,12 @@ func (i *DeleteOp) Descriptor() *TupleDesc {
 func (dop *DeleteOp) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
 	// TODO: some code goes here
 	deletions := int64(0)
+	done := false
 	return func() (*Tuple, error) {
+		if done {
+			return nil, nil
+		}
+		done = true
 		childIterator, err := dop.child.Iterator(tid)
 		if err != nil {
 			return nil, err
@@ -47,7 +55,14 @@ func (dop *DeleteOp) Iterator(tid TransactionID) (func() (*Tuple, error), error)
 				break
 			}
 
-			dop.deleteFile.deleteTuple(tuple, tid)
+			if err := dop.deleteFile.deleteTuple(tuple, tid); err != nil {
+				return nil, err
+			}
+			if dop.table != nil {
+				if err := dop.table.noteDelete(tid, tuple); err != nil {
+					return nil, err
+				}
+			}
 			deletions += 1
 		}
 		return &Tuple{
@@ -58,3 +73,11 @@ func (dop *DeleteOp) Iterator(tid TransactionID) (func() (*Tuple, error), error)
 		}, nil
 	}, nil
 }
+
+func (dop *DeleteOp) Explain() (string, string) {
+	return "Delete", targetName(dop.deleteFile, dop.table)
+}
+
+func (dop *DeleteOp) Children() []Operator {
+	return []Operator{dop.child}
+}
@@ -0,0 +1,332 @@
+package godb
+
+// EXPLAIN.
+//
+//	EXPLAIN [(option, ...)] statement
+//
+// returns the plan of a SELECT, INSERT, DELETE, COPY or DDL statement
+// without running it.  The options are
+//
+//	FORMAT {TEXT | JSON}   one row per operator, indented to show the shape
+//	                       of the plan (the default), or a single row with
+//	                       the plan as a JSON document
+//	COSTS [boolean]        whether to show the estimated rows and cost of
+//	                       each operator; on by default
+//
+// The cost of an operator is that of its inputs plus the work it does itself:
+// CostPerPage for each page a scan reads, and one for each row it processes
+// otherwise, e.g. each pair of rows a nested loops join compares.
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/xwb1989/sqlparser"
+)
+
+// An Explainable operator can describe itself in the output of EXPLAIN and
+// [OutputPhysicalPlan].  Explain returns the kind of the operator, such as
+// "Filter", and what it does, such as "emp.dept = {3}", which may be empty;
+// Children returns the operators it reads its input from.
+type Explainable interface {
+	Operator
+	Explain() (string, string)
+	Children() []Operator
+}
+
+// Options of EXPLAIN.
+type ExplainOptions struct {
+	Format string // "text" or "json"
+	Costs  bool
+}
+
+// A node of the plan EXPLAIN returns, encoded in JSON like PostgreSQL's.
+type ExplainNode struct {
+	Kind     string         `json:"Node Type"`
+	Detail   string         `json:"Detail,omitempty"`
+	Rows     *int           `json:"Plan Rows,omitempty"`
+	Cost     *float64       `json:"Total Cost,omitempty"`
+	Children []*ExplainNode `json:"Plans,omitempty"`
+}
+
+// Return the kind and detail of the node, as OutputPhysicalPlan prints them.
+func (n *ExplainNode) label() string {
+	if n.Detail == "" {
+		return n.Kind
+	}
+	return n.Kind + " " + n.Detail
+}
+
+// Return the plan tree of o, with the estimated rows and cost of each
+// operator.  The rows are the cardinalities the planner estimated for
+// operators wrapped in an [OperatorCard], and those of the first input of
+// other operators.
+func ExplainPlan(o Operator) *ExplainNode {
+	rows := -1
+	if oc, ok := o.(*OperatorCard); ok {
+		o, rows = oc.Op, oc.Cardinality
+	}
+	node := &ExplainNode{}
+	var children []Operator
+	if e, ok := o.(Explainable); ok {
+		node.Kind, node.Detail = e.Explain()
+		children = e.Children()
+	} else {
+		node.Kind = reflect.TypeOf(o).String()
+	}
+	var inputRows []int
+	cost := 0.0
+	for _, child := range children {
+		c := ExplainPlan(child)
+		node.Children = append(node.Children, c)
+		inputRows = append(inputRows, *c.Rows)
+		cost += *c.Cost
+	}
+	if rows < 0 {
+		rows = 0
+		if v, ok := o.(*ValueOp); ok {
+			rows = len(v.exprs)
+		} else if len(inputRows) > 0 {
+			rows = inputRows[0]
+		}
+	}
+	cost += operatorCost(o, rows, inputRows)
+	node.Rows, node.Cost = &rows, &cost
+	return node
+}
+
+// Return the cost of the work op does itself, given the rows it returns and
+// the rows of each of its inputs.
+func operatorCost(op Operator, rows int, inputRows []int) float64 {
+	switch op := op.(type) {
+	case *heapScanRange:
+		return float64((op.end - op.start) * CostPerPage)
+	case *heapColumnScan:
+		// every page is read, whichever columns are returned
+		return operatorCost(op.scan, rows, inputRows)
+	case *ColumnarScan:
+		// only the pages of the columns read are read
+		n, read := len(op.file.Desc.Fields), 0
+		for i := 0; i < n; i++ {
+			if op.reads(i) {
+				read++
+			}
+		}
+		if n == 0 {
+			return 0
+		}
+		return float64(op.file.NumPages()*CostPerPage*read) / float64(n)
+	case DBFile:
+		return float64(op.NumPages() * CostPerPage)
+	case *EqualityJoin:
+		return float64(inputRows[0]) * float64(inputRows[1])
+	case *OrderBy, *Window:
+		n := float64(inputRows[0])
+		return n * math.Max(math.Log2(n), 1)
+	}
+	if len(inputRows) == 0 {
+		return float64(rows)
+	}
+	total := 0
+	for _, n := range inputRows {
+		total += n
+	}
+	return float64(total)
+}
+
+// Return the EXPLAIN output of a plan: in the text format, one line for each
+// operator, and in the JSON format, a JSON document.
+func ExplainPlanString(o Operator, opts ExplainOptions) (string, error) {
+	node := ExplainPlan(o)
+	if !opts.Costs {
+		clearCosts(node)
+	}
+	switch opts.Format {
+	case "json":
+		var out strings.Builder
+		enc := json.NewEncoder(&out)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode([]map[string]*ExplainNode{{"Plan": node}}); err != nil {
+			return "", err
+		}
+		return strings.TrimSuffix(out.String(), "\n"), nil
+	case "text", "":
+		var out strings.Builder
+		writeExplainText(&out, node, 0)
+		return strings.TrimSuffix(out.String(), "\n"), nil
+	}
+	return "", GoDBError{ParseError, fmt.Sprintf("unsupported EXPLAIN format %s", opts.Format)}
+}
+
+func clearCosts(node *ExplainNode) {
+	node.Rows, node.Cost = nil, nil
+	for _, c := range node.Children {
+		clearCosts(c)
+	}
+}
+
+// Write a node and its children, indented and marked with "->" like the
+// plans PostgreSQL shows.
+func writeExplainText(out *strings.Builder, node *ExplainNode, depth int) {
+	if depth > 0 {
+		out.WriteString(strings.Repeat(" ", 6*(depth-1)) + "  ->  ")
+	}
+	out.WriteString(node.label())
+	if node.Cost != nil {
+		fmt.Fprintf(out, "  (cost=%.2f rows=%d)", *node.Cost, *node.Rows)
+	}
+	out.WriteString("\n")
+	for _, c := range node.Children {
+		writeExplainText(out, c, depth+1)
+	}
+}
+
+var explainRegexp = regexp.MustCompile(`(?is)^\s*explain(?:\s*\(([^()]*)\)|\s)\s*(.*?)[\s;]*$`)
+
+// CREATE, DROP, ALTER and REFRESH of tables and views, and the name of the
+// relation.
+var ddlStatementRegexp = regexp.MustCompile(`(?is)^\s*(create|drop|alter|refresh)\s+(table|view|materialized\s+view)\s+(?:if\s+(?:not\s+)?exists\s+)?([A-Za-z_][A-Za-z0-9_]*)`)
+
+func parseExplainOptions(text string) (ExplainOptions, error) {
+	opts := ExplainOptions{Format: "text", Costs: true}
+	toks := newSqlTokens(text)
+	for !toks.done() {
+		switch opt := toks.next(); opt {
+		case "format":
+			opts.Format = toks.next()
+			if opts.Format != "text" && opts.Format != "json" {
+				return opts, toks.errorf("expected TEXT or JSON")
+			}
+		case "costs":
+			switch toks.peek() {
+			case "true", "on", "1":
+				toks.next()
+			case "false", "off", "0":
+				toks.next()
+				opts.Costs = false
+			}
+		default:
+			return opts, toks.errorf("expected FORMAT or COSTS")
+		}
+		if !toks.done() {
+			if err := toks.expect(","); err != nil {
+				return opts, err
+			}
+		}
+	}
+	return opts, nil
+}
+
+// Return the rows of the result of an EXPLAIN statement: a row for each line
+// of the plan in the text format, or a single row in the JSON format.  The
+// statement is only planned, so DDL statements are not run.
+func Explain(c *Catalog, query string) ([]string, error) {
+	m := explainRegexp.FindStringSubmatch(query)
+	if m == nil {
+		return nil, GoDBError{ParseError, "expected EXPLAIN [(option, ...)] statement"}
+	}
+	opts, err := parseExplainOptions(m[1])
+	if err != nil {
+		return nil, err
+	}
+	op, err := planExplain(c, m[2])
+	if err != nil {
+		return nil, err
+	}
+	out, err := ExplainPlanString(op, opts)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Format == "json" {
+		return []string{out}, nil
+	}
+	return strings.Split(out, "\n"), nil
+}
+
+// Plan a statement for EXPLAIN.  DDL statements are planned as a ddlOp,
+// whose input is the plan of their query, if they have one.
+func planExplain(c *Catalog, query string) (Operator, error) {
+	if m := ddlStatementRegexp.FindStringSubmatch(query); m != nil {
+		op := &ddlOp{kind: titleWords(m[1] + " " + m[2]), name: strings.ToLower(m[3])}
+		var source string
+		if m := createTableAsRegexp.FindStringSubmatch(query); m != nil {
+			source = m[5]
+		} else if m := createViewRegexp.FindStringSubmatch(query); m != nil {
+			source = m[4]
+		}
+		if source != "" {
+			var err error
+			if op.child, err = planCopySource(c, source); err != nil {
+				return nil, err
+			}
+		}
+		return op, nil
+	}
+	if copyRegexp.MatchString(query) {
+		_, op, err := parseCopy(c, query)
+		return op, err
+	}
+	stmt, err := parseSQL(rewriteParams(query))
+	if err != nil {
+		return nil, err
+	}
+	switch stmt := stmt.(type) {
+	case sqlparser.SelectStatement, *sqlparser.Insert, *sqlparser.Delete:
+		_, op, err := planStatement(c, stmt)
+		return op, err
+	case *sqlparser.DDL:
+		return &ddlOp{kind: titleWords(stmt.Action + " table"), name: sqlparser.String(stmt.Table.Name)}, nil
+	}
+	return nil, GoDBError{ParseError, "EXPLAIN is not supported for " + sqlparser.String(stmt)}
+}
+
+// Capitalize the words of s, separated by single spaces.
+func titleWords(s string) string {
+	words := strings.Fields(strings.ToLower(s))
+	for i, w := range words {
+		words[i] = strings.ToUpper(w[:1]) + w[1:]
+	}
+	return strings.Join(words, " ")
+}
+
+// The plan of a DDL statement, which EXPLAIN shows but which cannot be run;
+// DDL statements are run as they are parsed.
+type ddlOp struct {
+	kind, name string
+	child      Operator // the query of CREATE TABLE AS or CREATE VIEW, or nil
+}
+
+func (d *ddlOp) Descriptor() *TupleDesc {
+	return &TupleDesc{}
+}
+
+func (d *ddlOp) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	return nil, GoDBError{IllegalOperationError, fmt.Sprintf("%s can only be explained", d.kind)}
+}
+
+func (d *ddlOp) Explain() (string, string) {
+	return d.kind, d.name
+}
+
+func (d *ddlOp) Children() []Operator {
+	if d.child == nil {
+		return nil
+	}
+	return []Operator{d.child}
+}
+
+// Return the name of the table an insert or delete modifies.
+func targetName(file DBFile, table *Table) string {
+	if table != nil {
+		return table.name
+	}
+	if hf, ok := file.(*HeapFile); ok {
+		return hf.BackingFile()
+	}
+	return ""
+}
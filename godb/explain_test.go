@@ -0,0 +1,153 @@
+package godb
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// Run an EXPLAIN statement, returning its rows.
+func explainRows(t *testing.T, s *Session, q string) []string {
+	t.Helper()
+	res, err := s.Execute(q)
+	if err != nil {
+		t.Fatalf("%s failed, %s", q, err.Error())
+	}
+	if res.Tag != "EXPLAIN" || len(res.Desc.Fields) != 1 || res.Desc.Fields[0].Fname != "QUERY PLAN" {
+		t.Fatalf("%s: unexpected result %+v", q, res)
+	}
+	return rowStrings(res.Tuples)
+}
+
+func TestExplain(t *testing.T) {
+	s := makeBatchTestSession(t, 30)
+	for _, c := range []struct {
+		query string
+		want  []string // the plan, without costs
+	}{
+		{"explain (costs off) select name from emp where dept = 3",
+			[]string{"Project emp.name, -> [name]", "  ->  Filter emp.dept = {3}", "        ->  Heap Scan "}},
+		{"EXPLAIN (COSTS OFF) select emp.name from emp join dept on emp.dept = dept.id order by emp.name desc",
+			[]string{"Order By name DESC", "  ->  Project emp.name, -> [name]", "        ->  Join emp.dept == dept.id",
+				"              ->  Heap Scan ", "              ->  Heap Scan "}},
+		{"explain (costs off) insert into emp values ('x', 1, 2), ('y', 2, 3)",
+			[]string{"Insert emp", "  ->  Values 2 rows"}},
+		{"explain (costs off) delete from emp where salary > 100",
+			[]string{"Delete emp", "  ->  Filter emp.salary > {100}", "        ->  Heap Scan "}},
+		{"explain (costs off) create table rich as select name from emp where salary > 100",
+			[]string{"Create Table rich", "  ->  Project emp.name, -> [name]", "        ->  Filter emp.salary > {100}", "              ->  Heap Scan "}},
+		{"explain (costs off) create view names as select name from emp",
+			[]string{"Create View names", "  ->  Project emp.name, -> [name]", "        ->  Heap Scan "}},
+		{"explain (costs off) create table t (a int)", []string{"Create Table t"}},
+		{"explain (costs off) drop table emp", []string{"Drop Table emp"}},
+		{"explain (costs off) alter table emp add column bonus int", []string{"Alter Table emp"}},
+	} {
+		got := explainRows(t, s, c.query)
+		if len(got) != len(c.want) {
+			t.Errorf("%s: expected %d rows, got %q", c.query, len(c.want), got)
+			continue
+		}
+		for i, w := range c.want {
+			if !strings.HasPrefix(got[i], w) || strings.Contains(got[i], "cost=") {
+				t.Errorf("%s: expected row %d to start with %q, got %q", c.query, i, w, got[i])
+			}
+		}
+	}
+
+	// EXPLAIN does not run the statements it explains
+	res, err := s.Execute("select count(*) from emp")
+	if err != nil {
+		t.Fatalf("count failed, %s", err.Error())
+	}
+	if got := rowStrings(res.Tuples); got[0] != "30" {
+		t.Errorf("expected emp to still have 30 rows, got %v", got)
+	}
+	for _, q := range []string{"select name from rich", "select name from names", "select bonus from emp"} {
+		if _, err := s.Execute(q); err == nil {
+			t.Errorf("%s: expected an error, since the DDL was only explained", q)
+		}
+	}
+
+	// the cost of a scan is that of reading its pages, which a filter adds
+	// one per row to.  Until the statistics of the table are computed, its
+	// pages are assumed to be full.
+	desc := s.catalog.tableMap["emp"].desc
+	pages := s.catalog.tableMap["emp"].file.NumPages()
+	got := explainRows(t, s, "explain select name from emp where dept = 3")
+	scan := fmt.Sprintf("(cost=%d.00 rows=%d)", pages*CostPerPage, pages*tuplesPerPage(&desc))
+	if !strings.HasSuffix(got[2], scan) {
+		t.Errorf("expected the scan to end with %q, got %q", scan, got[2])
+	}
+	if err := s.catalog.ComputeTableStats(); err != nil {
+		t.Fatalf("ComputeTableStats failed, %s", err.Error())
+	}
+	got = explainRows(t, s, "explain select name from emp where dept = 3")
+	for i, want := range []string{
+		fmt.Sprintf("(cost=%d.00 rows=15)", pages*CostPerPage+30+15),
+		fmt.Sprintf("(cost=%d.00 rows=15)", pages*CostPerPage+30),
+		fmt.Sprintf("(cost=%d.00 rows=30)", pages*CostPerPage),
+	} {
+		if !strings.HasSuffix(got[i], want) {
+			t.Errorf("expected row %d to end with %q, got %q", i, want, got[i])
+		}
+	}
+
+	for _, q := range []string{
+		"explain (format xml) select name from emp",
+		"explain (analyze) select name from emp",
+		"explain (costs off format json) select name from emp",
+		"explain begin",
+		"explain select nosuchcolumn from emp",
+	} {
+		if _, err := s.Execute(q); err == nil {
+			t.Errorf("%s: expected an error", q)
+		}
+	}
+}
+
+func TestExplainJSON(t *testing.T) {
+	s := makeBatchTestSession(t, 30)
+	for _, costs := range []string{"on", "off"} {
+		q := "explain (format json, costs " + costs + ") select dept, count(*) from emp where salary > 10 group by dept"
+		got := explainRows(t, s, q)
+		if len(got) != 1 {
+			t.Fatalf("%s: expected a single row, got %d", q, len(got))
+		}
+		var plans []struct {
+			Plan ExplainNode
+		}
+		if err := json.Unmarshal([]byte(got[0]), &plans); err != nil {
+			t.Fatalf("%s: invalid JSON %s, %s", q, got[0], err.Error())
+		}
+		var kinds []string
+		for node := &plans[0].Plan; node != nil; {
+			kinds = append(kinds, node.Kind)
+			if (node.Cost != nil) != (costs == "on") || (node.Rows != nil) != (costs == "on") {
+				t.Errorf("%s: unexpected costs in %+v", q, node)
+			}
+			if len(node.Children) == 0 {
+				break
+			}
+			node = node.Children[0]
+		}
+		if strings.Join(kinds, ",") != "Project,Aggregate,Filter,Heap Scan" {
+			t.Errorf("%s: unexpected plan %v", q, kinds)
+		}
+	}
+}
+
+// Plans that are not wrapped in an OperatorCard, or include operators that
+// OutputPhysicalPlan did not know, are printed too.
+func TestOutputPhysicalPlan(t *testing.T) {
+	s := makeBatchTestSession(t, 10)
+	_, plan, err := Parse(s.catalog, "insert into dept values (10, 'd10')")
+	if err != nil {
+		t.Fatalf("parse failed, %s", err.Error())
+	}
+	var out strings.Builder
+	OutputPhysicalPlan(func(format string, a ...any) { fmt.Fprintf(&out, format, a...) }, plan, "")
+	if out.String() != "Insert dept, card:1\n\tValues 1 rows, card:1\n" {
+		t.Errorf("unexpected plan %q", out.String())
+	}
+}
@@ -3,6 +3,9 @@ package godb
 import (
 	"fmt"
 	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -41,10 +44,22 @@ type ConstExpr struct {
 }
 
 func (c *ConstExpr) GetExprType() FieldType {
+	if p, ok := c.val.(*paramValue); ok {
+		return FieldType{"const", p.String(), p.dbType()}
+	}
 	return FieldType{"const", fmt.Sprintf("%v", c.val), c.constType}
 }
 
+// The value of a constant is fixed when it is planned, except for parameter
+// placeholders in prepared statements (see [Stmt]), which take the value
+// most recently bound to them.
 func (c *ConstExpr) EvalExpr(_ *Tuple) (DBValue, error) {
+	if p, ok := c.val.(*paramValue); ok {
+		if p.value == nil {
+			return nil, GoDBError{IllegalOperationError, fmt.Sprintf("no value supplied for parameter %s", p)}
+		}
+		return p.value, nil
+	}
 	return c.val, nil
 }
 
@@ -54,7 +69,7 @@ type FuncExpr struct {
 }
 
 func (f *FuncExpr) GetExprType() FieldType {
-	fType, exists := funcs[f.op]
+	fType, exists := lookupFunc(f.op)
 	//todo return err
 	if !exists {
 		return FieldType{f.op, "", IntType}
@@ -66,57 +81,265 @@ func (f *FuncExpr) GetExprType() FieldType {
 			ft = fieldExpr.GetExprType()
 		}
 	}
-	return FieldType{ft.Fname, ft.TableQualifier, fType.outType}
+	return FieldType{ft.Fname, ft.TableQualifier, f.resultType(fType)}
 
 }
 
+// Return the type of the function's result, which for some functions (e.g.,
+// coalesce) is the type of their arguments.
+func (f *FuncExpr) resultType(fType FuncType) DBType {
+	if fType.outType != UnknownType {
+		return fType.outType
+	}
+	argTypes := make([]DBType, len(f.args))
+	for i, arg := range f.args {
+		argTypes[i] = (*arg).GetExprType().Ftype
+	}
+	if fType.resultType != nil {
+		return fType.resultType(argTypes)
+	}
+	var types []DBType
+	for i, t := range argTypes {
+		if fType.argType(i) == UnknownType {
+			types = append(types, t)
+		}
+	}
+	return commonType(types...)
+}
+
+// Return the type that values of the given types are converted to when they
+// are combined: strings if any of them is a string, since ints are implicitly
+// converted to strings but not the other way round.
+func commonType(types ...DBType) DBType {
+	common := UnknownType
+	for _, t := range types {
+		switch {
+		case t == StringType:
+			return StringType
+		case t == IntType:
+			common = IntType
+		}
+	}
+	return common
+}
+
 type FuncType struct {
-	argTypes []DBType
-	outType  DBType
+	argTypes []DBType // UnknownType for arguments of either type
+	outType  DBType   // UnknownType if it is the common type of the arguments
 	f        func([]any) any
+
+	// the number of trailing arguments that may be left out
+	optional int
+	// if true, the last argument may be repeated
+	variadic bool
+	// if true, f is passed nil for NULL arguments; otherwise the result is
+	// NULL if any argument is
+	nulls bool
+	// if true, the result may differ between calls with the same arguments,
+	// so calls with constant arguments are not evaluated when the query is
+	// planned
+	volatile bool
+	// the type of the result given the types of the arguments, for functions
+	// whose outType is UnknownType if it is not the common type of all of
+	// their arguments of UnknownType
+	resultType func([]DBType) DBType
+	// if set, evaluates the function instead of f, evaluating only the
+	// arguments it needs
+	eval func(args []*Expr, t *Tuple) (DBValue, error)
+
+	// how the function is written, if it is not name(args), and what it does,
+	// for ListOfFunctions
+	syntax string
+	doc    string
+}
+
+// Return the type of the i'th argument.
+func (fType FuncType) argType(i int) DBType {
+	if i >= len(fType.argTypes) {
+		if len(fType.argTypes) == 0 {
+			return UnknownType
+		}
+		return fType.argTypes[len(fType.argTypes)-1]
+	}
+	return fType.argTypes[i]
+}
+
+// Return an error if the function cannot be called with n arguments.
+func (fType FuncType) checkArgs(name string, n int) error {
+	required := len(fType.argTypes) - fType.optional
+	if n < required || n > len(fType.argTypes) && !fType.variadic {
+		if fType.optional == 0 && !fType.variadic {
+			return GoDBError{ParseError, fmt.Sprintf("function %s expected %d args", name, len(fType.argTypes))}
+		}
+		return GoDBError{ParseError, fmt.Sprintf("function %s expected %s args, got %d", name, fType.arity(), n)}
+	}
+	return nil
+}
+
+func (fType FuncType) arity() string {
+	required := len(fType.argTypes) - fType.optional
+	switch {
+	case fType.variadic:
+		return fmt.Sprintf("at least %d", required)
+	case fType.optional > 0:
+		return fmt.Sprintf("%d to %d", required, len(fType.argTypes))
+	}
+	return fmt.Sprint(required)
+}
+
+// Return how the function is called, e.g. "lpad(string, int[, string])".
+func (fType FuncType) signature(name string) string {
+	if fType.syntax != "" {
+		return fType.syntax
+	}
+	var b strings.Builder
+	b.WriteString(name + "(")
+	for i, a := range fType.argTypes {
+		optional := i >= len(fType.argTypes)-fType.optional
+		if optional {
+			b.WriteString("[")
+		}
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		switch a {
+		case UnknownType:
+			b.WriteString("any")
+		default:
+			b.WriteString(a.String())
+		}
+		if fType.variadic && i == len(fType.argTypes)-1 {
+			b.WriteString(", ...")
+		}
+	}
+	for i := 0; i < fType.optional; i++ {
+		b.WriteString("]")
+	}
+	b.WriteString(")")
+	return b.String()
 }
 
 var funcs = map[string]FuncType{
 	//note should all be lower case
-	"+":                     {[]DBType{IntType, IntType}, IntType, addFunc},
-	"-":                     {[]DBType{IntType, IntType}, IntType, minusFunc},
-	"*":                     {[]DBType{IntType, IntType}, IntType, timesFunc},
-	"/":                     {[]DBType{IntType, IntType}, IntType, divFunc},
-	"mod":                   {[]DBType{IntType, IntType}, IntType, modFunc},
-	"rand":                  {[]DBType{}, IntType, randIntFunc},
-	"sq":                    {[]DBType{IntType}, IntType, sqFunc},
-	"getsubstr":             {[]DBType{StringType, IntType, IntType}, StringType, subStrFunc},
-	"epoch":                 {[]DBType{}, IntType, epoch},
-	"datetimestringtoepoch": {[]DBType{StringType}, IntType, dateTimeToEpoch},
-	"datestringtoepoch":     {[]DBType{StringType}, IntType, dateToEpoch},
-	"epochtodatetimestring": {[]DBType{IntType}, StringType, dateString},
-	"imin":                  {[]DBType{IntType, IntType}, IntType, minFunc},
-	"imax":                  {[]DBType{IntType, IntType}, IntType, maxFunc},
+	"+":                     {argTypes: []DBType{IntType, IntType}, outType: IntType, f: addFunc, syntax: "int + int"},
+	"-":                     {argTypes: []DBType{IntType, IntType}, outType: IntType, f: minusFunc, syntax: "int - int"},
+	"*":                     {argTypes: []DBType{IntType, IntType}, outType: IntType, f: timesFunc, syntax: "int * int"},
+	"/":                     {argTypes: []DBType{IntType, IntType}, outType: IntType, f: divFunc, syntax: "int / int", doc: "integer division"},
+	"mod":                   {argTypes: []DBType{IntType, IntType}, outType: IntType, f: modFunc, doc: "remainder of integer division; also written int % int"},
+	"rand":                  {argTypes: []DBType{}, outType: IntType, volatile: true, f: randIntFunc, doc: "a random non-negative int"},
+	"sq":                    {argTypes: []DBType{IntType}, outType: IntType, f: sqFunc, doc: "the square of a number"},
+	"getsubstr":             {argTypes: []DBType{StringType, IntType, IntType}, outType: StringType, f: subStrFunc, doc: "the count characters from the 0-based start; see substr"},
+	"epoch":                 {argTypes: []DBType{}, outType: IntType, volatile: true, f: epoch, doc: "the current time in seconds since 1970"},
+	"datetimestringtoepoch": {argTypes: []DBType{StringType}, outType: IntType, f: dateTimeToEpoch, doc: "parse a time in Unix date format to seconds since 1970"},
+	"datestringtoepoch":     {argTypes: []DBType{StringType}, outType: IntType, f: dateToEpoch, doc: "parse a YYYY-MM-DD date to seconds since 1970"},
+	"epochtodatetimestring": {argTypes: []DBType{IntType}, outType: StringType, f: dateString, doc: "format seconds since 1970 in Unix date format"},
+	"imin":                  {argTypes: []DBType{IntType, IntType}, outType: IntType, f: minFunc, doc: "the smaller of two ints"},
+	"imax":                  {argTypes: []DBType{IntType, IntType}, outType: IntType, f: maxFunc, doc: "the larger of two ints"},
+
+	// operators and expressions
+	"%":           {argTypes: []DBType{IntType, IntType}, outType: IntType, f: modFunc, syntax: "int % int", doc: "remainder of integer division"},
+	"||":          {argTypes: []DBType{StringType, StringType}, outType: StringType, f: concatOpFunc, syntax: "string || string", doc: "concatenation; NULL if either is NULL"},
+	"and":         {argTypes: []DBType{IntType, IntType}, outType: IntType, f: andFunc, nulls: true, syntax: "int AND int"},
+	"or":          {argTypes: []DBType{IntType, IntType}, outType: IntType, f: orFunc, nulls: true, syntax: "int OR int"},
+	"not":         {argTypes: []DBType{IntType}, outType: IntType, f: notFunc, syntax: "NOT int"},
+	"is null":     {argTypes: []DBType{UnknownType}, outType: IntType, f: isNullFunc, nulls: true, syntax: "any IS NULL"},
+	"is not null": {argTypes: []DBType{UnknownType}, outType: IntType, f: isNotNullFunc, nulls: true, syntax: "any IS NOT NULL"},
+	"case": {argTypes: []DBType{UnknownType}, outType: UnknownType, variadic: true, eval: caseEval, resultType: caseType,
+		syntax: "CASE [x] WHEN c THEN v [WHEN ...] [ELSE e] END", doc: "the first v whose condition c holds (or that equals x), else e or NULL"},
+	"cast_int":       {argTypes: []DBType{UnknownType}, outType: IntType, f: castIntFunc, syntax: "CAST(any AS int)"},
+	"cast_text":      {argTypes: []DBType{StringType}, outType: StringType, f: identityFunc, syntax: "CAST(any AS text)"},
+	"cast_date":      {argTypes: []DBType{StringType}, outType: StringType, f: castDateFunc, syntax: "CAST(string AS date)", doc: "the date of a timestamp, as YYYY-MM-DD"},
+	"cast_timestamp": {argTypes: []DBType{StringType}, outType: StringType, f: castTimestampFunc, syntax: "CAST(string AS timestamp)", doc: "a date or time as YYYY-MM-DD HH:MM:SS"},
+
+	// NULL handling
+	"coalesce": {argTypes: []DBType{UnknownType}, outType: UnknownType, f: coalesceFunc, variadic: true, nulls: true, doc: "the first argument that is not NULL"},
+	"nullif":   {argTypes: []DBType{UnknownType, UnknownType}, outType: UnknownType, f: nullIfFunc, nulls: true, resultType: nullIfType, doc: "NULL if the arguments are equal, else the first"},
+	"greatest": {argTypes: []DBType{UnknownType}, outType: UnknownType, f: extremeFunc(OpGt), variadic: true, nulls: true, doc: "the largest argument that is not NULL"},
+	"least":    {argTypes: []DBType{UnknownType}, outType: UnknownType, f: extremeFunc(OpLt), variadic: true, nulls: true, doc: "the smallest argument that is not NULL"},
+
+	// strings; ints passed as strings are converted to their decimal form
+	"upper":       {argTypes: []DBType{StringType}, outType: StringType, f: upperFunc},
+	"lower":       {argTypes: []DBType{StringType}, outType: StringType, f: lowerFunc},
+	"trim":        {argTypes: []DBType{StringType, StringType}, outType: StringType, f: trimFunc, optional: 1, doc: "remove the characters (default a space) from both ends"},
+	"ltrim":       {argTypes: []DBType{StringType, StringType}, outType: StringType, f: ltrimFunc, optional: 1, doc: "remove the characters (default a space) from the start"},
+	"rtrim":       {argTypes: []DBType{StringType, StringType}, outType: StringType, f: rtrimFunc, optional: 1, doc: "remove the characters (default a space) from the end"},
+	"length":      {argTypes: []DBType{StringType}, outType: IntType, f: lengthFunc, doc: "the number of characters"},
+	"char_length": {argTypes: []DBType{StringType}, outType: IntType, f: lengthFunc, doc: "the number of characters"},
+	"concat":      {argTypes: []DBType{StringType}, outType: StringType, f: concatFunc, variadic: true, nulls: true, doc: "the arguments joined together, skipping NULLs"},
+	"replace":     {argTypes: []DBType{StringType, StringType, StringType}, outType: StringType, f: replaceFunc, doc: "replace(s, from, to) replaces each from in s with to"},
+	"position":    {argTypes: []DBType{StringType, StringType}, outType: IntType, f: positionFunc, syntax: "position(string IN string)", doc: "the 1-based position of the first string in the second, or 0"},
+	"substr":      {argTypes: []DBType{StringType, IntType, IntType}, outType: StringType, f: substrFunc, optional: 1, doc: "substr(s, from[, count]): count characters (default all) from the 1-based from"},
+	"lpad":        {argTypes: []DBType{StringType, IntType, StringType}, outType: StringType, f: lpadFunc, optional: 1, doc: "lpad(s, n[, fill]) pads s on the left to n characters with fill (default a space)"},
+	"rpad":        {argTypes: []DBType{StringType, IntType, StringType}, outType: StringType, f: rpadFunc, optional: 1, doc: "rpad(s, n[, fill]) pads s on the right to n characters with fill (default a space)"},
+	"left":        {argTypes: []DBType{StringType, IntType}, outType: StringType, f: leftFunc, doc: "the first n characters, or all but the last -n"},
+	"right":       {argTypes: []DBType{StringType, IntType}, outType: StringType, f: rightFunc, doc: "the last n characters, or all but the first -n"},
+	"repeat":      {argTypes: []DBType{StringType, IntType}, outType: StringType, f: repeatFunc},
+	"reverse":     {argTypes: []DBType{StringType}, outType: StringType, f: reverseFunc},
+
+	// math; there are only ints, so results are rounded down
+	"abs":     {argTypes: []DBType{IntType}, outType: IntType, f: absFunc},
+	"sign":    {argTypes: []DBType{IntType}, outType: IntType, f: signFunc, doc: "-1, 0 or 1"},
+	"round":   {argTypes: []DBType{IntType, IntType}, outType: IntType, f: roundFunc, optional: 1, doc: "round(x[, d]) rounds to d digits; d < 0 rounds to tens, hundreds and so on"},
+	"floor":   {argTypes: []DBType{IntType}, outType: IntType, f: identityFunc},
+	"ceil":    {argTypes: []DBType{IntType}, outType: IntType, f: identityFunc},
+	"ceiling": {argTypes: []DBType{IntType}, outType: IntType, f: identityFunc},
+	"power":   {argTypes: []DBType{IntType, IntType}, outType: IntType, f: powerFunc, doc: "power(x, y) is x to the y"},
+	"sqrt":    {argTypes: []DBType{IntType}, outType: IntType, f: sqrtFunc, doc: "the square root, rounded down"},
+
+	// dates and times, as strings
+	"now":               {argTypes: []DBType{}, outType: StringType, volatile: true, f: nowFunc, doc: "the current time as YYYY-MM-DD HH:MM:SS"},
+	"current_timestamp": {argTypes: []DBType{}, outType: StringType, volatile: true, f: nowFunc, doc: "the current time as YYYY-MM-DD HH:MM:SS"},
+	"current_date":      {argTypes: []DBType{}, outType: StringType, volatile: true, f: currentDateFunc, doc: "the current date as YYYY-MM-DD"},
+	"date_trunc":        {argTypes: []DBType{StringType, StringType}, outType: StringType, f: dateTruncFunc, doc: "date_trunc(unit, t) truncates t to the second, minute, hour, day, week, month, quarter or year"},
+	"extract": {argTypes: []DBType{StringType, StringType}, outType: IntType, f: extractFunc, syntax: "extract(field FROM string)",
+		doc: "the year, quarter, month, week, day, doy, dow, isodow, hour, minute, second or epoch of a time"},
+	"date_part": {argTypes: []DBType{StringType, StringType}, outType: IntType, f: extractFunc, doc: "date_part(field, t) is extract(field FROM t)"},
+}
+
+// Descriptions of the built-in aggregates for ListOfFunctions.
+var aggregateDocs = map[string]string{
+	"count": "the number of rows, or of rows where the argument is not NULL",
+	"sum":   "the sum of the argument over the rows",
+	"avg":   "the average of the argument over the rows",
+	"min":   "the least value of the argument",
+	"max":   "the greatest value of the argument",
 }
 
+// Return a description of each of the functions and aggregates that can be
+// used in queries, one per line.
 func ListOfFunctions() string {
-	fList := ""
+	funcsMutex.RLock()
+	defer funcsMutex.RUnlock()
+	lines := make(map[string]string, len(funcs)+len(aggregates))
 	for name, f := range funcs {
-		args := "("
-		argList := f.argTypes
-		hasArg := false
-		for _, a := range argList {
-			if hasArg {
-				args = args + ","
-			}
-			switch a {
-			case IntType:
-				args = args + "int"
-			case StringType:
-				args = args + "string"
-			}
-			hasArg = true
+		out := "any"
+		if f.outType != UnknownType {
+			out = f.outType.String()
+		}
+		lines[name] = fmt.Sprintf("\t%s -> %s", f.signature(name), out)
+		if f.doc != "" {
+			lines[name] += ": " + f.doc
+		}
+	}
+	for name := range aggregates {
+		doc, ok := aggregateDocs[name]
+		if !ok {
+			doc = "user-defined"
 		}
-		args = args + ")"
-		fList = fList + "\t" + name + args + "\n"
+		lines[name] = fmt.Sprintf("\t%s(any) -> aggregate: %s", name, doc)
 	}
-	return fList
+	names := make([]string, 0, len(lines))
+	for name := range lines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var fList strings.Builder
+	for _, name := range names {
+		fList.WriteString(lines[name] + "\n")
+	}
+	return fList.String()
 }
+
 func minFunc(args []any) any {
 	first := args[0].(int64)
 	second := args[1].(int64)
@@ -170,10 +393,16 @@ func randIntFunc(args []any) any {
 }
 
 func modFunc(args []any) any {
+	if args[1].(int64) == 0 {
+		return errDivisionByZero
+	}
 	return args[0].(int64) % args[1].(int64)
 }
 
 func divFunc(args []any) any {
+	if args[1].(int64) == 0 {
+		return errDivisionByZero
+	}
 	return args[0].(int64) / args[1].(int64)
 }
 
@@ -211,41 +440,69 @@ func subStrFunc(args []any) any {
 }
 
 func (f *FuncExpr) EvalExpr(t *Tuple) (DBValue, error) {
-	fType, exists := funcs[f.op]
+	fType, exists := lookupFunc(f.op)
 	if !exists {
 		return nil, GoDBError{ParseError, fmt.Sprintf("unknown function %s", f.op)}
 	}
-	if len(f.args) != len(fType.argTypes) {
-		return nil, GoDBError{ParseError, fmt.Sprintf("function %s expected %d args", f.op, len(fType.argTypes))}
-	}
-	argvals := make([]any, len(fType.argTypes))
-	for i, argType := range fType.argTypes {
-		arg := *f.args[i]
-		if arg.GetExprType().Ftype != argType {
-			typeName := "string"
-			switch argType {
-			case IntType:
-				typeName = "int"
-			}
-			return nil, GoDBError{ParseError, fmt.Sprintf("function %s expected arg of type %s", f.op, typeName)}
+	if err := fType.checkArgs(f.op, len(f.args)); err != nil {
+		return nil, err
+	}
+	if fType.eval != nil {
+		v, err := fType.eval(f.args, t)
+		if err != nil {
+			return nil, err
+		}
+		return f.convertResult(fType, v), nil
+	}
+	argvals := make([]any, len(f.args))
+	for i, argExpr := range f.args {
+		arg := *argExpr
+		argType := fType.argType(i)
+		// ints are converted to strings where strings are expected, but
+		// strings are not converted to ints
+		if argType == IntType && arg.GetExprType().Ftype == StringType {
+			return nil, GoDBError{ParseError, fmt.Sprintf("function %s expected arg of type int", f.op)}
 		}
 		val, err := arg.EvalExpr(t)
 		if err != nil {
 			return nil, err
 		}
-		switch argType {
-		case IntType:
-			argvals[i] = val.(IntField).Value
-		case StringType:
-			argvals[i] = val.(StringField).Value
+		switch val := val.(type) {
+		case IntField:
+			if argType == StringType {
+				argvals[i] = strconv.FormatInt(val.Value, 10)
+			} else {
+				argvals[i] = val.Value
+			}
+		case StringField:
+			if argType == IntType {
+				return nil, GoDBError{TypeMismatchError, fmt.Sprintf("function %s expected arg of type int, got %q", f.op, val.Value)}
+			}
+			argvals[i] = val.Value
+		case nil:
+			if !fType.nulls {
+				return nil, nil
+			}
 		}
 	}
-	result := fType.f(argvals)
-	switch fType.outType {
-	case IntType:
-		return IntField{result.(int64)}, nil
-	case StringType:
-		return StringField{result.(string)}, nil
+	switch result := fType.f(argvals).(type) {
+	case error:
+		return nil, result
+	case int64:
+		return f.convertResult(fType, IntField{result}), nil
+	case string:
+		return StringField{result}, nil
+	case nil:
+		return nil, nil
 	}
 	return nil, GoDBError{ParseError, "unknown result type in function"}
 }
+
+// Convert an int result of a function whose type depends on its arguments to
+// a string if some of them are strings.
+func (f *FuncExpr) convertResult(fType FuncType, v DBValue) DBValue {
+	if i, ok := v.(IntField); ok && fType.outType == UnknownType && f.resultType(fType) == StringType {
+		return StringField{strconv.FormatInt(i.Value, 10)}
+	}
+	return v
+}
@@ -1,15 +1,38 @@
 package godb
 
+import "fmt"
+
 type Filter struct {
 	op    BoolOp
 	left  Expr
 	right Expr
 	child Operator
+
+	// compiles and caches the patterns of a LIKE or regular expression
+	// match; nil for other operators
+	matcher *patternMatcher
 }
 
 // Construct a filter operator on ints.
 func NewFilter(constExpr Expr, op BoolOp, field Expr, child Operator) (*Filter, error) {
-	return &Filter{op, field, constExpr, child}, nil
+	return &Filter{op, field, constExpr, child, newPatternMatcher(op, defaultLikeEscape)}, nil
+}
+
+// Set the escape character of the filter's LIKE pattern, or 0 for none.
+func (f *Filter) setEscape(escape rune) {
+	f.matcher = newPatternMatcher(f.op, escape)
+}
+
+// Return whether the filter's predicate holds for the given values.  It never
+// holds for a NULL.
+func (f *Filter) eval(leftVal, rightVal DBValue) (bool, error) {
+	if f.matcher != nil {
+		return f.matcher.match(leftVal, rightVal)
+	}
+	if leftVal == nil || rightVal == nil {
+		return false, nil
+	}
+	return leftVal.EvalPred(rightVal, f.op), nil
 }
 
 // Return a TupleDescriptor for this filter op.
@@ -50,9 +73,63 @@ func (f *Filter) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
 				return nil, err
 			}
 
-			if leftVal.EvalPred(rightVal, f.op) {
+			ok, err := f.eval(leftVal, rightVal)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
 				return tuple, nil
 			}
 		}
 	}, nil
 }
+
+// Return an iterator over the rows of the child that satisfy the predicate,
+// a batch at a time. Batches with no matching rows are skipped.
+func (f *Filter) BatchIterator(tid TransactionID) (func() (*Batch, error), error) {
+	childIter, err := batchIterator(f.child, tid)
+	if err != nil {
+		return nil, err
+	}
+
+	return func() (*Batch, error) {
+		for {
+			b, err := childIter()
+			if b == nil || err != nil {
+				return nil, err
+			}
+			left, err := evalVector(f.left, b)
+			if err != nil {
+				return nil, err
+			}
+			right, err := evalVector(f.right, b)
+			if err != nil {
+				return nil, err
+			}
+			var rows []int
+			if f.matcher != nil {
+				rows, err = f.matcher.selectRows(left, right)
+				if err != nil {
+					return nil, err
+				}
+			} else {
+				rows = selectRows(left, f.op, right)
+			}
+			switch len(rows) {
+			case 0:
+				continue
+			case b.Len():
+				return b, nil
+			}
+			return b.take(rows), nil
+		}
+	}, nil
+}
+
+func (f *Filter) Explain() (string, string) {
+	return "Filter", fmt.Sprintf("%s %s %s", exprToStr(f.left), opToStr(f.op), exprToStr(f.right))
+}
+
+func (f *Filter) Children() []Operator {
+	return []Operator{f.child}
+}
@@ -0,0 +1,268 @@
+package godb
+
+// FOREIGN KEY constraints.  A foreign key requires that the values of its
+// columns in each row of the referencing (child) table appear in the
+// referenced columns of some row of the parent table, which must be the
+// parent's PRIMARY KEY or have a UNIQUE constraint.  Rows with a missing
+// (nil) value in a foreign key column are not checked.
+//
+// Inserting a child row looks its key up in the index of the parent's
+// PRIMARY KEY or UNIQUE constraint.  Each foreign key also has an index of
+// the child's keys, so deleting a parent row can tell whether any rows
+// reference it without scanning the child table.  If some do, the foreign
+// key's ON DELETE action is carried out in the deleting transaction:
+//
+//	RESTRICT, NO ACTION  the delete fails (the default)
+//	CASCADE              the referencing rows are deleted too
+//	SET NULL             the referencing columns are set to NULL
+//
+// Columns that are NOT NULL cannot have a SET NULL action.
+
+import (
+	"fmt"
+)
+
+type fkAction int
+
+const (
+	fkRestrict fkAction = iota
+	fkCascade
+	fkSetNull
+)
+
+func (a fkAction) String() string {
+	switch a {
+	case fkCascade:
+		return "cascade"
+	case fkSetNull:
+		return "set null"
+	}
+	return "restrict"
+}
+
+// Parse the REFERENCES clause of a foreign key whose columns have been
+// parsed:
+//
+//	REFERENCES table [(column, ...)] [ON DELETE action]
+func (t *sqlTokens) references(con *constraint) error {
+	if err := t.expect("references"); err != nil {
+		return err
+	}
+	var err error
+	if con.refTable, err = t.ident(); err != nil {
+		return err
+	}
+	if t.peek() == "(" {
+		if con.refColumns, err = t.identList(); err != nil {
+			return err
+		}
+	}
+	if !t.accept("on") {
+		return nil
+	}
+	if err := t.expect("delete"); err != nil {
+		return err
+	}
+	switch t.next() {
+	case "restrict":
+		con.onDelete = fkRestrict
+	case "no":
+		con.onDelete = fkRestrict
+		return t.expect("action")
+	case "cascade":
+		con.onDelete = fkCascade
+	case "set":
+		con.onDelete = fkSetNull
+		return t.expect("null")
+	default:
+		return t.errorf("expected RESTRICT, NO ACTION, CASCADE, or SET NULL")
+	}
+	return nil
+}
+
+// Find the parent table and key of foreign key con of table self, which may
+// not have been added to the catalog yet, and check that the child and
+// parent columns match.
+func (c *Catalog) resolveForeignKey(self *Table, con *constraint) error {
+	parent := self
+	if con.refTable != self.name {
+		p, err := c.GetTableInfo(con.refTable)
+		if err != nil {
+			return err
+		}
+		parent = p
+	}
+	if len(con.refColumns) == 0 {
+		for _, pc := range parent.constraints {
+			if pc.kind == primaryKeyConstraint {
+				con.refColumns = append([]string{}, pc.columns...)
+			}
+		}
+		if len(con.refColumns) == 0 {
+			return GoDBError{ParseError, fmt.Sprintf("there is no primary key for referenced table %s", parent.name)}
+		}
+	}
+	if len(con.refColumns) != len(con.columns) {
+		return GoDBError{ParseError, fmt.Sprintf("number of referencing and referenced columns for foreign key %s disagree", con.name)}
+	}
+	for i, col := range con.columns {
+		pi := parent.columnIndex(con.refColumns[i])
+		if pi < 0 {
+			return GoDBError{ParseError, fmt.Sprintf("column %s referenced in foreign key %s does not exist in table %s", con.refColumns[i], con.name, parent.name)}
+		}
+		ct, pt := self.desc.Fields[self.columnIndex(col)].Ftype, parent.desc.Fields[pi].Ftype
+		if ct != pt {
+			return GoDBError{TypeMismatchError, fmt.Sprintf("foreign key %s: column %s of type %s cannot reference column %s of type %s", con.name, col, ct, con.refColumns[i], pt)}
+		}
+		if con.onDelete == fkSetNull {
+			for _, other := range self.constraints {
+				if (other.kind == notNullConstraint || other.kind == primaryKeyConstraint) && contains(other.columns, col) {
+					return GoDBError{ParseError, fmt.Sprintf("foreign key %s cannot set column %s to null, because it is not null", con.name, col)}
+				}
+			}
+		}
+	}
+	for _, pc := range parent.constraints {
+		if (pc.kind == primaryKeyConstraint || pc.kind == uniqueConstraint) && sameColumns(pc.columns, con.refColumns) {
+			con.parent = parent
+			con.refKey = pc
+			return nil
+		}
+	}
+	return GoDBError{ParseError, fmt.Sprintf("there is no unique constraint matching the columns of table %s referenced by foreign key %s", parent.name, con.name)}
+}
+
+// Add foreign key con, whose name has been set, to table t.
+func (c *Catalog) addForeignKey(t *Table, con *constraint) error {
+	for _, col := range con.columns {
+		if t.columnIndex(col) < 0 {
+			return GoDBError{ParseError, fmt.Sprintf("column %s named in constraint does not exist in table %s", col, t.name)}
+		}
+	}
+	if err := c.resolveForeignKey(t, con); err != nil {
+		return err
+	}
+	t.constraints = append(t.constraints, con)
+	c.linkConstraint(t, con)
+	t.resetIndexes()
+	return nil
+}
+
+func contains(cols []string, col string) bool {
+	for _, c := range cols {
+		if c == col {
+			return true
+		}
+	}
+	return false
+}
+
+// Return true if a and b have the same columns, in any order.
+func sameColumns(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, col := range a {
+		if !contains(b, col) {
+			return false
+		}
+	}
+	return true
+}
+
+// Return an error unless the parent table has a row with the key of child
+// row row.
+func (con *constraint) checkReference(tid TransactionID, row *Tuple) error {
+	child := con.table
+	fields := make([]DBValue, len(con.refKey.columns))
+	for i, pc := range con.refKey.columns {
+		for j, rc := range con.refColumns {
+			if rc == pc {
+				fields[i] = row.Fields[child.columnIndex(con.columns[j])]
+			}
+		}
+		if fields[i] == nil {
+			return nil
+		}
+	}
+	n, busy, err := con.refKey.index.lookup(con.parent.file, tid, (&Tuple{Fields: fields}).tupleKey())
+	if err != nil {
+		return err
+	}
+	if n == 0 || busy {
+		return GoDBError{ConstraintViolationError, fmt.Sprintf("insert into table %s violates foreign key constraint %s", child.name, con.name)}
+	}
+	return nil
+}
+
+// Carry out the ON DELETE action of the foreign key for the rows of the child
+// table that reference parent row row, which tid has deleted.
+func (con *constraint) deleteReferences(tid TransactionID, row *Tuple) error {
+	child := con.table
+	fields := make([]DBValue, len(con.refColumns))
+	for i, rc := range con.refColumns {
+		fields[i] = row.Fields[con.parent.columnIndex(rc)]
+		if fields[i] == nil {
+			return nil
+		}
+	}
+	k := (&Tuple{Fields: fields}).tupleKey()
+	n, busy, err := con.index.lookup(child.file, tid, k)
+	if err != nil {
+		return err
+	}
+	if n == 0 && !busy {
+		return nil
+	}
+	if busy || con.onDelete == fkRestrict {
+		return GoDBError{ConstraintViolationError, fmt.Sprintf("delete on table %s violates foreign key constraint %s on table %s", con.parent.name, con.name, child.name)}
+	}
+
+	// find the referencing rows before changing any, since the child
+	// table may be the parent table
+	var rows []*Tuple
+	iter, err := child.file.Iterator(tid)
+	if err != nil {
+		return err
+	}
+	for {
+		t, err := iter()
+		if err != nil {
+			return err
+		}
+		if t == nil {
+			break
+		}
+		if ck, ok := con.index.key(t); ok && ck == k {
+			rows = append(rows, &Tuple{Desc: t.Desc, Fields: t.Fields, Rid: t.Rid})
+		}
+	}
+
+	desc := *child.file.Descriptor()
+	for _, r := range rows {
+		if err := child.file.deleteTuple(r, tid); err != nil {
+			return err
+		}
+		if con.onDelete == fkCascade {
+			if err := child.noteDelete(tid, r); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := child.removeKeys(tid, r); err != nil {
+			return err
+		}
+		updated := &Tuple{Desc: desc, Fields: append([]DBValue{}, r.Fields...)}
+		for _, col := range con.columns {
+			i := child.columnIndex(col)
+			updated.Fields[i] = nil
+		}
+		if err := child.checkInsert(tid, updated); err != nil {
+			return err
+		}
+		if err := child.file.insertTuple(updated, tid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package godb
+
+import (
+	"testing"
+)
+
+func makeForeignKeyTestSession(t *testing.T, action string) (*Session, string) {
+	t.Helper()
+	s, dir := makeConstraintTestSession(t, "create table customers (id int primary key, name text)")
+	for _, q := range []string{
+		"create table orders (id int primary key, customer int references customers " + action + ", item text)",
+		"insert into customers values (1, 'sam'), (2, 'amy')",
+		"insert into orders values (10, 1, 'book'), (11, 1, 'pen'), (12, 2, 'cup')",
+	} {
+		if _, err := s.Execute(q); err != nil {
+			t.Fatalf("%s failed, %s", q, err.Error())
+		}
+	}
+	return s, dir
+}
+
+func TestForeignKeyInsertRestrict(t *testing.T) {
+	s, _ := makeForeignKeyTestSession(t, "")
+	expectViolation(t, s, "insert into orders values (13, 3, 'hat')")
+	expectViolation(t, s, "delete from customers where id = 1")
+	checkAlterQuery(t, s, "select name from customers where id = 1", []DBValue{StringField{"sam"}})
+
+	// a customer can be deleted once their orders are
+	for _, q := range []string{
+		"delete from orders where customer = 2",
+		"delete from customers where id = 2",
+	} {
+		if _, err := s.Execute(q); err != nil {
+			t.Fatalf("%s failed, %s", q, err.Error())
+		}
+	}
+	if _, err := s.Execute("drop table customers"); err == nil {
+		t.Errorf("expected an error dropping a referenced table")
+	}
+}
+
+func TestForeignKeyCascade(t *testing.T) {
+	s, _ := makeForeignKeyTestSession(t, "on delete cascade")
+	for _, q := range []string{"begin", "delete from customers where id = 1"} {
+		if _, err := s.Execute(q); err != nil {
+			t.Fatalf("%s failed, %s", q, err.Error())
+		}
+	}
+	checkAlterQuery(t, s, "select item from orders", []DBValue{StringField{"cup"}})
+	// the cascaded deletes are part of the transaction
+	if _, err := s.Execute("rollback"); err != nil {
+		t.Fatalf("rollback failed, %s", err.Error())
+	}
+	checkAlterQuery(t, s, "select item from orders where customer = 1 order by item", []DBValue{StringField{"book"}}, []DBValue{StringField{"pen"}})
+	if _, err := s.Execute("delete from customers where id = 1"); err != nil {
+		t.Fatalf("delete failed, %s", err.Error())
+	}
+	checkAlterQuery(t, s, "select item from orders", []DBValue{StringField{"cup"}})
+}
+
+func TestForeignKeySetNull(t *testing.T) {
+	s, _ := makeForeignKeyTestSession(t, "on delete set null")
+	if _, err := s.Execute("delete from customers where id = 2"); err != nil {
+		t.Fatalf("delete failed, %s", err.Error())
+	}
+	checkAlterQuery(t, s, "select customer is null from orders where id = 12", []DBValue{IntField{1}})
+	checkAlterQuery(t, s, "select id from orders where customer = 1 order by id", []DBValue{IntField{10}}, []DBValue{IntField{11}})
+
+	for _, q := range []string{
+		"create table bad (a int not null references customers on delete set null)",
+		"create table bad (a text references customers)",
+		"create table bad (a int references customers (name))",
+		"create table bad (a int references nosuchtable)",
+	} {
+		if _, err := s.Execute(q); err == nil {
+			t.Errorf("expected %s to fail", q)
+		}
+	}
+}
+
+func TestForeignKeyCatalog(t *testing.T) {
+	s, dir := makeForeignKeyTestSession(t, "on delete cascade")
+	// a table that references itself, and is saved before the table it
+	// references
+	q := "create table a_employees (id int primary key, boss int, cust int, foreign key (boss) references a_employees (id) on delete cascade, constraint works_for foreign key (cust) references customers)"
+	if _, err := s.Execute(q); err != nil {
+		t.Fatalf("create failed, %s", err.Error())
+	}
+
+	bp, _ := NewBufferPool(100)
+	c, err := NewCatalogFromFile("catalog.txt", bp, dir)
+	if err != nil {
+		t.Fatalf("failed to reload catalog, %s", err.Error())
+	}
+	s = NewSession(c, bp)
+	for _, q := range []string{
+		"insert into a_employees values (1, 1, 2)",
+		"insert into a_employees values (2, 1, 2)",
+		"insert into a_employees values (3, 2, 1)",
+	} {
+		if _, err := s.Execute(q); err != nil {
+			t.Fatalf("%s failed, %s", q, err.Error())
+		}
+	}
+	expectViolation(t, s, "insert into a_employees values (4, 7, 1)")
+	expectViolation(t, s, "delete from customers where id = 2")
+	if _, err := s.Execute("delete from a_employees where id = 2"); err != nil {
+		t.Fatalf("delete failed, %s", err.Error())
+	}
+	checkAlterQuery(t, s, "select id from a_employees", []DBValue{IntField{1}})
+	if _, err := s.Execute("delete from customers where id = 1"); err != nil {
+		t.Fatalf("delete failed, %s", err.Error())
+	}
+	checkAlterQuery(t, s, "select id from orders", []DBValue{IntField{12}})
+}
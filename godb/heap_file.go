@@ -1,11 +1,9 @@
 package godb
 
 import (
-	"bufio"
 	"bytes"
 	"fmt"
 	"os"
-	"strconv"
 	"strings"
 	"sync"
 )
@@ -68,65 +66,11 @@ func (f *HeapFile) NumPages() int {
 // - hasHeader:  whether or not the CSV file has a header
 // - sep: the character to use to separate fields
 // - skipLastField: if true, the final field is skipped (some TPC datasets include a trailing separator on each line)
-// Returns an error if the field cannot be opened or if a line is malformed
-// We provide the implementation of this method, but it won't work until
-// [HeapFile.insertTuple] and some other utility functions are implemented
+// Returns an error if the field cannot be opened or if a line is malformed.
+// The rows are loaded in a transaction of their own with [HeapFile.BulkLoad].
 func (f *HeapFile) LoadFromCSV(file *os.File, hasHeader bool, sep string, skipLastField bool) error {
-	scanner := bufio.NewScanner(file)
-	cnt := 0
-	tid := TransactionID(0)
-	for scanner.Scan() {
-		line := scanner.Text()
-		fields := strings.Split(line, sep)
-		if skipLastField {
-			fields = fields[0 : len(fields)-1]
-		}
-		numFields := len(fields)
-		cnt++
-		desc := f.Descriptor()
-		if desc == nil || desc.Fields == nil {
-			return GoDBError{MalformedDataError, "Descriptor was nil"}
-		}
-		if numFields != len(desc.Fields) {
-			return GoDBError{MalformedDataError, fmt.Sprintf("LoadFromCSV:  line %d (%s) does not have expected number of fields (expected %d, got %d)", cnt, line, len(f.Descriptor().Fields), numFields)}
-		}
-		if cnt == 1 && hasHeader {
-			continue
-		}
-		var newFields []DBValue
-		for fno, field := range fields {
-			switch f.Descriptor().Fields[fno].Ftype {
-			case IntType:
-				field = strings.TrimSpace(field)
-				floatVal, err := strconv.ParseFloat(field, 64)
-				if err != nil {
-					return GoDBError{TypeMismatchError, fmt.Sprintf("LoadFromCSV: couldn't convert value %s to int, tuple %d", field, cnt)}
-				}
-				intValue := int(floatVal)
-				newFields = append(newFields, IntField{int64(intValue)})
-			case StringType:
-				if len(field) > StringLength {
-					field = field[0:StringLength]
-				}
-				newFields = append(newFields, StringField{field})
-			}
-		}
-		newT := Tuple{*f.Descriptor(), newFields, nil}
-		// tid := NewTid()
-		bp := f.bufPool
-		f.insertTuple(&newT, tid)
-
-		// Force dirty pages to disk. CommitTransaction may not be implemented
-		// yet if this is called in lab 1 or 2.
-		bp.FlushAllPages()
-
-	}
-	delete(f.bufPool.transactionPages, tid)
-	delete(f.bufPool.concurrentAccessRecord, tid)
-	delete(f.bufPool.dirtyPages, tid)
-	delete(f.bufPool.sharedPages, tid)
-	delete(f.bufPool.runningTransactions, tid)
-	return nil
+	_, err := f.BulkLoad(file, BulkLoadOptions{HasHeader: hasHeader, Separator: sep, SkipLastField: skipLastField})
+	return err
 }
 
 // Read the specified page number from the HeapFile on disk. This method is
@@ -181,6 +125,9 @@ func (f *HeapFile) readPage(pageNo int) (Page, error) {
 // The page the tuple is inserted into should be marked as dirty.
 func (f *HeapFile) insertTuple(t *Tuple, tid TransactionID) error {
 	// TODO: some code goes here
+	if err := checkStorable(t); err != nil {
+		return err
+	}
 	f.mutex.Lock()
 	defer f.mutex.Unlock()
 	for pageNo := 0; pageNo < f.NumPages(); pageNo++ {
@@ -216,9 +163,9 @@ func (f *HeapFile) insertTuple(t *Tuple, tid TransactionID) error {
 	defer f.bufPool.mutex.Unlock()
 	_, ok := f.bufPool.pages[f.pageKey(newPageNo)]
 	if len(f.bufPool.pages) > f.bufPool.numPages && !ok {
-		for _, page := range f.bufPool.pages {
+		for key, page := range f.bufPool.pages {
 			if !page.isDirty() {
-				delete(f.bufPool.pages, page.getFile().pageKey(page.(*heapPage).PageNo))
+				delete(f.bufPool.pages, key)
 				break
 			}
 		}
@@ -351,6 +298,49 @@ func (f *HeapFile) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
 	}, nil
 }
 
+// Return an iterator over the tuples of the file in batches of up to
+// [BatchSize] rows, in the order [HeapFile.Iterator] returns them.
+func (f *HeapFile) BatchIterator(tid TransactionID) (func() (*Batch, error), error) {
+	return f.pageBatches(tid, 0, -1), nil
+}
+
+// Return an iterator over the tuples of pages [start, end) of the file in
+// batches. If end is negative, the pages to the end of the file are read.
+func (f *HeapFile) pageBatches(tid TransactionID, start, end int) func() (*Batch, error) {
+	pgIndex, slot := start, 0
+	var page *heapPage
+
+	return func() (*Batch, error) {
+		var b *Batch
+		for (pgIndex < end || end < 0) && pgIndex < f.numPages && (b == nil || b.Len() < BatchSize) {
+			if page == nil {
+				pg, err := f.bufPool.GetPage(f, pgIndex, tid, ReadPerm)
+				if err != nil {
+					return nil, err
+				}
+				page, slot = pg.(*heapPage), 0
+			}
+			for ; slot < page.numSlots && (b == nil || b.Len() < BatchSize); slot++ {
+				t := page.tuples[slot]
+				if t == nil {
+					continue
+				}
+				if b == nil {
+					b = newBatch(&t.Desc, BatchSize)
+				}
+				// the page's tuples may be read by other workers at the
+				// same time, so they are not changed
+				b.appendRow(t.Fields, HeapRecordID{PageNumber: page.PageNo, SlotNumber: slot})
+			}
+			if slot >= page.numSlots {
+				pgIndex++
+				page = nil
+			}
+		}
+		return b, nil
+	}
+}
+
 // internal strucuture to use as key for a heap page
 type heapHash struct {
 	FileName string
@@ -368,3 +358,95 @@ func (f *HeapFile) pageKey(pgNo int) any {
 		PageNo:   pgNo,
 	}
 }
+
+func (f *HeapFile) Explain() (string, string) {
+	return "Heap Scan", f.BackingFile()
+}
+
+func (f *HeapFile) Children() []Operator {
+	return nil
+}
+
+// A heapColumnScan returns only some of the columns of the rows of a heap
+// file scan, which is the file or a range of its pages, keeping their names,
+// table qualifiers and record ids.  Joins scan heap files this way so that
+// the rows they build are no wider than the plan needs; see
+// pruneScanColumns.
+type heapColumnScan struct {
+	scan    Operator
+	columns []int
+	desc    TupleDesc
+}
+
+// Return a scan of file returning the columns in used, or nil if it uses all
+// of them.
+func planHeapColumnScan(file *HeapFile, used []FieldType) *heapColumnScan {
+	s := &heapColumnScan{scan: file}
+	for i, ok := range usedColumns(file.Descriptor(), used) {
+		if ok {
+			s.columns = append(s.columns, i)
+		}
+	}
+	if len(s.columns) == len(file.Desc.Fields) {
+		return nil
+	}
+	if len(s.columns) == 0 {
+		// e.g. count(*); keep one column
+		s.columns = []int{0}
+	}
+	for _, i := range s.columns {
+		s.desc.Fields = append(s.desc.Fields, file.Desc.Fields[i])
+	}
+	return s
+}
+
+// Return a copy of the scan that scans scan instead, e.g., a range of the
+// file's pages.
+func (s *heapColumnScan) withScan(scan Operator) *heapColumnScan {
+	c := *s
+	c.scan = scan
+	return &c
+}
+
+func (s *heapColumnScan) Descriptor() *TupleDesc {
+	return &s.desc
+}
+
+func (s *heapColumnScan) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	iter, err := s.BatchIterator(tid)
+	if err != nil {
+		return nil, err
+	}
+	return batchTuples(iter), nil
+}
+
+func (s *heapColumnScan) BatchIterator(tid TransactionID) (func() (*Batch, error), error) {
+	iter, err := batchIterator(s.scan, tid)
+	if err != nil {
+		return nil, err
+	}
+	return func() (*Batch, error) {
+		b, err := iter()
+		if b == nil || err != nil {
+			return nil, err
+		}
+		out := &Batch{Desc: &s.desc, Columns: make([]*Vector, len(s.columns)), Rids: b.Rids}
+		for i, col := range s.columns {
+			out.Columns[i] = b.Columns[col]
+		}
+		return out, nil
+	}, nil
+}
+
+func (s *heapColumnScan) Explain() (string, string) {
+	kind, detail := s.scan.(Explainable).Explain()
+	var cols []string
+	for _, f := range s.desc.Fields {
+		cols = append(cols, f.Fname)
+	}
+	return kind, fmt.Sprintf("%s (%s)", detail, strings.Join(cols, ","))
+}
+
+func (s *heapColumnScan) Children() []Operator {
+	return nil
+}
@@ -4,6 +4,9 @@ type InsertOp struct {
 	// TODO: some code goes here
 	insertFile DBFile
 	child      Operator
+	// the catalog entry of insertFile, whose constraints are checked before
+	// each insert, or nil
+	table *Table
 }
 
 // Construct an insert operator that inserts the records in the child Operator
@@ -31,7 +34,12 @@ func (i *InsertOp) Descriptor() *TupleDesc {
 func (iop *InsertOp) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
 	// TODO: some code goes here
 	count := int64(0)
+	done := false
 	return func() (*Tuple, error) {
+		if done {
+			return nil, nil
+		}
+		done = true
 		childIterator, err := iop.child.Iterator(tid)
 		if err != nil {
 			return nil, err
@@ -47,7 +55,16 @@ func (iop *InsertOp) Iterator(tid TransactionID) (func() (*Tuple, error), error)
 				break
 			}
 
-			iop.insertFile.insertTuple(tuple, tid)
+			// store the tuple with the table's descriptor, not the child's
+			newTuple := &Tuple{Desc: *iop.insertFile.Descriptor(), Fields: tuple.Fields}
+			if iop.table != nil {
+				if err := iop.table.checkInsert(tid, newTuple); err != nil {
+					return nil, err
+				}
+			}
+			if err := iop.insertFile.insertTuple(newTuple, tid); err != nil {
+				return nil, err
+			}
 			count += 1
 		}
 		return &Tuple{
@@ -58,3 +75,11 @@ func (iop *InsertOp) Iterator(tid TransactionID) (func() (*Tuple, error), error)
 		}, nil
 	}, nil
 }
+
+func (iop *InsertOp) Explain() (string, string) {
+	return "Insert", targetName(iop.insertFile, iop.table)
+}
+
+func (iop *InsertOp) Children() []Operator {
+	return []Operator{iop.child}
+}
@@ -1,5 +1,7 @@
 package godb
 
+import "fmt"
+
 type EqualityJoin struct {
 	// Expressions that when applied to tuples from the left or right operators,
 	// respectively, return the value of the left or right side of the join
@@ -66,6 +68,9 @@ func (joinOp *EqualityJoin) Iterator(tid TransactionID) (func() (*Tuple, error),
 				if leftTuple == nil {
 					return nil, nil
 				}
+				if err := checkCancelled(tid); err != nil {
+					return nil, err
+				}
 
 				rightIterator, err = (*joinOp.right).Iterator(tid)
 			}
@@ -87,10 +92,114 @@ func (joinOp *EqualityJoin) Iterator(tid TransactionID) (func() (*Tuple, error),
 					return nil, err
 				}
 
-				if leftFieldExpr.EvalPred(rightFieldExpr, OpEq) {
+				if leftFieldExpr != nil && leftFieldExpr.EvalPred(rightFieldExpr, OpEq) {
 					return joinTuples(leftTuple, rightTuple), nil
 				}
 			}
 		}
 	}, nil
 }
+
+// A row of a batch.
+type batchRow struct {
+	b   *Batch
+	row int
+}
+
+// Return an iterator over the results of the join, a batch at a time. The
+// rows of the right input are hashed on the value of rightField, and each row
+// of the left input is then probed against them, so the join returns the same
+// rows in the same order as [EqualityJoin.Iterator], but holds the whole
+// right input in memory.
+func (joinOp *EqualityJoin) BatchIterator(tid TransactionID) (func() (*Batch, error), error) {
+	rightIter, err := batchIterator(*joinOp.right, tid)
+	if err != nil {
+		return nil, err
+	}
+	table := make(map[DBValue][]batchRow)
+	mem := newMemoryReservation(tid)
+	for {
+		if err := checkCancelled(tid); err != nil {
+			return nil, err
+		}
+		b, err := rightIter()
+		if err != nil {
+			return nil, err
+		}
+		if b == nil {
+			break
+		}
+		if err := mem.grow(b.memorySize() + int64(mapEntryOverhead*b.Len())); err != nil {
+			return nil, err
+		}
+		keys, err := evalVector(joinOp.rightField, b)
+		if err != nil {
+			return nil, err
+		}
+		for r := 0; r < b.Len(); r++ {
+			if k := keys.Value(r); k != nil {
+				table[k] = append(table[k], batchRow{b, r})
+			}
+		}
+	}
+	leftIter, err := batchIterator(*joinOp.left, tid)
+	if err != nil {
+		return nil, err
+	}
+
+	var left *Batch
+	var leftKeys *Vector
+	row := 0
+	var matches []batchRow
+	return func() (*Batch, error) {
+		var lefts, rights []batchRow
+		for len(lefts) < BatchSize {
+			if len(matches) > 0 {
+				lefts = append(lefts, batchRow{left, row - 1})
+				rights = append(rights, matches[0])
+				matches = matches[1:]
+				continue
+			}
+			if left == nil || row >= left.Len() {
+				next, err := leftIter()
+				if err != nil {
+					return nil, err
+				}
+				if next == nil {
+					break
+				}
+				if leftKeys, err = evalVector(joinOp.leftField, next); err != nil {
+					return nil, err
+				}
+				left, row = next, 0
+			}
+			matches = table[leftKeys.Value(row)]
+			row++
+		}
+		if len(lefts) == 0 {
+			mem.release()
+			return nil, nil
+		}
+		desc := lefts[0].b.Desc.merge(rights[0].b.Desc)
+		out := newBatch(desc, len(lefts))
+		nleft := len(lefts[0].b.Columns)
+		for i := range lefts {
+			for j, col := range lefts[i].b.Columns {
+				out.Columns[j].appendFrom(col, lefts[i].row)
+			}
+			for j, col := range rights[i].b.Columns {
+				out.Columns[nleft+j].appendFrom(col, rights[i].row)
+			}
+			out.Rids = append(out.Rids, nil)
+		}
+		return out, nil
+	}, nil
+}
+
+func (joinOp *EqualityJoin) Explain() (string, string) {
+	return "Join", fmt.Sprintf("%s == %s", exprToStr(joinOp.leftField), exprToStr(joinOp.rightField))
+}
+
+func (joinOp *EqualityJoin) Children() []Operator {
+	return []Operator{*joinOp.left, *joinOp.right}
+}
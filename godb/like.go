@@ -0,0 +1,336 @@
+package godb
+
+// Pattern matching predicates: LIKE, ILIKE, SIMILAR TO and POSIX-style
+// regular expression matches (~, ~*, !~, !~*).
+//
+// sqlparser only knows LIKE and REGEXP, so before a query is parsed
+// [rewriteMatchOperators] turns the other operators into one of those with a
+// COLLATE clause on the left operand naming how the pattern is matched, e.g.
+// "name ILIKE 'a%'" becomes "name COLLATE godb_ci LIKE 'a%'".  [comparisonOp]
+// maps the result back to one of the match [BoolOp]s.
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/xwb1989/sqlparser"
+)
+
+// The escape character of LIKE and SIMILAR TO patterns without an ESCAPE
+// clause.
+const defaultLikeEscape = '\\'
+
+// The number of compiled patterns a [patternMatcher] keeps, for predicates
+// whose pattern is not a constant.
+const maxCachedPatterns = 64
+
+// Collations that rewriteMatchOperators puts on the left of a LIKE or REGEXP
+// to select a variant of the operator.
+const (
+	collateFold    = "godb_ci"
+	collateSimilar = "godb_similar"
+)
+
+var matchOperatorRegexp = regexp.MustCompile(`(?i)^(?:(not\s+)?(ilike|similar\s+to)\b|(!?)~(\*?))`)
+
+// Rewrite the ILIKE, SIMILAR TO and ~ operators outside of quoted strings in
+// query into a form that sqlparser accepts.
+func rewriteMatchOperators(query string) string {
+	var out strings.Builder
+	var quote byte
+	for i := 0; i < len(query); i++ {
+		ch := query[i]
+		if quote != 0 {
+			if ch == '\\' && quote == '\'' && i+1 < len(query) {
+				out.WriteByte(ch)
+				i++
+				out.WriteByte(query[i])
+				continue
+			}
+			if ch == quote {
+				quote = 0
+			}
+			out.WriteByte(ch)
+			continue
+		}
+		if ch == '\'' || ch == '"' || ch == '`' {
+			quote = ch
+			out.WriteByte(ch)
+			continue
+		}
+		if i > 0 && isIdentByte(query[i-1]) && isIdentByte(ch) {
+			out.WriteByte(ch)
+			continue
+		}
+		m := matchOperatorRegexp.FindStringSubmatch(query[i:])
+		if m == nil {
+			out.WriteByte(ch)
+			continue
+		}
+		not := ""
+		if m[1] != "" || m[3] != "" {
+			not = "not "
+		}
+		switch {
+		case strings.EqualFold(m[2], "ilike"):
+			out.WriteString(" collate " + collateFold + " " + not + "like ")
+		case m[2] != "":
+			out.WriteString(" collate " + collateSimilar + " " + not + "regexp ")
+		case m[4] != "":
+			out.WriteString(" collate " + collateFold + " " + not + "regexp ")
+		default:
+			out.WriteString(" " + not + "regexp ")
+		}
+		i += len(m[0]) - 1
+	}
+	return out.String()
+}
+
+func isIdentByte(ch byte) bool {
+	return ch == '_' || ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9'
+}
+
+// The match operators that rewriteMatchOperators marks with a collation, by
+// the collation and operator.
+var collatedOps = map[string]BoolOp{
+	collateFold + " like":          OpILike,
+	collateFold + " not like":      OpNotILike,
+	collateFold + " regexp":        OpIRegexp,
+	collateFold + " not regexp":    OpNotIRegexp,
+	collateSimilar + " regexp":     OpSimilar,
+	collateSimilar + " not regexp": OpNotSimilar,
+}
+
+// Return the operator of a comparison, its left operand and the escape
+// character of its pattern (0 if there is none).
+//
+// Returns an error if the operator is not supported.
+func comparisonOp(expr *sqlparser.ComparisonExpr) (BoolOp, sqlparser.Expr, rune, error) {
+	left := expr.Left
+	op, ok := BoolOpMap[expr.Operator]
+	if c, isCollate := left.(*sqlparser.CollateExpr); isCollate {
+		left = c.Expr
+		op, ok = collatedOps[c.Charset+" "+expr.Operator]
+	}
+	if !ok {
+		return 0, nil, 0, GoDBError{ParseError, fmt.Sprintf("unsupported operator %s", expr.Operator)}
+	}
+	escape := rune(defaultLikeEscape)
+	if expr.Escape != nil {
+		v, ok := expr.Escape.(*sqlparser.SQLVal)
+		if !ok || v.Type != sqlparser.StrVal || utf8.RuneCount(v.Val) > 1 {
+			return 0, nil, 0, GoDBError{ParseError, fmt.Sprintf("invalid escape string %s", sqlparser.String(expr.Escape))}
+		}
+		if op != OpLike && op != OpNotLike && op != OpILike && op != OpNotILike {
+			return 0, nil, 0, GoDBError{ParseError, "ESCAPE is only supported with LIKE and ILIKE"}
+		}
+		escape, _ = utf8.DecodeRune(v.Val)
+		if len(v.Val) == 0 {
+			escape = 0
+		}
+	}
+	return op, left, escape, nil
+}
+
+type matchKind int
+
+const (
+	likeMatch matchKind = iota
+	regexMatch
+	similarMatch
+)
+
+// How a match operator compares a value to its pattern.
+type matchMode struct {
+	kind   matchKind
+	fold   bool // case insensitive
+	negate bool
+}
+
+var matchModes = map[BoolOp]matchMode{
+	OpLike:       {likeMatch, false, false},
+	OpNotLike:    {likeMatch, false, true},
+	OpILike:      {likeMatch, true, false},
+	OpNotILike:   {likeMatch, true, true},
+	OpRegexp:     {regexMatch, false, false},
+	OpNotRegexp:  {regexMatch, false, true},
+	OpIRegexp:    {regexMatch, true, false},
+	OpNotIRegexp: {regexMatch, true, true},
+	OpSimilar:    {similarMatch, false, false},
+	OpNotSimilar: {similarMatch, false, true},
+}
+
+// Return true if op matches strings against a pattern.
+func isMatchOp(op BoolOp) bool {
+	_, ok := matchModes[op]
+	return ok
+}
+
+// Compile a pattern into a regular expression.
+func compilePattern(mode matchMode, pattern string, escape rune) (*regexp.Regexp, error) {
+	var expr strings.Builder
+	if mode.fold {
+		expr.WriteString("(?i)")
+	}
+	switch mode.kind {
+	case regexMatch:
+		expr.WriteString(pattern)
+	default:
+		expr.WriteString("(?s)^(?:")
+		escaped := false
+		for _, ch := range pattern {
+			switch {
+			case escaped:
+				expr.WriteString(regexp.QuoteMeta(string(ch)))
+				escaped = false
+			case ch == escape && escape != 0:
+				escaped = true
+			case ch == '%':
+				expr.WriteString(".*")
+			case ch == '_':
+				expr.WriteString(".")
+			case mode.kind == similarMatch && !strings.ContainsRune(".^$\\", ch):
+				// the rest of SIMILAR TO patterns is regular expression syntax
+				expr.WriteRune(ch)
+			default:
+				expr.WriteString(regexp.QuoteMeta(string(ch)))
+			}
+		}
+		if escaped {
+			return nil, GoDBError{ParseError, fmt.Sprintf("pattern %q must not end with the escape character", pattern)}
+		}
+		expr.WriteString(")$")
+	}
+	re, err := regexp.Compile(expr.String())
+	if err != nil {
+		return nil, GoDBError{ParseError, fmt.Sprintf("invalid pattern %q: %s", pattern, err.Error())}
+	}
+	return re, nil
+}
+
+// Return whether v matches pattern with the match operator op, compiling the
+// pattern each time.
+func matchPattern(v, pattern string, op BoolOp, escape rune) (bool, error) {
+	mode := matchModes[op]
+	re, err := compilePattern(mode, pattern, escape)
+	if err != nil {
+		return false, err
+	}
+	return re.MatchString(v) != mode.negate, nil
+}
+
+// Evaluates a match operator, keeping the compiled patterns.  Safe for use by
+// several goroutines.
+type patternMatcher struct {
+	op     BoolOp
+	mode   matchMode
+	escape rune
+
+	mutex    sync.Mutex
+	patterns map[string]*regexp.Regexp
+}
+
+// Return a matcher for op, or nil if op is not a match operator.
+func newPatternMatcher(op BoolOp, escape rune) *patternMatcher {
+	mode, ok := matchModes[op]
+	if !ok {
+		return nil
+	}
+	return &patternMatcher{op: op, mode: mode, escape: escape, patterns: make(map[string]*regexp.Regexp)}
+}
+
+func (m *patternMatcher) compile(pattern string) (*regexp.Regexp, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if re, ok := m.patterns[pattern]; ok {
+		return re, nil
+	}
+	re, err := compilePattern(m.mode, pattern, m.escape)
+	if err != nil {
+		return nil, err
+	}
+	if len(m.patterns) >= maxCachedPatterns {
+		clear(m.patterns)
+	}
+	m.patterns[pattern] = re
+	return re, nil
+}
+
+// Return whether v matches pattern.  Values other than strings, including
+// NULL, match nothing (not even with a negated operator).
+func (m *patternMatcher) match(v, pattern DBValue) (bool, error) {
+	if p, ok := pattern.(*paramValue); ok {
+		pattern = p.value
+	}
+	if i, ok := pattern.(IntField); ok {
+		// a quoted pattern of digits is parsed as a number
+		pattern = StringField{strconv.FormatInt(i.Value, 10)}
+	}
+	s, ok := v.(StringField)
+	p, pok := pattern.(StringField)
+	if !ok || !pok {
+		return false, nil
+	}
+	re, err := m.compile(p.Value)
+	if err != nil {
+		return false, err
+	}
+	return re.MatchString(s.Value) != m.mode.negate, nil
+}
+
+// Return the positions of the rows of left that match the patterns in right.
+func (m *patternMatcher) selectRows(left, right *Vector) ([]int, error) {
+	n := left.Len()
+	rows := make([]int, 0, n)
+	if !left.boxed && !right.boxed && left.Type == StringType && right.Type == StringType {
+		var re *regexp.Regexp
+		for i := 0; i < n; i++ {
+			if re == nil || i > 0 && right.Strings[i] != right.Strings[i-1] {
+				var err error
+				if re, err = m.compile(right.Strings[i]); err != nil {
+					return nil, err
+				}
+			}
+			if re.MatchString(left.Strings[i]) != m.mode.negate {
+				rows = append(rows, i)
+			}
+		}
+		return rows, nil
+	}
+	for i := 0; i < n; i++ {
+		ok, err := m.match(left.Value(i), right.Value(i))
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			rows = append(rows, i)
+		}
+	}
+	return rows, nil
+}
+
+// Return the literal prefix of a LIKE pattern that every matching string
+// starts with, and whether the pattern is only that prefix (has no
+// wildcards).
+func likePrefix(pattern string, escape rune) (string, bool) {
+	var prefix strings.Builder
+	escaped := false
+	for _, ch := range pattern {
+		switch {
+		case escaped:
+			prefix.WriteRune(ch)
+			escaped = false
+		case ch == escape && escape != 0:
+			escaped = true
+		case ch == '%' || ch == '_':
+			return prefix.String(), false
+		default:
+			prefix.WriteRune(ch)
+		}
+	}
+	return prefix.String(), true
+}
@@ -0,0 +1,228 @@
+package godb
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestMatchPattern(t *testing.T) {
+	for _, c := range []struct {
+		v, pattern string
+		op         BoolOp
+		escape     rune
+		want       bool
+	}{
+		{"hello", "h%", OpLike, '\\', true},
+		{"hello", "h_llo", OpLike, '\\', true},
+		{"hello", "h_lo", OpLike, '\\', false},
+		{"hello", "H%", OpLike, '\\', false},
+		{"hello", "%ll%", OpLike, '\\', true},
+		{"a.c", "a.c", OpLike, '\\', true},
+		{"abc", "a.c", OpLike, '\\', false},
+		{"a(b", "a(%", OpLike, '\\', true},
+		{"line\nbreak", "line%", OpLike, '\\', true},
+		{"50%", `50\%`, OpLike, '\\', true},
+		{"500", `50\%`, OpLike, '\\', false},
+		{"a_b", "a!_b", OpLike, '!', true},
+		{"axb", "a!_b", OpLike, '!', false},
+		{`a\b`, `a\b`, OpLike, 0, true},
+		{"hello", "h%", OpNotLike, '\\', false},
+		{"hello", "HE%", OpILike, '\\', true},
+		{"hello", "HE%", OpNotILike, '\\', false},
+		{"hello", "^h.*o$", OpRegexp, '\\', true},
+		{"hello", "ell", OpRegexp, '\\', true},
+		{"hello", "ELL", OpRegexp, '\\', false},
+		{"hello", "ELL", OpIRegexp, '\\', true},
+		{"hello", "^x", OpNotRegexp, '\\', true},
+		{"hello", "L", OpNotIRegexp, '\\', false},
+		{"abc", "(a|x)%", OpSimilar, '\\', true},
+		{"xbc", "(a|b)%", OpSimilar, '\\', false},
+		{"bc", "b", OpSimilar, '\\', false},
+		{"a.c", "a.c", OpSimilar, '\\', true},
+		{"abc", "a.c", OpSimilar, '\\', false},
+		{"aaa", "a+", OpSimilar, '\\', true},
+		{"aaa", "a+", OpNotSimilar, '\\', false},
+	} {
+		got, err := matchPattern(c.v, c.pattern, c.op, c.escape)
+		if err != nil {
+			t.Fatalf("%q%v%q failed, %s", c.v, c.op, c.pattern, err.Error())
+		}
+		if got != c.want {
+			t.Errorf("%q%v%q: expected %v", c.v, c.op, c.pattern, c.want)
+		}
+		if got := (StringField{c.v}).EvalPred(StringField{c.pattern}, c.op); got != c.want && c.escape == '\\' {
+			t.Errorf("EvalPred %q%v%q: expected %v", c.v, c.op, c.pattern, c.want)
+		}
+	}
+	if _, err := matchPattern("a", `a\`, OpLike, '\\'); err == nil {
+		t.Errorf("expected a pattern ending with the escape character to fail")
+	}
+	if _, err := matchPattern("a", "(a", OpRegexp, '\\'); err == nil {
+		t.Errorf("expected an invalid regular expression to fail")
+	}
+}
+
+func TestRewriteMatchOperators(t *testing.T) {
+	for _, c := range []struct{ query, want string }{
+		{"select * from t where a ilike 'x'", "select * from t where a  collate godb_ci like  'x'"},
+		{"select * from t where a NOT ILIKE 'x'", "select * from t where a  collate godb_ci not like  'x'"},
+		{"select * from t where a similar to 'x'", "select * from t where a  collate godb_similar regexp  'x'"},
+		{"select * from t where a~'x'", "select * from t where a regexp 'x'"},
+		{"select * from t where a !~* 'x'", "select * from t where a  collate godb_ci not regexp  'x'"},
+		{"select * from t where a = 'ilike ~'", "select * from t where a = 'ilike ~'"},
+		{"select * from t where a != 'it\\'s ~'", "select * from t where a != 'it\\'s ~'"},
+		{"select silike from t where xilike like 'a'", "select silike from t where xilike like 'a'"},
+	} {
+		if got := rewriteMatchOperators(c.query); got != c.want {
+			t.Errorf("rewriting %q: expected %q, got %q", c.query, c.want, got)
+		}
+	}
+}
+
+func TestLikeQueries(t *testing.T) {
+	s := makeBatchTestSession(t, 2000)
+	var names []string
+	for i := 0; i < 2000; i++ {
+		names = append(names, "e"+strconv.Itoa(i%997))
+	}
+	count := func(match func(string) bool) int {
+		n := 0
+		for _, name := range names {
+			if match(name) {
+				n++
+			}
+		}
+		return n
+	}
+	for _, c := range []struct {
+		where string
+		match func(string) bool
+	}{
+		{"name like 'e1_'", regexp.MustCompile(`^e1.$`).MatchString},
+		{"name like 'e9%' and dept = 3", nil},
+		{"name not like '%1%'", func(n string) bool { return !strings.Contains(n, "1") }},
+		{"name ilike 'E12%'", func(n string) bool { return strings.HasPrefix(n, "e12") }},
+		{"name not ilike 'E%'", func(string) bool { return false }},
+		{"name like 'e!_%' escape '!'", func(string) bool { return false }},
+		{"name ~ '^e(12|34)$'", func(n string) bool { return n == "e12" || n == "e34" }},
+		{"name ~* '^E7'", func(n string) bool { return strings.HasPrefix(n, "e7") }},
+		{"name !~ '0'", func(n string) bool { return !strings.Contains(n, "0") }},
+		{"name similar to 'e(5|6)_'", regexp.MustCompile(`^e[56].$`).MatchString},
+		{"name not similar to 'e%'", func(string) bool { return false }},
+	} {
+		q := "select name from emp where " + c.where
+		want := runVectorized(t, s, q, false)
+		got := runVectorized(t, s, q, true)
+		if len(got) != len(want) {
+			t.Fatalf("%s: expected %d rows from batches, got %d", q, len(want), len(got))
+		}
+		if c.match != nil && len(want) != count(c.match) {
+			t.Errorf("%s: expected %d rows, got %d", q, count(c.match), len(want))
+		}
+	}
+
+	for _, q := range []string{
+		"select name from emp where name ~ '('",
+		"select name from emp where name like 'e\\\\'",
+		"select name from emp where name ~ 'a' escape '!'",
+		"select name from emp where name like 'a' escape 'xy'",
+	} {
+		if _, err := s.Execute(q); err == nil {
+			t.Errorf("expected %s to fail", q)
+		}
+	}
+
+	stmt, err := s.Prepare("select name from emp where name like $1")
+	if err != nil {
+		t.Fatalf("prepare failed, %s", err.Error())
+	}
+	for _, pattern := range []string{"e99_", "e1%"} {
+		res, err := s.ExecuteStmt(stmt, StringField{pattern})
+		if err != nil {
+			t.Fatalf("execute failed, %s", err.Error())
+		}
+		re := regexp.MustCompile("^" + strings.NewReplacer("%", ".*", "_", ".").Replace(pattern) + "$")
+		if len(res.Tuples) != count(re.MatchString) {
+			t.Errorf("like %s: expected %d rows, got %d", pattern, count(re.MatchString), len(res.Tuples))
+		}
+	}
+}
+
+func TestLikeCheckConstraint(t *testing.T) {
+	s := makeBatchTestSession(t, 0)
+	if _, err := s.Execute("create table codes (code varchar, check (code like 'C-%'), check (code !~ '[a-z]'))"); err != nil {
+		t.Fatalf("create failed, %s", err.Error())
+	}
+	if _, err := s.Execute("insert into codes values ('C-12')"); err != nil {
+		t.Fatalf("insert failed, %s", err.Error())
+	}
+	for _, q := range []string{"insert into codes values ('D-12')", "insert into codes values ('C-ab')"} {
+		if _, err := s.Execute(q); err == nil {
+			t.Errorf("expected %s to violate a check constraint", q)
+		}
+	}
+}
+
+func TestLikeSelectivity(t *testing.T) {
+	s := makeBatchTestSession(t, 5000)
+	if err := s.catalog.ComputeTableStats(); err != nil {
+		t.Fatalf("computing stats failed, %s", err.Error())
+	}
+	stats := s.catalog.GetTableStats("emp")
+	if stats == nil || stats.baseTups != 5000 {
+		t.Fatalf("expected stats for 5000 tuples, got %v", stats)
+	}
+	for _, c := range []struct {
+		op      BoolOp
+		pattern string
+		want    float64
+	}{
+		// e1, e10-e19 and e100-e199 are 111 of the 997 names
+		{OpLike, "e1%", 111.0 / 997},
+		{OpNotLike, "e1%", 1 - 111.0/997},
+		{OpLike, "e12", 1.0 / 997},
+		{OpLike, "%12", defaultMatchSelectivity},
+		{OpILike, "E1%", defaultMatchSelectivity},
+	} {
+		got, err := stats.EstimateSelectivity("name", c.op, StringField{c.pattern})
+		if err != nil {
+			t.Fatalf("estimate failed, %s", err.Error())
+		}
+		if math.Abs(got-c.want) > 0.02 {
+			t.Errorf("%v%q: expected selectivity about %.3f, got %.3f", c.op, c.pattern, c.want, got)
+		}
+	}
+	if got, _ := (*TableStats)(nil).EstimateSelectivity("name", OpLike, StringField{"e1%"}); got != defaultMatchSelectivity {
+		t.Errorf("expected the default selectivity without stats, got %f", got)
+	}
+}
+
+// The planner estimates the rows of a LIKE filter from the selectivity of its
+// pattern.
+func TestLikeFilterCardinality(t *testing.T) {
+	s := makeBatchTestSession(t, 5000)
+	if err := s.catalog.ComputeTableStats(); err != nil {
+		t.Fatalf("computing stats failed, %s", err.Error())
+	}
+	rowsRegexp := regexp.MustCompile(`rows=(\d+)\)$`)
+	for _, c := range []struct {
+		query string
+		want  float64
+	}{
+		{"explain select name from emp where name like 'e1%'", 5000 * 111.0 / 997},
+		{"explain select name from emp where name not like 'e1%'", 5000 * (1 - 111.0/997)},
+		{"explain select name from emp where name like '%12'", 5000 * defaultMatchSelectivity},
+	} {
+		got := explainRows(t, s, c.query)
+		m := rowsRegexp.FindStringSubmatch(got[1])
+		if m == nil || !strings.HasPrefix(got[1], "  ->  Filter") {
+			t.Fatalf("%s: expected a filter with its rows, got %q", c.query, got)
+		}
+		if rows, _ := strconv.ParseFloat(m[1], 64); math.Abs(rows-c.want) > 100 {
+			t.Errorf("%s: expected about %.0f rows, got %s", c.query, c.want, m[1])
+		}
+	}
+}
@@ -30,3 +30,11 @@ func (l *LimitOp) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
 	// TODO: some code goes here
 	return nil, fmt.Errorf("LimitOp.Iterator not implemented") // replace me
 }
+
+func (l *LimitOp) Explain() (string, string) {
+	return "Limit", exprToStr(l.limitTups)
+}
+
+func (l *LimitOp) Children() []Operator {
+	return []Operator{l.child}
+}
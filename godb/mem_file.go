@@ -91,3 +91,11 @@ func CreateMemFileFromTuples(tuples []Tuple) *MemFile {
 	}
 	return file
 }
+
+func (mf *MemFile) Explain() (string, string) {
+	return "Memory Scan", ""
+}
+
+func (mf *MemFile) Children() []Operator {
+	return nil
+}
@@ -0,0 +1,275 @@
+package godb
+
+// Memory accounting.  Operators that hold rows in memory -- sorts, hash
+// tables, aggregates, DISTINCT and set operations -- reserve an estimate of
+// the memory the rows use from the [MemoryTracker] of their query, which is
+// bound to the query's transaction with [BindMemoryTracker].  A query's
+// tracker in turn reserves from [GlobalMemory], so a query fails once it
+// exceeds either its own limit or that of all running queries together.
+//
+// Reserving more than a limit allows fails with a ResourceExhaustedError,
+// which most operators return; [OrderBy] instead writes the rows it has
+// sorted so far to a temporary file and merges the files at the end.
+// Operators whose iterator is abandoned before it finishes do not release
+// their memory, so the query's tracker releases everything it still holds
+// when it is closed.
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// A MemoryTracker counts the bytes reserved from it against a limit.  It is
+// safe for concurrent use, e.g., by the workers of a parallel query.
+type MemoryTracker struct {
+	mutex sync.Mutex
+	name  string // e.g., "query", for error messages
+	limit int64  // 0 for no limit
+	used  int64
+	peak  int64
+	// the tracker reservations are also made from, if any
+	parent *MemoryTracker
+	// functions to call when the tracker is closed
+	closeHooks []func()
+}
+
+// The tracker the memory of all queries is reserved from; its limit is set
+// with [SetGlobalMemoryLimit].
+var GlobalMemory = NewMemoryTracker("global", 0, nil)
+
+// Set the number of bytes all running queries together may use, or 0 for
+// no limit.
+func SetGlobalMemoryLimit(limit int64) error {
+	return GlobalMemory.SetLimit(limit)
+}
+
+// Create a tracker allowing limit bytes to be reserved, or any number if
+// limit is 0.  If parent is not nil, reservations are also made from it.
+func NewMemoryTracker(name string, limit int64, parent *MemoryTracker) *MemoryTracker {
+	return &MemoryTracker{name: name, limit: limit, parent: parent}
+}
+
+// Change the limit of the tracker.  Memory already reserved is kept, even if
+// it is more than the new limit.
+func (m *MemoryTracker) SetLimit(limit int64) error {
+	if limit < 0 {
+		return GoDBError{IllegalOperationError, fmt.Sprintf("%s memory limit must not be negative, got %d", m.name, limit)}
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.limit = limit
+	return nil
+}
+
+// Reserve n bytes, returning a ResourceExhaustedError, without reserving
+// anything, if that would exceed the limit of the tracker or of one of its
+// parents.
+func (m *MemoryTracker) Reserve(n int64) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.limit > 0 && m.used+n > m.limit {
+		return GoDBError{ResourceExhaustedError, fmt.Sprintf("out of memory: %s memory limit of %s exceeded", m.name, formatBytes(m.limit))}
+	}
+	if m.parent != nil {
+		if err := m.parent.Reserve(n); err != nil {
+			return err
+		}
+	}
+	m.used += n
+	m.peak = max(m.peak, m.used)
+	return nil
+}
+
+// Release n bytes reserved earlier.
+func (m *MemoryTracker) Release(n int64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	// the tracker may have been closed, releasing everything, already
+	n = min(n, m.used)
+	m.used -= n
+	if m.parent != nil {
+		m.parent.Release(n)
+	}
+}
+
+// Return the number of bytes reserved.
+func (m *MemoryTracker) Used() int64 {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.used
+}
+
+// Return the largest number of bytes that have been reserved at once.
+func (m *MemoryTracker) Peak() int64 {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.peak
+}
+
+// Arrange for f to be called when the tracker is closed, e.g., to remove
+// the temporary files of a sort.
+func (m *MemoryTracker) onClose(f func()) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.closeHooks = append(m.closeHooks, f)
+}
+
+// Call the functions registered with onClose, then release everything still
+// reserved, from the tracker's parents too.  Called when the query the
+// tracker counts the memory of finishes.  The hooks run first so that, e.g.,
+// the workers of a parallel operator they stop reserve nothing after the
+// tracker is closed.
+func (m *MemoryTracker) Close() {
+	m.mutex.Lock()
+	hooks := m.closeHooks
+	m.closeHooks = nil
+	m.mutex.Unlock()
+	for _, f := range hooks {
+		f()
+	}
+	m.Release(m.Used())
+}
+
+// Return true if err is a ResourceExhaustedError.
+func isResourceExhausted(err error) bool {
+	var gerr GoDBError
+	return errors.As(err, &gerr) && gerr.code == ResourceExhaustedError
+}
+
+// Format a number of bytes, e.g., 64MB.
+func formatBytes(n int64) string {
+	for _, unit := range []string{"GB", "MB", "kB"} {
+		size := memoryUnits[unit]
+		if n >= size && n%size == 0 {
+			return fmt.Sprintf("%d%s", n/size, unit)
+		}
+	}
+	return fmt.Sprintf("%d bytes", n)
+}
+
+// The units memory sizes may be written in, as for postgres settings.
+var memoryUnits = map[string]int64{"B": 1, "kB": 1 << 10, "MB": 1 << 20, "GB": 1 << 30, "TB": 1 << 40}
+
+// the trackers bound to transactions, by TransactionID
+var tidMemory sync.Map
+
+// Reserve the memory of the statements of transaction tid from m until the
+// returned function is called, which must be done when the statement
+// finishes.
+func BindMemoryTracker(m *MemoryTracker, tid TransactionID) func() {
+	tidMemory.Store(tid, m)
+	return func() {
+		tidMemory.Delete(tid)
+	}
+}
+
+// Return the tracker bound to tid, or nil if the memory of its statements is
+// not counted.
+func queryMemory(tid TransactionID) *MemoryTracker {
+	if v, ok := tidMemory.Load(tid); ok {
+		return v.(*MemoryTracker)
+	}
+	return nil
+}
+
+// The memory an operator has reserved for the rows it holds.
+type memoryReservation struct {
+	m *MemoryTracker // nil if memory is not counted
+	n int64
+}
+
+func newMemoryReservation(tid TransactionID) *memoryReservation {
+	return &memoryReservation{m: queryMemory(tid)}
+}
+
+// Reserve n more bytes.
+func (r *memoryReservation) grow(n int64) error {
+	if r.m == nil {
+		return nil
+	}
+	if err := r.m.Reserve(n); err != nil {
+		return err
+	}
+	r.n += n
+	return nil
+}
+
+// Release n of the bytes reserved.
+func (r *memoryReservation) shrink(n int64) {
+	if r.m == nil {
+		return
+	}
+	n = min(n, r.n)
+	r.n -= n
+	r.m.Release(n)
+}
+
+// Release all of the bytes reserved.
+func (r *memoryReservation) release() {
+	r.shrink(r.n)
+}
+
+// Estimates of the memory values use, including the headers of the slices,
+// strings and interfaces that hold them.
+const (
+	tupleOverhead = 80 // the Tuple, with its TupleDesc, Fields and Rid
+	valueOverhead = 16 // the interface in the Fields of a tuple
+	intSize       = 8
+	stringSize    = 16
+	// a map entry, excluding its key and value
+	mapEntryOverhead = 48
+	// the state of an aggregate of one group, e.g., a sum
+	aggStateSize = 64
+)
+
+// Return an estimate of the bytes v uses.
+func valueMemorySize(v DBValue) int64 {
+	if s, ok := v.(StringField); ok {
+		return valueOverhead + stringSize + int64(len(s.Value))
+	}
+	return valueOverhead + intSize
+}
+
+// Return an estimate of the bytes t uses.
+func (t *Tuple) memorySize() int64 {
+	n := int64(tupleOverhead)
+	for _, f := range t.Fields {
+		n += valueMemorySize(f)
+	}
+	return n
+}
+
+// Return an estimate of the bytes a map entry keyed by the tupleKey of t
+// uses, excluding its value.
+func tupleKeyMemorySize(t *Tuple) int64 {
+	n := int64(mapEntryOverhead + stringSize)
+	for _, f := range t.Fields {
+		if _, ok := f.(StringField); ok {
+			n += int64(StringLength)
+		} else {
+			n += intSize
+		}
+	}
+	return n
+}
+
+// Return an estimate of the bytes b uses.
+func (b *Batch) memorySize() int64 {
+	n := int64(valueOverhead * b.Len()) // the Rids
+	for _, col := range b.Columns {
+		switch {
+		case col.boxed:
+			for _, v := range col.Values {
+				n += valueMemorySize(v)
+			}
+		case col.Type == IntType:
+			n += int64(intSize * len(col.Ints))
+		default:
+			for _, s := range col.Strings {
+				n += int64(stringSize + len(s))
+			}
+		}
+	}
+	return n
+}
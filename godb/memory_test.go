@@ -0,0 +1,222 @@
+package godb
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMemoryTracker(t *testing.T) {
+	global := NewMemoryTracker("global", 1000, nil)
+	q1, q2 := NewMemoryTracker("query", 600, global), NewMemoryTracker("query", 0, global)
+	if err := q1.Reserve(500); err != nil {
+		t.Fatalf("reserve failed, %s", err.Error())
+	}
+	if err := q1.Reserve(200); !isResourceExhausted(err) || !strings.Contains(err.Error(), "query memory limit") {
+		t.Errorf("expected the query limit to be exceeded, got %v", err)
+	}
+	if err := q2.Reserve(600); !isResourceExhausted(err) || !strings.Contains(err.Error(), "global memory limit of 1000 bytes") {
+		t.Errorf("expected the global limit to be exceeded, got %v", err)
+	}
+	if q2.Used() != 0 || global.Used() != 500 {
+		t.Errorf("expected failed reservations to reserve nothing, got %d and %d", q2.Used(), global.Used())
+	}
+	if err := q2.Reserve(400); err != nil {
+		t.Fatalf("reserve failed, %s", err.Error())
+	}
+	q1.Release(300)
+	closed := false
+	q1.onClose(func() { closed = true })
+	q1.Close()
+	if q1.Used() != 0 || global.Used() != 400 || !closed {
+		t.Errorf("expected closing to release everything, got %d and %d", q1.Used(), global.Used())
+	}
+	// releasing after the tracker was closed does not release the memory of
+	// other queries
+	q1.Release(200)
+	if global.Used() != 400 || q1.Peak() != 500 {
+		t.Errorf("unexpected use %d, peak %d", global.Used(), q1.Peak())
+	}
+	if err := global.SetLimit(-1); err == nil {
+		t.Errorf("expected a negative limit to be rejected")
+	}
+}
+
+func TestQueryMemoryLimit(t *testing.T) {
+	s := makeBatchTestSession(t, 500)
+	if _, err := s.Execute("set query_memory_limit = '16kB'"); err != nil {
+		t.Fatalf("set failed, %s", err.Error())
+	}
+	for _, q := range []string{
+		"select name, salary from emp",
+		"select name, count(*) from emp group by name",
+		"select distinct name from emp",
+		"select name from emp intersect select name from emp",
+		"select count(*) from dept join emp on dept.id = emp.dept",
+		"select name, rank() over (order by salary) from emp",
+		"with recursive n(i) as (select id from dept where id = 0 union select i + 1 from n where i < 900) select count(*) from n",
+	} {
+		if _, err := s.Execute(q); !isResourceExhausted(err) {
+			t.Errorf("%s: expected the memory limit to be exceeded, got %v", q, err)
+		}
+	}
+	if GlobalMemory.Used() != 0 {
+		t.Errorf("expected failed queries to release their memory, %d bytes are still used", GlobalMemory.Used())
+	}
+
+	// queries that hold few rows are not affected
+	res, err := s.Execute("select dept, count(*) from emp group by dept")
+	if err != nil {
+		t.Fatalf("query failed, %s", err.Error())
+	}
+	if len(res.Tuples) != 10 {
+		t.Errorf("expected 10 groups, got %v", rowStrings(res.Tuples))
+	}
+
+	// running out of memory aborts the transaction
+	if _, err := s.Execute("begin"); err != nil {
+		t.Fatalf("begin failed, %s", err.Error())
+	}
+	if _, err := s.Execute("select name from emp"); !isResourceExhausted(err) {
+		t.Errorf("expected the memory limit to be exceeded, got %v", err)
+	}
+	if !s.TransactionFailed() {
+		t.Errorf("expected the transaction to have failed")
+	}
+	if _, err := s.Execute("rollback"); err != nil {
+		t.Fatalf("rollback failed, %s", err.Error())
+	}
+
+	if _, err := s.Execute("set query_memory_limit = default"); err != nil {
+		t.Fatalf("set failed, %s", err.Error())
+	}
+	defer SetGlobalMemoryLimit(0)
+	if err := SetGlobalMemoryLimit(16 << 10); err != nil {
+		t.Fatalf("set global limit failed, %s", err.Error())
+	}
+	if _, err := s.Execute("select name, salary from emp"); !isResourceExhausted(err) || !strings.Contains(err.Error(), "global memory limit of 16kB") {
+		t.Errorf("expected the global memory limit to be exceeded, got %v", err)
+	}
+}
+
+// The groups of aggregates and the hash tables of joins are counted while
+// they are built, whether they are run a tuple or a batch at a time or in
+// parallel, not only the rows the query returns.
+func TestOperatorMemoryLimit(t *testing.T) {
+	s := makeBatchTestSession(t, 3000)
+	if _, err := s.Execute("set query_memory_limit = '16kB'"); err != nil {
+		t.Fatalf("set failed, %s", err.Error())
+	}
+	defer s.SetParallelWorkers(1)
+	defer func(enabled bool) { EnableVectorizedExecution = enabled }(EnableVectorizedExecution)
+	for _, q := range []string{
+		// 997 groups
+		"select count(*) from (select name, sum(salary) as s from emp group by name) as g",
+		"select count(*) from (select salary, count(*) as n from emp group by salary) as g",
+		// a join whose build side is all of emp
+		"select count(*) from dept join emp on dept.id = emp.dept",
+	} {
+		for _, workers := range []int{1, 4} {
+			if err := s.SetParallelWorkers(workers); err != nil {
+				t.Fatalf("SetParallelWorkers failed, %s", err.Error())
+			}
+			for _, vectorized := range []bool{false, true} {
+				if strings.Contains(q, "join") && workers == 1 && !vectorized {
+					// a nested loops join, which holds no rows
+					continue
+				}
+				EnableVectorizedExecution = vectorized
+				if _, err := s.Execute(q); !isResourceExhausted(err) {
+					t.Errorf("%s (%d workers, vectorized %t): expected the memory limit to be exceeded, got %v", q, workers, vectorized, err)
+				}
+			}
+		}
+	}
+	if GlobalMemory.Used() != 0 {
+		t.Errorf("expected failed queries to release their memory, %d bytes are still used", GlobalMemory.Used())
+	}
+}
+
+func TestParseMemorySize(t *testing.T) {
+	for v, want := range map[string]int64{"64MB": 64 << 20, "1024": 1 << 20, "100 B": 100, "2gb": 2 << 30, "0": 0, "default": 0} {
+		if got, err := parseMemorySize(v); err != nil || got != want {
+			t.Errorf("%s: expected %d, got %d, %v", v, want, got, err)
+		}
+	}
+	for _, v := range []string{"", "MB", "1.5GB", "10 apples"} {
+		if _, err := parseMemorySize(v); err == nil {
+			t.Errorf("%s: expected an error", v)
+		}
+	}
+	s := makeBatchTestSession(t, 10)
+	if _, err := s.Execute("set query_memory_limit = -5"); err == nil {
+		t.Errorf("expected a negative limit to be rejected")
+	}
+}
+
+// An OrderBy over more rows than fit in its memory limit writes sorted runs
+// to temporary files, and merges them.
+func TestOrderBySpill(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+	s := makeBatchTestSession(t, 2000)
+	emp := s.catalog.tableMap["emp"].file
+	fields := emp.Descriptor().Fields
+	orderBy, err := NewOrderBy([]Expr{&FieldExpr{fields[2]}, &FieldExpr{fields[0]}}, emp, []bool{false, true})
+	if err != nil {
+		t.Fatalf("NewOrderBy failed, %s", err.Error())
+	}
+
+	sorted := func(limit int64, rows int) ([]string, *MemoryTracker) {
+		t.Helper()
+		tid := NewTID()
+		if err := s.bp.BeginTransaction(tid); err != nil {
+			t.Fatalf("begin failed, %s", err.Error())
+		}
+		defer s.bp.CommitTransaction(tid)
+		mem := NewMemoryTracker("query", limit, nil)
+		defer BindMemoryTracker(mem, tid)()
+		iter, err := orderBy.Iterator(tid)
+		if err != nil {
+			t.Fatalf("iterator failed, %s", err.Error())
+		}
+		var got []*Tuple
+		for rows < 0 || len(got) < rows {
+			tup, err := iter()
+			if err != nil {
+				t.Fatalf("sort failed, %s", err.Error())
+			}
+			if tup == nil {
+				break
+			}
+			got = append(got, tup)
+		}
+		return rowStrings(got), mem
+	}
+
+	want, _ := sorted(0, -1)
+	got, mem := sorted(32<<10, -1)
+	if strings.Join(got, ";") != strings.Join(want, ";") {
+		t.Errorf("expected the spilled sort to return the same rows, got %d rows", len(got))
+	}
+	if mem.Peak() > 32<<10 || mem.Used() != 0 {
+		t.Errorf("expected at most 32kB to be used, and all released, got %d and %d", mem.Peak(), mem.Used())
+	}
+	if files, _ := os.ReadDir(tmp); len(files) != 0 {
+		t.Errorf("expected the sort's files to be removed, got %d", len(files))
+	}
+
+	// the files of a sort that is not finished are removed when its
+	// query's tracker is closed
+	got, mem = sorted(32<<10, 1)
+	if got[0] != want[0] {
+		t.Errorf("expected %s first, got %s", want[0], got[0])
+	}
+	if files, _ := os.ReadDir(tmp); len(files) == 0 {
+		t.Errorf("expected the sort to write its rows to files")
+	}
+	mem.Close()
+	if files, _ := os.ReadDir(tmp); len(files) != 0 {
+		t.Errorf("expected the sort's files to be removed, got %d", len(files))
+	}
+}
@@ -1,7 +1,15 @@
 package godb
 
 import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
 	"sort"
+	"strings"
+	"sync"
 )
 
 type OrderBy struct {
@@ -45,6 +53,19 @@ func (o *OrderBy) Descriptor() *TupleDesc {
 
 type lessFunc func(p1, p2 *Tuple) bool
 
+// Return a lessFunc that orders tuples by the value of expr, in ascending or
+// descending order.
+func exprLessFunc(expr Expr, ascending bool) lessFunc {
+	want := OrderedLessThan
+	if !ascending {
+		want = OrderedGreaterThan
+	}
+	return func(t1, t2 *Tuple) bool {
+		cmp, err := t1.compareField(t2, expr)
+		return err == nil && cmp == want
+	}
+}
+
 type multiSorter struct {
 	tuples []Tuple
 	less   []lessFunc
@@ -70,7 +91,11 @@ func (ms *multiSorter) Swap(i, j int) {
 }
 
 func (ms *multiSorter) Less(i, j int) bool {
-	p, q := &ms.tuples[i], &ms.tuples[j]
+	return ms.lessTuples(&ms.tuples[i], &ms.tuples[j])
+}
+
+// Return true if p sorts before q.
+func (ms *multiSorter) lessTuples(p, q *Tuple) bool {
 	// Try all but the last comparison.
 	var k int
 	for k = 0; k < len(ms.less)-1; k++ {
@@ -93,22 +118,90 @@ func (ms *multiSorter) Less(i, j int) bool {
 func (o *OrderBy) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
 	// TODO: some code goes here
 	allTuples := make([]Tuple, 0)
+	sorter := OrderedBy(o.sortFuncs()...)
+	mem := newMemoryReservation(tid)
+	// the runs written out when the tuples no longer fit in memory
+	var runs []*sortRun
+	removeRuns := func() {
+		for _, r := range runs {
+			r.remove()
+		}
+	}
 
-	childIterator, err := (o.child).Iterator(tid)
+	childIterator, err := planIterator(o.child, tid)
 	if err != nil {
 		return nil, err
 	}
 	for {
+		if err := checkCancelled(tid); err != nil {
+			removeRuns()
+			return nil, err
+		}
 		tuple, err := childIterator()
 		if err != nil {
-			break
+			removeRuns()
+			return nil, err
 		}
 
 		if tuple == nil {
 			break
 		}
+		size := tuple.memorySize()
+		if err := mem.grow(size); err != nil {
+			if !isResourceExhausted(err) || len(allTuples) == 0 {
+				removeRuns()
+				return nil, err
+			}
+			// sort the tuples read so far and write them out, to make room
+			// for the rest
+			sorter.Sort(allTuples)
+			run, err := writeSortRun(allTuples, mem.m)
+			if err != nil {
+				removeRuns()
+				return nil, err
+			}
+			runs = append(runs, run)
+			allTuples = allTuples[:0:0]
+			mem.release()
+			if err := mem.grow(size); err != nil {
+				removeRuns()
+				return nil, err
+			}
+		}
 		allTuples = append(allTuples, *tuple)
 	}
+	sorter.Sort(allTuples)
+	if len(runs) > 0 {
+		return mergeSortRuns(sorter, runs, allTuples, mem)
+	}
+	i := 0
+	return func() (*Tuple, error) {
+		if i < len(allTuples) {
+			curTuple := allTuples[i]
+			i++
+			return &curTuple, nil
+		} else {
+			mem.release()
+			return nil, nil
+		}
+	}, nil
+}
+
+// Return the functions comparing tuples on each of the fields sorted by.
+// Return whether v1 is before v2 when sorting by op, OpLt or OpGt.  NULLs
+// sort after every other value, as in postgres: last in ascending order,
+// and first in descending order.
+func lessOrNull(v1, v2 DBValue, op BoolOp) bool {
+	if v1 == nil || v2 == nil {
+		if op == OpLt {
+			return v1 != nil
+		}
+		return v2 != nil
+	}
+	return v1.EvalPred(v2, op)
+}
+
+func (o *OrderBy) sortFuncs() []lessFunc {
 	sortFuncs := make([]lessFunc, 0)
 	for i := 0; i < len(o.orderBy); i++ {
 		fieldToSortBy := o.orderBy[i]
@@ -121,7 +214,7 @@ func (o *OrderBy) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
 						for i, t2Field := range t2.Desc.Fields {
 							if t2Field.Fname == fieldToSortBy.GetExprType().Fname {
 								t2FieldVal := t2.Fields[i]
-								return t1FieldVal.EvalPred(t2FieldVal, OpLt)
+								return lessOrNull(t1FieldVal, t2FieldVal, OpLt)
 							}
 						}
 					}
@@ -137,7 +230,7 @@ func (o *OrderBy) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
 						for i, t2Field := range t2.Desc.Fields {
 							if t2Field.Fname == fieldToSortBy.GetExprType().Fname {
 								t2FieldVal := t2.Fields[i]
-								return t1FieldVal.EvalPred(t2FieldVal, OpGt)
+								return lessOrNull(t1FieldVal, t2FieldVal, OpGt)
 							}
 						}
 					}
@@ -147,15 +240,230 @@ func (o *OrderBy) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
 			sortFuncs = append(sortFuncs, sortFunc)
 		}
 	}
-	OrderedBy(sortFuncs...).Sort(allTuples)
-	i := 0
+	return sortFuncs
+}
+
+func (o *OrderBy) Explain() (string, string) {
+	fields := make([]string, len(o.orderBy))
+	for i, e := range o.orderBy {
+		fields[i] = exprToStr(e)
+		if !o.ascending[i] {
+			fields[i] += " DESC"
+		}
+	}
+	return "Order By", strings.Join(fields, ", ")
+}
+
+func (o *OrderBy) Children() []Operator {
+	return []Operator{o.child}
+}
+
+// A sorted run of tuples, written to a temporary file by an [OrderBy] whose
+// input does not fit in its memory limit.
+type sortRun struct {
+	file *os.File
+	desc TupleDesc
+	once sync.Once
+}
+
+// Write tuples, which are sorted, to a temporary file.  The file is removed
+// once the run has been merged, or at the latest when m is closed.
+func writeSortRun(tuples []Tuple, m *MemoryTracker) (*sortRun, error) {
+	f, err := os.CreateTemp("", "godb-sort-*")
+	if err != nil {
+		return nil, err
+	}
+	r := &sortRun{file: f, desc: tuples[0].Desc}
+	m.onClose(r.remove)
+	w := bufio.NewWriter(f)
+	for i := range tuples {
+		if err := writeRunTuple(w, &tuples[i]); err != nil {
+			r.remove()
+			return nil, err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		r.remove()
+		return nil, err
+	}
+	return r, nil
+}
+
+// Write the fields of t, each a tag byte followed by a varint for integers,
+// or the length and bytes of strings; NULLs are just a tag.  Unlike [Tuple.writeTo], strings are
+// not truncated to StringLength.  Errors writing to w are reported by its
+// Flush method.
+func writeRunTuple(w *bufio.Writer, t *Tuple) error {
+	var buf [binary.MaxVarintLen64]byte
+	for _, f := range t.Fields {
+		switch v := f.(type) {
+		case IntField:
+			w.WriteByte('i')
+			w.Write(buf[:binary.PutVarint(buf[:], v.Value)])
+		case StringField:
+			w.WriteByte('s')
+			w.Write(buf[:binary.PutUvarint(buf[:], uint64(len(v.Value)))])
+			w.WriteString(v.Value)
+		case nil:
+			w.WriteByte('n')
+		default:
+			return GoDBError{TypeMismatchError, fmt.Sprintf("cannot sort values of type %T", f)}
+		}
+	}
+	return nil
+}
+
+// Return an iterator over the tuples of the run.  The tuples do not have
+// record ids.
+func (r *sortRun) iterator() (func() (*Tuple, error), error) {
+	if _, err := r.file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	rd := bufio.NewReader(r.file)
 	return func() (*Tuple, error) {
-		if i < len(allTuples) {
-			curTuple := allTuples[i]
+		fields := make([]DBValue, len(r.desc.Fields))
+		for i := range fields {
+			tag, err := rd.ReadByte()
+			if err == io.EOF && i == 0 {
+				return nil, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			switch tag {
+			case 'i':
+				v, err := binary.ReadVarint(rd)
+				if err != nil {
+					return nil, err
+				}
+				fields[i] = IntField{v}
+			case 's':
+				n, err := binary.ReadUvarint(rd)
+				if err != nil {
+					return nil, err
+				}
+				b := make([]byte, n)
+				if _, err := io.ReadFull(rd, b); err != nil {
+					return nil, err
+				}
+				fields[i] = StringField{string(b)}
+			case 'n':
+				fields[i] = nil
+			default:
+				return nil, GoDBError{MalformedDataError, fmt.Sprintf("corrupt sort run %s", r.file.Name())}
+			}
+		}
+		return &Tuple{Desc: r.desc, Fields: fields}, nil
+	}, nil
+}
+
+// Close and delete the run's file.
+func (r *sortRun) remove() {
+	r.once.Do(func() {
+		r.file.Close()
+		os.Remove(r.file.Name())
+	})
+}
+
+// Return an iterator merging the sorted runs with the sorted tuples that
+// are still in memory.  The runs are removed, and the memory of the tuples
+// released, once all have been returned.
+func mergeSortRuns(sorter *multiSorter, runs []*sortRun, tuples []Tuple, mem *memoryReservation) (func() (*Tuple, error), error) {
+	done := func() {
+		for _, r := range runs {
+			r.remove()
+		}
+		mem.release()
+	}
+	var sources []func() (*Tuple, error)
+	for _, r := range runs {
+		iter, err := r.iterator()
+		if err != nil {
+			done()
+			return nil, err
+		}
+		sources = append(sources, iter)
+	}
+	i := 0
+	sources = append(sources, func() (*Tuple, error) {
+		if i < len(tuples) {
 			i++
-			return &curTuple, nil
-		} else {
+			return &tuples[i-1], nil
+		}
+		return nil, nil
+	})
+
+	h := &mergeHeap{sorter: sorter}
+	for j, src := range sources {
+		t, err := src()
+		if err != nil {
+			done()
+			return nil, err
+		}
+		if t != nil {
+			h.items = append(h.items, mergeItem{t, j})
+		}
+	}
+	heap.Init(h)
+	return func() (*Tuple, error) {
+		if h.Len() == 0 {
+			done()
 			return nil, nil
 		}
+		top := h.items[0]
+		next, err := sources[top.source]()
+		if err != nil {
+			done()
+			return nil, err
+		}
+		if next == nil {
+			heap.Pop(h)
+		} else {
+			h.items[0].t = next
+			heap.Fix(h, 0)
+		}
+		return top.t, nil
 	}, nil
 }
+
+// The next tuple of one of the inputs of a merge.
+type mergeItem struct {
+	t      *Tuple
+	source int
+}
+
+// A heap of the next tuples of the inputs of a merge; see [container/heap].
+// Equal tuples are returned in the order of their inputs.
+type mergeHeap struct {
+	sorter *multiSorter
+	items  []mergeItem
+}
+
+func (h *mergeHeap) Len() int {
+	return len(h.items)
+}
+
+func (h *mergeHeap) Less(i, j int) bool {
+	a, b := h.items[i], h.items[j]
+	switch {
+	case h.sorter.lessTuples(a.t, b.t):
+		return true
+	case h.sorter.lessTuples(b.t, a.t):
+		return false
+	}
+	return a.source < b.source
+}
+
+func (h *mergeHeap) Swap(i, j int) {
+	h.items[i], h.items[j] = h.items[j], h.items[i]
+}
+
+func (h *mergeHeap) Push(x any) {
+	h.items = append(h.items, x.(mergeItem))
+}
+
+func (h *mergeHeap) Pop() any {
+	x := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return x
+}
@@ -2,6 +2,7 @@ package godb
 
 import (
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -232,3 +233,49 @@ func TestOrderByFieldsOrder(t *testing.T) {
 		t.Fatalf("Unexpected descriptor of ordered tuple")
 	}
 }
+
+// NULLs sort last in ascending order and first in descending order, and
+// survive being spilled to a sort run.
+func TestOrderByNulls(t *testing.T) {
+	s := makeBatchTestSession(t, 10)
+	for _, c := range []struct{ query, want string }{
+		{"select nullif(dept, 3) as d from emp where dept < 5 order by d", "0;1;2;4;"},
+		{"select nullif(dept, 3) as d from emp where dept < 5 order by d desc", ";4;2;1;0"},
+		{"select name, nullif(dept, 3) as d from emp where dept > 1 and dept < 5 order by d desc, name", "e3,;e4,4;e2,2"},
+	} {
+		res, err := s.Execute(c.query)
+		if err != nil {
+			t.Fatalf("%s failed, %s", c.query, err.Error())
+		}
+		if got := strings.Join(rowStrings(res.Tuples), ";"); got != c.want {
+			t.Errorf("%s: expected %s, got %s", c.query, c.want, got)
+		}
+	}
+
+	t.Setenv("TMPDIR", t.TempDir())
+	desc := TupleDesc{Fields: []FieldType{{Fname: "a", Ftype: IntType}, {Fname: "b", Ftype: StringType}}}
+	mem := NewMemoryTracker("query", 0, nil)
+	defer mem.Close()
+	run, err := writeSortRun([]Tuple{{Desc: desc, Fields: []DBValue{IntField{1}, nil}}, {Desc: desc, Fields: []DBValue{nil, StringField{"x"}}}}, mem)
+	if err != nil {
+		t.Fatalf("writing the run failed, %s", err.Error())
+	}
+	iter, err := run.iterator()
+	if err != nil {
+		t.Fatalf("reading the run failed, %s", err.Error())
+	}
+	var got []*Tuple
+	for {
+		tup, err := iter()
+		if err != nil {
+			t.Fatalf("reading the run failed, %s", err.Error())
+		}
+		if tup == nil {
+			break
+		}
+		got = append(got, tup)
+	}
+	if rows := strings.Join(rowStrings(got), ";"); rows != "1,;,x" || got[0].Fields[1] != nil || got[1].Fields[0] != nil {
+		t.Errorf("expected the NULLs to be read back, got %s", rows)
+	}
+}
@@ -0,0 +1,606 @@
+package godb
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Parallel query execution.
+//
+// [Parallelize] rewrites a plan to run on several worker goroutines. The heap
+// file scan at the leaf of a pipeline of filters and projections is split into
+// page ranges, and a copy of the pipeline is run on each range; an [Exchange]
+// gathers the batches of the copies. Grouped aggregates and equality joins
+// partition their input rows by the hash of the group or join key, and run a
+// serial [Aggregator] or hash join on each partition. Each of these reserves
+// the memory of its groups or hash table from the query's [MemoryTracker].
+//
+// Parallel operators return rows in the order the workers produce them, so
+// the order of rows not sorted by an ORDER BY may differ between runs.
+
+// The number of worker goroutines a session runs each query with, unless it
+// is changed with SET parallel_workers.
+const DefaultParallelWorkers = 1
+
+// The number of batches each worker may send before waiting for them to be
+// read.
+const exchangeBufferSize = 4
+
+// A workerGroup runs the goroutines of a parallel operator, stopping them all
+// if any fails, or once the operator's consumer stops reading their rows.
+type workerGroup struct {
+	wg   sync.WaitGroup
+	quit chan struct{}
+	once sync.Once
+	err  error
+}
+
+func newWorkerGroup() *workerGroup {
+	return &workerGroup{quit: make(chan struct{})}
+}
+
+// Run f on a new goroutine, failing the group if it returns an error.
+func (g *workerGroup) run(f func() error) {
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		if err := f(); err != nil {
+			g.fail(err)
+		}
+	}()
+}
+
+func (g *workerGroup) fail(err error) {
+	g.once.Do(func() {
+		g.err = err
+		close(g.quit)
+	})
+}
+
+// Stop the goroutines of the group, if they are still running, and wait for
+// them to return.
+func (g *workerGroup) stop() {
+	g.once.Do(func() {
+		close(g.quit)
+	})
+	g.wg.Wait()
+}
+
+// Return an iterator over the batches sent on out, calling start to start
+// the group on its first call.  The group is stopped once the iterator
+// returns its last batch or an error, and, in case the iterator is abandoned
+// before then, when the memory tracker of tid's query is closed.
+func (g *workerGroup) iterator(tid TransactionID, out <-chan *Batch, start func()) func() (*Batch, error) {
+	started := false
+	return func() (*Batch, error) {
+		if !started {
+			started = true
+			if m := queryMemory(tid); m != nil {
+				m.onClose(g.stop)
+			}
+			start()
+		}
+		b, err := g.receive(out)
+		if b == nil || err != nil {
+			g.stop()
+		}
+		return b, err
+	}
+}
+
+// Send b on ch, returning false without sending it if the group has stopped.
+func (g *workerGroup) send(ch chan<- *Batch, b *Batch) bool {
+	select {
+	case ch <- b:
+		return true
+	case <-g.quit:
+		return false
+	}
+}
+
+// Receive the next batch from ch, returning nil once ch is closed or the
+// group has stopped, or the error the group failed with.
+func (g *workerGroup) receive(ch <-chan *Batch) (*Batch, error) {
+	select {
+	case b, ok := <-ch:
+		if ok {
+			return b, nil
+		}
+	case <-g.quit:
+		return nil, g.err
+	}
+	select {
+	case <-g.quit:
+		return nil, g.err
+	default:
+		return nil, nil
+	}
+}
+
+// Run each of the producers on its own goroutine, then close the channels
+// once all of them have returned.
+func (g *workerGroup) runAll(producers []func() error, chans []chan *Batch) {
+	var wg sync.WaitGroup
+	for _, f := range producers {
+		f := f
+		wg.Add(1)
+		g.run(func() error {
+			defer wg.Done()
+			return f()
+		})
+	}
+	g.run(func() error {
+		wg.Wait()
+		for _, ch := range chans {
+			close(ch)
+		}
+		return nil
+	})
+}
+
+// Return a function that reads all of the batches of op and sends each to
+// the channel chosen by route, which may split it.
+func sendBatches(g *workerGroup, op Operator, tid TransactionID, route func(*Batch) (map[int]*Batch, error), chans []chan *Batch) func() error {
+	return func() error {
+		iter, err := batchIterator(op, tid)
+		if err != nil {
+			return err
+		}
+		for {
+			if err := checkCancelled(tid); err != nil {
+				return err
+			}
+			b, err := iter()
+			if b == nil || err != nil {
+				return err
+			}
+			parts, err := route(b)
+			if err != nil {
+				return err
+			}
+			for i, part := range parts {
+				if !g.send(chans[i], part) {
+					return nil
+				}
+			}
+		}
+	}
+}
+
+// Route every batch to the first channel.
+func sendAll(b *Batch) (map[int]*Batch, error) {
+	return map[int]*Batch{0: b}, nil
+}
+
+// A heapScanRange scans pages [start, end) of a heap file, or the pages from
+// start to the end of the file if end is negative.
+type heapScanRange struct {
+	file       *HeapFile
+	start, end int
+}
+
+func (r *heapScanRange) Descriptor() *TupleDesc {
+	return r.file.Descriptor()
+}
+
+func (r *heapScanRange) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	return batchTuples(r.file.pageBatches(tid, r.start, r.end)), nil
+}
+
+func (r *heapScanRange) BatchIterator(tid TransactionID) (func() (*Batch, error), error) {
+	return r.file.pageBatches(tid, r.start, r.end), nil
+}
+
+// An Exchange runs each of its fragments, plans that each produce part of
+// the rows of a query, on its own goroutine, and gathers their rows.
+type Exchange struct {
+	fragments []Operator
+}
+
+func NewExchange(fragments ...Operator) *Exchange {
+	return &Exchange{fragments}
+}
+
+// Construct a scan that splits the pages of file into ranges, read by one
+// worker each.
+func NewParallelScan(file *HeapFile, workers int) *Exchange {
+	return NewExchange(splitPipeline(file, workers)...)
+}
+
+func (e *Exchange) Descriptor() *TupleDesc {
+	return e.fragments[0].Descriptor()
+}
+
+func (e *Exchange) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	iter, err := e.BatchIterator(tid)
+	if err != nil {
+		return nil, err
+	}
+	return batchTuples(iter), nil
+}
+
+// Return an iterator over the batches of the fragments, in the order they are
+// produced. The fragments are started on the first call to the iterator.
+func (e *Exchange) BatchIterator(tid TransactionID) (func() (*Batch, error), error) {
+	g := newWorkerGroup()
+	out := make(chan *Batch, exchangeBufferSize*len(e.fragments))
+	return g.iterator(tid, out, func() {
+		producers := make([]func() error, len(e.fragments))
+		for i, frag := range e.fragments {
+			producers[i] = sendBatches(g, frag, tid, sendAll, []chan *Batch{out})
+		}
+		g.runAll(producers, []chan *Batch{out})
+	}), nil
+}
+
+// An exchangeSource is the input of an operator run on one partition of a
+// parallel operator, reading the batches sent to that partition.
+type exchangeSource struct {
+	desc *TupleDesc
+	g    *workerGroup
+	ch   chan *Batch
+}
+
+func (s *exchangeSource) Descriptor() *TupleDesc {
+	return s.desc
+}
+
+func (s *exchangeSource) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	iter, err := s.BatchIterator(tid)
+	if err != nil {
+		return nil, err
+	}
+	return batchTuples(iter), nil
+}
+
+func (s *exchangeSource) BatchIterator(tid TransactionID) (func() (*Batch, error), error) {
+	return func() (*Batch, error) {
+		return s.g.receive(s.ch)
+	}, nil
+}
+
+// Make the channels that the batches of each of n partitions are sent on.
+func newPartitions(n int) []chan *Batch {
+	chans := make([]chan *Batch, n)
+	for i := range chans {
+		chans[i] = make(chan *Batch, exchangeBufferSize)
+	}
+	return chans
+}
+
+// Return a function that splits a batch into n partitions by the hash of the
+// values of exprs in each row.
+func hashPartitioner(exprs []Expr, n int) func(*Batch) (map[int]*Batch, error) {
+	return func(b *Batch) (map[int]*Batch, error) {
+		keys := make([]*Vector, len(exprs))
+		for i, e := range exprs {
+			k, err := evalVector(e, b)
+			if err != nil {
+				return nil, err
+			}
+			keys[i] = k
+		}
+		rows := make(map[int][]int)
+		for r := 0; r < b.Len(); r++ {
+			h := hashSeed
+			for _, k := range keys {
+				h = k.hash(h, r)
+			}
+			p := int(h % uint64(n))
+			rows[p] = append(rows[p], r)
+		}
+		parts := make(map[int]*Batch, len(rows))
+		for p, rs := range rows {
+			if len(rs) == b.Len() {
+				parts[p] = b
+			} else {
+				parts[p] = b.take(rs)
+			}
+		}
+		return parts, nil
+	}
+}
+
+// A ParallelAggregator computes a grouped aggregate by partitioning the rows
+// of its inputs by the hash of their group key, and aggregating each
+// partition on its own goroutine.
+type ParallelAggregator struct {
+	agg     *Aggregator
+	inputs  []Operator // fragments that together produce the rows of agg's child
+	workers int
+}
+
+func NewParallelAggregator(agg *Aggregator, workers int) *ParallelAggregator {
+	return &ParallelAggregator{agg, splitPipeline(agg.child, workers), workers}
+}
+
+func (p *ParallelAggregator) Descriptor() *TupleDesc {
+	return p.agg.Descriptor()
+}
+
+func (p *ParallelAggregator) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	iter, err := p.BatchIterator(tid)
+	if err != nil {
+		return nil, err
+	}
+	return batchTuples(iter), nil
+}
+
+func (p *ParallelAggregator) BatchIterator(tid TransactionID) (func() (*Batch, error), error) {
+	g := newWorkerGroup()
+	partitions := newPartitions(p.workers)
+	out := make(chan *Batch, p.workers)
+	return g.iterator(tid, out, func() {
+		route := hashPartitioner(p.agg.groupByFields, p.workers)
+		producers := make([]func() error, len(p.inputs))
+		for i, in := range p.inputs {
+			producers[i] = sendBatches(g, in, tid, route, partitions)
+		}
+		g.runAll(producers, partitions)
+
+		aggregators := make([]func() error, p.workers)
+		for i := range aggregators {
+			src := &exchangeSource{p.agg.child.Descriptor(), g, partitions[i]}
+			agg := NewGroupedAggregator(p.agg.newAggState, p.agg.groupByFields, src)
+			aggregators[i] = sendBatches(g, agg, tid, sendAll, []chan *Batch{out})
+		}
+		g.runAll(aggregators, []chan *Batch{out})
+	}), nil
+}
+
+// A ParallelHashJoin computes an equality join by partitioning the rows of
+// both of its inputs by the hash of their join key, and joining each pair of
+// partitions on its own goroutine.
+type ParallelHashJoin struct {
+	join        *EqualityJoin
+	left, right []Operator // fragments that together produce the join's inputs
+	workers     int
+}
+
+func NewParallelHashJoin(join *EqualityJoin, workers int) *ParallelHashJoin {
+	return &ParallelHashJoin{join, splitPipeline(*join.left, workers), splitPipeline(*join.right, workers), workers}
+}
+
+func (p *ParallelHashJoin) Descriptor() *TupleDesc {
+	return p.join.Descriptor()
+}
+
+func (p *ParallelHashJoin) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	iter, err := p.BatchIterator(tid)
+	if err != nil {
+		return nil, err
+	}
+	return batchTuples(iter), nil
+}
+
+func (p *ParallelHashJoin) BatchIterator(tid TransactionID) (func() (*Batch, error), error) {
+	g := newWorkerGroup()
+	leftParts, rightParts := newPartitions(p.workers), newPartitions(p.workers)
+	out := make(chan *Batch, p.workers)
+	return g.iterator(tid, out, func() {
+		for _, side := range []struct {
+			inputs []Operator
+			key    Expr
+			parts  []chan *Batch
+		}{{p.left, p.join.leftField, leftParts}, {p.right, p.join.rightField, rightParts}} {
+			route := hashPartitioner([]Expr{side.key}, p.workers)
+			producers := make([]func() error, len(side.inputs))
+			for i, in := range side.inputs {
+				producers[i] = sendBatches(g, in, tid, route, side.parts)
+			}
+			g.runAll(producers, side.parts)
+		}
+
+		joins := make([]func() error, p.workers)
+		for i := range joins {
+			var left, right Operator
+			left = &exchangeSource{(*p.join.left).Descriptor(), g, leftParts[i]}
+			right = &exchangeSource{(*p.join.right).Descriptor(), g, rightParts[i]}
+			join := &EqualityJoin{p.join.leftField, p.join.rightField, &left, &right, p.join.maxBufferSize}
+			joins[i] = sendBatches(g, join, tid, sendAll, []chan *Batch{out})
+		}
+		g.runAll(joins, []chan *Batch{out})
+	}), nil
+}
+
+// Rewrite a plan to run on the supplied number of worker goroutines. Scans,
+// with the filters and projections above them, are split into page ranges
+// and gathered by an [Exchange]; grouped aggregates and equality joins are
+// replaced by their partitioned parallel versions. Other operators are kept,
+// with their children parallelized, except for those that modify tables.
+func Parallelize(op Operator, workers int) Operator {
+	if workers <= 1 {
+		return op
+	}
+	switch o := op.(type) {
+	case *OperatorCard:
+		return &OperatorCard{o.Cardinality, Parallelize(o.Op, workers)}
+	case *Aggregator:
+		if o.groupByFields != nil {
+			return NewParallelAggregator(o, workers)
+		}
+		return NewAggregator(o.newAggState, gatherPipeline(o.child, workers))
+	case *EqualityJoin:
+		return NewParallelHashJoin(o, workers)
+	case *OrderBy:
+		sorted := *o
+		sorted.child = Parallelize(o.child, workers)
+		return &sorted
+	case *Window:
+		window := *o
+		window.child = Parallelize(o.child, workers)
+		return &window
+	case *SetOp:
+		setOp := *o
+		setOp.left = Parallelize(o.left, workers)
+		setOp.right = Parallelize(o.right, workers)
+		return &setOp
+	case *RecursiveCTE:
+		cte := *o
+		cte.anchor = Parallelize(o.anchor, workers)
+		cte.step = Parallelize(o.step, workers)
+		return &cte
+	case *Project:
+		if o.distinct {
+			proj := *o
+			proj.child = Parallelize(o.child, workers)
+			return &proj
+		}
+		return gatherPipeline(op, workers)
+	case *HeapFile, *heapColumnScan, *Filter:
+		return gatherPipeline(op, workers)
+	}
+	return op
+}
+
+// Split op into fragments and gather them with an exchange, if it is split
+// into more than one.
+func gatherPipeline(op Operator, workers int) Operator {
+	fragments := splitPipeline(op, workers)
+	if len(fragments) == 1 {
+		return fragments[0]
+	}
+	return NewExchange(fragments...)
+}
+
+// Return fragments that together produce the rows of op. If op is a heap file
+// scan, possibly under filters and non-distinct projections, the pages of the
+// file are split into up to workers ranges, with a copy of the filters and
+// projections above each; otherwise op is parallelized and returned as the
+// only fragment.
+func splitPipeline(op Operator, workers int) []Operator {
+	switch o := op.(type) {
+	case *OperatorCard:
+		return splitPipeline(o.Op, workers)
+	case *HeapFile:
+		n := min(workers, max(o.NumPages(), 1))
+		pages := (o.NumPages() + n - 1) / n
+		fragments := make([]Operator, n)
+		for i := range fragments {
+			end := (i + 1) * pages
+			if i == n-1 {
+				end = -1
+			}
+			fragments[i] = &heapScanRange{o, i * pages, end}
+		}
+		return fragments
+	case *heapColumnScan:
+		fragments := splitPipeline(o.scan, workers)
+		for i, scan := range fragments {
+			fragments[i] = o.withScan(scan)
+		}
+		return fragments
+	case *Filter:
+		fragments := splitPipeline(o.child, workers)
+		for i, child := range fragments {
+			filter := *o
+			filter.child = child
+			fragments[i] = &filter
+		}
+		return fragments
+	case *Project:
+		if o.distinct {
+			break
+		}
+		fragments := splitPipeline(o.child, workers)
+		for i, child := range fragments {
+			proj := *o
+			proj.child = child
+			fragments[i] = &proj
+		}
+		return fragments
+	}
+	return []Operator{Parallelize(op, workers)}
+}
+
+// The FNV-1a hash of no bytes, which partition hashes start from.
+const hashSeed uint64 = 14695981039346656037
+
+func hashBytes(h uint64, b []byte) uint64 {
+	for _, c := range b {
+		h ^= uint64(c)
+		h *= 1099511628211
+	}
+	return h
+}
+
+func hashInt(h uint64, x int64) uint64 {
+	h = hashBytes(h, []byte{'i'})
+	for i := 0; i < 8; i++ {
+		h ^= uint64(x>>(8*i)) & 0xff
+		h *= 1099511628211
+	}
+	return h
+}
+
+func hashString(h uint64, s string) uint64 {
+	h = hashBytes(h, []byte{'s'})
+	for i := 0; i < len(s); i++ {
+		h ^= uint64(s[i])
+		h *= 1099511628211
+	}
+	return h
+}
+
+// Add the ith value of the vector to the hash h. Equal values hash the same
+// whether or not the vector is boxed.
+func (v *Vector) hash(h uint64, i int) uint64 {
+	switch {
+	case !v.boxed && v.Type == IntType:
+		return hashInt(h, v.Ints[i])
+	case !v.boxed:
+		return hashString(h, v.Strings[i])
+	}
+	switch x := v.Values[i].(type) {
+	case IntField:
+		return hashInt(h, x.Value)
+	case StringField:
+		return hashString(h, x.Value)
+	case nil:
+		return hashBytes(h, []byte{'n'})
+	}
+	// other values are all sent to the same partition
+	return h
+}
+
+func (r *heapScanRange) Explain() (string, string) {
+	return "Heap Scan", fmt.Sprintf("%s pages %d-%d", r.file.BackingFile(), r.start, r.end-1)
+}
+
+func (r *heapScanRange) Children() []Operator {
+	return nil
+}
+
+func (e *Exchange) Explain() (string, string) {
+	return "Exchange", fmt.Sprintf("%d workers", len(e.fragments))
+}
+
+func (e *Exchange) Children() []Operator {
+	return e.fragments
+}
+
+func (s *exchangeSource) Explain() (string, string) {
+	return "Exchange Source", ""
+}
+
+func (s *exchangeSource) Children() []Operator {
+	return nil
+}
+
+func (p *ParallelAggregator) Explain() (string, string) {
+	_, detail := p.agg.Explain()
+	return "Parallel Aggregate", fmt.Sprintf("%s %d workers", detail, p.workers)
+}
+
+func (p *ParallelAggregator) Children() []Operator {
+	return p.inputs
+}
+
+func (p *ParallelHashJoin) Explain() (string, string) {
+	_, detail := p.join.Explain()
+	return "Parallel Hash Join", fmt.Sprintf("%s, %d workers", detail, p.workers)
+}
+
+func (p *ParallelHashJoin) Children() []Operator {
+	return append(append([]Operator{}, p.left...), p.right...)
+}
@@ -0,0 +1,180 @@
+package godb
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"testing"
+	"time"
+)
+
+// Return the keys of a set of tuples, sorted, to compare results whose order
+// depends on the workers.
+func sortedTupleKeys(tuples []*Tuple) []string {
+	keys := make([]string, len(tuples))
+	for i, t := range tuples {
+		keys[i] = t.tupleKey().(string)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestParallelMatchesSerialExecution(t *testing.T) {
+	s := makeBatchTestSession(t, 20000)
+	for _, q := range []string{
+		"select name, salary from emp where salary > 500",
+		"select dept, sum(salary) as total, count(*) as n, max(name) as top, min(salary) as low, avg(salary) as mean from emp group by dept",
+		"select name, count(*) as n from emp where salary < 300 group by name",
+		"select sum(salary) as total, count(*) as n from emp where dept = 3",
+		"select emp.name, dept.dname from emp join dept on emp.dept = dept.id where emp.salary < 20",
+		// both sides of the join read the same pages at the same time
+		"select a.name, b.name from emp a join emp b on a.salary = b.salary where a.dept = 2",
+		"select distinct dept from emp",
+	} {
+		want := runVectorized(t, s, q, true)
+		for _, workers := range []int{2, 3, 8} {
+			if err := s.SetParallelWorkers(workers); err != nil {
+				t.Fatalf("SetParallelWorkers failed, %s", err.Error())
+			}
+			got := runVectorized(t, s, q, true)
+			wantKeys, gotKeys := sortedTupleKeys(want), sortedTupleKeys(got)
+			if len(gotKeys) != len(wantKeys) {
+				t.Fatalf("%s with %d workers: expected %d rows, got %d", q, workers, len(wantKeys), len(gotKeys))
+			}
+			for i := range wantKeys {
+				if gotKeys[i] != wantKeys[i] {
+					t.Fatalf("%s with %d workers: results differ from serial execution", q, workers)
+				}
+			}
+		}
+		s.SetParallelWorkers(1)
+	}
+
+	// sorted results are in the same order
+	q := "select dept, count(*) as n from emp where salary > 900 group by dept order by dept"
+	want := runVectorized(t, s, q, true)
+	if _, err := s.Execute("set parallel_workers = 4"); err != nil {
+		t.Fatalf("set failed, %s", err.Error())
+	}
+	got := runVectorized(t, s, q, true)
+	if len(got) != 10 || len(got) != len(want) {
+		t.Fatalf("%s: expected 10 rows, got %d", q, len(got))
+	}
+	for i := range want {
+		if !got[i].equals(want[i]) {
+			t.Errorf("%s: row %d is %v, expected %v", q, i, got[i], want[i])
+		}
+	}
+}
+
+// The workers of a parallel query are stopped when the query stops reading
+// their rows because it fails.
+func TestParallelWorkersStop(t *testing.T) {
+	// more batches than the workers can send before they are read
+	s := makeBatchTestSession(t, 30000)
+	defer s.SetParallelWorkers(1)
+	if _, err := s.Execute("set parallel_workers = 4"); err != nil {
+		t.Fatalf("set failed, %s", err.Error())
+	}
+	before := runtime.NumGoroutine()
+	if _, err := s.Execute("set query_memory_limit = 64"); err != nil {
+		t.Fatalf("set failed, %s", err.Error())
+	}
+	for _, q := range []string{
+		"select name, salary from emp",
+		"select name, count(*) from emp group by name",
+		"select emp.name from dept join emp on dept.id = emp.dept",
+	} {
+		if _, err := s.Execute(q); !isResourceExhausted(err) {
+			t.Errorf("%s: expected the memory limit to be exceeded, got %v", q, err)
+		}
+	}
+	if _, err := s.Execute("set query_memory_limit = default"); err != nil {
+		t.Fatalf("set failed, %s", err.Error())
+	}
+	// goroutines that have been stopped may take a moment to exit
+	for i := 0; i < 100 && runtime.NumGoroutine() > before; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Errorf("expected %d goroutines, got %d", before, n)
+	}
+	if GlobalMemory.Used() != 0 {
+		t.Errorf("expected the queries to release their memory, %d bytes are still used", GlobalMemory.Used())
+	}
+}
+
+func TestParallelPlan(t *testing.T) {
+	s := makeBatchTestSession(t, 20000)
+	_, plan, err := Parse(s.catalog, "select dept, sum(salary) as total from emp where salary > 10 group by dept")
+	if err != nil {
+		t.Fatalf("parse failed, %s", err.Error())
+	}
+	var agg *ParallelAggregator
+	var find func(Operator)
+	find = func(op Operator) {
+		switch op := op.(type) {
+		case *OperatorCard:
+			find(op.Op)
+		case *Project:
+			find(op.child)
+		case *ParallelAggregator:
+			agg = op
+		}
+	}
+	find(Parallelize(plan, 4))
+	if agg == nil {
+		t.Fatalf("expected a parallel aggregator in the plan")
+	}
+	if len(agg.inputs) != 4 {
+		t.Fatalf("expected the scan to be split into 4 page ranges, got %d", len(agg.inputs))
+	}
+	pages := 0
+	for _, in := range agg.inputs {
+		filter, ok := in.(*Filter)
+		if !ok {
+			t.Fatalf("expected a filter above each page range, got %T", in)
+		}
+		r := filter.child.(*heapScanRange)
+		if r.start != pages {
+			t.Errorf("expected a page range starting at %d, got %d", pages, r.start)
+		}
+		pages = r.end
+	}
+	if pages != -1 {
+		t.Errorf("expected the last page range to read to the end of the file")
+	}
+}
+
+func TestSetParallelWorkers(t *testing.T) {
+	s := makeBatchTestSession(t, 10)
+	for _, q := range []string{"set parallel_workers = 3", "SET parallel_workers TO 2", "set session parallel_workers = default"} {
+		res, err := s.Execute(q)
+		if err != nil {
+			t.Fatalf("%s failed, %s", q, err.Error())
+		}
+		if res.Tag != "SET" {
+			t.Errorf("%s: expected tag SET, got %s", q, res.Tag)
+		}
+	}
+	if s.parallelWorkers != DefaultParallelWorkers {
+		t.Errorf("expected the default number of workers, got %d", s.parallelWorkers)
+	}
+	for _, q := range []string{"set parallel_workers = 0", "set parallel_workers = many", "set no_such_setting = 1"} {
+		if _, err := s.Execute(q); err == nil {
+			t.Errorf("expected %s to fail", q)
+		}
+	}
+}
+
+func BenchmarkParallelGroupBy(b *testing.B) {
+	s := makeBatchTestSession(b, 50000)
+	for _, workers := range []int{1, 4} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			s.SetParallelWorkers(workers)
+			for i := 0; i < b.N; i++ {
+				runVectorized(b, s, "select name, sum(salary) as total, count(*) as n from emp group by name", true)
+			}
+		})
+	}
+}
@@ -14,6 +14,7 @@ type LogicalFilterNode struct {
 	fieldExpr LogicalSelectNode
 	constExpr LogicalSelectNode
 	predOp    BoolOp
+	escape    rune // the escape character of a LIKE pattern
 }
 
 type LogicalJoinNode struct {
@@ -29,6 +30,8 @@ const (
 	ExprFunc  SelectExprType = iota
 	ExprStar  SelectExprType = iota
 	ExprAggr  SelectExprType = iota
+	// a window function call
+	ExprWindow SelectExprType = iota
 )
 
 type LogicalSelectNode struct {
@@ -40,6 +43,9 @@ type LogicalSelectNode struct {
 	value       string
 	args        []*LogicalSelectNode //for functions other than aggregates
 	cachedField *FieldType
+	param       *paramValue //for ? and $n placeholders in prepared statements
+	null        bool        //for the NULL constant
+	window      *windowSpec //for window function calls
 }
 
 func NewFieldSelectNode(table string, field string, alias string) LogicalSelectNode {
@@ -98,6 +104,8 @@ func (t SelectExprType) String() string {
 		return "ExprStar"
 	case ExprAggr:
 		return "ExprAggr"
+	case ExprWindow:
+		return "ExprWindow"
 	default:
 		return "Unknown"
 	}
@@ -119,6 +127,24 @@ func (op BoolOp) String() string {
 		return "<"
 	case OpLike:
 		return " LIKE "
+	case OpNotLike:
+		return " NOT LIKE "
+	case OpILike:
+		return " ILIKE "
+	case OpNotILike:
+		return " NOT ILIKE "
+	case OpRegexp:
+		return " ~ "
+	case OpNotRegexp:
+		return " !~ "
+	case OpIRegexp:
+		return " ~* "
+	case OpNotIRegexp:
+		return " !~* "
+	case OpSimilar:
+		return " SIMILAR TO "
+	case OpNotSimilar:
+		return " NOT SIMILAR TO "
 	default:
 		return "??"
 	}
@@ -248,6 +274,9 @@ type LogicalPlan struct {
 	limit         *LogicalSelectNode
 	distinct      bool
 	alias         string
+	setOp         *LogicalSetOp // if set, the plan is of a set operation
+	recursiveCTE  *LogicalRecursiveCTE
+	empty         bool // if set, the WHERE clause is never true; see rewrite.go
 }
 
 func (p *LogicalPlan) getSubplanFields(c *Catalog) []*FieldType {
@@ -264,15 +293,24 @@ func parseWhere(c *Catalog, subqueries []*LogicalPlan, ts []*LogicalTableNode, e
 	switch expr := expr.(type) {
 	case *sqlparser.AndExpr:
 		// Parse AND by parsing left and right sides
-		filterListLeft, joinListLeft, _ := parseWhere(c, subqueries, ts, expr.Left)
-		filterListRight, joinListRight, _ := parseWhere(c, subqueries, ts, expr.Right)
+		filterListLeft, joinListLeft, err := parseWhere(c, subqueries, ts, expr.Left)
+		if err != nil {
+			return nil, nil, err
+		}
+		filterListRight, joinListRight, err := parseWhere(c, subqueries, ts, expr.Right)
+		if err != nil {
+			return nil, nil, err
+		}
 		filterExprs := append(filterListLeft, filterListRight...)
 		joinExprs := append(joinListLeft, joinListRight...)
 		return filterExprs, joinExprs, nil
 
 	case *sqlparser.ComparisonExpr:
-		op := BoolOpMap[expr.Operator]
-		left, err := parseExpr(c, expr.Left, "")
+		op, leftExpr, escape, err := comparisonOp(expr)
+		if err != nil {
+			return nil, nil, err
+		}
+		left, err := parseExpr(c, leftExpr, "")
 		if err != nil {
 			return nil, nil, err
 		}
@@ -295,7 +333,7 @@ func parseWhere(c *Catalog, subqueries []*LogicalPlan, ts []*LogicalTableNode, e
 			}
 			return nil, []*LogicalJoinNode{{left, right, op}}, nil
 		} else {
-			return []*LogicalFilterNode{{*left, *right, op}}, nil, nil
+			return []*LogicalFilterNode{{*left, *right, op, escape}}, nil, nil
 		}
 
 	default:
@@ -303,24 +341,44 @@ func parseWhere(c *Catalog, subqueries []*LogicalPlan, ts []*LogicalTableNode, e
 	}
 }
 
-func parseFrom(c *Catalog, t sqlparser.TableExpr) ([]*LogicalTableNode, []*LogicalPlan, []*LogicalJoinNode, error) {
+func parseFrom(c *Catalog, ctes cteScope, t sqlparser.TableExpr) ([]*LogicalTableNode, []*LogicalPlan, []*LogicalJoinNode, error) {
 	switch tableEx := t.(type) {
 	case *sqlparser.AliasedTableExpr:
 		switch tableEx.Expr.(type) {
 		case *sqlparser.Subquery:
 			sq := (tableEx.Expr).(*sqlparser.Subquery)
 			//print("got subquery")
-			switch stmt := sq.Select.(type) {
-			case *sqlparser.Select:
-				subplan, err := parseStatement(c, stmt)
+			subplan, err := parseQueryIn(c, ctes, sq.Select)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			subplan.alias = strings.ToLower(sqlparser.String(tableEx.As))
+			return nil, []*LogicalPlan{subplan}, nil, nil
+		case sqlparser.SimpleTableExpr:
+			tableName := strings.ToLower(sqlparser.GetTableName(tableEx.Expr).CompliantName())
+			if cte := ctes.lookup(tableName); cte != nil {
+				subplan, err := c.planCTE(cte)
 				if err != nil {
 					return nil, nil, nil, err
 				}
-				subplan.alias = strings.ToLower(sqlparser.String(tableEx.As))
+				subplan.alias = tableName
+				if !tableEx.As.IsEmpty() {
+					subplan.alias = strings.ToLower(sqlparser.String(tableEx.As))
+				}
+				return nil, []*LogicalPlan{subplan}, nil, nil
+			}
+			if v, ok := c.views[tableName]; ok {
+				// plan the view as a subquery named after it
+				subplan, err := c.planView(v)
+				if err != nil {
+					return nil, nil, nil, err
+				}
+				subplan.alias = tableName
+				if !tableEx.As.IsEmpty() {
+					subplan.alias = strings.ToLower(sqlparser.String(tableEx.As))
+				}
 				return nil, []*LogicalPlan{subplan}, nil, nil
 			}
-		case sqlparser.SimpleTableExpr:
-			tableName := strings.ToLower(sqlparser.GetTableName(tableEx.Expr).CompliantName())
 			//fmt.Printf("got simple table, name %s\n", tableName)
 			dbFile, err := c.GetTable(tableName)
 			if err != nil {
@@ -339,7 +397,7 @@ func parseFrom(c *Catalog, t sqlparser.TableExpr) ([]*LogicalTableNode, []*Logic
 			joins    []*LogicalJoinNode
 		)
 		for _, e := range tableEx.Exprs {
-			newTables, newSubplans, newJoins, err := parseFrom(c, e)
+			newTables, newSubplans, newJoins, err := parseFrom(c, ctes, e)
 			if err != nil {
 				return nil, nil, nil, err
 			}
@@ -350,11 +408,11 @@ func parseFrom(c *Catalog, t sqlparser.TableExpr) ([]*LogicalTableNode, []*Logic
 		return tables, subplans, joins, nil
 	case *sqlparser.JoinTableExpr:
 		joinTable, _ := t.(*sqlparser.JoinTableExpr)
-		leftTables, leftSubplans, leftJoins, err := parseFrom(c, joinTable.LeftExpr)
+		leftTables, leftSubplans, leftJoins, err := parseFrom(c, ctes, joinTable.LeftExpr)
 		if err != nil {
 			return nil, nil, nil, err
 		}
-		rightTables, rightSubplans, rightJoins, err := parseFrom(c, joinTable.RightExpr)
+		rightTables, rightSubplans, rightJoins, err := parseFrom(c, ctes, joinTable.RightExpr)
 		if err != nil {
 			return nil, nil, nil, err
 		}
@@ -374,13 +432,16 @@ func parseFrom(c *Catalog, t sqlparser.TableExpr) ([]*LogicalTableNode, []*Logic
 }
 
 func isAgg(f string) bool {
-	return f == "count" || f == "sum" || f == "avg" || f == "min" || f == "max"
+	return lookupAggregate(f) != nil
 }
 
 func parseExpr(c *Catalog, expr sqlparser.Expr, alias string) (*LogicalSelectNode, error) {
 	switch expr := expr.(type) {
 	case *sqlparser.FuncExpr:
 		funName := strings.ToLower(sqlparser.String(expr.Name))
+		if strings.Trim(funName, "`") == windowFuncName {
+			return parseWindowExpr(c, expr, alias)
+		}
 		if isAgg(funName) {
 			if len(expr.Exprs) != 1 {
 				return nil, GoDBError{ParseError, fmt.Sprintf("expected one argument to aggregate %s in select list", sqlparser.String(expr.Name))}
@@ -418,6 +479,10 @@ func parseExpr(c *Catalog, expr sqlparser.Expr, alias string) (*LogicalSelectNod
 		}
 	case *sqlparser.BinaryExpr:
 		opname := expr.Operator
+		if opname == sqlparser.BitOrStr {
+			// rewriteFunctionSyntax turns || into |
+			opname = "||"
+		}
 		left, err := parseExpr(c, expr.Left, "")
 		if err != nil {
 			return nil, err
@@ -433,6 +498,83 @@ func parseExpr(c *Catalog, expr sqlparser.Expr, alias string) (*LogicalSelectNod
 		return &outer, nil
 	case *sqlparser.ParenExpr:
 		return parseExpr(c, expr.Expr, alias)
+	case *sqlparser.UnaryExpr:
+		operand, err := parseExpr(c, expr.Expr, alias)
+		if err != nil {
+			return nil, err
+		}
+		switch expr.Operator {
+		case sqlparser.UPlusStr:
+			return operand, nil
+		case sqlparser.UMinusStr:
+			zero := NewConstSelectNode("0", "")
+			outer := NewFuncSelectNode("-", []*LogicalSelectNode{&zero, operand}, alias)
+			return &outer, nil
+		}
+		return nil, GoDBError{ParseError, fmt.Sprintf("unsupported operator %s", expr.Operator)}
+	case *sqlparser.ComparisonExpr:
+		op, left, escape, err := comparisonOp(expr)
+		if err != nil {
+			return nil, err
+		}
+		if escape != defaultLikeEscape {
+			return nil, GoDBError{ParseError, "ESCAPE is only supported in WHERE clauses"}
+		}
+		return parseFuncExpr(c, predicateName(op), alias, left, expr.Right)
+	case *sqlparser.AndExpr:
+		return parseFuncExpr(c, "and", alias, expr.Left, expr.Right)
+	case *sqlparser.OrExpr:
+		return parseFuncExpr(c, "or", alias, expr.Left, expr.Right)
+	case *sqlparser.NotExpr:
+		return parseFuncExpr(c, "not", alias, expr.Expr)
+	case *sqlparser.IsExpr:
+		switch expr.Operator {
+		case sqlparser.IsNullStr, sqlparser.IsNotNullStr:
+			return parseFuncExpr(c, expr.Operator, alias, expr.Expr)
+		}
+		return nil, GoDBError{ParseError, fmt.Sprintf("unsupported operator %s", expr.Operator)}
+	case *sqlparser.RangeCond:
+		between := &sqlparser.AndExpr{
+			Left:  &sqlparser.ComparisonExpr{Operator: sqlparser.GreaterEqualStr, Left: expr.Left, Right: expr.From},
+			Right: &sqlparser.ComparisonExpr{Operator: sqlparser.LessEqualStr, Left: expr.Left, Right: expr.To},
+		}
+		if expr.Operator == sqlparser.NotBetweenStr {
+			return parseFuncExpr(c, "not", alias, between)
+		}
+		return parseExpr(c, between, alias)
+	case *sqlparser.CaseExpr:
+		var args []sqlparser.Expr
+		for _, when := range expr.Whens {
+			cond := when.Cond
+			if expr.Expr != nil {
+				cond = &sqlparser.ComparisonExpr{Operator: sqlparser.EqualStr, Left: expr.Expr, Right: when.Cond}
+			}
+			args = append(args, cond, when.Val)
+		}
+		if expr.Else != nil {
+			args = append(args, expr.Else)
+		}
+		return parseFuncExpr(c, "case", alias, args...)
+	case *sqlparser.ConvertExpr:
+		// rewriteFunctionSyntax turns the standard types into MySQL's
+		var name string
+		switch strings.ToLower(expr.Type.Type) {
+		case "signed", "unsigned":
+			name = "cast_int"
+		case "char":
+			name = "cast_text"
+		case "date":
+			name = "cast_date"
+		case "datetime":
+			name = "cast_timestamp"
+		default:
+			return nil, GoDBError{ParseError, fmt.Sprintf("unsupported type %s in CAST", expr.Type.Type)}
+		}
+		return parseFuncExpr(c, name, alias, expr.Expr)
+	case *sqlparser.NullVal:
+		field := NewConstSelectNode("null", alias)
+		field.null = true
+		return &field, nil
 	case *sqlparser.ColName:
 		field := NewFieldSelectNode(strings.ToLower(sqlparser.String(expr.Qualifier)), strings.ToLower(sqlparser.String(expr.Name)), alias)
 		if len(field.table) > 1 && (field.table[0] == '\'' || field.table[0] == '`') {
@@ -444,10 +586,19 @@ func parseExpr(c *Catalog, expr sqlparser.Expr, alias string) (*LogicalSelectNod
 
 		return &field, nil
 	case *sqlparser.SQLVal:
+		if expr.Type == sqlparser.ValArg {
+			param, err := newParamValue(string(expr.Val))
+			if err != nil {
+				return nil, err
+			}
+			field := NewConstSelectNode(param.String(), alias)
+			field.param = param
+			return &field, nil
+		}
 		str := sqlparser.String(expr)
-		if str[0] == '\'' {
-			str = str[1 : len(str)-1]
-			//str = str[-1]
+		if expr.Type == sqlparser.StrVal {
+			// use the unescaped value rather than the quoted literal
+			str = string(expr.Val)
 		}
 		field := NewConstSelectNode(str, alias)
 		return &field, nil
@@ -456,6 +607,21 @@ func parseExpr(c *Catalog, expr sqlparser.Expr, alias string) (*LogicalSelectNod
 	}
 
 }
+
+// Parse a call of the function name with the given arguments.
+func parseFuncExpr(c *Catalog, name string, alias string, args ...sqlparser.Expr) (*LogicalSelectNode, error) {
+	exprList := make([]*LogicalSelectNode, len(args))
+	for i, arg := range args {
+		e, err := parseExpr(c, arg, "")
+		if err != nil {
+			return nil, err
+		}
+		exprList[i] = e
+	}
+	outer := NewFuncSelectNode(name, exprList, alias)
+	return &outer, nil
+}
+
 func parseSelect(c *Catalog, stmt sqlparser.SelectExpr) (*LogicalSelectNode, error) {
 	star, ok := stmt.(*sqlparser.StarExpr)
 	if ok {
@@ -483,11 +649,24 @@ func extractAggs(s *LogicalSelectNode) []*LogicalSelectNode {
 			aggs = append(aggs, extractAggs(subs)...)
 		}
 		return aggs
+	case ExprWindow:
+		// window functions are computed over the results of aggregates
+		var aggs []*LogicalSelectNode
+		for _, subs := range s.args {
+			aggs = append(aggs, extractAggs(subs)...)
+		}
+		for _, p := range s.window.partitionBy {
+			aggs = append(aggs, extractAggs(p)...)
+		}
+		for _, o := range s.window.orderBy {
+			aggs = append(aggs, extractAggs(o.expr)...)
+		}
+		return aggs
 	}
 	return nil
 }
 
-func parseStatement(c *Catalog, s *sqlparser.Select) (*LogicalPlan, error) {
+func parseStatement(c *Catalog, ctes cteScope, s *sqlparser.Select) (*LogicalPlan, error) {
 	from := s.From
 	var (
 		tables   []*LogicalTableNode
@@ -498,7 +677,7 @@ func parseStatement(c *Catalog, s *sqlparser.Select) (*LogicalPlan, error) {
 	)
 
 	for _, t := range from {
-		newTables, newSubplans, newJoins, err := parseFrom(c, t)
+		newTables, newSubplans, newJoins, err := parseFrom(c, ctes, t)
 		if err != nil {
 			return nil, err
 		}
@@ -545,28 +724,38 @@ func parseStatement(c *Catalog, s *sqlparser.Select) (*LogicalPlan, error) {
 		groupBys[i] = &GroupBy{expr}
 	}
 
-	var orderBys = make([]*OrderByNode, len(s.OrderBy))
-	for i, oby := range s.OrderBy {
+	orderBys, err := parseOrderBy(c, s.OrderBy)
+	if err != nil {
+		return nil, err
+	}
+
+	limExpr, err := parseLimit(c, s.Limit)
+	if err != nil {
+		return nil, err
+	}
+
+	p := LogicalPlan{filters, joins, selects, aggs, tables, subplans, groupBys, orderBys, limExpr, s.Distinct != "", "", nil, nil, false}
+
+	return &p, nil
+}
+
+func parseOrderBy(c *Catalog, orderBy sqlparser.OrderBy) ([]*OrderByNode, error) {
+	var orderBys = make([]*OrderByNode, len(orderBy))
+	for i, oby := range orderBy {
 		expr, err := parseExpr(c, oby.Expr, "")
 		if err != nil {
 			return nil, err
 		}
 		orderBys[i] = &OrderByNode{expr, oby.Direction == sqlparser.AscScr}
 	}
+	return orderBys, nil
+}
 
-	lim := s.Limit
-	var limExpr *LogicalSelectNode
-	if lim != nil {
-		var err error
-		limExpr, err = parseExpr(c, lim.Rowcount, "")
-		if err != nil {
-			return nil, err
-		}
+func parseLimit(c *Catalog, lim *sqlparser.Limit) (*LogicalSelectNode, error) {
+	if lim == nil {
+		return nil, nil
 	}
-
-	p := LogicalPlan{filters, joins, selects, aggs, tables, subplans, groupBys, orderBys, limExpr, s.Distinct != "", ""}
-
-	return &p, nil
+	return parseExpr(c, lim.Rowcount, "")
 }
 
 // Given a table name tab, a field name, and a map between table names and operators, do one of the following:
@@ -629,6 +818,17 @@ type PlanNode struct {
 
 func (s *LogicalSelectNode) generateExpr(c *Catalog, inputDesc *TupleDesc, tableMap map[string]*PlanNode) (Expr, string, error) {
 	switch s.exprType {
+	case ExprWindow:
+		// the value of the function is a field of the Window operator's
+		// tuples, see planWindows
+		if s.cachedField == nil {
+			return nil, "", GoDBError{ParseError, fmt.Sprintf("window function %s is only allowed in the select list", *s.funcOp)}
+		}
+		fieldName := *s.funcOp
+		if s.alias != "" {
+			fieldName = s.alias
+		}
+		return &FieldExpr{*s.cachedField}, fieldName, nil
 	case ExprAggr:
 		fallthrough
 	case ExprField:
@@ -682,6 +882,20 @@ func (s *LogicalSelectNode) generateExpr(c *Catalog, inputDesc *TupleDesc, table
 		e := FieldExpr{field}
 		return &e, fieldName, nil
 	case ExprConst:
+		if s.param != nil {
+			fieldName := s.param.String()
+			if s.alias != "" {
+				fieldName = s.alias
+			}
+			return &ConstExpr{s.param, UnknownType}, fieldName, nil
+		}
+		if s.null {
+			fieldName := s.value
+			if s.alias != "" {
+				fieldName = s.alias
+			}
+			return &ConstExpr{nil, UnknownType}, fieldName, nil
+		}
 		var fval DBValue
 		constType := StringType
 		intFval, e := strconv.Atoi(s.value)
@@ -712,7 +926,7 @@ func (s *LogicalSelectNode) generateExpr(c *Catalog, inputDesc *TupleDesc, table
 		}
 
 		fe := FuncExpr{*s.funcOp, exprs}
-		return &fe, fieldName, nil
+		return foldConstants(&fe), fieldName, nil
 	}
 	return nil, "", GoDBError{ParseError, "unhandled expression type in select list"}
 
@@ -757,7 +971,24 @@ func opToStr(op BoolOp) string {
 		return "<"
 	case OpLike:
 		return " LIKE "
-
+	case OpNotLike:
+		return " NOT LIKE "
+	case OpILike:
+		return " ILIKE "
+	case OpNotILike:
+		return " NOT ILIKE "
+	case OpRegexp:
+		return " ~ "
+	case OpNotRegexp:
+		return " !~ "
+	case OpIRegexp:
+		return " ~* "
+	case OpNotIRegexp:
+		return " !~* "
+	case OpSimilar:
+		return " SIMILAR TO "
+	case OpNotSimilar:
+		return " NOT SIMILAR TO "
 	}
 	return "??"
 }
@@ -768,68 +999,16 @@ func GetUnexportedField(field reflect.Value) interface{} {
 	return reflect.NewAt(field.Type(), unsafe.Pointer(field.UnsafeAddr())).Elem().Interface()
 }
 
+// Print a physical plan, one line per operator, with its children indented
+// below it.  See [Explainable].
 func OutputPhysicalPlan(printf func(format string, a ...any), o Operator, indent string) {
-	oc := o.(*OperatorCard)
-	switch op := oc.Op.(type) {
-	case *EqualityJoin:
-		printf("%sJoin, %+v == %+v, card:%d\n", indent, exprToStr(op.leftField), exprToStr(op.rightField), oc.Cardinality)
-		indent = indent + "\t"
-		OutputPhysicalPlan(printf, *op.left, indent)
-		OutputPhysicalPlan(printf, *op.right, indent)
-	case *Project:
-		selectStr := ""
-		for _, ex := range op.selectFields {
-			selectStr += exprToStr(ex) + ","
-		}
-		printf("%sProject %+v -> %+v, card:%d\n", indent, selectStr, op.outputNames, oc.Cardinality)
-		indent = indent + "\t"
-		OutputPhysicalPlan(printf, op.child, indent)
-
-	case *Filter:
-		printf("%sFilter %s %s %s, card:%d", indent, exprToStr(op.left), opToStr(op.op), exprToStr(op.right), oc.Cardinality)
-		indent = indent + "\t"
-		OutputPhysicalPlan(printf, op.child, indent)
-
-	case *HeapFile:
-		printf("%sHeap Scan %s, card:%d\n", indent, op.BackingFile(), oc.Cardinality)
-
-	case *OrderBy:
-		orderStr := ""
-		if len(op.orderBy) > 0 {
-			orderStr += exprToStr(op.orderBy[0])
-			for i := 1; i < len(op.orderBy); i++ {
-				orderStr += ", " + exprToStr(op.orderBy[i])
-			}
-		}
-		printf("%sOrder By %s, card:%d\n", indent, orderStr, oc.Cardinality)
-		indent = indent + "\t"
-		OutputPhysicalPlan(printf, op.child, indent)
-
-	case *LimitOp:
-		printf("%sLimit %s, card:%d\n", indent, exprToStr(op.limitTups), oc.Cardinality)
-		indent = indent + "\t"
-		OutputPhysicalPlan(printf, op.child, indent)
-
-	case *Aggregator:
-		gbyStr := ""
-		if len(op.groupByFields) > 0 {
-			gbyStr = "Group By "
-		}
-		for _, ex := range op.groupByFields {
-			gbyStr += exprToStr(ex) + ","
-		}
-
-		aggStr := ""
-		for _, ex := range op.newAggState {
-			aggStr += fmt.Sprintf("%s(%s),", reflect.TypeOf(ex), ex.GetTupleDesc().HeaderString(false))
-		}
-
-		printf("%sAggregate, %s %s, card:%d\n", indent, aggStr, gbyStr, oc.Cardinality)
-		indent = indent + "\t"
-		OutputPhysicalPlan(printf, op.child, indent)
+	outputExplainNode(printf, ExplainPlan(o), indent)
+}
 
-	default:
-		printf("%sUnknown op, %s\n", indent, reflect.TypeOf(op))
+func outputExplainNode(printf func(format string, a ...any), node *ExplainNode, indent string) {
+	printf("%s%s, card:%d\n", indent, node.label(), *node.Rows)
+	for _, c := range node.Children {
+		outputExplainNode(printf, c, indent+"\t")
 	}
 }
 
@@ -851,6 +1030,24 @@ func (o *OperatorCard) Iterator(tid TransactionID) (func() (*Tuple, error), erro
 	return o.Op.Iterator(tid)
 }
 
+func (o *OperatorCard) BatchIterator(tid TransactionID) (func() (*Batch, error), error) {
+	return batchIterator(o.Op, tid)
+}
+
+func (o *OperatorCard) Explain() (string, string) {
+	if e, ok := o.Op.(Explainable); ok {
+		return e.Explain()
+	}
+	return reflect.TypeOf(o.Op).String(), ""
+}
+
+func (o *OperatorCard) Children() []Operator {
+	if e, ok := o.Op.(Explainable); ok {
+		return e.Children()
+	}
+	return nil
+}
+
 func NewOperatorCard(op Operator, card int) *OperatorCard {
 	_, ok := op.(*OperatorCard)
 	if ok {
@@ -882,6 +1079,15 @@ type TableAndField struct {
 }
 
 func makePhysicalPlan(c *Catalog, plan *LogicalPlan) (*OperatorCard, error) {
+	if plan.setOp != nil {
+		return makeSetOpPlan(c, plan)
+	}
+	if plan.recursiveCTE != nil {
+		return makeRecursiveCTEPlan(c, plan)
+	}
+	if err := rewriteLogicalPlan(c, plan); err != nil {
+		return nil, err
+	}
 	tableMap := make(map[string]*PlanNode) // mapping from table aliases to operators
 	tableStats := make(map[string]Stats)   // mapping from table aliases to table stats
 	sel := make(map[string]float64)        // mapping from table aliases to selectivities
@@ -899,10 +1105,9 @@ func makePhysicalPlan(c *Catalog, plan *LogicalPlan) (*OperatorCard, error) {
 	}
 
 	for _, t := range plan.tables {
-		var stats Stats
-		stats = c.GetTableStats(t.tableName)
-		if stats == nil {
-			stats = &DummyStats{}
+		var stats Stats = &DummyStats{}
+		if ts := c.GetTableStats(t.tableName); ts != nil {
+			stats = ts
 		}
 
 		name := t.tableName
@@ -914,14 +1119,19 @@ func makePhysicalPlan(c *Catalog, plan *LogicalPlan) (*OperatorCard, error) {
 		td := (*t.file).Descriptor()
 		td.setTableAlias(name)
 
-		card := 0
-		if stats != nil {
-			card = stats.EstimateCardinality(1.0)
-		}
+		card := stats.EstimateCardinality(1.0)
 		tableMap[name] = &PlanNode{NewOperatorCard(*t.file, card), td}
 		sel[name] = 1.0
 	}
 
+	if plan.empty {
+		// the WHERE clause is never true, so there is no need to read any
+		// of the tables
+		for name, node := range tableMap {
+			tableMap[name] = &PlanNode{NewOperatorCard(&emptyOp{node.desc}, 0), node.desc}
+		}
+	}
+
 	//now apply each filter to appropriate table
 	for _, f := range plan.filters {
 		tabName, fieldName, err := f.fieldExpr.getTableField(c, plan.subqueries, plan.tables)
@@ -960,10 +1170,12 @@ func makePhysicalPlan(c *Catalog, plan *LogicalPlan) (*OperatorCard, error) {
 		}
 		sel[table] *= filterSel
 
+		inferParamType(rightExpr, leftExpr.GetExprType().Ftype)
 		newOp, err := NewFilter(rightExpr, f.predOp, leftExpr, op)
 		if err != nil {
 			return nil, err
 		}
+		newOp.setEscape(f.escape)
 
 		tableMap[table] = &PlanNode{NewOperatorCard(newOp, int(float64(op.Cardinality)*filterSel)), &desc}
 	}
@@ -1131,26 +1343,22 @@ func makePhysicalPlan(c *Catalog, plan *LogicalPlan) (*OperatorCard, error) {
 				if err != nil {
 					return nil, err
 				}
-				aggExpr, _, err := s.args[0].generateExpr(c, node.desc, tableMap)
-				if err != nil {
+				var aggExpr Expr
+				if s.args[0].exprType == ExprField && fieldName == "*" {
+					// count(*) counts every row, even one whose first
+					// field is NULL
+					aggExpr = &ConstExpr{IntField{1}, IntType}
+				} else if aggExpr, _, err = s.args[0].generateExpr(c, node.desc, tableMap); err != nil {
 					return nil, err
 				}
 
-				switch *s.funcOp {
-				case "max":
-					as = &MaxAggState{}
-
-				case "min":
-					as = &MinAggState{}
-				case "avg":
-					as = &AvgAggState{}
-				case "sum":
-					as = &SumAggState{}
-				case "count":
-					as = &CountAggState{}
-				default:
+				newState := lookupAggregate(*s.funcOp)
+				if newState == nil {
 					return nil, GoDBError{IllegalOperationError, fmt.Sprintf("unknown aggregate function %s", *s.funcOp)}
 				}
+				if as = newState(); as == nil {
+					return nil, GoDBError{IllegalOperationError, fmt.Sprintf("aggregate function %s returned no aggregation state", *s.funcOp)}
+				}
 
 				//make sure name has unique id
 				name := fmt.Sprintf("%s(%s.%s)%d", *s.funcOp, tabName, fieldName, aggCnt)
@@ -1187,6 +1395,14 @@ func makePhysicalPlan(c *Catalog, plan *LogicalPlan) (*OperatorCard, error) {
 		}
 	}
 
+	if windows := extractWindows(plan.selects); len(windows) > 0 {
+		windowOp, err := planWindows(c, windows, topOp, tableMap)
+		if err != nil {
+			return nil, err
+		}
+		topOp = NewOperatorCard(windowOp, topOp.Cardinality)
+	}
+
 	exprList := make([]Expr, len(plan.selects))
 	for i, s := range plan.selects {
 		switch s.exprType {
@@ -1210,7 +1426,12 @@ func makePhysicalPlan(c *Catalog, plan *LogicalPlan) (*OperatorCard, error) {
 		}
 		topOp = NewOperatorCard(projOp, topOp.Cardinality)
 	}
+	return planOrderByAndLimit(c, plan, topOp, tableMap)
+}
 
+// Add the ORDER BY and LIMIT of plan above topOp, the rest of its physical
+// plan.
+func planOrderByAndLimit(c *Catalog, plan *LogicalPlan, topOp *OperatorCard, tableMap map[string]*PlanNode) (*OperatorCard, error) {
 	if len(plan.orderByFields) > 0 {
 		var ascs []bool
 
@@ -1236,13 +1457,23 @@ func makePhysicalPlan(c *Catalog, plan *LogicalPlan) (*OperatorCard, error) {
 		if err != nil {
 			return nil, err
 		}
-		numTupsExpr, err := expr.EvalExpr(&Tuple{})
-		if err != nil {
-			return nil, err
+		card := topOp.Cardinality
+		// the limit of a prepared statement may not be known until it is run
+		inferParamType(expr, IntType)
+		if !isParamExpr(expr) {
+			numTupsExpr, err := expr.EvalExpr(&Tuple{})
+			if err != nil {
+				return nil, err
+			}
+			numTups, ok := numTupsExpr.(IntField)
+			if !ok {
+				return nil, GoDBError{TypeMismatchError, "limit must be an integer"}
+			}
+			card = min(int(numTups.Value), card)
 		}
-		numTups := numTupsExpr.(IntField).Value
-		topOp = NewOperatorCard(NewLimitOp(expr, topOp), min(int(numTups), topOp.Cardinality))
+		topOp = NewOperatorCard(NewLimitOp(expr, topOp), card)
 	}
+	pruneScanColumns(topOp)
 	return topOp, nil
 }
 
@@ -1251,17 +1482,19 @@ func parseInsert(c *Catalog, insStmt *sqlparser.Insert) (Operator, error) {
 		return nil, GoDBError{ParseError, "GoDB doesn't support inserts of incomplete tuples"}
 	}
 	tab := insStmt.Table.Name
-	file, err := c.GetTable(sqlparser.String(tab))
+	table, err := c.writableTable(sqlparser.String(tab))
 	if err != nil {
 		return nil, err
 	}
+	file := table.file
 
 	switch stmt := insStmt.Rows.(type) {
 	case sqlparser.Values:
 		var exprAr []([]Expr)
+		desc := file.Descriptor()
 		for _, t := range stmt {
 			var tupAr []Expr
-			for _, e := range t {
+			for i, e := range t {
 				expr, err := parseExpr(c, e, "")
 				if err != nil {
 					return nil, err
@@ -1270,16 +1503,20 @@ func parseInsert(c *Catalog, insStmt *sqlparser.Insert) (Operator, error) {
 				if err != nil {
 					return nil, err
 				}
+				if i < len(desc.Fields) {
+					inferParamType(exprOp, desc.Fields[i].Ftype)
+				}
 				tupAr = append(tupAr, exprOp)
 			}
 			exprAr = append(exprAr, tupAr)
 		}
 		iterOp := NewValueOp(exprAr)
 		insertOp := NewInsertOp(file, iterOp)
+		insertOp.table = table
 		return insertOp, nil
 
-	case *sqlparser.Select:
-		plan, err := parseStatement(c, stmt)
+	case sqlparser.SelectStatement:
+		plan, err := parseQuery(c, stmt)
 		if err != nil {
 			return nil, err
 		}
@@ -1287,18 +1524,43 @@ func parseInsert(c *Catalog, insStmt *sqlparser.Insert) (Operator, error) {
 		if err != nil {
 			return nil, err
 		}
+		if err := checkInsertColumns(table, op.Descriptor()); err != nil {
+			return nil, err
+		}
 
 		insertOp := NewInsertOp(file, op)
+		insertOp.table = table
 		return insertOp, nil
 	}
 	return nil, nil
 }
 
+// Return an error unless rows described by desc can be inserted into table t.
+func checkInsertColumns(t *Table, desc *TupleDesc) error {
+	if len(desc.Fields) != len(t.desc.Fields) {
+		return GoDBError{ParseError, fmt.Sprintf("INSERT has %d expressions but table %s has %d columns", len(desc.Fields), t.name, len(t.desc.Fields))}
+	}
+	for i, f := range desc.Fields {
+		col := t.desc.Fields[i]
+		if f.Ftype != UnknownType && f.Ftype != col.Ftype {
+			return GoDBError{TypeMismatchError, fmt.Sprintf("column %s of table %s is of type %s but expression is of type %s", col.Fname, t.name, col.Ftype, f.Ftype)}
+		}
+	}
+	return nil
+}
+
 func parseDelete(c *Catalog, delStmt *sqlparser.Delete) (Operator, error) {
 	if len(delStmt.TableExprs) > 1 {
 		return nil, GoDBError{ParseError, "godb does not supporting deleting from multiple tables"}
 	}
-	tables, subplans, joins, err := parseFrom(c, delStmt.TableExprs[0])
+	if t, ok := delStmt.TableExprs[0].(*sqlparser.AliasedTableExpr); ok {
+		if name, ok := t.Expr.(sqlparser.TableName); ok {
+			if _, err := c.writableTable(strings.ToLower(name.Name.CompliantName())); err != nil {
+				return nil, err
+			}
+		}
+	}
+	tables, subplans, joins, err := parseFrom(c, nil, delStmt.TableExprs[0])
 	if err != nil {
 		return nil, err
 	}
@@ -1346,13 +1608,21 @@ func parseDelete(c *Catalog, delStmt *sqlparser.Delete) (Operator, error) {
 		//dbField, _ := fieldNameToField(f.table, f.field, &PlanNode{op, &desc})
 
 		//newInt, _ := strconv.Atoi(f.constVal)
-		newOp, err = NewFilter(rightExpr, f.predOp, leftExpr, newOp)
+		inferParamType(rightExpr, leftExpr.GetExprType().Ftype)
+		filter, err := NewFilter(rightExpr, f.predOp, leftExpr, newOp)
 		if err != nil {
 			return nil, err
 		}
+		filter.setEscape(f.escape)
+		newOp = filter
 	}
 
-	return NewDeleteOp(*tables[0].file, newOp), nil
+	deleteOp := NewDeleteOp(*tables[0].file, newOp)
+	deleteOp.table, err = c.GetTableInfo(tables[0].tableName)
+	if err != nil {
+		return nil, err
+	}
+	return deleteOp, nil
 }
 
 type QueryType int
@@ -1364,63 +1634,106 @@ const (
 	AbortXactionType     QueryType = iota
 	CreateTableQueryType QueryType = iota
 	DropTableQueryType   QueryType = iota
+	AlterTableQueryType  QueryType = iota
+	PrepareQueryType     QueryType = iota
+	DeallocateQueryType  QueryType = iota
+	CreateViewQueryType  QueryType = iota
+	DropViewQueryType    QueryType = iota
+	RefreshViewQueryType QueryType = iota
+	SetQueryType         QueryType = iota
+	ExplainQueryType     QueryType = iota
 	UnknownQueryType     QueryType = iota
 )
 
 func processDDL(c *Catalog, ddl *sqlparser.DDL) (QueryType, error) {
 	switch ddl.Action {
-	case "create":
-		fields := make([]FieldType, len(ddl.TableSpec.Columns))
-		tabName := sqlparser.String(ddl.NewName.Name)
-		t, _ := c.GetTable(tabName)
-		if t != nil {
-			return UnknownQueryType, GoDBError{ParseError, fmt.Sprintf("table %s already exists", tabName)}
-		}
-		for i, col := range ddl.TableSpec.Columns {
-			var colType DBType
-			colName := sqlparser.String(col.Name)
-			switch col.Type.Type {
-			case "int":
-				colType = IntType
-			case "string":
-				fallthrough
-			case "text":
-				fallthrough
-			case "varchar":
-				colType = StringType
-			default:
-				return UnknownQueryType, GoDBError{ParseError, fmt.Sprintf("unsupported column type %s", col.Type.Type)}
-
-			}
-			fields[i] = FieldType{colName, "", colType}
+	case "drop":
+		tabName := sqlparser.String(ddl.Table.Name)
+		if t, err := c.GetTableInfo(tabName); err == nil && t.view != nil {
+			return UnknownQueryType, GoDBError{IllegalOperationError, fmt.Sprintf("%s is a materialized view; use DROP MATERIALIZED VIEW", tabName)}
 		}
-
-		_, err := c.addTable(tabName, TupleDesc{fields})
+		err := c.dropTable(tabName)
 		if err != nil {
 			return UnknownQueryType, err
 		}
-		return CreateTableQueryType, nil
+		return DropTableQueryType, nil
 
-	case "drop":
-		tabName := sqlparser.String(ddl.Table.Name)
-		err := c.dropTable(tabName)
+	case "rename":
+		t, err := c.GetTableInfo(sqlparser.String(ddl.Table.Name))
 		if err != nil {
 			return UnknownQueryType, err
 		}
-		return DropTableQueryType, nil
+		if err := c.renameTable(t, sqlparser.String(ddl.NewName.Name)); err != nil {
+			return UnknownQueryType, err
+		}
+		return AlterTableQueryType, nil
 	default:
 		return UnknownQueryType, GoDBError{ParseError, fmt.Sprintf("unsupported ddl statement %s", ddl.Action)}
 	}
 }
 
+// Parse and plan a query.  DDL statements are run as they are parsed, and
+// save the catalog to its file.
 func Parse(c *Catalog, query string) (QueryType, Operator, error) {
-	stmt, err := sqlparser.Parse(query)
+	qType, op, err := parse(c, query)
+	if err != nil {
+		return UnknownQueryType, nil, err
+	}
+	switch qType {
+	case CreateTableQueryType, DropTableQueryType, AlterTableQueryType,
+		CreateViewQueryType, DropViewQueryType, RefreshViewQueryType:
+		if err := c.save(); err != nil {
+			return UnknownQueryType, nil, err
+		}
+	}
+	return qType, op, nil
+}
+
+// Parse a statement with sqlparser.  The standard SQL syntax that it does not
+// accept is parsed first -- WITH clauses by parseWith and set operations by
+// parseSetOperations -- or rewritten; see parseRewritten.
+func parseSQL(query string) (sqlparser.Statement, error) {
+	if withRegexp.MatchString(query) {
+		return parseWith(query)
+	}
+	return parseSetOperations(query)
+}
+
+// Parse a statement without WITH clauses or set operations with sqlparser,
+// after rewriting the syntax it does not accept; see rewriteWindowSyntax,
+// rewriteMatchOperators and rewriteFunctionSyntax.
+func parseRewritten(query string) (sqlparser.Statement, error) {
+	return sqlparser.Parse(rewriteFunctionSyntax(rewriteMatchOperators(rewriteWindowSyntax(query))))
+}
+
+func parse(c *Catalog, query string) (QueryType, Operator, error) {
+	if alterTableRegexp.MatchString(query) {
+		qType, err := parseAlterTable(c, query)
+		return qType, nil, err
+	}
+	if viewStatementRegexp.MatchString(query) {
+		qType, err := parseViewStatement(c, query)
+		return qType, nil, err
+	}
+	if createTableRegexp.MatchString(query) {
+		qType, err := parseCreateTable(c, query)
+		return qType, nil, err
+	}
+	if copyRegexp.MatchString(query) {
+		return parseCopy(c, query)
+	}
+	stmt, err := parseSQL(rewriteParams(query))
 	if err != nil {
 		return UnknownQueryType, nil, err
 	}
+	return planStatement(c, stmt)
+}
+
+// Plan a statement that has already been parsed, running it if it is DDL.
+func planStatement(c *Catalog, stmt sqlparser.Statement) (QueryType, Operator, error) {
 	switch stmt := stmt.(type) {
-	case *sqlparser.Select:
-		plan, err := parseStatement(c, stmt)
+	case sqlparser.SelectStatement:
+		plan, err := parseQuery(c, stmt)
 		if err != nil {
 			//fmt.Printf("Err: %s\n", err.Error())
 			return UnknownQueryType, nil, err
@@ -0,0 +1,913 @@
+package godb
+
+// A network server that speaks the PostgreSQL v3 frontend/backend protocol,
+// so that psql and standard postgres drivers can be used against GoDB.
+//
+// Each connection gets its own [Session] (and so its own transactions) on the
+// [Catalog] and [BufferPool] shared by the server.  Both the simple query
+// protocol and the extended (Parse / Bind / Describe / Execute / Sync)
+// protocol are supported.  Authentication and SSL are not; clients that ask
+// for SSL are told it is unavailable and may continue in the clear.
+//
+// A CancelRequest with the process ID and secret key a connection was given
+// cancels the statement the connection is running, if any.
+//
+// See https://www.postgresql.org/docs/current/protocol.html
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	pgProtocolVersion = 196608 // 3.0
+	pgSSLRequest      = 80877103
+	pgCancelRequest   = 80877102
+	pgGSSENCRequest   = 80877104
+	// the longest message a client may send, so that a bogus length cannot
+	// make the server allocate gigabytes
+	pgMaxMessageLength = 16 << 20
+)
+
+// Type OIDs of the postgres types GoDB values are mapped to.
+const (
+	pgInt2OID    int32 = 21
+	pgInt4OID    int32 = 23
+	pgInt8OID    int32 = 20
+	pgTextOID    int32 = 25
+	pgVarcharOID int32 = 1043
+)
+
+type Server struct {
+	catalog *Catalog
+	bp      *BufferPool
+
+	mutex    sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]bool
+	nextPid  int32
+	closed   bool
+	// the connections, by process ID, so that CancelRequests can find them
+	pgConns map[int32]*pgConn
+	// the directory COPY statements may read and write files in, or "" if
+	// they may not
+	copyDir string
+}
+
+// Create a server that runs the queries of its clients against the supplied
+// catalog and buffer pool.
+func NewServer(c *Catalog, bp *BufferPool) *Server {
+	return &Server{catalog: c, bp: bp, conns: make(map[net.Conn]bool), pgConns: make(map[int32]*pgConn)}
+}
+
+// Allow the clients of the server to COPY to and from the files in dir, see
+// [Session.RestrictCopy], or to none if dir is empty, the default, since
+// the files are read and written by the server's process.
+func (srv *Server) SetCopyDirectory(dir string) error {
+	if dir != "" {
+		var err error
+		if dir, err = resolveCopyDir(dir); err != nil {
+			return err
+		}
+	}
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	srv.copyDir = dir
+	return nil
+}
+
+// Listen on the TCP address addr (e.g., "localhost:5432") and serve
+// connections until the server is closed.
+func (srv *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return srv.Serve(l)
+}
+
+// Accept connections on l and serve each one on its own goroutine.  Always
+// returns a non-nil error; after [Server.Close] the error is
+// [net.ErrClosed].
+func (srv *Server) Serve(l net.Listener) error {
+	srv.mutex.Lock()
+	if srv.closed {
+		srv.mutex.Unlock()
+		l.Close()
+		return net.ErrClosed
+	}
+	srv.listener = l
+	srv.mutex.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		srv.mutex.Lock()
+		srv.conns[conn] = true
+		srv.nextPid++
+		pid := srv.nextPid
+		s := NewSession(srv.catalog, srv.bp)
+		s.copyRestricted, s.copyDir = true, srv.copyDir
+		pc := newPgConn(conn, srv, s, pid)
+		srv.pgConns[pid] = pc
+		srv.mutex.Unlock()
+
+		go func() {
+			if err := pc.serve(); err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				log.Printf("godb server: connection %d: %s", pid, err.Error())
+			}
+			pc.session.Close()
+			conn.Close()
+			srv.mutex.Lock()
+			delete(srv.conns, conn)
+			delete(srv.pgConns, pid)
+			srv.mutex.Unlock()
+		}()
+	}
+}
+
+// Return the address the server is listening on, or nil if it is not
+// listening.
+func (srv *Server) Addr() net.Addr {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	if srv.listener == nil {
+		return nil
+	}
+	return srv.listener.Addr()
+}
+
+// Cancel the statement the connection with process ID pid is running, if key
+// is the connection's secret key.
+func (srv *Server) cancel(pid, key int32) {
+	srv.mutex.Lock()
+	pc, ok := srv.pgConns[pid]
+	srv.mutex.Unlock()
+	if !ok || pc.key != key {
+		return
+	}
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+	if pc.cancelStatement != nil {
+		pc.cancelStatement()
+	}
+}
+
+// Stop listening and close all open connections.  Transactions that are open
+// on those connections are aborted.
+func (srv *Server) Close() error {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	srv.closed = true
+	var err error
+	if srv.listener != nil {
+		err = srv.listener.Close()
+	}
+	for conn := range srv.conns {
+		conn.Close()
+	}
+	return err
+}
+
+// A statement created by a Parse message
+type pgStatement struct {
+	query string
+	// the planned statement, or nil if query could not be prepared (e.g.,
+	// because it is DDL), in which case it is run from its text and
+	// takes no parameters
+	stmt       *Stmt
+	paramTypes []int32
+}
+
+// A portal created by a Bind message; the statement is run on the first
+// Execute and its results are handed out across Executes.
+type pgPortal struct {
+	stmt          *pgStatement
+	args          []DBValue
+	resultFormats []int16
+	result        *Result
+	sent          int
+}
+
+type pgConn struct {
+	conn    net.Conn
+	srv     *Server
+	r       *bufio.Reader
+	w       *bufio.Writer
+	session *Session
+	pid     int32
+	key     int32 // the secret key of CancelRequests
+
+	mutex sync.Mutex
+	// cancels the statement being run, or nil
+	cancelStatement context.CancelFunc
+
+	stmts   map[string]*pgStatement
+	portals map[string]*pgPortal
+	// after an error in the extended protocol, messages are discarded until
+	// the next Sync
+	skipToSync bool
+}
+
+func newPgConn(conn net.Conn, srv *Server, s *Session, pid int32) *pgConn {
+	return &pgConn{
+		conn:    conn,
+		srv:     srv,
+		r:       bufio.NewReader(conn),
+		w:       bufio.NewWriter(conn),
+		session: s,
+		pid:     pid,
+		key:     rand.Int31(),
+		stmts:   make(map[string]*pgStatement),
+		portals: make(map[string]*pgPortal),
+	}
+}
+
+func (c *pgConn) serve() error {
+	if err := c.startup(); err != nil {
+		return err
+	}
+	for {
+		typ, body, err := c.readMessage()
+		if err != nil {
+			return err
+		}
+		if c.skipToSync && typ != 'S' && typ != 'X' {
+			continue
+		}
+		msg := &pgReader{body, nil}
+		switch typ {
+		case 'Q':
+			err = c.handleQuery(msg.string())
+		case 'P':
+			err = c.handleParse(msg)
+		case 'B':
+			err = c.handleBind(msg)
+		case 'D':
+			err = c.handleDescribe(msg)
+		case 'E':
+			err = c.handleExecute(msg)
+		case 'C':
+			err = c.handleClose(msg)
+		case 'S':
+			c.skipToSync = false
+			err = c.sendReady()
+		case 'H':
+			err = c.w.Flush()
+		case 'X':
+			return nil
+		default:
+			err = c.sendError(GoDBError{IllegalOperationError, fmt.Sprintf("unsupported message type '%c'", typ)})
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Process the startup packet (and any SSL negotiation that precedes it) and
+// greet the client.
+func (c *pgConn) startup() error {
+	for {
+		var hdr [8]byte
+		if _, err := io.ReadFull(c.r, hdr[:]); err != nil {
+			return err
+		}
+		length := int(binary.BigEndian.Uint32(hdr[0:4]))
+		code := binary.BigEndian.Uint32(hdr[4:8])
+		if length < 8 || length > 10000 {
+			return fmt.Errorf("invalid startup packet length %d", length)
+		}
+		rest := make([]byte, length-8)
+		if _, err := io.ReadFull(c.r, rest); err != nil {
+			return err
+		}
+		switch code {
+		case pgSSLRequest, pgGSSENCRequest:
+			if _, err := c.conn.Write([]byte{'N'}); err != nil {
+				return err
+			}
+			continue
+		case pgCancelRequest:
+			if len(rest) == 8 {
+				c.srv.cancel(int32(binary.BigEndian.Uint32(rest[0:4])), int32(binary.BigEndian.Uint32(rest[4:8])))
+			}
+			return io.EOF
+		case pgProtocolVersion:
+		default:
+			return fmt.Errorf("unsupported protocol version %d", code)
+		}
+		break
+	}
+
+	c.send('R', newPgWriter().int32(0).bytes())
+	params := [][2]string{
+		{"server_version", "9.6.0"},
+		{"server_encoding", "UTF8"},
+		{"client_encoding", "UTF8"},
+		{"DateStyle", "ISO, MDY"},
+		{"integer_datetimes", "on"},
+		{"standard_conforming_strings", "on"},
+	}
+	for _, p := range params {
+		c.send('S', newPgWriter().string(p[0]).string(p[1]).bytes())
+	}
+	c.send('K', newPgWriter().int32(c.pid).int32(c.key).bytes())
+	return c.sendReady()
+}
+
+func (c *pgConn) readMessage() (byte, []byte, error) {
+	typ, err := c.r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	var lenBuf [4]byte
+	if _, err := io.ReadFull(c.r, lenBuf[:]); err != nil {
+		return 0, nil, err
+	}
+	length := int(binary.BigEndian.Uint32(lenBuf[:]))
+	if length < 4 || length > pgMaxMessageLength {
+		err := GoDBError{MalformedDataError, fmt.Sprintf("invalid message length %d", length)}
+		// the rest of the stream cannot be parsed, so the connection is
+		// closed after reporting the error
+		c.sendError(err)
+		c.w.Flush()
+		return 0, nil, err
+	}
+	body := make([]byte, length-4)
+	if _, err := io.ReadFull(c.r, body); err != nil {
+		return 0, nil, err
+	}
+	return typ, body, nil
+}
+
+func (c *pgConn) send(typ byte, body []byte) error {
+	c.w.WriteByte(typ)
+	var lenBuf [4]byte
+	binary.BigEndian.PutUint32(lenBuf[:], uint32(len(body)+4))
+	c.w.Write(lenBuf[:])
+	_, err := c.w.Write(body)
+	return err
+}
+
+func (c *pgConn) sendReady() error {
+	status := byte('I')
+	switch {
+	case c.session.TransactionFailed():
+		status = 'E'
+	case c.session.InTransaction():
+		status = 'T'
+	}
+	c.send('Z', []byte{status})
+	return c.w.Flush()
+}
+
+func (c *pgConn) sendError(err error) error {
+	code := "XX000"
+	if gerr, ok := err.(GoDBError); ok {
+		code = sqlState(gerr.code)
+	} else if strings.Contains(err.Error(), "syntax error") {
+		code = "42601"
+	}
+	c.send('E', newPgWriter().
+		byte('S').string("ERROR").
+		byte('V').string("ERROR").
+		byte('C').string(code).
+		byte('M').string(err.Error()).
+		byte(0).bytes())
+	return nil
+}
+
+// Map a GoDB error code to the closest postgres SQLSTATE.
+func sqlState(code GoDBErrorCode) string {
+	switch code {
+	case ParseError:
+		return "42601"
+	case NoSuchTableError:
+		return "42P01"
+	case DuplicateTableError:
+		return "42P07"
+	case AmbiguousNameError:
+		return "42702"
+	case TypeMismatchError, IncompatibleTypesError:
+		return "42804"
+	case MalformedDataError:
+		return "22000"
+	case IllegalTransactionError:
+		return "25000"
+	case DeadlockError:
+		return "40P01"
+	case IllegalOperationError:
+		return "0A000"
+	case ConstraintViolationError:
+		return "23000"
+	case QueryCancelledError:
+		return "57014"
+	case ResourceExhaustedError:
+		return "53200"
+	}
+	return "XX000"
+}
+
+// Run a statement with a context that a CancelRequest cancels.
+func (c *pgConn) run(f func(ctx context.Context) (*Result, error)) (*Result, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	c.mutex.Lock()
+	c.cancelStatement = cancel
+	c.mutex.Unlock()
+	defer func() {
+		c.mutex.Lock()
+		c.cancelStatement = nil
+		c.mutex.Unlock()
+		cancel()
+	}()
+	return f(ctx)
+}
+
+// Run each of the ;-separated statements in a simple Query message.
+func (c *pgConn) handleQuery(query string) error {
+	stmts := splitStatements(query)
+	if len(stmts) == 0 {
+		c.send('I', nil)
+		return c.sendReady()
+	}
+	for _, q := range stmts {
+		res, err := c.run(func(ctx context.Context) (*Result, error) {
+			return c.session.ExecuteContext(ctx, q)
+		})
+		if err != nil {
+			c.sendError(err)
+			break
+		}
+		if rowDesc := resultRowDesc(res); rowDesc != nil {
+			c.send('T', rowDescription(rowDesc, nil))
+			for _, t := range res.Tuples {
+				c.send('D', dataRow(t, nil))
+			}
+		}
+		c.send('C', newPgWriter().string(res.Tag).bytes())
+	}
+	return c.sendReady()
+}
+
+func (c *pgConn) handleParse(msg *pgReader) error {
+	name := msg.string()
+	query := msg.string()
+	stmt := &pgStatement{query: query, paramTypes: make([]int32, msg.count(4))}
+	for i := range stmt.paramTypes {
+		stmt.paramTypes[i] = msg.int32()
+	}
+	if msg.err != nil {
+		return c.extendedError(msg.err)
+	}
+	if strings.TrimSpace(query) != "" {
+		prepared, err := c.session.Prepare(query)
+		if err != nil && countParams(query) > 0 {
+			return c.extendedError(err)
+		}
+		// statements without parameters that cannot be prepared are run
+		// as simple queries when executed, which reports any error then
+		if err == nil {
+			stmt.stmt = prepared
+			for i, t := range prepared.ParamTypes() {
+				if i == len(stmt.paramTypes) {
+					stmt.paramTypes = append(stmt.paramTypes, 0)
+				}
+				if stmt.paramTypes[i] == 0 {
+					stmt.paramTypes[i] = pgTypeOID(t)
+				}
+			}
+		}
+	}
+	c.stmts[name] = stmt
+	return c.send('1', nil)
+}
+
+func (c *pgConn) handleBind(msg *pgReader) error {
+	portalName := msg.string()
+	stmtName := msg.string()
+	stmt, ok := c.stmts[stmtName]
+	if !ok {
+		return c.extendedError(GoDBError{ParseError, fmt.Sprintf("prepared statement \"%s\" does not exist", stmtName)})
+	}
+	paramFormats := make([]int16, msg.count(2))
+	for i := range paramFormats {
+		paramFormats[i] = msg.int16()
+	}
+	// each argument is at least its length
+	args := make([]DBValue, msg.count(4))
+	for i := range args {
+		format := int16(0)
+		if len(paramFormats) == 1 {
+			format = paramFormats[0]
+		} else if i < len(paramFormats) {
+			format = paramFormats[i]
+		}
+		oid := int32(0)
+		if i < len(stmt.paramTypes) {
+			oid = stmt.paramTypes[i]
+		}
+		length := msg.int32()
+		if length < 0 {
+			return c.extendedError(GoDBError{IllegalOperationError, "GoDB does not support NULL parameters"})
+		}
+		val := msg.next(int(length))
+		if msg.err != nil {
+			return c.extendedError(msg.err)
+		}
+		arg, err := decodeParam(val, format, oid)
+		if err != nil {
+			return c.extendedError(err)
+		}
+		args[i] = arg
+	}
+	resultFormats := make([]int16, msg.count(2))
+	for i := range resultFormats {
+		resultFormats[i] = msg.int16()
+	}
+	if msg.err != nil {
+		return c.extendedError(msg.err)
+	}
+	// parameters are bound when the portal is executed, since the plan of
+	// a statement is shared by all of its portals
+	c.portals[portalName] = &pgPortal{stmt: stmt, args: args, resultFormats: resultFormats}
+	return c.send('2', nil)
+}
+
+func (c *pgConn) handleDescribe(msg *pgReader) error {
+	kind := msg.byte()
+	name := msg.string()
+	if msg.err != nil {
+		return c.extendedError(msg.err)
+	}
+	switch kind {
+	case 'S':
+		stmt, ok := c.stmts[name]
+		if !ok {
+			return c.extendedError(GoDBError{ParseError, fmt.Sprintf("prepared statement \"%s\" does not exist", name)})
+		}
+		desc, err := c.describe(stmt)
+		if err != nil {
+			return c.extendedError(err)
+		}
+		pd := newPgWriter().int16(int16(len(stmt.paramTypes)))
+		for _, oid := range stmt.paramTypes {
+			if oid == 0 {
+				oid = pgTextOID
+			}
+			pd.int32(oid)
+		}
+		c.send('t', pd.bytes())
+		if desc == nil {
+			return c.send('n', nil)
+		}
+		return c.send('T', rowDescription(desc, nil))
+	case 'P':
+		portal, ok := c.portals[name]
+		if !ok {
+			return c.extendedError(GoDBError{ParseError, fmt.Sprintf("portal \"%s\" does not exist", name)})
+		}
+		var desc *TupleDesc
+		var err error
+		if portal.result != nil {
+			desc = resultRowDesc(portal.result)
+		} else {
+			desc, err = c.describe(portal.stmt)
+		}
+		if err != nil {
+			return c.extendedError(err)
+		}
+		if desc == nil {
+			return c.send('n', nil)
+		}
+		return c.send('T', rowDescription(desc, portal.resultFormats))
+	}
+	return c.extendedError(GoDBError{IllegalOperationError, fmt.Sprintf("invalid describe target '%c'", kind)})
+}
+
+func (c *pgConn) handleExecute(msg *pgReader) error {
+	name := msg.string()
+	maxRows := int(msg.int32())
+	if msg.err != nil {
+		return c.extendedError(msg.err)
+	}
+	portal, ok := c.portals[name]
+	if !ok {
+		return c.extendedError(GoDBError{ParseError, fmt.Sprintf("portal \"%s\" does not exist", name)})
+	}
+	if strings.TrimSpace(portal.stmt.query) == "" {
+		return c.send('I', nil)
+	}
+	if portal.result == nil {
+		var res *Result
+		var err error
+		res, err = c.run(func(ctx context.Context) (*Result, error) {
+			if portal.stmt.stmt != nil {
+				return c.session.ExecuteStmtContext(ctx, portal.stmt.stmt, portal.args...)
+			}
+			return c.session.ExecuteContext(ctx, portal.stmt.query)
+		})
+		if err != nil {
+			return c.extendedError(err)
+		}
+		portal.result = res
+	}
+	res := portal.result
+	if resultRowDesc(res) != nil {
+		end := len(res.Tuples)
+		if maxRows > 0 && portal.sent+maxRows < end {
+			end = portal.sent + maxRows
+		}
+		for ; portal.sent < end; portal.sent++ {
+			c.send('D', dataRow(res.Tuples[portal.sent], portal.resultFormats))
+		}
+		if portal.sent < len(res.Tuples) {
+			return c.send('s', nil)
+		}
+	}
+	return c.send('C', newPgWriter().string(res.Tag).bytes())
+}
+
+func (c *pgConn) handleClose(msg *pgReader) error {
+	kind := msg.byte()
+	name := msg.string()
+	if kind == 'S' {
+		delete(c.stmts, name)
+	} else {
+		delete(c.portals, name)
+	}
+	return c.send('3', nil)
+}
+
+// Return the descriptor of the rows a statement produces, or nil if it does
+// not produce rows.
+func (c *pgConn) describe(stmt *pgStatement) (*TupleDesc, error) {
+	if stmt.stmt != nil {
+		return stmt.stmt.Descriptor(), nil
+	}
+	if strings.TrimSpace(stmt.query) == "" {
+		return nil, nil
+	}
+	return c.session.Describe(stmt.query)
+}
+
+func (c *pgConn) extendedError(err error) error {
+	c.skipToSync = true
+	return c.sendError(err)
+}
+
+// Return the descriptor of the rows to send to the client for a result, or
+// nil if the result should be reported with just a command tag.  Inserts and
+// deletes return a count tuple in GoDB but only a tag in postgres.
+func resultRowDesc(res *Result) *TupleDesc {
+	if res.Desc == nil || strings.HasPrefix(res.Tag, "INSERT") || strings.HasPrefix(res.Tag, "DELETE") || strings.HasPrefix(res.Tag, "COPY") {
+		return nil
+	}
+	return res.Desc
+}
+
+func formatFor(formats []int16, i int) int16 {
+	if len(formats) == 1 {
+		return formats[0]
+	}
+	if i < len(formats) {
+		return formats[i]
+	}
+	return 0
+}
+
+func rowDescription(desc *TupleDesc, formats []int16) []byte {
+	w := newPgWriter().int16(int16(len(desc.Fields)))
+	for i, f := range desc.Fields {
+		w.string(f.Fname).int32(0).int16(0)
+		switch f.Ftype {
+		case IntType:
+			w.int32(pgInt8OID).int16(8)
+		default:
+			w.int32(pgTextOID).int16(-1)
+		}
+		w.int32(-1).int16(formatFor(formats, i))
+	}
+	return w.bytes()
+}
+
+func dataRow(t *Tuple, formats []int16) []byte {
+	w := newPgWriter().int16(int16(len(t.Fields)))
+	for i, f := range t.Fields {
+		var val []byte
+		switch f := f.(type) {
+		case IntField:
+			if formatFor(formats, i) == 1 {
+				val = binary.BigEndian.AppendUint64(nil, uint64(f.Value))
+			} else {
+				val = []byte(strconv.FormatInt(f.Value, 10))
+			}
+		case StringField:
+			val = []byte(f.Value)
+		case nil:
+			// a NULL is sent as a length of -1 with no bytes
+			w.int32(-1)
+			continue
+		}
+		w.int32(int32(len(val)))
+		w.buf.Write(val)
+	}
+	return w.bytes()
+}
+
+// Return the postgres type a GoDB type is sent to clients as.
+func pgTypeOID(t DBType) int32 {
+	if t == IntType {
+		return pgInt8OID
+	}
+	return pgTextOID
+}
+
+// Decode the value of a bound parameter.
+func decodeParam(val []byte, format int16, oid int32) (DBValue, error) {
+	if format == 1 {
+		// binary parameters of unspecified type are assumed to be integers,
+		// since those are the only binary values that differ from text
+		switch oid {
+		case pgInt8OID, pgInt4OID, pgInt2OID, 0:
+			switch len(val) {
+			case 8:
+				return IntField{int64(binary.BigEndian.Uint64(val))}, nil
+			case 4:
+				return IntField{int64(int32(binary.BigEndian.Uint32(val)))}, nil
+			case 2:
+				return IntField{int64(int16(binary.BigEndian.Uint16(val)))}, nil
+			}
+			return nil, GoDBError{MalformedDataError, fmt.Sprintf("invalid binary integer of length %d", len(val))}
+		}
+	}
+	s := string(val)
+	switch oid {
+	case pgInt8OID, pgInt4OID, pgInt2OID:
+		v, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, GoDBError{TypeMismatchError, fmt.Sprintf("invalid integer parameter %q", s)}
+		}
+		return IntField{v}, nil
+	}
+	// text values are converted to the type of the parameter when bound
+	return StringField{s}, nil
+}
+
+// Return the highest numbered $n placeholder in query.
+func countParams(query string) int {
+	max := 0
+	substituteParams(query, func(n int) string {
+		if n > max {
+			max = n
+		}
+		return ""
+	})
+	return max
+}
+
+// Split a string on the semicolons that are outside of quoted strings,
+// dropping empty statements.
+func splitStatements(query string) []string {
+	var stmts []string
+	var quote byte
+	start := 0
+	for i := 0; i <= len(query); i++ {
+		if i < len(query) {
+			ch := query[i]
+			if quote != 0 {
+				if ch == '\\' && quote == '\'' && i+1 < len(query) {
+					i++
+				} else if ch == quote {
+					quote = 0
+				}
+				continue
+			}
+			if ch == '\'' || ch == '"' || ch == '`' {
+				quote = ch
+				continue
+			}
+			if ch != ';' {
+				continue
+			}
+		}
+		if s := strings.TrimSpace(query[start:i]); s != "" {
+			stmts = append(stmts, s)
+		}
+		start = i + 1
+	}
+	return stmts
+}
+
+// Helpers to decode the body of a protocol message.  Reads past the end of
+// the message set err rather than failing immediately.
+type pgReader struct {
+	buf []byte
+	err error
+}
+
+func (r *pgReader) next(n int) []byte {
+	if r.err != nil || n < 0 || n > len(r.buf) {
+		r.err = GoDBError{MalformedDataError, "truncated protocol message"}
+		return nil
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func (r *pgReader) byte() byte {
+	b := r.next(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (r *pgReader) int16() int16 {
+	b := r.next(2)
+	if b == nil {
+		return 0
+	}
+	return int16(binary.BigEndian.Uint16(b))
+}
+
+func (r *pgReader) int32() int32 {
+	b := r.next(4)
+	if b == nil {
+		return 0
+	}
+	return int32(binary.BigEndian.Uint32(b))
+}
+
+// Read the int16 count of the items of an array that follows, each at least
+// size bytes long.  Counts that are negative, or that the rest of the
+// message is too short for, set err and return 0.
+func (r *pgReader) count(size int) int {
+	n := int(r.int16())
+	if r.err == nil && (n < 0 || n*size > len(r.buf)) {
+		r.err = GoDBError{MalformedDataError, fmt.Sprintf("invalid array length %d in protocol message", n)}
+	}
+	if r.err != nil {
+		return 0
+	}
+	return n
+}
+
+func (r *pgReader) string() string {
+	i := bytes.IndexByte(r.buf, 0)
+	if r.err != nil || i < 0 {
+		r.err = GoDBError{MalformedDataError, "unterminated string in protocol message"}
+		return ""
+	}
+	s := string(r.buf[:i])
+	r.buf = r.buf[i+1:]
+	return s
+}
+
+// Helper to encode the body of a protocol message.
+type pgWriter struct {
+	buf bytes.Buffer
+}
+
+func newPgWriter() *pgWriter {
+	return &pgWriter{}
+}
+
+func (w *pgWriter) byte(b byte) *pgWriter {
+	w.buf.WriteByte(b)
+	return w
+}
+
+func (w *pgWriter) int16(v int16) *pgWriter {
+	binary.Write(&w.buf, binary.BigEndian, v)
+	return w
+}
+
+func (w *pgWriter) int32(v int32) *pgWriter {
+	binary.Write(&w.buf, binary.BigEndian, v)
+	return w
+}
+
+func (w *pgWriter) string(s string) *pgWriter {
+	w.buf.WriteString(s)
+	w.buf.WriteByte(0)
+	return w
+}
+
+func (w *pgWriter) bytes() []byte {
+	return w.buf.Bytes()
+}
@@ -0,0 +1,367 @@
+package godb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// A minimal postgres protocol client, just enough to exercise the server.
+type pgTestClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+type pgTestResult struct {
+	cols []string
+	rows [][]string
+	tags []string
+	err  string
+	// the transaction status of the ReadyForQuery message
+	status byte
+}
+
+func startTestServer(t *testing.T) (*Server, *pgTestClient) {
+	t.Helper()
+	bp, c, err := MakeParserTestDatabase(1000)
+	if err != nil {
+		t.Fatalf("failed to create test database, %s", err.Error())
+	}
+	srv := NewServer(c, bp)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen, %s", err.Error())
+	}
+	go srv.Serve(l)
+	t.Cleanup(func() { srv.Close() })
+	cl := dialTestServer(t, l.Addr().String())
+	res := cl.query("insert into t values ('kathy', 45), ('bill', 30), ('mike', 45)")
+	if res.err != "" {
+		t.Fatalf("failed to insert test data, %s", res.err)
+	}
+	return srv, cl
+}
+
+func dialTestServer(t *testing.T, addr string) *pgTestClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to connect, %s", err.Error())
+	}
+	t.Cleanup(func() { conn.Close() })
+	cl := &pgTestClient{t, conn, bufio.NewReader(conn)}
+
+	// ask for SSL first, as psql does by default
+	ssl := newPgWriter().int32(8).int32(pgSSLRequest).bytes()
+	conn.Write(ssl)
+	if b, err := cl.r.ReadByte(); err != nil || b != 'N' {
+		t.Fatalf("expected SSL to be refused, got %c (%v)", b, err)
+	}
+
+	body := newPgWriter().int32(pgProtocolVersion).string("user").string("godb").byte(0).bytes()
+	conn.Write(append(newPgWriter().int32(int32(len(body)+4)).bytes(), body...))
+	cl.readUntilReady()
+	return cl
+}
+
+func (cl *pgTestClient) send(typ byte, body []byte) {
+	msg := []byte{typ}
+	msg = binary.BigEndian.AppendUint32(msg, uint32(len(body)+4))
+	cl.conn.Write(append(msg, body...))
+}
+
+func (cl *pgTestClient) readUntilReady() *pgTestResult {
+	cl.t.Helper()
+	res := &pgTestResult{}
+	for {
+		typ, err := cl.r.ReadByte()
+		if err != nil {
+			cl.t.Fatalf("failed to read message, %s", err.Error())
+		}
+		var lenBuf [4]byte
+		io.ReadFull(cl.r, lenBuf[:])
+		body := make([]byte, binary.BigEndian.Uint32(lenBuf[:])-4)
+		io.ReadFull(cl.r, body)
+		msg := &pgReader{body, nil}
+		switch typ {
+		case 'T':
+			n := int(msg.int16())
+			res.cols = nil
+			for i := 0; i < n; i++ {
+				res.cols = append(res.cols, msg.string())
+				msg.next(18)
+			}
+		case 'D':
+			n := int(msg.int16())
+			var row []string
+			for i := 0; i < n; i++ {
+				if size := int(msg.int32()); size >= 0 {
+					row = append(row, string(msg.next(size)))
+				} else {
+					row = append(row, "NULL")
+				}
+			}
+			res.rows = append(res.rows, row)
+		case 'C':
+			res.tags = append(res.tags, msg.string())
+		case 'E':
+			for {
+				code := msg.byte()
+				if code == 0 {
+					break
+				}
+				val := msg.string()
+				if code == 'M' {
+					res.err = val
+				}
+			}
+		case 'Z':
+			res.status = msg.byte()
+			return res
+		}
+	}
+}
+
+func (cl *pgTestClient) query(q string) *pgTestResult {
+	cl.t.Helper()
+	cl.send('Q', newPgWriter().string(q).bytes())
+	return cl.readUntilReady()
+}
+
+func TestServerSimpleQuery(t *testing.T) {
+	_, cl := startTestServer(t)
+
+	res := cl.query("select name, age from t where age > 40")
+	if res.err != "" {
+		t.Fatalf("query failed, %s", res.err)
+	}
+	if len(res.cols) != 2 || res.cols[0] != "name" || res.cols[1] != "age" {
+		t.Fatalf("unexpected columns %v", res.cols)
+	}
+	if len(res.rows) == 0 {
+		t.Fatalf("expected rows, got none")
+	}
+	for _, row := range res.rows {
+		if age, _ := strconv.Atoi(row[1]); age <= 40 {
+			t.Errorf("row %v does not satisfy predicate", row)
+		}
+	}
+	if len(res.tags) != 1 || res.tags[0] != "SELECT "+strconv.Itoa(len(res.rows)) {
+		t.Errorf("unexpected command tags %v", res.tags)
+	}
+
+	res = cl.query("insert into t values ('zed', 99); select name from t where age = 99")
+	if res.err != "" {
+		t.Fatalf("query failed, %s", res.err)
+	}
+	if len(res.tags) != 2 || res.tags[0] != "INSERT 0 1" {
+		t.Errorf("unexpected command tags %v", res.tags)
+	}
+	// sam and bo of testdb.txt are 99 too
+	var names []string
+	for _, row := range res.rows {
+		names = append(names, row[0])
+	}
+	sort.Strings(names)
+	if strings.Join(names, ",") != "bo,sam,zed" {
+		t.Errorf("expected inserted row with those of testdb.txt, got %v", res.rows)
+	}
+
+	res = cl.query("select name, nullif(name, 'zed') from t where age = 99 order by name")
+	if len(res.rows) != 3 || res.rows[0][1] != "bo" || res.rows[2][1] != "NULL" {
+		t.Errorf("expected zed to be sent as NULL, got %v", res.rows)
+	}
+
+	res = cl.query("select nosuchfield from t")
+	if res.err == "" {
+		t.Errorf("expected an error for a missing field")
+	}
+}
+
+func TestServerTransactionsPerConnection(t *testing.T) {
+	srv, cl := startTestServer(t)
+	cl2 := dialTestServer(t, srv.Addr().String())
+
+	res := cl.query("begin")
+	if res.err != "" || len(res.tags) != 1 || res.tags[0] != "BEGIN" || res.status != 'T' {
+		t.Fatalf("begin failed, %v", res)
+	}
+	// the second connection is not in a transaction, so it cannot commit
+	res = cl2.query("commit")
+	if res.err == "" {
+		t.Errorf("expected commit outside of a transaction to fail")
+	}
+	res = cl.query("insert into t2 values ('amy', 7)")
+	if res.err != "" {
+		t.Fatalf("insert failed, %s", res.err)
+	}
+	// an error fails the transaction, which then rejects statements until
+	// it is rolled back
+	res = cl.query("select nosuchfield from t")
+	if res.err == "" || res.status != 'E' {
+		t.Fatalf("expected the transaction to fail, got %v", res)
+	}
+	res = cl.query("select name from t")
+	if res.err == "" || res.status != 'E' {
+		t.Errorf("expected the failed transaction to reject statements, got %v", res)
+	}
+	res = cl.query("rollback")
+	if res.err != "" || res.tags[0] != "ROLLBACK" || res.status != 'I' {
+		t.Fatalf("rollback failed, %v", res)
+	}
+	res = cl2.query("select name from t2 where age = 7")
+	if len(res.rows) != 0 {
+		t.Errorf("rolled back insert is visible: %v", res.rows)
+	}
+}
+
+func TestServerExtendedQuery(t *testing.T) {
+	_, cl := startTestServer(t)
+
+	cl.send('P', newPgWriter().string("s1").string("select name, age from t where age = $1").int16(0).bytes())
+	cl.send('D', newPgWriter().byte('S').string("s1").bytes())
+	// bind 45 as a binary int8, and ask for results in binary
+	cl.send('B', newPgWriter().string("").string("s1").
+		int16(1).int16(1).
+		int16(1).int32(8).int32(0).int32(45).
+		int16(1).int16(1).bytes())
+	cl.send('E', newPgWriter().string("").int32(0).bytes())
+	cl.send('S', nil)
+	res := cl.readUntilReady()
+	if res.err != "" {
+		t.Fatalf("extended query failed, %s", res.err)
+	}
+	if len(res.cols) != 2 || res.cols[1] != "age" {
+		t.Fatalf("unexpected columns %v", res.cols)
+	}
+	if len(res.rows) == 0 {
+		t.Fatalf("expected rows, got none")
+	}
+	for _, row := range res.rows {
+		if binary.BigEndian.Uint64([]byte(row[1])) != 45 {
+			t.Errorf("unexpected row %v", row)
+		}
+	}
+
+	// a string parameter containing a quote, in text format
+	cl.send('P', newPgWriter().string("").string("select age from t where name = $1").int16(1).int32(pgTextOID).bytes())
+	cl.send('B', newPgWriter().string("").string("").int16(0).int16(1).int32(4).byte('o').byte('\'').byte('n').byte('e').int16(0).bytes())
+	cl.send('E', newPgWriter().string("").int32(0).bytes())
+	cl.send('S', nil)
+	res = cl.readUntilReady()
+	if res.err != "" {
+		t.Fatalf("extended query failed, %s", res.err)
+	}
+	if len(res.rows) != 0 {
+		t.Errorf("expected no rows, got %v", res.rows)
+	}
+}
+
+// Malformed messages are answered with errors, without bringing the server
+// down.
+func TestServerMalformedMessages(t *testing.T) {
+	srv, cl := startTestServer(t)
+
+	for _, msg := range []struct {
+		typ  byte
+		body []byte
+	}{
+		{'P', newPgWriter().string("s1").string("select name from t where age = $1").int16(-1).bytes()},
+		{'P', newPgWriter().string("s1").string("select name from t where age = $1").int16(1000).int32(0).bytes()},
+		{'B', newPgWriter().string("").string("").int16(-2).bytes()},
+		{'B', newPgWriter().string("").string("").int16(0).int16(30000).int32(1).bytes()},
+		{'B', newPgWriter().string("").string("").int16(0).int16(1).int32(100).byte('x').bytes()},
+	} {
+		if msg.typ == 'B' {
+			cl.send('P', newPgWriter().string("").string("select name from t where age = $1").int16(0).bytes())
+		}
+		cl.send(msg.typ, msg.body)
+		cl.send('S', nil)
+		if res := cl.readUntilReady(); res.err == "" {
+			t.Errorf("%c %v: expected an error", msg.typ, msg.body)
+		}
+	}
+	if res := cl.query("select name from t where age = 99"); res.err != "" || len(res.rows) != 2 {
+		t.Errorf("expected the connection to still work, got %v", res)
+	}
+
+	// a message too long to be read closes the connection
+	cl.conn.Write([]byte{'Q', 0x7f, 0xff, 0xff, 0xff})
+	if typ, err := cl.r.ReadByte(); err != nil || typ != 'E' {
+		t.Errorf("expected an error response, got %c, %v", typ, err)
+	}
+	if _, err := io.ReadAll(cl.r); err != nil {
+		t.Errorf("expected the connection to be closed, got %s", err.Error())
+	}
+	cl2 := dialTestServer(t, srv.Addr().String())
+	if res := cl2.query("select name from t where age = 99"); res.err != "" {
+		t.Errorf("expected the server to still work, got %s", res.err)
+	}
+}
+
+// Clients may only COPY the files in the server's copy directory, and none
+// if it has none.
+func TestServerCopyFiles(t *testing.T) {
+	srv, cl := startTestServer(t)
+	dir, outside := t.TempDir(), t.TempDir()
+	secret := filepath.Join(outside, "secret.csv")
+	if err := os.WriteFile(secret, []byte("eve,1\n"), 0644); err != nil {
+		t.Fatalf("write failed, %s", err.Error())
+	}
+	for _, q := range []string{"copy t from '" + secret + "'", "copy t to '" + filepath.Join(outside, "out.csv") + "'"} {
+		if res := cl.query(q); res.err == "" {
+			t.Errorf("%s: expected COPY of files to be refused", q)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(outside, "out.csv")); err == nil {
+		t.Errorf("expected no file to be written")
+	}
+
+	if err := srv.SetCopyDirectory(dir); err != nil {
+		t.Fatalf("SetCopyDirectory failed, %s", err.Error())
+	}
+	if err := os.Symlink(outside, filepath.Join(dir, "link")); err != nil {
+		t.Fatalf("symlink failed, %s", err.Error())
+	}
+	cl = dialTestServer(t, srv.Addr().String())
+	for _, q := range []string{"copy t to 'out.csv'", "copy t from 'out.csv'", "copy t to '" + filepath.Join(dir, "abs.csv") + "'"} {
+		if res := cl.query(q); res.err != "" {
+			t.Errorf("%s failed, %s", q, res.err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "out.csv")); err != nil {
+		t.Errorf("expected out.csv to be written to the copy directory, %s", err.Error())
+	}
+	for _, q := range []string{
+		"copy t from '" + secret + "'",
+		"copy t from '../" + filepath.Base(outside) + "/secret.csv'",
+		"copy t from 'link/secret.csv'",
+		"copy (select name from t) to 'link/out.csv'",
+	} {
+		if res := cl.query(q); res.err == "" {
+			t.Errorf("%s: expected a file outside of the copy directory to be refused", q)
+		}
+	}
+	if res := cl.query("select name from t where name = 'eve'"); len(res.rows) != 0 {
+		t.Errorf("expected no rows to be copied from outside the copy directory, got %v", res.rows)
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	stmts := splitStatements("select 'a;b' from t; ; insert into t values ('x', 1);")
+	if len(stmts) != 2 || stmts[0] != "select 'a;b' from t" {
+		t.Errorf("unexpected split %q", stmts)
+	}
+	stmts = splitStatements(`select 'a\';b' from t; select 1\`)
+	if len(stmts) != 2 || stmts[0] != `select 'a\';b' from t` {
+		t.Errorf("unexpected split %q", stmts)
+	}
+}
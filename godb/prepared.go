@@ -0,0 +1,365 @@
+package godb
+
+// Prepared statements.  A statement is parsed and planned once by [Prepare];
+// its parameters, written as ? or $n in the SQL text, are placeholders in the
+// plan's constant expressions that are filled in by each call to [Stmt.Bind].
+// Since parameter values never pass through the parser, they cannot change
+// the meaning of the statement.
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/xwb1989/sqlparser"
+)
+
+// The value of a ConstExpr that stands for a statement parameter.  Several
+// placeholders may share an index if a parameter is used more than once.
+type paramValue struct {
+	index int    // 0 for $1 or the first ?, and so on
+	typ   DBType // the type the parameter is used as, or UnknownType
+	value DBValue
+}
+
+// Create a placeholder from a sqlparser value argument.  sqlparser numbers
+// ? placeholders :v1, :v2, ..., and rewriteParams turns $n into :vn.
+func newParamValue(arg string) (*paramValue, error) {
+	n, err := strconv.Atoi(strings.TrimPrefix(arg, ":v"))
+	if !strings.HasPrefix(arg, ":v") || err != nil || n < 1 {
+		return nil, GoDBError{ParseError, fmt.Sprintf("unsupported parameter %s", arg)}
+	}
+	return &paramValue{index: n - 1, typ: UnknownType}, nil
+}
+
+func (p *paramValue) String() string {
+	return "$" + strconv.Itoa(p.index+1)
+}
+
+func (p *paramValue) EvalPred(v DBValue, op BoolOp) bool {
+	if p.value == nil {
+		return false
+	}
+	return p.value.EvalPred(v, op)
+}
+
+// The type of the bound value, or the inferred type if nothing is bound yet.
+func (p *paramValue) dbType() DBType {
+	switch p.value.(type) {
+	case IntField:
+		return IntType
+	case StringField:
+		return StringType
+	}
+	return p.typ
+}
+
+// Return true if e is a parameter placeholder.
+func isParamExpr(e Expr) bool {
+	c, ok := e.(*ConstExpr)
+	if !ok {
+		return false
+	}
+	_, ok = c.val.(*paramValue)
+	return ok
+}
+
+// If e is a parameter placeholder whose type is not yet known, record that it
+// is used as type t (e.g., because it is compared against a field of type t).
+func inferParamType(e Expr, t DBType) {
+	if !isParamExpr(e) || t == UnknownType {
+		return
+	}
+	p := e.(*ConstExpr).val.(*paramValue)
+	if p.typ == UnknownType {
+		p.typ = t
+	}
+}
+
+// A statement that has been parsed and planned, and can be run repeatedly
+// with different parameter values.
+//
+// A Stmt's plan is shared by all of its executions, so it must not be
+// bound again while a previous execution is still iterating.
+type Stmt struct {
+	catalog   *Catalog
+	query     string
+	version   int // catalog version the plan was made against
+	qType     QueryType
+	plan      Operator
+	params    []*paramValue
+	numParams int
+}
+
+// Parse and plan query, which may contain ? or $n parameter placeholders.
+// Only SELECT, INSERT, DELETE, and transaction control statements can be
+// prepared; DDL runs as soon as it is parsed, so it cannot be.
+func Prepare(c *Catalog, query string) (*Stmt, error) {
+	stmt := &Stmt{catalog: c, query: query}
+	if err := stmt.replan(); err != nil {
+		return nil, err
+	}
+	return stmt, nil
+}
+
+// (Re)plan the statement against the current catalog.
+func (s *Stmt) replan() error {
+	parsed, err := parseSQL(rewriteParams(s.query))
+	if err != nil {
+		return err
+	}
+	switch parsed.(type) {
+	case sqlparser.SelectStatement, *sqlparser.Insert, *sqlparser.Delete,
+		*sqlparser.Begin, *sqlparser.Commit, *sqlparser.Rollback:
+	default:
+		return GoDBError{IllegalOperationError, "only SELECT, INSERT, DELETE, and transaction statements can be prepared"}
+	}
+	numParams := 0
+	err = sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		if v, ok := node.(*sqlparser.SQLVal); ok && v.Type == sqlparser.ValArg {
+			p, err := newParamValue(string(v.Val))
+			if err != nil {
+				return false, err
+			}
+			numParams = max(numParams, p.index+1)
+		}
+		return true, nil
+	}, statementNodes(parsed)...)
+	if err != nil {
+		return err
+	}
+	qType, plan, err := planStatement(s.catalog, parsed)
+	if err != nil {
+		return err
+	}
+
+	var params []*paramValue
+	if plan != nil {
+		params = planParams(plan, nil)
+	}
+	found := make([]bool, numParams)
+	for _, p := range params {
+		found[p.index] = true
+	}
+	for i, ok := range found {
+		if !ok {
+			return GoDBError{IllegalOperationError, fmt.Sprintf("parameter $%d is not supported where it is used", i+1)}
+		}
+	}
+	s.version = s.catalog.version
+	s.qType = qType
+	s.plan = plan
+	s.params = params
+	s.numParams = numParams
+	return nil
+}
+
+func (s *Stmt) QueryType() QueryType {
+	return s.qType
+}
+
+// Return the number of parameters the statement takes.
+func (s *Stmt) NumParams() int {
+	return s.numParams
+}
+
+// Return the type of each parameter, as inferred from how it is used (e.g.,
+// compared against an int field, or inserted into a string column), or
+// UnknownType if it could not be inferred.
+func (s *Stmt) ParamTypes() []DBType {
+	types := make([]DBType, s.numParams)
+	for i := range types {
+		types[i] = UnknownType
+	}
+	for _, p := range s.params {
+		if types[p.index] == UnknownType {
+			types[p.index] = p.typ
+		}
+	}
+	return types
+}
+
+// Return the descriptor of the rows the statement produces, or nil if it
+// does not produce rows.
+func (s *Stmt) Descriptor() *TupleDesc {
+	if s.plan == nil {
+		return nil
+	}
+	return s.plan.Descriptor()
+}
+
+// Set the values of the statement's parameters, returning the plan to run
+// with them.  If tables have been created or dropped since the statement was
+// planned, it is planned again first.  Values are converted to the inferred
+// types of their parameters where possible, e.g., a string "42" bound to a
+// parameter compared against an int field becomes the int 42.
+func (s *Stmt) Bind(args ...DBValue) (Operator, error) {
+	if len(args) != s.numParams {
+		return nil, GoDBError{IllegalOperationError, fmt.Sprintf("statement takes %d parameters, got %d", s.numParams, len(args))}
+	}
+	if s.version != s.catalog.version {
+		if err := s.replan(); err != nil {
+			return nil, err
+		}
+	}
+	for _, p := range s.params {
+		v, err := coerceParam(p, args[p.index])
+		if err != nil {
+			return nil, err
+		}
+		p.value = v
+	}
+	return s.plan, nil
+}
+
+// Convert a value to the type of parameter p.  Strings bound to parameters of
+// unknown type are treated like literals in SQL text: if they look like an
+// integer they are one.
+func coerceParam(p *paramValue, v DBValue) (DBValue, error) {
+	t := p.typ
+	switch v := v.(type) {
+	case IntField:
+		if t == StringType {
+			return StringField{strconv.FormatInt(v.Value, 10)}, nil
+		}
+		return v, nil
+	case StringField:
+		i, err := strconv.ParseInt(v.Value, 10, 64)
+		switch {
+		case t == IntType && err != nil:
+			return nil, GoDBError{TypeMismatchError, fmt.Sprintf("invalid integer %q for parameter %s", v.Value, p)}
+		case t != StringType && err == nil:
+			return IntField{i}, nil
+		}
+		return v, nil
+	case nil:
+		return nil, GoDBError{IllegalOperationError, fmt.Sprintf("GoDB does not support NULL parameters (%s)", p)}
+	}
+	return nil, GoDBError{TypeMismatchError, fmt.Sprintf("unsupported type %T for parameter %s", v, p)}
+}
+
+// Append the parameter placeholders in the expressions of a physical plan to
+// params.
+func planParams(o Operator, params []*paramValue) []*paramValue {
+	switch op := o.(type) {
+	case *OperatorCard:
+		return planParams(op.Op, params)
+	case *Filter:
+		params = exprParams(params, op.left, op.right)
+		return planParams(op.child, params)
+	case *Project:
+		params = exprParams(params, op.selectFields...)
+		return planParams(op.child, params)
+	case *OrderBy:
+		params = exprParams(params, op.orderBy...)
+		return planParams(op.child, params)
+	case *LimitOp:
+		params = exprParams(params, op.limitTups)
+		return planParams(op.child, params)
+	case *Aggregator:
+		params = exprParams(params, op.groupByFields...)
+		for _, st := range op.newAggState {
+			switch st := st.(type) {
+			case *CountAggState:
+				params = exprParams(params, st.expr)
+			case *SumAggState:
+				params = exprParams(params, st.expr)
+			case *AvgAggState:
+				params = exprParams(params, st.expr)
+			case *MaxAggState:
+				params = exprParams(params, st.expr)
+			case *MinAggState:
+				params = exprParams(params, st.expr)
+			}
+		}
+		return planParams(op.child, params)
+	case *Window:
+		for _, f := range op.funcs {
+			params = exprParams(params, f.exprs()...)
+		}
+		return planParams(op.child, params)
+	case *SetOp:
+		params = planParams(op.left, params)
+		return planParams(op.right, params)
+	case *RecursiveCTE:
+		params = planParams(op.anchor, params)
+		return planParams(op.step, params)
+	case *EqualityJoin:
+		params = exprParams(params, op.leftField, op.rightField)
+		params = planParams(*op.left, params)
+		return planParams(*op.right, params)
+	case *InsertOp:
+		return planParams(op.child, params)
+	case *DeleteOp:
+		return planParams(op.child, params)
+	case *ValueOp:
+		for _, row := range op.exprs {
+			params = exprParams(params, row...)
+		}
+	}
+	return params
+}
+
+func exprParams(params []*paramValue, exprs ...Expr) []*paramValue {
+	for _, e := range exprs {
+		switch e := e.(type) {
+		case *ConstExpr:
+			if p, ok := e.val.(*paramValue); ok {
+				params = append(params, p)
+			}
+		case *FuncExpr:
+			for _, arg := range e.args {
+				params = exprParams(params, *arg)
+			}
+		}
+	}
+	return params
+}
+
+// Rewrite $n placeholders, which sqlparser does not accept, as :vn, which is
+// how it names ? placeholders.
+func rewriteParams(query string) string {
+	return substituteParams(query, func(n int) string {
+		return ":v" + strconv.Itoa(n)
+	})
+}
+
+// Call f on the number of each $n placeholder in query that is outside of a
+// quoted string, replacing the placeholder with the result.
+func substituteParams(query string, f func(int) string) string {
+	var out strings.Builder
+	var quote byte
+	for i := 0; i < len(query); i++ {
+		ch := query[i]
+		if quote != 0 {
+			if ch == '\\' && quote == '\'' && i+1 < len(query) {
+				out.WriteByte(ch)
+				i++
+				out.WriteByte(query[i])
+				continue
+			}
+			if ch == quote {
+				quote = 0
+			}
+			out.WriteByte(ch)
+			continue
+		}
+		if ch == '\'' || ch == '"' || ch == '`' {
+			quote = ch
+			out.WriteByte(ch)
+			continue
+		}
+		if ch == '$' && i+1 < len(query) && query[i+1] >= '0' && query[i+1] <= '9' {
+			j := i + 1
+			for j < len(query) && query[j] >= '0' && query[j] <= '9' {
+				j++
+			}
+			n, _ := strconv.Atoi(query[i+1 : j])
+			out.WriteString(f(n))
+			i = j - 1
+			continue
+		}
+		out.WriteByte(ch)
+	}
+	return out.String()
+}
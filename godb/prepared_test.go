@@ -0,0 +1,158 @@
+package godb
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+func makePreparedTestSession(t *testing.T) *Session {
+	t.Helper()
+	bp, c, err := MakeParserTestDatabase(1000)
+	if err != nil {
+		t.Fatalf("failed to create test database, %s", err.Error())
+	}
+	s := NewSession(c, bp)
+	if _, err := s.Execute("insert into t2 values ('kathy', 45), ('bill', 30), ('mike', 45)"); err != nil {
+		t.Fatalf("failed to insert test data, %s", err.Error())
+	}
+	return s
+}
+
+func TestPreparedSelect(t *testing.T) {
+	s := makePreparedTestSession(t)
+	stmt, err := s.Prepare("select name from t2 where age = ? and name <> $2")
+	if err != nil {
+		t.Fatalf("prepare failed, %s", err.Error())
+	}
+	if stmt.NumParams() != 2 {
+		t.Fatalf("expected 2 parameters, got %d", stmt.NumParams())
+	}
+	if types := stmt.ParamTypes(); types[0] != IntType || types[1] != StringType {
+		t.Errorf("unexpected parameter types %v", types)
+	}
+
+	// t2 holds the rows of testdb.txt as well as those inserted above, so
+	// there are two kathys aged 45
+	res, err := s.ExecuteStmt(stmt, IntField{45}, StringField{"mike"})
+	if err != nil {
+		t.Fatalf("execute failed, %s", err.Error())
+	}
+	if got := rowStrings(res.Tuples); strings.Join(got, ";") != "kathy;kathy" {
+		t.Errorf("unexpected result %v", got)
+	}
+
+	// the same plan with different values; the string is converted to an
+	// int since age is an int
+	res, err = s.ExecuteStmt(stmt, StringField{"30"}, StringField{"mike"})
+	if err != nil {
+		t.Fatalf("execute failed, %s", err.Error())
+	}
+	if got := rowStrings(res.Tuples); strings.Join(got, ";") != "bill;bill" {
+		t.Errorf("unexpected result %v", got)
+	}
+
+	if _, err := s.ExecuteStmt(stmt, IntField{45}); err == nil {
+		t.Errorf("expected an error for a missing parameter")
+	}
+	if _, err := s.ExecuteStmt(stmt, StringField{"old"}, StringField{"mike"}); err == nil {
+		t.Errorf("expected an error for a non-integer age")
+	}
+}
+
+func TestPreparedInsertNoInjection(t *testing.T) {
+	s := makePreparedTestSession(t)
+	ins, err := s.Prepare("insert into t2 values ($1, $2)")
+	if err != nil {
+		t.Fatalf("prepare failed, %s", err.Error())
+	}
+	evil := "x' or 'a' = 'a"
+	if _, err := s.ExecuteStmt(ins, StringField{evil}, IntField{7}); err != nil {
+		t.Fatalf("insert failed, %s", err.Error())
+	}
+	sel, err := s.Prepare("select name, age from t2 where name = ?")
+	if err != nil {
+		t.Fatalf("prepare failed, %s", err.Error())
+	}
+	res, err := s.ExecuteStmt(sel, StringField{evil})
+	if err != nil {
+		t.Fatalf("execute failed, %s", err.Error())
+	}
+	if len(res.Tuples) != 1 || res.Tuples[0].Fields[0] != (StringField{evil}) || res.Tuples[0].Fields[1] != (IntField{7}) {
+		t.Errorf("unexpected result %v", res.Tuples)
+	}
+}
+
+func TestPreparedSQL(t *testing.T) {
+	s := makePreparedTestSession(t)
+	if _, err := s.Execute("PREPARE byage (int) AS select name from t2 where age = $1"); err != nil {
+		t.Fatalf("prepare failed, %s", err.Error())
+	}
+	res, err := s.Execute("execute byage(45)")
+	if err != nil {
+		t.Fatalf("execute failed, %s", err.Error())
+	}
+	got := rowStrings(res.Tuples)
+	sort.Strings(got)
+	if strings.Join(got, ";") != "kathy;kathy;mike" {
+		t.Errorf("expected the kathy of testdb.txt and the inserted kathy and mike, got %v", got)
+	}
+	if _, err := s.Execute("prepare byage as select name from t2"); err == nil {
+		t.Errorf("expected an error preparing a duplicate name")
+	}
+	if _, err := s.Execute("deallocate byage"); err != nil {
+		t.Fatalf("deallocate failed, %s", err.Error())
+	}
+	if _, err := s.Execute("execute byage(45)"); err == nil {
+		t.Errorf("expected an error executing a deallocated statement")
+	}
+	if _, err := s.Execute("prepare mk as create table x (a int)"); err == nil {
+		t.Errorf("expected an error preparing DDL")
+	}
+}
+
+func TestPreparedReplan(t *testing.T) {
+	// use a scratch catalog, since the session saves it on DDL
+	bp, err := NewBufferPool(100)
+	if err != nil {
+		t.Fatalf("failed to create buffer pool, %s", err.Error())
+	}
+	s := NewSession(NewCatalog("catalog.txt", bp, t.TempDir()), bp)
+	if _, err := s.Execute("create table scratch (name text, age int)"); err != nil {
+		t.Fatalf("create failed, %s", err.Error())
+	}
+	stmt, err := s.Prepare("select name from scratch where age = ?")
+	if err != nil {
+		t.Fatalf("prepare failed, %s", err.Error())
+	}
+	if _, err := s.Execute("drop table scratch"); err != nil {
+		t.Fatalf("drop failed, %s", err.Error())
+	}
+	if _, err := s.ExecuteStmt(stmt, IntField{45}); err == nil {
+		t.Errorf("expected an error running a statement on a dropped table")
+	}
+	if _, err := s.Execute("create table scratch (name text, age int)"); err != nil {
+		t.Fatalf("create failed, %s", err.Error())
+	}
+	if _, err := s.Execute("insert into scratch values ('amy', 45)"); err != nil {
+		t.Fatalf("insert failed, %s", err.Error())
+	}
+	res, err := s.ExecuteStmt(stmt, IntField{45})
+	if err != nil {
+		t.Fatalf("execute failed, %s", err.Error())
+	}
+	if len(res.Tuples) != 1 || res.Tuples[0].Fields[0] != (StringField{"amy"}) {
+		t.Errorf("expected the row of the new table, got %v", res.Tuples)
+	}
+}
+
+func TestRewriteParams(t *testing.T) {
+	q := rewriteParams("select '$1' from t where a = $1 and b = $12")
+	if q != "select '$1' from t where a = :v1 and b = :v12" {
+		t.Errorf("unexpected rewrite %s", q)
+	}
+	q = rewriteParams(`select 'a\'$1' from t where a = $1`)
+	if q != `select 'a\'$1' from t where a = :v1` {
+		t.Errorf("unexpected rewrite %s", q)
+	}
+}
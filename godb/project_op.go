@@ -8,8 +8,7 @@ type Project struct {
 	child        Operator
 	// You may want to add additional fields here
 	// TODO: some code goes here
-	distinct          bool
-	encounteredTuples map[any]bool
+	distinct bool
 }
 
 // Construct a projection operator. It saves the list of selected field, child,
@@ -24,11 +23,10 @@ func NewProjectOp(selectFields []Expr, outputNames []string, distinct bool, chil
 		return nil, fmt.Errorf("outputNames and selectFields unequal length")
 	}
 	return &Project{
-		selectFields:      selectFields,
-		outputNames:       outputNames,
-		child:             child,
-		distinct:          distinct,
-		encounteredTuples: make(map[any]bool),
+		selectFields: selectFields,
+		outputNames:  outputNames,
+		child:        child,
+		distinct:     distinct,
 	}, nil
 }
 
@@ -64,6 +62,10 @@ func (p *Project) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
 	if err != nil {
 		return nil, err
 	}
+	// the keys of the tuples returned by a distinct projection, and their
+	// memory
+	encounteredTuples := make(map[any]bool)
+	mem := newMemoryReservation(tid)
 
 	return func() (*Tuple, error) {
 		for {
@@ -73,6 +75,7 @@ func (p *Project) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
 			}
 
 			if tuple == nil {
+				mem.release()
 				return nil, nil
 			}
 
@@ -88,10 +91,13 @@ func (p *Project) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
 
 			outputTuple := Tuple{Desc: *p.Descriptor(), Fields: projectedFields, Rid: tuple.Rid}
 			if p.distinct {
-				if p.encounteredTuples[outputTuple.tupleKey()] {
+				if encounteredTuples[outputTuple.tupleKey()] {
 					continue
 				} else {
-					p.encounteredTuples[outputTuple.tupleKey()] = true
+					if err := mem.grow(tupleKeyMemorySize(&outputTuple)); err != nil {
+						return nil, err
+					}
+					encounteredTuples[outputTuple.tupleKey()] = true
 					return &outputTuple, nil
 				}
 			} else {
@@ -100,3 +106,46 @@ func (p *Project) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
 		}
 	}, nil
 }
+
+// Return an iterator over the projected rows of the child, a batch at a
+// time. Distinct projections are computed a tuple at a time.
+func (p *Project) BatchIterator(tid TransactionID) (func() (*Batch, error), error) {
+	if p.distinct {
+		iter, err := p.Iterator(tid)
+		if err != nil {
+			return nil, err
+		}
+		return tupleBatches(iter), nil
+	}
+	childIter, err := batchIterator(p.child, tid)
+	if err != nil {
+		return nil, err
+	}
+	desc := p.Descriptor()
+
+	return func() (*Batch, error) {
+		b, err := childIter()
+		if b == nil || err != nil {
+			return nil, err
+		}
+		out := &Batch{Desc: desc, Columns: make([]*Vector, len(p.selectFields)), Rids: b.Rids}
+		for i, e := range p.selectFields {
+			if out.Columns[i], err = evalVector(e, b); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	}, nil
+}
+
+func (p *Project) Explain() (string, string) {
+	selectStr := ""
+	for _, ex := range p.selectFields {
+		selectStr += exprToStr(ex) + ","
+	}
+	return "Project", fmt.Sprintf("%s -> %v", selectStr, p.outputNames)
+}
+
+func (p *Project) Children() []Operator {
+	return []Operator{p.child}
+}
@@ -0,0 +1,193 @@
+package godb
+
+import (
+	"fmt"
+)
+
+// The maximum number of times the recursive step of a recursive CTE is run,
+// so that a query whose rows form a cycle, e.g. a UNION ALL over a graph with
+// a loop, fails instead of running forever.
+var MaxRecursiveIterations = 1000
+
+// A RecursiveCTE computes a recursive CTE as a fixpoint: the rows of its
+// anchor are returned and become the contents of its work table, then the
+// recursive step, which reads the work table, is run again and again with the
+// rows returned by its previous run until it returns no new rows.
+//
+// Unless all is set (UNION rather than UNION ALL), rows that have already
+// been returned are discarded, using their [Tuple.tupleKey], so recursion over
+// data with cycles stops once no new rows are found.
+type RecursiveCTE struct {
+	anchor, step Operator
+	all          bool
+	work         *workTable
+	desc         *TupleDesc
+}
+
+// Construct a recursive CTE.  The work table's descriptor must describe the
+// rows of anchor, which must be compatible with those of step.
+func NewRecursiveCTE(anchor, step Operator, all bool, work *workTable) (*RecursiveCTE, error) {
+	if _, err := setOpDesc("UNION", anchor.Descriptor(), step.Descriptor()); err != nil {
+		return nil, err
+	}
+	return &RecursiveCTE{anchor, step, all, work, work.desc.copy()}, nil
+}
+
+func (r *RecursiveCTE) Descriptor() *TupleDesc {
+	return r.desc
+}
+
+func (r *RecursiveCTE) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	iter, err := planIterator(r.anchor, tid)
+	if err != nil {
+		return nil, err
+	}
+	// the keys of the rows returned, to discard duplicates
+	returned := make(map[any]bool)
+	// the rows returned by the current iteration, which the next reads
+	var next []*Tuple
+	iterations := 0
+	// the memory of returned, next and the work table, and the part of it
+	// that next and the work table use
+	mem := newMemoryReservation(tid)
+	var nextSize, workSize int64
+
+	return func() (*Tuple, error) {
+		for {
+			t, err := iter()
+			if err != nil {
+				return nil, err
+			}
+			if t == nil {
+				if len(next) == 0 {
+					mem.release()
+					return nil, nil
+				}
+				if err := checkCancelled(tid); err != nil {
+					return nil, err
+				}
+				if iterations++; iterations > MaxRecursiveIterations {
+					return nil, GoDBError{IllegalOperationError, fmt.Sprintf("recursive query %s did not finish after %d iterations; its rows may form a cycle, which UNION instead of UNION ALL would stop at", r.work.name, MaxRecursiveIterations)}
+				}
+				r.work.rows, next = next, nil
+				mem.shrink(workSize)
+				workSize, nextSize = nextSize, 0
+				if iter, err = planIterator(r.step, tid); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			if !r.all {
+				key := t.tupleKey()
+				if returned[key] {
+					continue
+				}
+				if err := mem.grow(tupleKeyMemorySize(t)); err != nil {
+					return nil, err
+				}
+				returned[key] = true
+			}
+			t = &Tuple{*r.desc, t.Fields, nil}
+			if err := mem.grow(t.memorySize()); err != nil {
+				return nil, err
+			}
+			nextSize += t.memorySize()
+			next = append(next, t)
+			return t, nil
+		}
+	}, nil
+}
+
+// The table that the recursive step of a recursive CTE reads the rows of the
+// previous iteration from.  It is scanned like the system tables.
+type workTable struct {
+	name string
+	desc *TupleDesc
+	rows []*Tuple
+}
+
+func newWorkTable(name string, columns []string) *workTable {
+	desc := &TupleDesc{}
+	for _, col := range columns {
+		desc.Fields = append(desc.Fields, FieldType{col, name, UnknownType})
+	}
+	return &workTable{name: name, desc: desc}
+}
+
+// Return a logical plan that selects all of the columns of the table.
+func (w *workTable) plan() *LogicalPlan {
+	selects := make([]*LogicalSelectNode, len(w.desc.Fields))
+	for i, f := range w.desc.Fields {
+		s := NewFieldSelectNode(w.name, f.Fname, "")
+		selects[i] = &s
+	}
+	var file DBFile = w
+	return &LogicalPlan{selects: selects, tables: []*LogicalTableNode{{w.name, "", &file}}}
+}
+
+func (w *workTable) NumPages() int {
+	return 0
+}
+
+func (w *workTable) insertTuple(t *Tuple, tid TransactionID) error {
+	return GoDBError{IllegalOperationError, fmt.Sprintf("recursive query %s is read-only", w.name)}
+}
+
+func (w *workTable) deleteTuple(t *Tuple, tid TransactionID) error {
+	return GoDBError{IllegalOperationError, fmt.Sprintf("recursive query %s is read-only", w.name)}
+}
+
+func (w *workTable) readPage(pageNo int) (Page, error) {
+	return nil, GoDBError{IllegalOperationError, fmt.Sprintf("recursive query %s has no pages", w.name)}
+}
+
+func (w *workTable) flushPage(page Page) error {
+	return nil
+}
+
+type workPageKey struct {
+	name string
+	pgNo int
+}
+
+func (w *workTable) pageKey(pgNo int) any {
+	return workPageKey{w.name, pgNo}
+}
+
+// Return a copy of the descriptor, since the planner sets its table alias.
+func (w *workTable) Descriptor() *TupleDesc {
+	return w.desc.copy()
+}
+
+func (w *workTable) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	rows := w.rows
+	desc := w.desc.copy()
+	i := 0
+	return func() (*Tuple, error) {
+		if i >= len(rows) {
+			return nil, nil
+		}
+		t := &Tuple{Desc: *desc, Fields: rows[i].Fields, Rid: i}
+		i++
+		return t, nil
+	}, nil
+}
+
+func (r *RecursiveCTE) Explain() (string, string) {
+	if r.all {
+		return "Recursive CTE", r.work.name + " UNION ALL"
+	}
+	return "Recursive CTE", r.work.name + " UNION"
+}
+
+func (r *RecursiveCTE) Children() []Operator {
+	return []Operator{r.anchor, r.step}
+}
+
+func (w *workTable) Explain() (string, string) {
+	return "Work Table Scan", w.name
+}
+
+func (w *workTable) Children() []Operator {
+	return nil
+}
@@ -0,0 +1,523 @@
+package godb
+
+// Rule-based rewrites of logical plans, which makePhysicalPlan applies to
+// each plan before planning it.  The rules, in the order they are applied:
+//
+//   - filters that compare a constant to a column are turned around, so the
+//     column is on the left, and filters that compare two constants are
+//     evaluated: if true they are removed, and if false the plan is marked
+//     empty, so its tables are not scanned;
+//   - a filter on a column of an equality join is copied to the column it is
+//     joined with, e.g. "a.x = b.y and a.x < 5" implies "b.y < 5";
+//   - filters on the columns of a subquery are pushed into it, through its
+//     select list, so they are applied to its tables rather than to its
+//     results, unless the columns call volatile functions such as rand();
+//   - columns of a subquery that its parent does not use are removed from its
+//     select list.
+//
+// Heap files scanned by joins are also scanned for only the columns a plan
+// uses, once it is planned; see pruneScanColumns.
+//
+// In addition, when an expression is generated from a plan, function calls
+// whose arguments are all constants are evaluated once; see foldConstants.
+
+import (
+	"fmt"
+)
+
+// Whether makePhysicalPlan rewrites logical plans and folds constants.
+var EnableQueryRewrites = true
+
+// Rewrite plan in place before it is planned.  Plans of set operations and
+// recursive CTEs are not rewritten; the plans of their queries are when they
+// are planned.
+func rewriteLogicalPlan(c *Catalog, plan *LogicalPlan) error {
+	if !EnableQueryRewrites || plan.setOp != nil || plan.recursiveCTE != nil {
+		return nil
+	}
+	if err := simplifyFilters(plan); err != nil {
+		return err
+	}
+	inferJoinFilters(c, plan)
+	pushFiltersIntoSubqueries(c, plan)
+	pruneSubqueryColumns(c, plan)
+	return nil
+}
+
+// Return the field nodes of an expression, including *.
+func columnRefs(s *LogicalSelectNode) []*LogicalSelectNode {
+	switch s.exprType {
+	case ExprField, ExprStar:
+		return []*LogicalSelectNode{s}
+	case ExprConst:
+		return nil
+	}
+	var refs []*LogicalSelectNode
+	for _, arg := range s.args {
+		refs = append(refs, columnRefs(arg)...)
+	}
+	if s.window != nil {
+		for _, p := range s.window.partitionBy {
+			refs = append(refs, columnRefs(p)...)
+		}
+		for _, o := range s.window.orderBy {
+			refs = append(refs, columnRefs(o.expr)...)
+		}
+	}
+	return refs
+}
+
+// Return true if an expression uses no columns or parameters, so its value
+// is known when the query is planned.
+func isConstantNode(s *LogicalSelectNode) bool {
+	switch s.exprType {
+	case ExprConst:
+		return s.param == nil
+	case ExprFunc:
+		if fType, ok := lookupFunc(*s.funcOp); !ok || fType.volatile {
+			return false
+		}
+		for _, arg := range s.args {
+			if !isConstantNode(arg) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// Return true if an expression calls a function whose value may differ each
+// time it is called, such as rand(), or one that is not known.
+func isVolatileNode(s *LogicalSelectNode) bool {
+	if s.exprType == ExprFunc {
+		if fType, ok := lookupFunc(*s.funcOp); !ok || fType.volatile {
+			return true
+		}
+	}
+	for _, arg := range s.args {
+		if isVolatileNode(arg) {
+			return true
+		}
+	}
+	return false
+}
+
+// The operator that gives the same result with its operands swapped, or false
+// if there is none.
+var swappedOps = map[BoolOp]BoolOp{OpEq: OpEq, OpNeq: OpNeq, OpLt: OpGt, OpGt: OpLt, OpLe: OpGe, OpGe: OpLe}
+
+// Turn around filters with a constant on the left, and evaluate filters of
+// two constants.
+func simplifyFilters(plan *LogicalPlan) error {
+	var filters []*LogicalFilterNode
+	for _, f := range plan.filters {
+		leftRefs, rightRefs := columnRefs(&f.fieldExpr), columnRefs(&f.constExpr)
+		if len(leftRefs) == 0 && len(rightRefs) > 0 {
+			if op, ok := swappedOps[f.predOp]; ok {
+				f.fieldExpr, f.constExpr, f.predOp = f.constExpr, f.fieldExpr, op
+			}
+		}
+		if !isConstantNode(&f.fieldExpr) || !isConstantNode(&f.constExpr) {
+			filters = append(filters, f)
+			continue
+		}
+		ok, err := evalConstantFilter(f)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			plan.empty = true
+		}
+	}
+	plan.filters = filters
+	return nil
+}
+
+// Evaluate a filter that compares two constants.
+func evalConstantFilter(f *LogicalFilterNode) (bool, error) {
+	var vals [2]DBValue
+	for i, s := range []*LogicalSelectNode{&f.fieldExpr, &f.constExpr} {
+		expr, _, err := s.generateExpr(nil, nil, nil)
+		if err != nil {
+			return false, err
+		}
+		if vals[i], err = expr.EvalExpr(&Tuple{}); err != nil {
+			return false, err
+		}
+		if vals[i] == nil {
+			// comparisons with NULL are never true
+			return false, nil
+		}
+	}
+	filter, err := NewFilter(nil, f.predOp, nil, nil)
+	if err != nil {
+		return false, err
+	}
+	filter.setEscape(f.escape)
+	return filter.eval(vals[0], vals[1])
+}
+
+// A column of a table or subquery of a plan.
+type planColumn struct {
+	table, field string
+}
+
+// Return the column that a filter's expression is, or false if it is not a
+// single column.
+func filterColumn(c *Catalog, plan *LogicalPlan, s *LogicalSelectNode) (planColumn, bool) {
+	if s.exprType != ExprField || s.funcOp != nil {
+		return planColumn{}, false
+	}
+	table, field, err := s.getTableField(c, plan.subqueries, plan.tables)
+	if err != nil || table == "" {
+		return planColumn{}, false
+	}
+	return planColumn{table, field}, true
+}
+
+// Copy each filter of a column to the columns it is joined with, until there
+// are no more filters to add.
+func inferJoinFilters(c *Catalog, plan *LogicalPlan) {
+	// the filters of each column, by their operator and constant
+	type filterKey struct {
+		col   planColumn
+		op    BoolOp
+		value string
+	}
+	have := make(map[filterKey]bool)
+	key := func(col planColumn, f *LogicalFilterNode) filterKey {
+		return filterKey{col, f.predOp, fmt.Sprintf("%v %p", f.constExpr.String(), f.constExpr.param)}
+	}
+	var queue []*LogicalFilterNode
+	for _, f := range plan.filters {
+		if col, ok := filterColumn(c, plan, &f.fieldExpr); ok && len(columnRefs(&f.constExpr)) == 0 {
+			have[key(col, f)] = true
+			queue = append(queue, f)
+		}
+	}
+	for len(queue) > 0 {
+		f := queue[0]
+		queue = queue[1:]
+		col, _ := filterColumn(c, plan, &f.fieldExpr)
+		for _, j := range plan.joins {
+			left, leftOk := filterColumn(c, plan, j.left)
+			right, rightOk := filterColumn(c, plan, j.right)
+			if !leftOk || !rightOk || j.predOp != OpEq {
+				continue
+			}
+			other, otherCol := j.right, right
+			switch col {
+			case left:
+			case right:
+				other, otherCol = j.left, left
+			default:
+				continue
+			}
+			inferred := &LogicalFilterNode{*other, f.constExpr, f.predOp, f.escape}
+			if k := key(otherCol, inferred); !have[k] {
+				have[k] = true
+				plan.filters = append(plan.filters, inferred)
+				queue = append(queue, inferred)
+			}
+		}
+	}
+}
+
+// Return the subquery of plan named table, or nil if there is none.
+func findSubquery(plan *LogicalPlan, table string) *LogicalPlan {
+	for _, sq := range plan.subqueries {
+		if sq.alias == table {
+			return sq
+		}
+	}
+	return nil
+}
+
+// Move the filters of plan on columns of a subquery into the subquery.
+func pushFiltersIntoSubqueries(c *Catalog, plan *LogicalPlan) {
+	var filters []*LogicalFilterNode
+	for _, f := range plan.filters {
+		if !pushFilterIntoSubquery(c, plan, f) {
+			filters = append(filters, f)
+		}
+	}
+	plan.filters = filters
+}
+
+// A filter to add to a plan.
+type pushedFilter struct {
+	plan      *LogicalPlan
+	fieldExpr *LogicalSelectNode
+}
+
+// Push f into the subquery whose columns it uses, returning false if it uses
+// columns of more than one table or subquery, or cannot be pushed into the
+// subquery.
+func pushFilterIntoSubquery(c *Catalog, plan *LogicalPlan, f *LogicalFilterNode) bool {
+	if len(columnRefs(&f.constExpr)) > 0 {
+		return false
+	}
+	var sq *LogicalPlan
+	for _, ref := range columnRefs(&f.fieldExpr) {
+		table, _, err := ref.getTableField(c, plan.subqueries, plan.tables)
+		if err != nil || ref.exprType != ExprField {
+			return false
+		}
+		s := findSubquery(plan, table)
+		if s == nil || sq != nil && s != sq {
+			return false
+		}
+		sq = s
+	}
+	if sq == nil {
+		return false
+	}
+	pushed, ok := pushdownFilters(c, sq, &f.fieldExpr)
+	if !ok {
+		return false
+	}
+	for _, p := range pushed {
+		p.plan.filters = append(p.plan.filters, &LogicalFilterNode{*p.fieldExpr, f.constExpr, f.predOp, f.escape})
+	}
+	return true
+}
+
+// Return the filters to add to the plans of subquery sq, and of its queries
+// if it is a set operation, to filter its rows on fieldExpr, an expression of
+// its columns; or false if that cannot be done.  A filter can be pushed into
+// a query without aggregates, window functions or a LIMIT, whose columns it
+// uses are columns or named expressions of a single table or subquery.  It
+// is not pushed through expressions that call volatile functions, since the
+// filter would see other values than the subquery returns.
+func pushdownFilters(c *Catalog, sq *LogicalPlan, fieldExpr *LogicalSelectNode) ([]pushedFilter, bool) {
+	if sq.recursiveCTE != nil || sq.limit != nil {
+		return nil, false
+	}
+	if sq.setOp != nil {
+		// the columns of a set operation are those of its queries, by
+		// position
+		names := make([]string, len(sq.selects))
+		for i, f := range sq.setOp.left.getSubplanFields(c) {
+			names[i] = f.Fname
+		}
+		var pushed []pushedFilter
+		for _, side := range []*LogicalPlan{sq.setOp.left, sq.setOp.right} {
+			sideFields := side.getSubplanFields(c)
+			if len(sideFields) != len(names) {
+				return nil, false
+			}
+			expr, ok := substituteColumns(fieldExpr, func(name string) *LogicalSelectNode {
+				for i, n := range names {
+					if n == name {
+						col := NewFieldSelectNode("", sideFields[i].Fname, "")
+						return &col
+					}
+				}
+				return nil
+			})
+			if !ok {
+				return nil, false
+			}
+			p, ok := pushdownFilters(c, side, expr)
+			if !ok {
+				return nil, false
+			}
+			pushed = append(pushed, p...)
+		}
+		return pushed, true
+	}
+	if len(sq.aggs) > 0 || len(extractWindows(sq.selects)) > 0 {
+		return nil, false
+	}
+	expr, ok := substituteColumns(fieldExpr, func(name string) *LogicalSelectNode {
+		col := subqueryColumn(c, sq, name)
+		if col != nil && isVolatileNode(col) {
+			return nil
+		}
+		return col
+	})
+	if !ok {
+		return nil, false
+	}
+	// the filter is applied to a single table of the subquery
+	table := ""
+	for i, ref := range columnRefs(expr) {
+		t, _, err := ref.getTableField(c, sq.subqueries, sq.tables)
+		if err != nil || i > 0 && t != table {
+			return nil, false
+		}
+		table = t
+	}
+	return []pushedFilter{{sq, expr}}, true
+}
+
+// Return a copy of expr in which each column is replaced by column(name), or
+// false if column returns nil for any of them.
+func substituteColumns(expr *LogicalSelectNode, column func(string) *LogicalSelectNode) (*LogicalSelectNode, bool) {
+	switch expr.exprType {
+	case ExprConst:
+		return expr, true
+	case ExprField:
+		if expr.funcOp != nil {
+			return nil, false
+		}
+		col := column(expr.field)
+		return col, col != nil
+	case ExprFunc:
+		s := *expr
+		s.args = make([]*LogicalSelectNode, len(expr.args))
+		for i, arg := range expr.args {
+			var ok bool
+			if s.args[i], ok = substituteColumns(arg, column); !ok {
+				return nil, false
+			}
+		}
+		s.alias = ""
+		return &s, true
+	}
+	return nil, false
+}
+
+// Return the expression of subquery sq's column named name, or nil if it is
+// not a column or named expression of sq's select list, or is ambiguous.
+func subqueryColumn(c *Catalog, sq *LogicalPlan, name string) *LogicalSelectNode {
+	var col *LogicalSelectNode
+	for _, s := range sq.selects {
+		var match *LogicalSelectNode
+		switch {
+		case s.exprType == ExprStar && s.funcOp == nil:
+			// with * in its select list, any column may be one of the
+			// subquery's tables
+			field := NewFieldSelectNode(s.table, name, "")
+			match = &field
+		case s.exprType == ExprField && s.funcOp == nil && (s.alias == name || s.alias == "" && s.field == name):
+			field := *s
+			field.alias = ""
+			match = &field
+		case s.exprType == ExprFunc && s.alias == name:
+			match = s
+		default:
+			continue
+		}
+		if col != nil {
+			return nil
+		}
+		col = match
+	}
+	return col
+}
+
+// Remove the columns of subqueries that plan does not use from their select
+// lists.  Subqueries with DISTINCT or that are set operations are left alone,
+// since their columns determine which rows are duplicates.
+func pruneSubqueryColumns(c *Catalog, plan *LogicalPlan) {
+	if len(plan.subqueries) == 0 {
+		return
+	}
+	used := make(map[planColumn]bool)
+	var nodes []*LogicalSelectNode
+	for _, s := range plan.selects {
+		if s.exprType == ExprStar && s.funcOp == nil {
+			// * uses all of the columns
+			return
+		}
+		nodes = append(nodes, s)
+	}
+	for _, f := range plan.filters {
+		nodes = append(nodes, &f.fieldExpr, &f.constExpr)
+	}
+	for _, j := range plan.joins {
+		nodes = append(nodes, j.left, j.right)
+	}
+	for _, g := range plan.groupByFields {
+		nodes = append(nodes, g.expr)
+	}
+	for _, o := range plan.orderByFields {
+		nodes = append(nodes, o.expr)
+	}
+	for _, n := range nodes {
+		for _, ref := range columnRefs(n) {
+			if ref.exprType == ExprStar {
+				// e.g. count(*), which uses no column
+				continue
+			}
+			table, field, err := ref.getTableField(c, plan.subqueries, plan.tables)
+			if err != nil {
+				return
+			}
+			used[planColumn{table, field}] = true
+		}
+	}
+
+	for _, sq := range plan.subqueries {
+		if sq.distinct || sq.setOp != nil || sq.recursiveCTE != nil {
+			continue
+		}
+		// columns that the subquery's ORDER BY names
+		ordered := make(map[string]bool)
+		for _, o := range sq.orderByFields {
+			for _, ref := range columnRefs(o.expr) {
+				ordered[ref.field] = true
+			}
+		}
+		fields := sq.getSubplanFields(c)
+		isUsed := func(name string) bool {
+			return used[planColumn{sq.alias, name}] || used[planColumn{"", name}] || ordered[name]
+		}
+		var selects []*LogicalSelectNode
+		for i, s := range sq.selects {
+			if isUsed(fields[i].Fname) || s.alias != "" && isUsed(s.alias) ||
+				s.exprType == ExprStar || len(extractAggs(s)) > 0 || s.exprType == ExprWindow {
+				selects = append(selects, s)
+			}
+		}
+		if len(selects) == 0 {
+			selects = sq.selects[:1]
+		}
+		sq.selects = selects
+	}
+}
+
+// Return the value of a function call whose arguments are all constants as a
+// constant, or f if it cannot be evaluated when the query is planned.
+func foldConstants(f *FuncExpr) Expr {
+	fType, ok := lookupFunc(f.op)
+	if !EnableQueryRewrites || !ok || fType.volatile || len(f.args) == 0 {
+		return f
+	}
+	for _, arg := range f.args {
+		if _, ok := (*arg).(*ConstExpr); !ok || isParamExpr(*arg) {
+			return f
+		}
+	}
+	v, err := f.EvalExpr(&Tuple{})
+	if err != nil {
+		// report the error when the query is run
+		return f
+	}
+	return &ConstExpr{v, f.GetExprType().Ftype}
+}
+
+// An operator that returns no rows, for plans whose filters are never true.
+type emptyOp struct {
+	desc *TupleDesc
+}
+
+func (e *emptyOp) Descriptor() *TupleDesc {
+	return e.desc
+}
+
+func (e *emptyOp) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	return func() (*Tuple, error) {
+		return nil, nil
+	}, nil
+}
+
+func (e *emptyOp) Explain() (string, string) {
+	return "Empty Scan", ""
+}
+
+func (e *emptyOp) Children() []Operator {
+	return nil
+}
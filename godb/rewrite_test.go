@@ -0,0 +1,161 @@
+package godb
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// Return the physical plan of q as a string.
+func planString(t *testing.T, s *Session, q string) string {
+	t.Helper()
+	_, plan, err := Parse(s.catalog, q)
+	if err != nil {
+		t.Fatalf("planning %s failed, %s", q, err.Error())
+	}
+	var out strings.Builder
+	OutputPhysicalPlan(func(format string, a ...any) {
+		fmt.Fprintf(&out, strings.TrimSuffix(format, "\n")+"\n", a...)
+	}, plan, "")
+	return out.String()
+}
+
+func TestQueryRewrites(t *testing.T) {
+	s := makeBatchTestSession(t, 30)
+	// emp has names e0 to e29, with dept i%10 and salary 7i
+	for _, c := range []struct {
+		query string
+		want  []string
+	}{
+		{"select name from emp where 3 > dept and 1 + 1 = 2 and salary >= 140",
+			[]string{"e20", "e21", "e22"}},
+		{"select name from emp where 1 = 0", nil},
+		{"select count(*) from emp where 'a' = 'b'", []string{"0"}},
+		{"select name from emp where 'e' || '1' = name", []string{"e1"}},
+		{"select x.n from (select name as n, dept, salary from emp) x where x.dept = 3 and x.salary > 80",
+			[]string{"e13", "e23"}},
+		{"select n from (select name as n, salary * 2 as twice from emp) x where twice < 20",
+			[]string{"e0", "e1"}},
+		{"select d, total from (select dept as d, sum(salary) as total from emp group by dept) x where d = 2",
+			[]string{"2,252"}},
+		{"select x.dept from (select dept from emp union select id from dept) x where x.dept > 7",
+			[]string{"8", "9"}},
+		{"select x.dept from (select dept from emp where salary < 50 except select id from dept where id < 3) x where x.dept < 6",
+			[]string{"3", "4", "5"}},
+		{"select emp.name, dept.dname from emp join dept on emp.dept = dept.id where dept.id = 4 and emp.salary < 50",
+			[]string{"e4,d4"}},
+		{"select x.name, dept.dname from (select name, dept from emp) x join dept on x.dept = dept.id where dept.id = 5",
+			[]string{"e15,d5", "e25,d5", "e5,d5"}},
+		{"select upper('abc') || name from emp where name = 'e3'", []string{"ABCe3"}},
+		// the joined scans only return the columns used, including those
+		// of filters and joins that are not selected
+		{"select emp.name from emp join dept on emp.dept = dept.id where dept.dname = 'd4'",
+			[]string{"e14", "e24", "e4"}},
+		{"select a.name, b.name from emp a join emp b on a.salary = b.salary where a.dept = 2",
+			[]string{"e12,e12", "e2,e2", "e22,e22"}},
+	} {
+		for _, rewrite := range []bool{false, true} {
+			EnableQueryRewrites = rewrite
+			res, err := s.Execute(c.query)
+			EnableQueryRewrites = true
+			if err != nil {
+				if !rewrite {
+					// the query may only be supported with rewrites
+					continue
+				}
+				t.Fatalf("%s failed, %s", c.query, err.Error())
+			}
+			got := rowStrings(res.Tuples)
+			sort.Strings(got)
+			sort.Strings(c.want)
+			if strings.Join(got, ";") != strings.Join(c.want, ";") {
+				t.Errorf("%s with rewrites %v: expected %v, got %v", c.query, rewrite, c.want, got)
+			}
+		}
+	}
+}
+
+func TestQueryRewritePlans(t *testing.T) {
+	s := makeBatchTestSession(t, 30)
+	if err := s.catalog.ComputeTableStats(); err != nil {
+		t.Fatalf("ComputeTableStats failed, %s", err.Error())
+	}
+	for _, c := range []struct {
+		query string
+		want  []string // lines of the plan, without indentation
+		not   []string // strings the plan must not contain
+	}{
+		// the filter is applied below the subquery's projection, and the
+		// subquery only projects the columns that are used
+		{"select x.n from (select name as n, dept, salary from emp) x where x.dept = 3",
+			[]string{"Project emp.name, -> [n], card:15", "Filter emp.dept = {3}, card:15"},
+			[]string{"x.dept"}},
+		{"select name from emp where 1 = 2",
+			[]string{"Empty Scan, card:0"},
+			[]string{"Heap Scan"}},
+		{"select name from emp where dept < 2 + 3",
+			[]string{"Filter emp.dept < {5}, card:15"},
+			nil},
+		// the filter on dept.id is copied to emp.dept
+		{"select emp.name from emp join dept on emp.dept = dept.id where dept.id = 4",
+			[]string{"Filter emp.dept = {4}, card:15", "Filter dept.id = {4}, card:5"},
+			nil},
+		// filters are not pushed into aggregates
+		{"select d from (select dept as d, count(*) as n from emp group by dept) x where x.n > 2",
+			[]string{"Filter x.n > {2}, card:0"},
+			nil},
+		// rand() is evaluated for each row
+		{"select name from emp where salary < rand() + 1",
+			[]string{"Filter emp.salary < +(rand(),{1},), card:30"},
+			nil},
+		// a filter on a column computed with rand() is not pushed into the
+		// subquery, where it would see other values
+		{"select x.n from (select name as n, rand() as r from emp) x where x.r < 5",
+			[]string{"Filter x.r < {5}, card:30"},
+			[]string{"Filter rand()"}},
+	} {
+		plan := planString(t, s, c.query)
+		lines := strings.Split(plan, "\n")
+		for i := range lines {
+			lines[i] = strings.TrimSpace(lines[i])
+		}
+		for _, w := range c.want {
+			found := false
+			for _, l := range lines {
+				found = found || l == w
+			}
+			if !found {
+				t.Errorf("%s: expected the plan to contain %q, got\n%s", c.query, w, plan)
+			}
+		}
+		for _, n := range c.not {
+			if strings.Contains(plan, n) {
+				t.Errorf("%s: expected the plan not to contain %q, got\n%s", c.query, n, plan)
+			}
+		}
+	}
+
+	// the scans of a join only return the columns the plan uses
+	plan := planString(t, s, "select emp.name from emp join dept on emp.dept = dept.id where dept.id = 4")
+	if !strings.Contains(plan, "emp.dat (name,dept)") || !strings.Contains(plan, "dept.dat (id)") {
+		t.Errorf("expected the joined scans to return only the columns used, got\n%s", plan)
+	}
+
+	stmt, err := s.Prepare("select x.n from (select name as n, dept from emp) x where x.dept = $1 and 1 = 1")
+	if err != nil {
+		t.Fatalf("prepare failed, %s", err.Error())
+	}
+	for _, dept := range []int64{3, 7} {
+		res, err := s.ExecuteStmt(stmt, IntField{dept})
+		if err != nil {
+			t.Fatalf("execute failed, %s", err.Error())
+		}
+		got := rowStrings(res.Tuples)
+		sort.Strings(got)
+		want := fmt.Sprintf("e%d;e%d;e%d", dept+10, dept+20, dept)
+		if strings.Join(got, ";") != want {
+			t.Errorf("with dept %d, expected %s, got %v", dept, want, got)
+		}
+	}
+}
@@ -0,0 +1,640 @@
+package godb
+
+// The built-in scalar functions listed in [funcs], other than the arithmetic
+// operators and the original lab functions in exprs.go.
+//
+// Functions are called with int64 and string arguments (and nil for NULL,
+// if the function accepts NULLs) and return an int64, a string, nil for NULL
+// or an error.  Dates and times are strings in the formats of [dateLayout]
+// and [timestampLayout].
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	dateLayout      = "2006-01-02"
+	timestampLayout = "2006-01-02 15:04:05"
+)
+
+var errDivisionByZero = GoDBError{IllegalOperationError, "division by zero"}
+
+// Register the comparison operators, which can be used as functions in
+// select lists and CASE expressions, returning 1 if the comparison holds and 0
+// if it does not.
+func init() {
+	for op := OpGt; op <= OpNotSimilar; op++ {
+		name := predicateName(op)
+		argType, argName := UnknownType, "any"
+		if isMatchOp(op) {
+			argType, argName = StringType, "string"
+		}
+		funcs[name] = FuncType{argTypes: []DBType{argType, argType}, outType: IntType, f: comparisonFunc(op),
+			syntax: argName + " " + name + " " + argName, doc: "1 if the comparison holds, else 0"}
+	}
+}
+
+// Return the name of the function that evaluates op.
+func predicateName(op BoolOp) string {
+	return strings.ToLower(strings.TrimSpace(op.String()))
+}
+
+// Convert an argument of a function back into a value.
+func argValue(arg any) DBValue {
+	switch arg := arg.(type) {
+	case int64:
+		return IntField{arg}
+	case string:
+		return StringField{arg}
+	}
+	return nil
+}
+
+// Convert two arguments of either type to values of the same type.
+func commonValues(a, b any) (DBValue, DBValue) {
+	_, aInt := a.(int64)
+	_, bInt := b.(int64)
+	if aInt != bInt {
+		return StringField{fmt.Sprint(a)}, StringField{fmt.Sprint(b)}
+	}
+	return argValue(a), argValue(b)
+}
+
+func comparisonFunc(op BoolOp) func([]any) any {
+	return func(args []any) any {
+		if isMatchOp(op) {
+			match, err := matchPattern(args[0].(string), args[1].(string), op, defaultLikeEscape)
+			if err != nil {
+				return err
+			}
+			return boolInt(match)
+		}
+		a, b := commonValues(args[0], args[1])
+		return boolInt(a.EvalPred(b, op))
+	}
+}
+
+func boolInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// Return whether a condition holds; NULL does not.
+func isTrue(v any) bool {
+	i, ok := v.(int64)
+	return ok && i != 0
+}
+
+// AND and OR with SQL's three-valued logic: NULL AND false is false, and
+// NULL OR true is true.
+func andFunc(args []any) any {
+	var result any = int64(1)
+	for _, a := range args {
+		switch {
+		case a == nil:
+			result = nil
+		case !isTrue(a):
+			return int64(0)
+		}
+	}
+	return result
+}
+
+func orFunc(args []any) any {
+	var result any = int64(0)
+	for _, a := range args {
+		switch {
+		case a == nil:
+			result = nil
+		case isTrue(a):
+			return int64(1)
+		}
+	}
+	return result
+}
+
+func notFunc(args []any) any {
+	return boolInt(!isTrue(args[0]))
+}
+
+func isNullFunc(args []any) any {
+	return boolInt(args[0] == nil)
+}
+
+func isNotNullFunc(args []any) any {
+	return boolInt(args[0] != nil)
+}
+
+// Evaluate CASE WHEN c1 THEN v1 [WHEN c2 THEN v2 ...] [ELSE e] END, whose
+// arguments are c1, v1, c2, v2, ..., e.  Only the value that is chosen is
+// evaluated, so that e.g. a division in a branch that is not taken does not
+// fail.
+func caseEval(args []*Expr, t *Tuple) (DBValue, error) {
+	for i := 0; i+1 < len(args); i += 2 {
+		cond, err := (*args[i]).EvalExpr(t)
+		if err != nil {
+			return nil, err
+		}
+		if c, ok := cond.(IntField); ok && c.Value != 0 {
+			return (*args[i+1]).EvalExpr(t)
+		}
+	}
+	if len(args)%2 == 1 {
+		return (*args[len(args)-1]).EvalExpr(t)
+	}
+	return nil, nil
+}
+
+// The type of a CASE expression is the common type of its values.
+func caseType(argTypes []DBType) DBType {
+	var types []DBType
+	for i := 1; i < len(argTypes); i += 2 {
+		types = append(types, argTypes[i])
+	}
+	if len(argTypes)%2 == 1 {
+		types = append(types, argTypes[len(argTypes)-1])
+	}
+	return commonType(types...)
+}
+
+func coalesceFunc(args []any) any {
+	for _, a := range args {
+		if a != nil {
+			return a
+		}
+	}
+	return nil
+}
+
+func nullIfFunc(args []any) any {
+	if args[0] == nil || args[1] == nil {
+		return args[0]
+	}
+	if a, b := commonValues(args[0], args[1]); a.EvalPred(b, OpEq) {
+		return nil
+	}
+	return args[0]
+}
+
+func nullIfType(argTypes []DBType) DBType {
+	return argTypes[0]
+}
+
+// greatest and least ignore NULLs, and compare values as strings if any of
+// them is a string.
+func extremeFunc(op BoolOp) func([]any) any {
+	return func(args []any) any {
+		var best any
+		for _, a := range args {
+			if a == nil {
+				continue
+			}
+			if best == nil {
+				best = a
+				continue
+			}
+			if x, y := commonValues(a, best); x.EvalPred(y, op) {
+				best = a
+			}
+		}
+		return best
+	}
+}
+
+// String functions.  Lengths and positions count characters, not bytes.
+
+func upperFunc(args []any) any {
+	return strings.ToUpper(args[0].(string))
+}
+
+func lowerFunc(args []any) any {
+	return strings.ToLower(args[0].(string))
+}
+
+// Return the characters to trim: the second argument, or a space.
+func trimChars(args []any) string {
+	if len(args) > 1 {
+		return args[1].(string)
+	}
+	return " "
+}
+
+func trimFunc(args []any) any {
+	return strings.Trim(args[0].(string), trimChars(args))
+}
+
+func ltrimFunc(args []any) any {
+	return strings.TrimLeft(args[0].(string), trimChars(args))
+}
+
+func rtrimFunc(args []any) any {
+	return strings.TrimRight(args[0].(string), trimChars(args))
+}
+
+func lengthFunc(args []any) any {
+	return int64(utf8.RuneCountInString(args[0].(string)))
+}
+
+// concat skips NULL arguments, but || is NULL if either argument is.
+func concatFunc(args []any) any {
+	var b strings.Builder
+	for _, a := range args {
+		if a != nil {
+			b.WriteString(a.(string))
+		}
+	}
+	return b.String()
+}
+
+func concatOpFunc(args []any) any {
+	return args[0].(string) + args[1].(string)
+}
+
+func replaceFunc(args []any) any {
+	s, from, to := args[0].(string), args[1].(string), args[2].(string)
+	if from == "" {
+		return s
+	}
+	return strings.ReplaceAll(s, from, to)
+}
+
+func positionFunc(args []any) any {
+	sub, s := args[0].(string), args[1].(string)
+	i := strings.Index(s, sub)
+	if i < 0 {
+		return int64(0)
+	}
+	return int64(utf8.RuneCountInString(s[:i]) + 1)
+}
+
+// Return the characters of s from start up to end, clamped to the string.
+func runeSlice(s string, start, end int64) string {
+	r := []rune(s)
+	start = max(0, min(start, int64(len(r))))
+	end = max(start, min(end, int64(len(r))))
+	return string(r[start:end])
+}
+
+func substrFunc(args []any) any {
+	s, from := args[0].(string), args[1].(int64)
+	end := int64(math.MaxInt64)
+	if len(args) > 2 {
+		count := args[2].(int64)
+		if count < 0 {
+			return GoDBError{IllegalOperationError, "negative substring length not allowed"}
+		}
+		end = from - 1 + count
+	}
+	return runeSlice(s, from-1, end)
+}
+
+// Pad s to n characters with fill on the left or right, or truncate it to n
+// characters if it is longer.
+func pad(args []any, left bool) any {
+	s, n := args[0].(string), args[1].(int64)
+	fill := " "
+	if len(args) > 2 {
+		fill = args[2].(string)
+	}
+	length := int64(utf8.RuneCountInString(s))
+	if n <= length {
+		return runeSlice(s, 0, n)
+	}
+	if fill == "" {
+		return s
+	}
+	padding := runeSlice(strings.Repeat(fill, int(n-length)), 0, n-length)
+	if left {
+		return padding + s
+	}
+	return s + padding
+}
+
+func lpadFunc(args []any) any {
+	return pad(args, true)
+}
+
+func rpadFunc(args []any) any {
+	return pad(args, false)
+}
+
+// left and right return the first or last n characters, or all but the last
+// or first -n characters if n is negative.
+func leftFunc(args []any) any {
+	s, n := args[0].(string), args[1].(int64)
+	if n < 0 {
+		n += int64(utf8.RuneCountInString(s))
+	}
+	return runeSlice(s, 0, n)
+}
+
+func rightFunc(args []any) any {
+	s, n := args[0].(string), args[1].(int64)
+	length := int64(utf8.RuneCountInString(s))
+	if n < 0 {
+		return runeSlice(s, -n, length)
+	}
+	return runeSlice(s, length-n, length)
+}
+
+func repeatFunc(args []any) any {
+	return strings.Repeat(args[0].(string), int(max(0, args[1].(int64))))
+}
+
+func reverseFunc(args []any) any {
+	r := []rune(args[0].(string))
+	for i, j := 0, len(r)-1; i < j; i, j = i+1, j-1 {
+		r[i], r[j] = r[j], r[i]
+	}
+	return string(r)
+}
+
+// Math functions.  GoDB has no fractional numbers, so these take and return
+// ints; e.g. round rounds to a (non-positive) number of decimal digits.
+
+func absFunc(args []any) any {
+	x := args[0].(int64)
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func signFunc(args []any) any {
+	x := args[0].(int64)
+	switch {
+	case x > 0:
+		return int64(1)
+	case x < 0:
+		return int64(-1)
+	}
+	return int64(0)
+}
+
+func identityFunc(args []any) any {
+	return args[0]
+}
+
+func roundFunc(args []any) any {
+	x := args[0].(int64)
+	if len(args) < 2 || args[1].(int64) >= 0 {
+		return x
+	}
+	digits := -args[1].(int64)
+	if digits > 18 {
+		return int64(0)
+	}
+	p := int64(math.Pow10(int(digits)))
+	// round half away from zero
+	if x < 0 {
+		return -((-x + p/2) / p * p)
+	}
+	return (x + p/2) / p * p
+}
+
+func powerFunc(args []any) any {
+	x, y := args[0].(int64), args[1].(int64)
+	if y < 0 {
+		return GoDBError{IllegalOperationError, "negative exponents are not supported"}
+	}
+	result := int64(1)
+	for ; y > 0; y >>= 1 {
+		if y&1 == 1 {
+			result *= x
+		}
+		x *= x
+	}
+	return result
+}
+
+func sqrtFunc(args []any) any {
+	x := args[0].(int64)
+	if x < 0 {
+		return GoDBError{IllegalOperationError, "cannot take square root of a negative number"}
+	}
+	r := int64(math.Sqrt(float64(x)))
+	// correct for floating point error in large numbers
+	for r*r > x {
+		r--
+	}
+	for (r+1)*(r+1) <= x {
+		r++
+	}
+	return r
+}
+
+// Casts.
+
+func castIntFunc(args []any) any {
+	switch a := args[0].(type) {
+	case int64:
+		return a
+	case string:
+		i, err := strconv.ParseInt(strings.TrimSpace(a), 10, 64)
+		if err != nil {
+			return GoDBError{TypeMismatchError, fmt.Sprintf("invalid input syntax for type int: %q", a)}
+		}
+		return i
+	}
+	return nil
+}
+
+func castDateFunc(args []any) any {
+	t, err := parseTimestamp(args[0].(string))
+	if err != nil {
+		return err
+	}
+	return t.Format(dateLayout)
+}
+
+func castTimestampFunc(args []any) any {
+	t, err := parseTimestamp(args[0].(string))
+	if err != nil {
+		return err
+	}
+	return t.Format(timestampLayout)
+}
+
+// Date and time functions.
+
+var timestampLayouts = []string{timestampLayout, "2006-01-02T15:04:05", time.RFC3339, dateLayout, time.UnixDate}
+
+func parseTimestamp(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range timestampLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, GoDBError{TypeMismatchError, fmt.Sprintf("invalid input syntax for type timestamp: %q", s)}
+}
+
+func nowFunc(args []any) any {
+	return time.Now().Format(timestampLayout)
+}
+
+func currentDateFunc(args []any) any {
+	return time.Now().Format(dateLayout)
+}
+
+func dateTruncFunc(args []any) any {
+	t, err := parseTimestamp(args[1].(string))
+	if err != nil {
+		return err
+	}
+	y, m, d := t.Date()
+	switch strings.ToLower(args[0].(string)) {
+	case "second":
+		t = t.Truncate(time.Second)
+	case "minute":
+		t = t.Truncate(time.Minute)
+	case "hour":
+		t = time.Date(y, m, d, t.Hour(), 0, 0, 0, t.Location())
+	case "day":
+		t = time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+	case "week":
+		// weeks start on Monday
+		t = time.Date(y, m, d-(int(t.Weekday())+6)%7, 0, 0, 0, 0, t.Location())
+	case "month":
+		t = time.Date(y, m, 1, 0, 0, 0, 0, t.Location())
+	case "quarter":
+		t = time.Date(y, m-(m-1)%3, 1, 0, 0, 0, 0, t.Location())
+	case "year":
+		t = time.Date(y, 1, 1, 0, 0, 0, 0, t.Location())
+	default:
+		return GoDBError{IllegalOperationError, fmt.Sprintf("unit %q not recognized by date_trunc", args[0])}
+	}
+	return t.Format(timestampLayout)
+}
+
+func extractFunc(args []any) any {
+	t, err := parseTimestamp(args[1].(string))
+	if err != nil {
+		return err
+	}
+	var v int
+	switch strings.ToLower(args[0].(string)) {
+	case "year":
+		v = t.Year()
+	case "quarter":
+		v = (int(t.Month())-1)/3 + 1
+	case "month":
+		v = int(t.Month())
+	case "week":
+		_, v = t.ISOWeek()
+	case "day":
+		v = t.Day()
+	case "doy":
+		v = t.YearDay()
+	case "dow":
+		v = int(t.Weekday())
+	case "isodow":
+		v = (int(t.Weekday())+6)%7 + 1
+	case "hour":
+		v = t.Hour()
+	case "minute":
+		v = t.Minute()
+	case "second":
+		v = t.Second()
+	case "epoch":
+		return t.Unix()
+	default:
+		return GoDBError{IllegalOperationError, fmt.Sprintf("unit %q not recognized by extract", args[0])}
+	}
+	return int64(v)
+}
+
+// sqlparser accepts MySQL's syntax, so before a query is parsed
+// rewriteFunctionSyntax turns the standard SQL forms that it does not accept
+// into ones it does:
+//
+//   - a || b into a | b, which parseExpr treats as concatenation
+//   - CAST(x AS int) and the like into the MySQL types CAST accepts
+//   - EXTRACT(field FROM x) into extract('field', x)
+//   - POSITION(a IN b) into position(a, b)
+//   - SUBSTRING(s, ...) into `substr`(s, ...), as sqlparser only accepts
+//     a column as the first argument of SUBSTRING
+var sqlTokenizer = regexp.MustCompile("(?s)'(?:[^'\\\\]|\\\\.)*'|\"[^\"]*\"|`[^`]*`|[A-Za-z_][A-Za-z0-9_]*|\\|\\||\\s+|.")
+
+// The MySQL types that CAST types are rewritten as.
+var castTypes = map[string]string{
+	"int": "signed", "integer": "signed", "bigint": "signed", "smallint": "signed",
+	"text": "char", "varchar": "char", "string": "char",
+	"timestamp": "datetime",
+}
+
+func rewriteFunctionSyntax(query string) string {
+	toks := sqlTokenizer.FindAllString(query, -1)
+	// the calls to CAST, EXTRACT and POSITION that are open, and the depth
+	// of parentheses inside each of them
+	type call struct {
+		name  string
+		depth int
+		args  int // the number of tokens seen in the call so far
+	}
+	var calls []*call
+	next := func(i int) int {
+		for i++; i < len(toks) && strings.TrimSpace(toks[i]) == ""; i++ {
+		}
+		return i
+	}
+	for i := 0; i < len(toks); i++ {
+		tok := strings.ToLower(toks[i])
+		var top *call
+		if len(calls) > 0 {
+			top = calls[len(calls)-1]
+		}
+		switch {
+		case tok == "||":
+			toks[i] = "|"
+		case (tok == "substring" || tok == "substr") && next(i) < len(toks) && toks[next(i)] == "(":
+			toks[i] = "`substr`"
+		case tok == "(":
+			if top != nil {
+				top.depth++
+			}
+		case tok == ")":
+			if top != nil {
+				if top.depth--; top.depth == 0 {
+					calls = calls[:len(calls)-1]
+				}
+			}
+		case (tok == "cast" || tok == "extract" || tok == "position") && next(i) < len(toks) && toks[next(i)] == "(":
+			calls = append(calls, &call{name: tok})
+		case top != nil && top.depth == 1:
+			switch {
+			case top.name == "cast" && tok == "as":
+				if j := next(i); j < len(toks) {
+					if t, ok := castTypes[strings.ToLower(toks[j])]; ok {
+						toks[j] = t
+						// drop the length of varchar(n)
+						if k := next(j); k < len(toks) && toks[k] == "(" {
+							for ; k < len(toks) && toks[k] != ")"; k++ {
+								toks[k] = ""
+							}
+							if k < len(toks) {
+								toks[k] = ""
+							}
+						}
+					}
+				}
+			case top.name == "extract" && top.args == 0 && strings.TrimSpace(tok) != "":
+				toks[i] = "'" + tok + "'"
+			case top.name == "extract" && tok == "from", top.name == "position" && tok == "in":
+				toks[i] = ","
+			}
+			if strings.TrimSpace(tok) != "" {
+				top.args++
+			}
+		}
+	}
+	return strings.Join(toks, "")
+}
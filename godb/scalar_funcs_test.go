@@ -0,0 +1,127 @@
+package godb
+
+import (
+	"strings"
+	"testing"
+)
+
+// Run a query returning a single row and return it as a comma separated
+// string.
+func queryRow(t *testing.T, s *Session, q string) string {
+	t.Helper()
+	res, err := s.Execute(q)
+	if err != nil {
+		t.Fatalf("%s failed, %s", q, err.Error())
+	}
+	if len(res.Tuples) != 1 {
+		t.Fatalf("%s: expected one row, got %d", q, len(res.Tuples))
+	}
+	return res.Tuples[0].PrettyPrintString(false)
+}
+
+func TestScalarFunctions(t *testing.T) {
+	s := makeBatchTestSession(t, 20)
+	for _, c := range []struct{ exprs, want string }{
+		{"upper(name), lower('ABC'), length(name), char_length('héllo')", "E7,abc,2,5"},
+		{"trim('  a b  '), ltrim('xxaxx', 'x'), rtrim('xxaxx', 'x')", "a b,axx,xxa"},
+		{"concat(name, '-', dept), name || '/' || salary, 'n' || 1 + 2", "e7-7,e7/49,n3"},
+		{"replace('banana', 'an', 'AN'), position('an' in 'banana'), position('x' in 'banana')", "bANANa,2,0"},
+		{"lpad(name, 5, '*'), rpad(name, 4), lpad('abcdef', 3), left('abcdef', 2), right('abcdef', -4)", "***e7,e7  ,abc,ab,ef"},
+		{"substring(name, 2), substring('hello', 2, 3), substr('hello', 0, 2), repeat('ab', 2), reverse('abc')", "7,ell,h,abab,cba"},
+		{"abs(-dept), sign(-3), round(1250, -2), round(-1250, -2), round(7), power(2, 10), sqrt(99), -salary", "7,-1,1300,-1300,7,1024,9,-49"},
+		{"salary % 10, mod(salary, 7), floor(dept), ceil(dept), greatest(dept, 3, 12), least(dept, 3)", "9,0,7,7,12,3"},
+		{"coalesce(null, dept), coalesce(nullif(dept, 7), 0), nullif(dept, 8), greatest('b', 1)", "7,0,7,b"},
+		{"case when dept < 5 then 'low' when dept < 8 then 'mid' else 'high' end", "mid"},
+		{"case dept when 7 then 'seven' end, case dept when 1 then 'one' end is null", "seven,1"},
+		{"case when dept > 0 then salary / dept else 0 end, case when dept = 7 then 1 else 'x' end", "7,1"},
+		{"dept = 7, dept <> 7, name like 'e%', name ilike 'E7', dept between 1 and 3, not dept > 3", "1,0,1,1,0,0"},
+		{"dept > 3 and dept < 9, dept < 3 or name ~ '7$'", "1,1"},
+		{"cast(dept as text) || 'x', cast('12' as int) + 1, cast(name as varchar(10)), cast('2024-03-05 10:11:12' as date)", "7x,13,e7,2024-03-05"},
+		{"cast('2024-03-05' as timestamp), date_trunc('month', '2024-03-05 10:11:12'), date_trunc('week', '2024-03-07')", "2024-03-05 00:00:00,2024-03-01 00:00:00,2024-03-04 00:00:00"},
+		{"extract(year from '2024-03-05 10:11:12'), extract(month from '2024-03-05'), date_part('dow', '2024-03-05'), extract(epoch from '1970-01-02')", "2024,3,2,86400"},
+	} {
+		q := "select " + c.exprs + " from emp where name = 'e7'"
+		if got := queryRow(t, s, q); got != c.want {
+			t.Errorf("%s: expected %s, got %s", q, c.want, got)
+		}
+	}
+
+	now := queryRow(t, s, "select now(), current_date from emp where name = 'e7'")
+	if parts := strings.Split(now, ","); len(parts) != 2 || len(parts[0]) != len(timestampLayout) || !strings.HasPrefix(parts[0], parts[1]) {
+		t.Errorf("unexpected current time %s", now)
+	}
+
+	for _, q := range []string{
+		"select salary / (dept - dept) from emp",
+		"select cast(name as int) from emp",
+		"select upper(name, name) from emp",
+		"select lpad(name) from emp",
+		"select abs(name) from emp",
+		"select extract(century from '2024-01-01') from emp",
+		"select cast('yesterday' as date) from emp",
+		"select sqrt(-dept - 1) from emp",
+	} {
+		if _, err := s.Execute(q); err == nil {
+			t.Errorf("expected %s to fail", q)
+		}
+	}
+}
+
+func TestFunctionTypes(t *testing.T) {
+	s := makeBatchTestSession(t, 20)
+	for _, c := range []struct {
+		expr string
+		want DBType
+	}{
+		{"coalesce(dept, salary)", IntType},
+		{"coalesce(dept, name)", StringType},
+		{"case when dept = 1 then 1 else 'x' end", StringType},
+		{"case when dept = 1 then 1 end", IntType},
+		{"nullif(name, 'x')", StringType},
+		{"dept = 1", IntType},
+		{"name || dept", StringType},
+		{"cast(name as int)", IntType},
+	} {
+		desc, err := s.Describe("select " + c.expr + " from emp")
+		if err != nil {
+			t.Fatalf("describe %s failed, %s", c.expr, err.Error())
+		}
+		if got := desc.Fields[0].Ftype; got != c.want {
+			t.Errorf("%s: expected type %v, got %v", c.expr, c.want, got)
+		}
+	}
+}
+
+func TestListOfFunctions(t *testing.T) {
+	list := ListOfFunctions()
+	for _, want := range []string{
+		"\tlpad(string, int[, string]) -> string: ",
+		"\tcoalesce(any, ...) -> any: ",
+		"\tCASE [x] WHEN c THEN v [WHEN ...] [ELSE e] END -> any: ",
+		"\tstring || string -> string",
+		"\tstring like string -> int",
+		"\textract(field FROM string) -> int",
+		"\timin(int, int) -> int",
+	} {
+		if !strings.Contains(list, want) {
+			t.Errorf("expected the list of functions to contain %q", want)
+		}
+	}
+	if n := strings.Count(list, "\n"); n != len(funcs)+len(aggregates) {
+		t.Errorf("expected one line for each of %d functions, got %d", len(funcs)+len(aggregates), n)
+	}
+}
+
+func TestRewriteFunctionSyntax(t *testing.T) {
+	for _, c := range []struct{ query, want string }{
+		{"select a || 'x||y' from t", "select a | 'x||y' from t"},
+		{"select CAST(a AS INTEGER), cast(b as varchar(20)) as text from t", "select CAST(a AS signed), cast(b as char) as text from t"},
+		{"select extract(Year from d), position('a' in (b)) from t", "select extract('year' , d), position('a' , (b)) from t"},
+		{"select cast(extract(day from d) as text) from t where x in (1)", "select cast(extract('day' , d) as char) from t where x in (1)"},
+		{"select substring('abc', 1) from t", "select `substr`('abc', 1) from t"},
+	} {
+		if got := rewriteFunctionSyntax(c.query); got != c.want {
+			t.Errorf("rewriting %q: expected %q, got %q", c.query, c.want, got)
+		}
+	}
+}
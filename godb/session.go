@@ -0,0 +1,557 @@
+package godb
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xwb1989/sqlparser"
+)
+
+// A Session is the state of one client of a database: the catalog and buffer
+// pool it runs against, and the transaction it currently has open, if any.
+// Statements executed outside of an explicit BEGIN run in their own
+// transaction which is committed when the statement completes.
+//
+// Sessions are not safe for concurrent use, but many sessions may share the
+// same [Catalog] and [BufferPool].
+type Session struct {
+	catalog *Catalog
+	bp      *BufferPool
+	tid     TransactionID
+	inTxn   bool
+	// whether a statement of the open transaction failed, which aborted it;
+	// other statements are rejected until COMMIT or ROLLBACK ends it
+	failed bool
+	// statements created with PREPARE, by name
+	prepared map[string]*Stmt
+	// the number of worker goroutines queries are run with
+	parallelWorkers int
+	// how long a statement may run before it is cancelled, or 0 for no limit
+	statementTimeout time.Duration
+	// the bytes a statement may hold in memory, or 0 for no limit
+	queryMemoryLimit int64
+	// whether COPY may only read and write the files in copyDir, or none if
+	// it is empty; see RestrictCopy
+	copyRestricted bool
+	copyDir        string
+}
+
+// The result of executing a statement in a [Session].
+type Result struct {
+	Type QueryType
+	// Descriptor of the rows in Tuples, or nil if the statement does not
+	// return rows (e.g., BEGIN or CREATE TABLE)
+	Desc   *TupleDesc
+	Tuples []*Tuple
+	// Command tag describing the statement, e.g., "SELECT 3" or "INSERT 0 1"
+	Tag string
+}
+
+func NewSession(c *Catalog, bp *BufferPool) *Session {
+	return &Session{catalog: c, bp: bp, prepared: make(map[string]*Stmt), parallelWorkers: DefaultParallelWorkers}
+}
+
+// Set the number of worker goroutines the session runs queries with; see
+// [Parallelize]. This is also set with SET parallel_workers = n.
+func (s *Session) SetParallelWorkers(n int) error {
+	if n < 1 {
+		return GoDBError{IllegalOperationError, fmt.Sprintf("parallel_workers must be at least 1, got %d", n)}
+	}
+	s.parallelWorkers = n
+	return nil
+}
+
+// Set how long a statement may run before it is cancelled with a
+// QueryCancelledError, or 0 for no limit.  This is also set with SET
+// statement_timeout = milliseconds, or a duration such as '5s'.
+func (s *Session) SetStatementTimeout(d time.Duration) error {
+	if d < 0 {
+		return GoDBError{IllegalOperationError, fmt.Sprintf("statement_timeout must not be negative, got %s", d)}
+	}
+	s.statementTimeout = d
+	return nil
+}
+
+// Set the number of bytes of memory a statement may use for the rows it
+// holds, such as those it sorts or hashes and those of its result, or 0 for
+// no limit; see [MemoryTracker].  This is also set with SET
+// query_memory_limit = kilobytes, or a size such as '64MB'.
+func (s *Session) SetQueryMemoryLimit(n int64) error {
+	if n < 0 {
+		return GoDBError{IllegalOperationError, fmt.Sprintf("query_memory_limit must not be negative, got %d", n)}
+	}
+	s.queryMemoryLimit = n
+	return nil
+}
+
+// Return true if the session has an explicit transaction open, including
+// one that has failed.
+func (s *Session) InTransaction() bool {
+	return s.inTxn
+}
+
+// Return true if a statement of the session's explicit transaction failed,
+// so that it only accepts COMMIT or ROLLBACK.
+func (s *Session) TransactionFailed() bool {
+	return s.failed
+}
+
+// Return the catalog the session runs against.
+func (s *Session) Catalog() *Catalog {
+	return s.catalog
+}
+
+// Parse and run a single SQL statement, returning its result.
+//
+// If the statement fails inside an explicit transaction, the transaction is
+// aborted, and, as in postgres, the session rejects every other statement
+// until COMMIT or ROLLBACK ends it.
+func (s *Session) Execute(query string) (*Result, error) {
+	return s.ExecuteContext(context.Background(), query)
+}
+
+// Parse and run a single SQL statement like [Session.Execute], cancelling it
+// with a QueryCancelledError if ctx is cancelled or the session's statement
+// timeout passes before it finishes.  The statement's transaction is then
+// aborted, as for any other error.
+func (s *Session) ExecuteContext(ctx context.Context, query string) (*Result, error) {
+	if s.failed && !endTransactionRegexp.MatchString(query) {
+		return nil, errTransactionFailed
+	}
+	// sqlparser does not know the prepared statement commands
+	switch {
+	case prepareRegexp.MatchString(query):
+		return s.executePrepare(query)
+	case executeRegexp.MatchString(query):
+		return s.executeExecute(ctx, query)
+	case deallocateRegexp.MatchString(query):
+		return s.executeDeallocate(query)
+	case setRegexp.MatchString(query):
+		return s.executeSet(query)
+	case explainRegexp.MatchString(query):
+		return s.executeExplain(query)
+	case s.inTxn && createTableAsRegexp.MatchString(query):
+		// it runs in a transaction of its own; see create_table_as.go
+		s.abortOnError()
+		return nil, GoDBError{IllegalTransactionError, "CREATE TABLE AS cannot run inside a transaction block"}
+	}
+	s.catalog.mutex.Lock()
+	qType, plan, err := Parse(s.catalog, query)
+	s.catalog.mutex.Unlock()
+	if err != nil {
+		s.abortOnError()
+		return nil, err
+	}
+	return s.run(ctx, qType, plan)
+}
+
+// Parse and plan a statement for later execution with [Session.ExecuteStmt].
+func (s *Session) Prepare(query string) (*Stmt, error) {
+	s.catalog.mutex.Lock()
+	defer s.catalog.mutex.Unlock()
+	return Prepare(s.catalog, query)
+}
+
+// Run a prepared statement with the supplied parameter values.
+func (s *Session) ExecuteStmt(stmt *Stmt, args ...DBValue) (*Result, error) {
+	return s.ExecuteStmtContext(context.Background(), stmt, args...)
+}
+
+// Run a prepared statement like [Session.ExecuteStmt], cancelling it as
+// [Session.ExecuteContext] does.
+func (s *Session) ExecuteStmtContext(ctx context.Context, stmt *Stmt, args ...DBValue) (*Result, error) {
+	if s.failed && stmt.QueryType() != CommitXactionType && stmt.QueryType() != AbortXactionType {
+		return nil, errTransactionFailed
+	}
+	s.catalog.mutex.Lock()
+	plan, err := stmt.Bind(args...)
+	s.catalog.mutex.Unlock()
+	if err != nil {
+		s.abortOnError()
+		return nil, err
+	}
+	return s.run(ctx, stmt.QueryType(), plan)
+}
+
+var (
+	// PREPARE name [(type, ...)] AS statement; parameter types are
+	// inferred from the statement, so any declared types are ignored
+	prepareRegexp    = regexp.MustCompile(`(?is)^\s*prepare\s+(\w+)\s*(?:\([^)]*\))?\s+as\s+(.*)$`)
+	executeRegexp    = regexp.MustCompile(`(?is)^\s*execute\s+(\w+)\s*(?:\((.*)\))?\s*$`)
+	deallocateRegexp = regexp.MustCompile(`(?is)^\s*deallocate\s+(?:prepare\s+)?(\w+)\s*$`)
+	// SET [SESSION] name {= | TO} value
+	setRegexp = regexp.MustCompile(`(?is)^\s*set\s+(?:session\s+)?(\w+)\s*(?:=|\sto\s)\s*(.*?)\s*$`)
+	// the statements a failed transaction accepts
+	endTransactionRegexp = regexp.MustCompile(`(?is)^\s*(?:commit|rollback)\b`)
+)
+
+var errTransactionFailed = GoDBError{IllegalTransactionError, "current transaction is aborted, commands ignored until end of transaction block"}
+
+// The descriptor of the result of EXPLAIN, a row for each line of the plan.
+var explainDesc = TupleDesc{Fields: []FieldType{{Fname: "QUERY PLAN", Ftype: StringType}}}
+
+func (s *Session) executeExplain(query string) (*Result, error) {
+	s.catalog.mutex.Lock()
+	rows, err := Explain(s.catalog, query)
+	s.catalog.mutex.Unlock()
+	if err != nil {
+		s.abortOnError()
+		return nil, err
+	}
+	res := &Result{Type: ExplainQueryType, Desc: &explainDesc, Tag: "EXPLAIN"}
+	for _, line := range rows {
+		res.Tuples = append(res.Tuples, &Tuple{Desc: explainDesc, Fields: []DBValue{StringField{line}}})
+	}
+	return res, nil
+}
+
+func (s *Session) executePrepare(query string) (*Result, error) {
+	m := prepareRegexp.FindStringSubmatch(query)
+	name := strings.ToLower(m[1])
+	if _, ok := s.prepared[name]; ok {
+		return nil, GoDBError{IllegalOperationError, fmt.Sprintf("prepared statement \"%s\" already exists", name)}
+	}
+	stmt, err := s.Prepare(m[2])
+	if err != nil {
+		s.abortOnError()
+		return nil, err
+	}
+	s.prepared[name] = stmt
+	return &Result{Type: PrepareQueryType, Tag: "PREPARE"}, nil
+}
+
+func (s *Session) executeExecute(ctx context.Context, query string) (*Result, error) {
+	m := executeRegexp.FindStringSubmatch(query)
+	name := strings.ToLower(m[1])
+	stmt, ok := s.prepared[name]
+	if !ok {
+		return nil, GoDBError{IllegalOperationError, fmt.Sprintf("prepared statement \"%s\" does not exist", name)}
+	}
+	var args []DBValue
+	if strings.TrimSpace(m[2]) != "" {
+		var err error
+		args, err = parseConstList(m[2])
+		if err != nil {
+			s.abortOnError()
+			return nil, err
+		}
+	}
+	return s.ExecuteStmtContext(ctx, stmt, args...)
+}
+
+func (s *Session) executeDeallocate(query string) (*Result, error) {
+	name := strings.ToLower(deallocateRegexp.FindStringSubmatch(query)[1])
+	if name == "all" {
+		s.prepared = make(map[string]*Stmt)
+	} else if _, ok := s.prepared[name]; !ok {
+		return nil, GoDBError{IllegalOperationError, fmt.Sprintf("prepared statement \"%s\" does not exist", name)}
+	}
+	delete(s.prepared, name)
+	return &Result{Type: DeallocateQueryType, Tag: "DEALLOCATE"}, nil
+}
+
+func (s *Session) executeSet(query string) (*Result, error) {
+	m := setRegexp.FindStringSubmatch(query)
+	name, value := strings.ToLower(m[1]), strings.Trim(m[2], "'")
+	switch name {
+	case "parallel_workers":
+		n := DefaultParallelWorkers
+		if !strings.EqualFold(value, "default") {
+			var err error
+			if n, err = strconv.Atoi(value); err != nil {
+				return nil, GoDBError{IllegalOperationError, fmt.Sprintf("invalid value for parallel_workers: %s", value)}
+			}
+		}
+		if err := s.SetParallelWorkers(n); err != nil {
+			return nil, err
+		}
+	case "statement_timeout":
+		d, err := parseTimeout(value)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.SetStatementTimeout(d); err != nil {
+			return nil, err
+		}
+	case "query_memory_limit":
+		n, err := parseMemorySize(value)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.SetQueryMemoryLimit(n); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, GoDBError{IllegalOperationError, fmt.Sprintf("unrecognized configuration parameter \"%s\"", name)}
+	}
+	return &Result{Type: SetQueryType, Tag: "SET"}, nil
+}
+
+// Parse the value of statement_timeout: a number of milliseconds, a duration
+// such as 5s, 100ms or 1min, or DEFAULT, which like 0 means no timeout.
+func parseTimeout(value string) (time.Duration, error) {
+	value = strings.ToLower(strings.ReplaceAll(value, " ", ""))
+	if value == "default" {
+		return 0, nil
+	}
+	if ms, err := strconv.Atoi(value); err == nil {
+		return time.Duration(ms) * time.Millisecond, nil
+	}
+	d, err := time.ParseDuration(strings.Replace(value, "min", "m", 1))
+	if err != nil {
+		return 0, GoDBError{IllegalOperationError, fmt.Sprintf("invalid value for statement_timeout: %s", value)}
+	}
+	return d, nil
+}
+
+// Parse the value of query_memory_limit: a number of kilobytes, a size such
+// as 64MB, or DEFAULT, which like 0 means no limit.
+func parseMemorySize(value string) (int64, error) {
+	value = strings.ReplaceAll(value, " ", "")
+	if strings.EqualFold(value, "default") {
+		return 0, nil
+	}
+	digits := strings.TrimLeft(value, "-0123456789")
+	n, err := strconv.ParseInt(value[:len(value)-len(digits)], 10, 64)
+	unit := int64(1 << 10)
+	if digits != "" {
+		unit = 0
+		for name, size := range memoryUnits {
+			if strings.EqualFold(digits, name) {
+				unit = size
+			}
+		}
+	}
+	if err != nil || unit == 0 {
+		return 0, GoDBError{IllegalOperationError, fmt.Sprintf("invalid value for query_memory_limit: %s", value)}
+	}
+	return n * unit, nil
+}
+
+// Parse a comma separated list of constants, e.g., the arguments of EXECUTE.
+func parseConstList(list string) ([]DBValue, error) {
+	stmt, err := sqlparser.Parse("select " + list)
+	if err != nil {
+		return nil, err
+	}
+	var vals []DBValue
+	for _, se := range stmt.(*sqlparser.Select).SelectExprs {
+		ae, ok := se.(*sqlparser.AliasedExpr)
+		if !ok {
+			return nil, GoDBError{ParseError, "expected a constant"}
+		}
+		lsn, err := parseExpr(nil, ae.Expr, "")
+		if err != nil {
+			return nil, err
+		}
+		if lsn.exprType != ExprConst || lsn.param != nil {
+			return nil, GoDBError{ParseError, fmt.Sprintf("expected a constant, got %s", sqlparser.String(ae.Expr))}
+		}
+		e, _, err := lsn.generateExpr(nil, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		v, err := e.EvalExpr(nil)
+		if err != nil {
+			return nil, err
+		}
+		vals = append(vals, v)
+	}
+	return vals, nil
+}
+
+func (s *Session) run(ctx context.Context, qType QueryType, plan Operator) (*Result, error) {
+	switch qType {
+	case IteratorType:
+		return s.runPlan(ctx, plan)
+	case BeginXactionType:
+		if s.inTxn {
+			return nil, GoDBError{IllegalTransactionError, "cannot start transaction while in transaction"}
+		}
+		s.tid = NewTID()
+		if err := s.bp.BeginTransaction(s.tid); err != nil {
+			return nil, err
+		}
+		s.inTxn = true
+		return &Result{Type: qType, Tag: "BEGIN"}, nil
+	case CommitXactionType:
+		if !s.inTxn {
+			return nil, GoDBError{IllegalTransactionError, "cannot commit transaction unless in transaction"}
+		}
+		if s.failed {
+			// already aborted; postgres reports this as a rollback
+			s.inTxn, s.failed = false, false
+			return &Result{Type: AbortXactionType, Tag: "ROLLBACK"}, nil
+		}
+		s.inTxn = false
+		if err := s.bp.CommitTransaction(s.tid); err != nil {
+			return nil, err
+		}
+		return &Result{Type: qType, Tag: "COMMIT"}, nil
+	case AbortXactionType:
+		if !s.inTxn {
+			return nil, GoDBError{IllegalTransactionError, "cannot abort transaction unless in transaction"}
+		}
+		if !s.failed {
+			s.bp.AbortTransaction(s.tid)
+		}
+		s.inTxn, s.failed = false, false
+		return &Result{Type: qType, Tag: "ROLLBACK"}, nil
+	case CreateTableQueryType, DropTableQueryType, AlterTableQueryType,
+		CreateViewQueryType, DropViewQueryType, RefreshViewQueryType:
+		// already run, and the catalog saved, by Parse
+		return &Result{Type: qType, Tag: ddlTags[qType]}, nil
+	}
+	return nil, GoDBError{ParseError, "unknown query type"}
+}
+
+// Command tags of the DDL statements, which are run when they are parsed.
+var ddlTags = map[QueryType]string{
+	CreateTableQueryType: "CREATE TABLE",
+	DropTableQueryType:   "DROP TABLE",
+	AlterTableQueryType:  "ALTER TABLE",
+	CreateViewQueryType:  "CREATE VIEW",
+	DropViewQueryType:    "DROP VIEW",
+	RefreshViewQueryType: "REFRESH MATERIALIZED VIEW",
+}
+
+// Run a physical plan to completion, in the session's open transaction if
+// there is one, or in a new transaction otherwise.  If ctx is cancelled, the
+// statement timeout passes, or the statement uses more memory than it may,
+// the transaction is aborted.
+func (s *Session) runPlan(ctx context.Context, plan Operator) (*Result, error) {
+	if err := ctx.Err(); err != nil {
+		s.abortOnError()
+		return nil, cancelledError(err)
+	}
+	if err := s.checkCopyFiles(plan); err != nil {
+		s.abortOnError()
+		return nil, err
+	}
+	if s.statementTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.statementTimeout)
+		defer cancel()
+	}
+	if !s.inTxn {
+		s.tid = NewTID()
+		if err := s.bp.BeginTransaction(s.tid); err != nil {
+			return nil, err
+		}
+	}
+	mem := NewMemoryTracker("query", s.queryMemoryLimit, GlobalMemory)
+	unbind, unbindMem := BindContext(ctx, s.tid), BindMemoryTracker(mem, s.tid)
+	tuples, err := s.collect(plan)
+	unbind()
+	unbindMem()
+	mem.Close()
+	if err != nil {
+		s.bp.AbortTransaction(s.tid)
+		s.failed = s.inTxn
+		return nil, err
+	}
+	if !s.inTxn {
+		if err := s.bp.CommitTransaction(s.tid); err != nil {
+			return nil, err
+		}
+	}
+	res := &Result{Type: IteratorType, Desc: plan.Descriptor(), Tuples: tuples}
+	res.Tag = commandTag(plan, tuples)
+	return res, nil
+}
+
+// Return the descriptor of the rows a statement would produce, without
+// running it, or nil if the statement does not produce rows.
+func (s *Session) Describe(query string) (*TupleDesc, error) {
+	if explainRegexp.MatchString(query) {
+		return &explainDesc, nil
+	}
+	stmt, err := parseSQL(query)
+	if err != nil {
+		return nil, err
+	}
+	sel, ok := stmt.(sqlparser.SelectStatement)
+	if !ok {
+		return nil, nil
+	}
+	s.catalog.mutex.Lock()
+	defer s.catalog.mutex.Unlock()
+	plan, err := parseQuery(s.catalog, sel)
+	if err != nil {
+		return nil, err
+	}
+	op, err := makePhysicalPlan(s.catalog, plan)
+	if err != nil {
+		return nil, err
+	}
+	return op.Descriptor(), nil
+}
+
+func (s *Session) collect(plan Operator) ([]*Tuple, error) {
+	iter, err := planIterator(Parallelize(plan, s.parallelWorkers), s.tid)
+	if err != nil {
+		return nil, err
+	}
+	// the result counts toward the statement's memory too
+	mem := newMemoryReservation(s.tid)
+	var tuples []*Tuple
+	for {
+		tup, err := iter()
+		if err != nil {
+			return nil, err
+		}
+		if tup == nil {
+			return tuples, nil
+		}
+		if err := mem.grow(tup.memorySize()); err != nil {
+			return nil, err
+		}
+		tuples = append(tuples, tup)
+	}
+}
+
+// Abort the open transaction, if any, after one of its statements failed.
+// The session stays in the transaction, which has failed, until COMMIT or
+// ROLLBACK ends it.
+func (s *Session) abortOnError() {
+	if s.inTxn && !s.failed {
+		s.bp.AbortTransaction(s.tid)
+		s.failed = true
+	}
+}
+
+// Abort any open transaction and forget prepared statements; called when the
+// client goes away.
+func (s *Session) Close() {
+	s.abortOnError()
+	s.inTxn, s.failed = false, false
+	s.prepared = make(map[string]*Stmt)
+}
+
+// Return a postgres-style command tag for a completed plan.
+func commandTag(plan Operator, tuples []*Tuple) string {
+	count := func() int64 {
+		if len(tuples) == 1 && len(tuples[0].Fields) == 1 {
+			if f, ok := tuples[0].Fields[0].(IntField); ok {
+				return f.Value
+			}
+		}
+		return 0
+	}
+	switch plan := plan.(type) {
+	case *InsertOp:
+		if _, ok := plan.child.(*CopyFromOp); ok {
+			return fmt.Sprintf("COPY %d", count())
+		}
+		return fmt.Sprintf("INSERT 0 %d", count())
+	case *CopyToOp:
+		return fmt.Sprintf("COPY %d", count())
+	case *DeleteOp:
+		return fmt.Sprintf("DELETE %d", count())
+	}
+	return fmt.Sprintf("SELECT %d", len(tuples))
+}
@@ -0,0 +1,180 @@
+package godb
+
+import (
+	"fmt"
+)
+
+type SetOpKind int
+
+const (
+	UnionOp SetOpKind = iota
+	IntersectOp
+	ExceptOp
+)
+
+func (k SetOpKind) String() string {
+	switch k {
+	case IntersectOp:
+		return "INTERSECT"
+	case ExceptOp:
+		return "EXCEPT"
+	}
+	return "UNION"
+}
+
+// A SetOp combines the results of two queries with the same number of
+// columns: UNION returns the tuples of both, INTERSECT the tuples of the
+// left that are also in the right, and EXCEPT the tuples of the left that are
+// not in the right.
+//
+// Unless all is set, duplicates are removed from the result, using the
+// [Tuple.tupleKey] of each tuple as in [Aggregator].  With all set, a tuple
+// that appears m times in the left and n times in the right appears m+n
+// times in the result of UNION ALL, min(m, n) times in INTERSECT ALL and
+// max(m-n, 0) times in EXCEPT ALL.
+type SetOp struct {
+	kind        SetOpKind
+	all         bool
+	left, right Operator
+	desc        *TupleDesc
+}
+
+// Construct a set operation.  Returns an error if the results of left and
+// right do not have the same number of columns, or have columns of different
+// types.  The columns of the result are named after those of left.
+func NewSetOp(kind SetOpKind, all bool, left, right Operator) (*SetOp, error) {
+	desc, err := setOpDesc(kind.String(), left.Descriptor(), right.Descriptor())
+	if err != nil {
+		return nil, err
+	}
+	return &SetOp{kind, all, left, right, desc}, nil
+}
+
+// Return the descriptor of the result of set operation op on rows described
+// by left and right, or an error if they are not compatible.
+func setOpDesc(op string, left, right *TupleDesc) (*TupleDesc, error) {
+	if len(left.Fields) != len(right.Fields) {
+		return nil, GoDBError{TypeMismatchError, fmt.Sprintf("each %s query must have the same number of columns, got %d and %d", op, len(left.Fields), len(right.Fields))}
+	}
+	desc := left.copy()
+	for i, f := range right.Fields {
+		switch t := desc.Fields[i].Ftype; {
+		case t == UnknownType:
+			desc.Fields[i].Ftype = f.Ftype
+		case f.Ftype != UnknownType && f.Ftype != t:
+			return nil, GoDBError{TypeMismatchError, fmt.Sprintf("%s types %v and %v cannot be matched in column %d", op, t, f.Ftype, i+1)}
+		}
+	}
+	return desc, nil
+}
+
+func (s *SetOp) Descriptor() *TupleDesc {
+	return s.desc
+}
+
+// Return the name of the operation, e.g. "UNION ALL".
+func (s *SetOp) name() string {
+	if s.all {
+		return s.kind.String() + " ALL"
+	}
+	return s.kind.String()
+}
+
+// Return an iterator over the result of the set operation.  INTERSECT and
+// EXCEPT first read all of the tuples of the right into a hash table; the
+// tuples of the left, and for UNION then the right, are then returned as they
+// are read.
+func (s *SetOp) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	// the number of times each tuple of the right is yet to be matched, by
+	// its key
+	var counts map[any]int
+	// the memory of counts and of returned, below
+	mem := newMemoryReservation(tid)
+	if s.kind != UnionOp {
+		counts = make(map[any]int)
+		rightIter, err := planIterator(s.right, tid)
+		if err != nil {
+			return nil, err
+		}
+		for {
+			if err := checkCancelled(tid); err != nil {
+				return nil, err
+			}
+			t, err := rightIter()
+			if err != nil {
+				return nil, err
+			}
+			if t == nil {
+				break
+			}
+			key := t.tupleKey()
+			if counts[key] == 0 {
+				if err := mem.grow(tupleKeyMemorySize(t)); err != nil {
+					return nil, err
+				}
+			}
+			counts[key]++
+		}
+	}
+	iter, err := planIterator(s.left, tid)
+	if err != nil {
+		return nil, err
+	}
+	readingLeft := true
+	// the keys of the tuples returned, to remove duplicates
+	returned := make(map[any]bool)
+
+	return func() (*Tuple, error) {
+		for {
+			t, err := iter()
+			if err != nil {
+				return nil, err
+			}
+			if t == nil {
+				if !readingLeft || s.kind != UnionOp {
+					mem.release()
+					return nil, nil
+				}
+				readingLeft = false
+				if iter, err = planIterator(s.right, tid); err != nil {
+					return nil, err
+				}
+				continue
+			}
+
+			key := t.tupleKey()
+			keep := true
+			switch s.kind {
+			case IntersectOp:
+				keep = counts[key] > 0
+				if keep && s.all {
+					counts[key]--
+				}
+			case ExceptOp:
+				keep = counts[key] == 0
+				if !keep && s.all {
+					counts[key]--
+				}
+			}
+			if keep && !s.all {
+				if keep = !returned[key]; keep {
+					if err := mem.grow(tupleKeyMemorySize(t)); err != nil {
+						return nil, err
+					}
+				}
+				returned[key] = true
+			}
+			if keep {
+				return &Tuple{*s.desc, t.Fields, nil}, nil
+			}
+		}
+	}, nil
+}
+
+func (s *SetOp) Explain() (string, string) {
+	return s.name(), ""
+}
+
+func (s *SetOp) Children() []Operator {
+	return []Operator{s.left, s.right}
+}
@@ -0,0 +1,391 @@
+package godb
+
+// Parsing of set operations.  sqlparser knows UNION but not INTERSECT or
+// EXCEPT, so [parseSetOperations] splits a query at the set operators outside
+// of parentheses, parses each of the queries between them, and combines them
+// into sqlparser.Unions whose Type names the operation, e.g., "intersect
+// all".  Queries in parentheses that contain set operations, e.g., the
+// subqueries of a FROM or IN, are parsed in the same way, and replaced by a
+// placeholder query while the query around them is parsed by sqlparser.
+//
+// As in standard SQL, INTERSECT binds more tightly than UNION and EXCEPT,
+// which are evaluated from left to right, and an ORDER BY or LIMIT after the
+// last query applies to the result of the set operations.
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/xwb1989/sqlparser"
+)
+
+// The types of the sqlparser.Unions of the set operations sqlparser does not
+// know.
+const (
+	intersectStr    = "intersect"
+	intersectAllStr = "intersect all"
+	exceptStr       = "except"
+	exceptAllStr    = "except all"
+)
+
+var setOperatorRegexp = regexp.MustCompile(`(?i)^(union|intersect|except)(?:\s+(all|distinct))?\b`)
+
+var orderByOrLimitRegexp = regexp.MustCompile(`(?i)^(order\s+by|limit)\b`)
+
+var selectKeywordRegexp = regexp.MustCompile(`(?i)^select\b`)
+
+// a query, as opposed to, e.g., the expression of an IN list
+var queryStartRegexp = regexp.MustCompile(`(?is)^\s*(select\b|with\b|\()`)
+
+// The prefix of the column that the placeholder queries select.
+const setOpPlaceholder = "godb_set_operation_"
+
+// Return the offsets in query of the words outside of quoted strings and
+// parentheses.
+func topLevelWords(query string) []int {
+	var words []int
+	var quote byte
+	depth := 0
+	for i := 0; i < len(query); i++ {
+		ch := query[i]
+		switch {
+		case quote != 0:
+			if ch == '\\' && quote == '\'' {
+				i++
+			} else if ch == quote {
+				quote = 0
+			}
+		case ch == '\'' || ch == '"' || ch == '`':
+			quote = ch
+		case ch == '(':
+			depth++
+		case ch == ')':
+			depth--
+		case depth == 0 && isIdentByte(ch) && (i == 0 || !isIdentByte(query[i-1])):
+			words = append(words, i)
+		}
+	}
+	return words
+}
+
+// Return true if query has set operators outside of parentheses.
+func hasSetOperators(query string) bool {
+	for _, w := range topLevelWords(query) {
+		if setOperatorRegexp.MatchString(query[w:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Parse a statement that does not start with a WITH clause, and whose set
+// operations are written in standard SQL.
+func parseSetOperations(query string) (sqlparser.Statement, error) {
+	query = strings.TrimSpace(query)
+	if !queryStartRegexp.MatchString(query) {
+		return parseSetOperationSubqueries(query)
+	}
+	var queries []sqlparser.SelectStatement
+	var types []string
+	start := 0
+	for _, w := range topLevelWords(query) {
+		if w < start {
+			continue
+		}
+		m := setOperatorRegexp.FindStringSubmatch(query[w:])
+		if m == nil {
+			continue
+		}
+		sel, err := parseSetOperand(query[start:w])
+		if err != nil {
+			return nil, err
+		}
+		queries = append(queries, sel)
+		types = append(types, strings.ToLower(m[1]))
+		if strings.EqualFold(m[2], "all") {
+			types[len(types)-1] += " all"
+		}
+		start = w + len(m[0])
+	}
+	if len(types) == 0 {
+		if query[0] == '(' && closingParen(query, 1) == len(query)-1 {
+			sel, err := parseSetOperand(query[1 : len(query)-1])
+			if err != nil {
+				return nil, err
+			}
+			return &sqlparser.ParenSelect{Select: sel}, nil
+		}
+		return parseSetOperationSubqueries(query)
+	}
+
+	// an ORDER BY or LIMIT after the last query is that of the set operations
+	last, tail := query[start:], ""
+	for _, w := range topLevelWords(last) {
+		if orderByOrLimitRegexp.MatchString(last[w:]) {
+			last, tail = last[:w], last[w:]
+			break
+		}
+	}
+	sel, err := parseSetOperand(last)
+	if err != nil {
+		return nil, err
+	}
+	queries = append(queries, sel)
+
+	// combine the queries of each run of INTERSECTs, and then the results
+	// from left to right
+	terms := queries[:1]
+	var termTypes []string
+	for i, typ := range types {
+		if typ == intersectStr || typ == intersectAllStr {
+			terms[len(terms)-1] = &sqlparser.Union{Type: typ, Left: terms[len(terms)-1], Right: queries[i+1]}
+		} else {
+			terms = append(terms, queries[i+1])
+			termTypes = append(termTypes, typ)
+		}
+	}
+	result := terms[0]
+	for i, typ := range termTypes {
+		result = &sqlparser.Union{Type: typ, Left: result, Right: terms[i+1]}
+	}
+	u := result.(*sqlparser.Union)
+	if tail != "" {
+		stmt, err := parseRewritten("select 1 from dual " + tail)
+		if err != nil {
+			return nil, err
+		}
+		sel, ok := stmt.(*sqlparser.Select)
+		if !ok || sel.Lock != "" {
+			return nil, GoDBError{ParseError, fmt.Sprintf("unexpected %q after set operation", tail)}
+		}
+		u.OrderBy, u.Limit = sel.OrderBy, sel.Limit
+	}
+	return u, nil
+}
+
+// Parse a query of a set operation, or in parentheses.
+func parseSetOperand(query string) (sqlparser.SelectStatement, error) {
+	if strings.TrimSpace(query) == "" {
+		return nil, GoDBError{ParseError, "missing query in set operation"}
+	}
+	stmt, err := parseSQL(query)
+	if err != nil {
+		return nil, err
+	}
+	sel, ok := stmt.(sqlparser.SelectStatement)
+	if !ok {
+		return nil, GoDBError{ParseError, fmt.Sprintf("expected a query, got %q", strings.TrimSpace(query))}
+	}
+	return sel, nil
+}
+
+// Parse a statement with sqlparser.  The queries in it with set operations
+// -- those in parentheses, and the query of, e.g., an INSERT -- are parsed by
+// parseSetOperations and replaced by placeholder queries first, which are
+// replaced by the parsed queries again afterwards.
+func parseSetOperationSubqueries(query string) (sqlparser.Statement, error) {
+	var queries []sqlparser.SelectStatement
+	placeholder := func(text string) (string, error) {
+		sel, err := parseSetOperand(text)
+		if err != nil {
+			return "", err
+		}
+		queries = append(queries, sel)
+		return "select " + setOpPlaceholder + strconv.Itoa(len(queries)-1), nil
+	}
+	if !queryStartRegexp.MatchString(query) && hasSetOperators(query) {
+		for _, w := range topLevelWords(query) {
+			if selectKeywordRegexp.MatchString(query[w:]) {
+				p, err := placeholder(query[w:])
+				if err != nil {
+					return nil, err
+				}
+				query = query[:w] + p
+				break
+			}
+		}
+	}
+	var out strings.Builder
+	var quote byte
+	for i := 0; i < len(query); i++ {
+		ch := query[i]
+		switch {
+		case quote != 0:
+			if ch == '\\' && quote == '\'' && i+1 < len(query) {
+				out.WriteByte(ch)
+				i++
+				ch = query[i]
+			} else if ch == quote {
+				quote = 0
+			}
+		case ch == '\'' || ch == '"' || ch == '`':
+			quote = ch
+		case ch == '(':
+			end := closingParen(query, i+1)
+			if inner := query[i+1 : end]; end < len(query) && queryStartRegexp.MatchString(inner) && hasSetOperators(inner) {
+				p, err := placeholder(inner)
+				if err != nil {
+					return nil, err
+				}
+				out.WriteString("(" + p + ")")
+				i = end
+				continue
+			}
+		}
+		out.WriteByte(ch)
+	}
+	stmt, err := parseRewritten(out.String())
+	if err != nil || len(queries) == 0 {
+		return stmt, err
+	}
+
+	filled := 0
+	fill := func(sel sqlparser.SelectStatement) sqlparser.SelectStatement {
+		if i := placeholderIndex(sel); i >= 0 && i < len(queries) {
+			filled++
+			return queries[i]
+		}
+		return sel
+	}
+	sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		switch node := node.(type) {
+		case *sqlparser.Subquery:
+			node.Select = fill(node.Select)
+		case *sqlparser.ParenSelect:
+			node.Select = fill(node.Select)
+		case *sqlparser.Insert:
+			if sel, ok := node.Rows.(sqlparser.SelectStatement); ok {
+				node.Rows = fill(sel)
+			}
+		}
+		return true, nil
+	}, stmt)
+	if filled != len(queries) {
+		return nil, GoDBError{ParseError, "set operations are not supported here: " + query}
+	}
+	return stmt, nil
+}
+
+// Return the index of the query that sel is the placeholder of, or -1 if it
+// is not a placeholder.
+func placeholderIndex(sel sqlparser.SelectStatement) int {
+	s, ok := sel.(*sqlparser.Select)
+	if !ok || len(s.SelectExprs) != 1 {
+		return -1
+	}
+	e, ok := s.SelectExprs[0].(*sqlparser.AliasedExpr)
+	if !ok {
+		return -1
+	}
+	col, ok := e.Expr.(*sqlparser.ColName)
+	if !ok || !col.Qualifier.IsEmpty() {
+		return -1
+	}
+	i, err := strconv.Atoi(strings.TrimPrefix(col.Name.String(), setOpPlaceholder))
+	if err != nil || !strings.HasPrefix(col.Name.String(), setOpPlaceholder) {
+		return -1
+	}
+	return i
+}
+
+// Return the set operation of u, and whether it keeps duplicate rows.
+func setOpOf(u *sqlparser.Union) (SetOpKind, bool) {
+	kind := UnionOp
+	switch u.Type {
+	case intersectStr, intersectAllStr:
+		kind = IntersectOp
+	case exceptStr, exceptAllStr:
+		kind = ExceptOp
+	}
+	return kind, strings.HasSuffix(u.Type, " all")
+}
+
+// A set operation of a logical plan.
+type LogicalSetOp struct {
+	kind        SetOpKind
+	all         bool
+	left, right *LogicalPlan
+}
+
+// Parse a query, which may be a SELECT, a parenthesized query, a set
+// operation or a query with a WITH clause.
+func parseQuery(c *Catalog, stmt sqlparser.SelectStatement) (*LogicalPlan, error) {
+	return parseQueryIn(c, nil, stmt)
+}
+
+// Parse a query, with the CTEs of ctes in scope.
+func parseQueryIn(c *Catalog, ctes cteScope, stmt sqlparser.SelectStatement) (*LogicalPlan, error) {
+	switch stmt := stmt.(type) {
+	case *sqlparser.Select:
+		return parseStatement(c, ctes, stmt)
+	case *sqlparser.ParenSelect:
+		return parseQueryIn(c, ctes, stmt.Select)
+	case *sqlparser.Union:
+		return parseSetOp(c, ctes, stmt)
+	case *withQuery:
+		return parseWithQuery(c, ctes, stmt)
+	}
+	return nil, GoDBError{ParseError, "unsupported query " + sqlparser.String(stmt)}
+}
+
+// Parse a set operation.  The plan's select list, tables and subqueries are
+// those of its left query, which name its columns.
+func parseSetOp(c *Catalog, ctes cteScope, u *sqlparser.Union) (*LogicalPlan, error) {
+	left, err := parseQueryIn(c, ctes, u.Left)
+	if err != nil {
+		return nil, err
+	}
+	right, err := parseQueryIn(c, ctes, u.Right)
+	if err != nil {
+		return nil, err
+	}
+	orderBys, err := parseOrderBy(c, u.OrderBy)
+	if err != nil {
+		return nil, err
+	}
+	limit, err := parseLimit(c, u.Limit)
+	if err != nil {
+		return nil, err
+	}
+	kind, all := setOpOf(u)
+	return &LogicalPlan{
+		selects:       left.selects,
+		tables:        left.tables,
+		subqueries:    left.subqueries,
+		orderByFields: orderBys,
+		limit:         limit,
+		setOp:         &LogicalSetOp{kind, all, left, right},
+	}, nil
+}
+
+// Make the physical plan of a set operation, followed by its ORDER BY and
+// LIMIT.  The cardinality of the result is estimated as that of the largest
+// result the operation could have.
+func makeSetOpPlan(c *Catalog, plan *LogicalPlan) (*OperatorCard, error) {
+	left, err := makePhysicalPlan(c, plan.setOp.left)
+	if err != nil {
+		return nil, err
+	}
+	right, err := makePhysicalPlan(c, plan.setOp.right)
+	if err != nil {
+		return nil, err
+	}
+	op, err := NewSetOp(plan.setOp.kind, plan.setOp.all, left, right)
+	if err != nil {
+		return nil, err
+	}
+	var card int
+	switch op.kind {
+	case UnionOp:
+		card = left.Cardinality + right.Cardinality
+	case IntersectOp:
+		card = min(left.Cardinality, right.Cardinality)
+	case ExceptOp:
+		card = left.Cardinality
+	}
+	topOp := NewOperatorCard(op, card)
+	tableMap := map[string]*PlanNode{"": {topOp, op.Descriptor()}}
+	return planOrderByAndLimit(c, plan, topOp, tableMap)
+}
@@ -0,0 +1,177 @@
+package godb
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/xwb1989/sqlparser"
+)
+
+// Return the set operations of stmt, parenthesized, e.g.,
+// "(select a from t union (select a from u intersect select a from v))".
+func setOpString(stmt sqlparser.SQLNode) string {
+	switch stmt := stmt.(type) {
+	case *sqlparser.Union:
+		return "(" + setOpString(stmt.Left) + " " + stmt.Type + " " + setOpString(stmt.Right) + ")" + sqlparser.String(stmt.OrderBy) + sqlparser.String(stmt.Limit)
+	case *sqlparser.ParenSelect:
+		return setOpString(stmt.Select)
+	}
+	return sqlparser.String(stmt)
+}
+
+func TestParseSetOperations(t *testing.T) {
+	for _, c := range []struct{ query, want string }{
+		{"select a from t intersect select a from u", "(select a from t intersect select a from u)"},
+		{"select a from t EXCEPT ALL (Select a from u)", "(select a from t except all select a from u)"},
+		{"select a from t except distinct select a from u", "(select a from t except select a from u)"},
+		// INTERSECT binds more tightly than UNION and EXCEPT
+		{"select a from t union select a from u intersect select a from v",
+			"(select a from t union (select a from u intersect select a from v))"},
+		{"select a from t intersect select a from u except all select a from v intersect all select a from w union select a from x",
+			"(((select a from t intersect select a from u) except all (select a from v intersect all select a from w)) union select a from x)"},
+		{"(select a from t union select a from u) intersect select a from v",
+			"((select a from t union select a from u) intersect select a from v)"},
+		// an ORDER BY or LIMIT after the last query is that of the result
+		{"select a from t union select a from u order by a desc limit 2",
+			"(select a from t union select a from u) order by a desc limit 2"},
+		{"select a from t union (select a from u order by a limit 2)",
+			"(select a from t union select a from u order by a asc limit 2)"},
+		{"select a from t where a in (select a from u except select a from v)",
+			"select a from t where a in (select a from u except select a from v)"},
+		{"select x.a from (select a from t intersect select a from u) as x, (select b from w) as y",
+			"select x.a from (select a from t intersect select a from u) as x, (select b from w) as y"},
+		{"insert into t select a from u union all select a from v",
+			"insert into t select a from u union all select a from v"},
+		{"select 'x intersect select' from t", "select 'x intersect select' from t"},
+		{`select 'it\'s (select a union' from t`, `select 'it\'s (select a union' from t`},
+		{"select a as exceptional from intersection", "select a as exceptional from intersection"},
+	} {
+		stmt, err := parseSQL(c.query)
+		if err != nil {
+			t.Errorf("parsing %q failed, %s", c.query, err.Error())
+			continue
+		}
+		if got := setOpString(stmt); got != c.want {
+			t.Errorf("parsing %q: expected %q, got %q", c.query, c.want, got)
+		}
+	}
+	for _, q := range []string{"select a from t union", "intersect select a from t", "select a from t union delete from u"} {
+		if _, err := parseSQL(q); err == nil {
+			t.Errorf("parsing %q: expected an error", q)
+		}
+	}
+}
+
+func TestSetOperations(t *testing.T) {
+	s := makeBatchTestSession(t, 30)
+	// emp has names e0 to e29, with dept i%10 and salary 7i
+	for _, c := range []struct {
+		query string
+		want  []string
+	}{
+		{"select dept from emp where dept < 3 union select id from dept where id > 1 and id < 5",
+			[]string{"0", "1", "2", "3", "4"}},
+		{"select dept from emp where dept < 2 union all select id from dept where id < 2",
+			[]string{"0", "0", "0", "0", "1", "1", "1", "1"}},
+		{"select dept from emp where dept < 4 intersect select id from dept where id > 1",
+			[]string{"2", "3"}},
+		{"select dept from emp where dept < 4 except select id from dept where id > 1",
+			[]string{"0", "1"}},
+		{"select dept from emp where dept < 2 intersect all select dept from emp where dept = 1 and salary < 100",
+			[]string{"1", "1"}},
+		{"select dept from emp where dept < 2 except all select dept from emp where dept = 1 and salary < 100",
+			[]string{"0", "0", "0", "1"}},
+		{"select name, salary from emp where dept = 1 except select name, salary from emp where salary > 100",
+			[]string{"e1,7", "e11,77"}},
+		// UNION and EXCEPT are evaluated from left to right, INTERSECT first
+		{"select id from dept where id < 3 union select id from dept where id = 5 except select id from dept where id = 0",
+			[]string{"1", "2", "5"}},
+		{"select id from dept where id < 3 union select id from dept where id > 6 intersect select id from dept where id = 8",
+			[]string{"0", "1", "2", "8"}},
+		{"select id from dept where id < 5 except select id from dept where id < 3 intersect select id from dept where id > 1",
+			[]string{"0", "1", "3", "4"}},
+		{"select count(*) from (select id from dept where id < 2 union select id from dept where id > 7 intersect select dept from emp where dept = 9) u",
+			[]string{"3"}},
+		{"select id from dept where id < 3 union (select id from dept where id > 5 except select id from dept where id > 6)",
+			[]string{"0", "1", "2", "6"}},
+		{"select dept as d from emp where dept > 7 union select id from dept where id < 2 order by d desc",
+			[]string{"9", "8", "1", "0"}},
+		{"select count(*) from (select dept from emp union select id from dept) u",
+			[]string{"10"}},
+		{"select u.dname from (select dname from dept where id < 2 union all select name from emp where salary = 14) u order by u.dname",
+			[]string{"d0", "d1", "e2"}},
+	} {
+		res, err := s.Execute(c.query)
+		if err != nil {
+			t.Fatalf("%s failed, %s", c.query, err.Error())
+		}
+		got := rowStrings(res.Tuples)
+		if !strings.Contains(c.query, "order by") {
+			// the rows are returned in no particular order
+			sort.Strings(got)
+			sort.Strings(c.want)
+		}
+		if strings.Join(got, ";") != strings.Join(c.want, ";") {
+			t.Errorf("%s: expected %v, got %v", c.query, c.want, got)
+		}
+	}
+
+	for _, q := range []string{
+		"select name, dept from emp union select id from dept",
+		"select name from emp intersect select id from dept",
+	} {
+		_, err := s.Execute(q)
+		if err == nil {
+			t.Errorf("%s: expected an error", q)
+		} else if gerr, ok := err.(GoDBError); !ok || gerr.code != TypeMismatchError {
+			t.Errorf("%s: expected a type mismatch error, got %v", q, err)
+		}
+	}
+}
+
+func TestSetOperationPlans(t *testing.T) {
+	s := makeBatchTestSession(t, 200)
+	q := "select name, dept from emp where salary < 500 union select name, dept from emp where dept = 3 except select name, dept from emp where salary < 100"
+	want := rowStrings(runVectorized(t, s, q, false))
+	for _, workers := range []int{1, 4} {
+		if _, err := s.Execute(fmt.Sprintf("set parallel_workers = %d", workers)); err != nil {
+			t.Fatalf("set failed, %s", err.Error())
+		}
+		got := rowStrings(runVectorized(t, s, q, true))
+		sort.Strings(got)
+		sort.Strings(want)
+		if strings.Join(got, ";") != strings.Join(want, ";") {
+			t.Errorf("with %d workers, expected the same rows as without batches", workers)
+		}
+	}
+
+	_, plan, err := Parse(s.catalog, q)
+	if err != nil {
+		t.Fatalf("planning failed, %s", err.Error())
+	}
+	var out strings.Builder
+	OutputPhysicalPlan(func(format string, a ...any) { fmt.Fprintf(&out, format, a...) }, plan, "")
+	if !strings.HasPrefix(out.String(), "EXCEPT, card:") || !strings.Contains(out.String(), "\tUNION, card:") {
+		t.Errorf("expected an EXCEPT of a UNION, got\n%s", out.String())
+	}
+
+	s = makeBatchTestSession(t, 30)
+	stmt, err := s.Prepare("select dept from emp where salary < $1 except select id from dept where id > $2")
+	if err != nil {
+		t.Fatalf("prepare failed, %s", err.Error())
+	}
+	res, err := s.ExecuteStmt(stmt, IntField{50}, IntField{3})
+	if err != nil {
+		t.Fatalf("execute failed, %s", err.Error())
+	}
+	got := rowStrings(res.Tuples)
+	sort.Strings(got)
+	if strings.Join(got, ";") != "0;1;2;3" {
+		t.Errorf("expected depts 0 to 3, got %v", got)
+	}
+	if desc := res.Desc; len(desc.Fields) != 1 || desc.Fields[0].Fname != "dept" {
+		t.Errorf("expected a dept column, got %v", desc)
+	}
+}
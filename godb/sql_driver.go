@@ -0,0 +1,325 @@
+package godb
+
+// A database/sql driver for embedding GoDB in Go programs:
+//
+//	db, err := sql.Open("godb", "path/to/catalog.txt")
+//	rows, err := db.Query("select name, age from t where age > ?", 30)
+//
+// The data source name is the path of a catalog file.  All connections opened
+// with the same data source name share one [Catalog] and [BufferPool]; each
+// connection has its own [Session].  Statements are prepared with [Prepare];
+// their arguments are written as ? or $n in the SQL text and may be integers,
+// strings, byte slices, or booleans.  Statements run with a context, e.g. with
+// QueryContext, are cancelled when the context is.
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sync"
+)
+
+// Number of buffer pool pages used for databases opened through the driver.
+var DriverBufferPoolPages = 10000
+
+type Driver struct {
+	mutex sync.Mutex
+	dbs   map[string]*driverDB
+}
+
+// The catalog and buffer pool shared by the connections to one database.
+type driverDB struct {
+	catalog *Catalog
+	bp      *BufferPool
+	conns   int
+}
+
+func init() {
+	sql.Register("godb", &Driver{dbs: make(map[string]*driverDB)})
+}
+
+// Open a new connection to the database whose catalog is at path dsn.
+func (d *Driver) Open(dsn string) (driver.Conn, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	db, ok := d.dbs[dsn]
+	if !ok {
+		bp, err := NewBufferPool(DriverBufferPoolPages)
+		if err != nil {
+			return nil, err
+		}
+		c, err := NewCatalogFromFile(filepath.Base(dsn), bp, filepath.Dir(dsn))
+		if err != nil {
+			return nil, err
+		}
+		db = &driverDB{catalog: c, bp: bp}
+		d.dbs[dsn] = db
+	}
+	db.conns++
+	return &driverConn{d, dsn, db, NewSession(db.catalog, db.bp)}, nil
+}
+
+// Called when a connection closes; once the last connection to a database
+// is closed its pages are flushed and its state is forgotten, so that a later
+// Open rereads the catalog.
+func (d *Driver) release(dsn string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	db := d.dbs[dsn]
+	if db == nil {
+		return
+	}
+	db.conns--
+	if db.conns == 0 {
+		db.bp.FlushAllPages()
+		delete(d.dbs, dsn)
+	}
+}
+
+type driverConn struct {
+	driver  *Driver
+	dsn     string
+	db      *driverDB
+	session *Session
+}
+
+func (c *driverConn) Prepare(query string) (driver.Stmt, error) {
+	stmt, err := c.session.Prepare(query)
+	if err != nil {
+		// statements without arguments that cannot be prepared, such as
+		// DDL, are run from their text
+		if countPlaceholders(query) > 0 || countParams(query) > 0 {
+			return nil, err
+		}
+		return &driverStmt{conn: c, query: query}, nil
+	}
+	return &driverStmt{conn: c, query: query, stmt: stmt}, nil
+}
+
+func (c *driverConn) Close() error {
+	if c.session == nil {
+		return nil
+	}
+	c.session.Close()
+	c.session = nil
+	c.driver.release(c.dsn)
+	return nil
+}
+
+func (c *driverConn) Begin() (driver.Tx, error) {
+	if _, err := c.session.Execute("begin"); err != nil {
+		return nil, err
+	}
+	return &driverTx{c}, nil
+}
+
+type driverTx struct {
+	conn *driverConn
+}
+
+func (tx *driverTx) Commit() error {
+	res, err := tx.conn.session.Execute("commit")
+	if err == nil && res.Tag == "ROLLBACK" {
+		// a statement of the transaction failed, so it was rolled back
+		return GoDBError{IllegalTransactionError, "transaction was rolled back, because one of its statements failed"}
+	}
+	return err
+}
+
+func (tx *driverTx) Rollback() error {
+	_, err := tx.conn.session.Execute("rollback")
+	return err
+}
+
+type driverStmt struct {
+	conn  *driverConn
+	query string
+	stmt  *Stmt // nil if the statement could not be prepared
+}
+
+func (s *driverStmt) Close() error {
+	return nil
+}
+
+func (s *driverStmt) NumInput() int {
+	if s.stmt == nil {
+		return 0
+	}
+	return s.stmt.NumParams()
+}
+
+func (s *driverStmt) run(ctx context.Context, args []driver.Value) (*Result, error) {
+	if s.stmt == nil {
+		return s.conn.session.ExecuteContext(ctx, s.query)
+	}
+	vals, err := driverArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	return s.conn.session.ExecuteStmtContext(ctx, s.stmt, vals...)
+}
+
+func (s *driverStmt) Exec(args []driver.Value) (driver.Result, error) {
+	res, err := s.run(context.Background(), args)
+	if err != nil {
+		return nil, err
+	}
+	return driverResult{res}, nil
+}
+
+func (s *driverStmt) Query(args []driver.Value) (driver.Rows, error) {
+	res, err := s.run(context.Background(), args)
+	if err != nil {
+		return nil, err
+	}
+	return &driverRows{res: res}, nil
+}
+
+func (s *driverStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	res, err := s.run(ctx, values(args))
+	if err != nil {
+		return nil, err
+	}
+	return driverResult{res}, nil
+}
+
+func (s *driverStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	res, err := s.run(ctx, values(args))
+	if err != nil {
+		return nil, err
+	}
+	return &driverRows{res: res}, nil
+}
+
+// Return the values of args, which are positional; GoDB has no named
+// parameters.
+func values(args []driver.NamedValue) []driver.Value {
+	vals := make([]driver.Value, len(args))
+	for i, arg := range args {
+		vals[i] = arg.Value
+	}
+	return vals
+}
+
+type driverResult struct {
+	res *Result
+}
+
+func (r driverResult) LastInsertId() (int64, error) {
+	return 0, GoDBError{IllegalOperationError, "GoDB does not support LastInsertId"}
+}
+
+// The number of rows inserted or deleted, taken from the count tuple that
+// InsertOp and DeleteOp return.
+func (r driverResult) RowsAffected() (int64, error) {
+	switch r.res.Tag[0] {
+	case 'I', 'D':
+		if len(r.res.Tuples) == 1 {
+			if f, ok := r.res.Tuples[0].Fields[0].(IntField); ok {
+				return f.Value, nil
+			}
+		}
+	}
+	return 0, nil
+}
+
+type driverRows struct {
+	res *Result
+	pos int
+}
+
+func (r *driverRows) Columns() []string {
+	if r.res.Desc == nil {
+		return nil
+	}
+	cols := make([]string, len(r.res.Desc.Fields))
+	for i, f := range r.res.Desc.Fields {
+		cols[i] = f.Fname
+	}
+	return cols
+}
+
+func (r *driverRows) ColumnTypeDatabaseTypeName(index int) string {
+	switch r.res.Desc.Fields[index].Ftype {
+	case IntType:
+		return "INT"
+	case StringType:
+		return "STRING"
+	}
+	return ""
+}
+
+func (r *driverRows) Close() error {
+	r.pos = len(r.res.Tuples)
+	return nil
+}
+
+func (r *driverRows) Next(dest []driver.Value) error {
+	if r.pos >= len(r.res.Tuples) {
+		return io.EOF
+	}
+	t := r.res.Tuples[r.pos]
+	r.pos++
+	for i, f := range t.Fields {
+		switch f := f.(type) {
+		case IntField:
+			dest[i] = f.Value
+		case StringField:
+			dest[i] = f.Value
+		case nil:
+			dest[i] = nil
+		}
+	}
+	return nil
+}
+
+// Return the number of ? placeholders in query that are outside of quoted
+// strings.  As for sqlparser, a backslash escapes the next byte of a string.
+func countPlaceholders(query string) int {
+	n := 0
+	var quote byte
+	for i := 0; i < len(query); i++ {
+		ch := query[i]
+		switch {
+		case quote != 0:
+			if ch == '\\' && quote == '\'' {
+				i++
+			} else if ch == quote {
+				quote = 0
+			}
+		case ch == '\'' || ch == '"' || ch == '`':
+			quote = ch
+		case ch == '?':
+			n++
+		}
+	}
+	return n
+}
+
+// Convert database/sql arguments to GoDB values.
+func driverArgs(args []driver.Value) ([]DBValue, error) {
+	vals := make([]DBValue, len(args))
+	for i, arg := range args {
+		switch v := arg.(type) {
+		case int64:
+			vals[i] = IntField{v}
+		case bool:
+			vals[i] = IntField{0}
+			if v {
+				vals[i] = IntField{1}
+			}
+		case string:
+			vals[i] = StringField{v}
+		case []byte:
+			vals[i] = StringField{string(v)}
+		case nil:
+			return nil, GoDBError{IllegalOperationError, "GoDB does not support NULL arguments"}
+		default:
+			return nil, GoDBError{TypeMismatchError, fmt.Sprintf("unsupported argument type %T", arg)}
+		}
+	}
+	return vals, nil
+}
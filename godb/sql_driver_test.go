@@ -0,0 +1,160 @@
+package godb
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"testing"
+)
+
+func openTestDriverDB(t *testing.T) *sql.DB {
+	t.Helper()
+	// recreate empty table files for the tables in catalog.txt
+	if _, _, err := MakeTestDatabase(10, "catalog.txt"); err != nil {
+		t.Fatalf("failed to create test database, %s", err.Error())
+	}
+	db, err := sql.Open("godb", "catalog.txt")
+	if err != nil {
+		t.Fatalf("failed to open database, %s", err.Error())
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestDriverExecQuery(t *testing.T) {
+	db := openTestDriverDB(t)
+
+	res, err := db.Exec("insert into t values (?, ?), (?, ?)", "sam", 25, "o'neil", 40)
+	if err != nil {
+		t.Fatalf("insert failed, %s", err.Error())
+	}
+	if n, _ := res.RowsAffected(); n != 2 {
+		t.Errorf("expected 2 rows affected, got %d", n)
+	}
+
+	rows, err := db.Query("select name, age from t where age > ?", 30)
+	if err != nil {
+		t.Fatalf("query failed, %s", err.Error())
+	}
+	defer rows.Close()
+	cols, _ := rows.Columns()
+	if len(cols) != 2 || cols[0] != "name" || cols[1] != "age" {
+		t.Errorf("unexpected columns %v", cols)
+	}
+	n := 0
+	for rows.Next() {
+		var name string
+		var age int
+		if err := rows.Scan(&name, &age); err != nil {
+			t.Fatalf("scan failed, %s", err.Error())
+		}
+		if name != "o'neil" || age != 40 {
+			t.Errorf("unexpected row (%s, %d)", name, age)
+		}
+		n++
+	}
+	if n != 1 {
+		t.Errorf("expected 1 row, got %d", n)
+	}
+
+	var count int64
+	if err := db.QueryRow("select count(*) from t").Scan(&count); err != nil {
+		t.Fatalf("count failed, %s", err.Error())
+	}
+	if count != 2 {
+		t.Errorf("expected 2 rows, got %d", count)
+	}
+
+	var ages []sql.NullInt64
+	rows, err = db.Query("select name, nullif(age, 25) from t order by name")
+	if err != nil {
+		t.Fatalf("query failed, %s", err.Error())
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		var age sql.NullInt64
+		if err := rows.Scan(&name, &age); err != nil {
+			t.Fatalf("scan failed, %s", err.Error())
+		}
+		ages = append(ages, age)
+	}
+	if len(ages) != 2 || ages[0] != (sql.NullInt64{Int64: 40, Valid: true}) || ages[1].Valid {
+		t.Errorf("expected ages 40 and NULL, got %v", ages)
+	}
+
+	if _, err := db.Query("select nosuchfield from t"); err == nil {
+		t.Errorf("expected an error for a missing field")
+	}
+}
+
+func TestDriverTransactions(t *testing.T) {
+	db := openTestDriverDB(t)
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("begin failed, %s", err.Error())
+	}
+	if _, err := tx.Exec("insert into t2 values ('amy', 7)"); err != nil {
+		t.Fatalf("insert failed, %s", err.Error())
+	}
+	var name string
+	if err := tx.QueryRow("select name from t2 where age = 7").Scan(&name); err != nil || name != "amy" {
+		t.Errorf("transaction does not see its own insert (%v)", err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatalf("rollback failed, %s", err.Error())
+	}
+	if err := db.QueryRow("select name from t2 where age = 7").Scan(&name); err != sql.ErrNoRows {
+		t.Errorf("rolled back insert is visible (%v)", err)
+	}
+
+	tx, _ = db.Begin()
+	tx.Exec("insert into t2 values ('bob', 8)")
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("commit failed, %s", err.Error())
+	}
+	if err := db.QueryRow("select name from t2 where age = 8").Scan(&name); err != nil || name != "bob" {
+		t.Errorf("committed insert is not visible (%v)", err)
+	}
+
+	// committing a transaction with a failed statement rolls it back
+	tx, _ = db.Begin()
+	tx.Exec("insert into t2 values ('cy', 9)")
+	if _, err := tx.Exec("select nosuchfield from t2"); err == nil {
+		t.Fatalf("expected an error for a missing field")
+	}
+	if err := tx.Commit(); err == nil {
+		t.Errorf("expected commit of a failed transaction to fail")
+	}
+	if err := db.QueryRow("select name from t2 where age = 9").Scan(&name); err != sql.ErrNoRows {
+		t.Errorf("insert of failed transaction is visible (%v)", err)
+	}
+}
+
+func TestDriverArgs(t *testing.T) {
+	q := "select '?' from t where a = ? and b = ?"
+	if n := countPlaceholders(q); n != 2 {
+		t.Errorf("expected 2 placeholders, got %d", n)
+	}
+	// sqlparser reads a backslash in a string as an escape
+	q = `select name from t where name <> 'it\'s' and age = ?`
+	if n := countPlaceholders(q); n != 1 {
+		t.Errorf("expected 1 placeholder, got %d", n)
+	}
+	db := openTestDriverDB(t)
+	rows, err := db.Query(q, 3)
+	if err != nil {
+		t.Fatalf("query failed, %s", err.Error())
+	}
+	rows.Close()
+	vals, err := driverArgs([]driver.Value{int64(3), "x'y", true})
+	if err != nil {
+		t.Fatalf("failed to convert arguments, %s", err.Error())
+	}
+	if vals[0] != (IntField{3}) || vals[1] != (StringField{"x'y"}) || vals[2] != (IntField{1}) {
+		t.Errorf("unexpected values %v", vals)
+	}
+	if _, err := driverArgs([]driver.Value{nil}); err == nil {
+		t.Errorf("expected an error for a NULL argument")
+	}
+}
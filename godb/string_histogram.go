@@ -0,0 +1,105 @@
+package godb
+
+import (
+	"math/rand"
+	"sort"
+)
+
+// The most values of a column that are kept to build its histogram; larger
+// tables are sampled.
+const maxHistogramSample = 10000
+
+// The selectivity of a pattern match whose selectivity cannot be estimated
+// from a histogram, e.g. one with a leading wildcard.
+const defaultMatchSelectivity = 0.1
+
+// An equi-depth histogram of the values of a string column.
+type stringHistogram struct {
+	min string
+	// the largest value in each bucket; each bucket holds about the same
+	// number of values
+	bounds   []string
+	distinct int
+}
+
+// Build a histogram with up to NumHistBins buckets from a sample of the values
+// of a column.  The values are sorted in place.
+func newStringHistogram(values []string) *stringHistogram {
+	if len(values) == 0 {
+		return nil
+	}
+	sort.Strings(values)
+	bins := min(NumHistBins, len(values))
+	h := &stringHistogram{min: values[0], bounds: make([]string, bins)}
+	for i := range h.bounds {
+		h.bounds[i] = values[(i+1)*len(values)/bins-1]
+	}
+	for i, v := range values {
+		if i == 0 || v != values[i-1] {
+			h.distinct++
+		}
+	}
+	return h
+}
+
+// Return the estimated fraction of values less than s.
+func (h *stringHistogram) fractionBelow(s string) float64 {
+	if s <= h.min {
+		return 0
+	}
+	// the buckets before i hold only values less than s; assume half of the
+	// values in bucket i are
+	i := sort.SearchStrings(h.bounds, s)
+	if i == len(h.bounds) {
+		return 1
+	}
+	return (float64(i) + 0.5) / float64(len(h.bounds))
+}
+
+// Return the estimated fraction of values that start with prefix.
+func (h *stringHistogram) prefixSelectivity(prefix string) float64 {
+	end := []byte(prefix)
+	for len(end) > 0 && end[len(end)-1] == 0xff {
+		end = end[:len(end)-1]
+	}
+	if len(end) == 0 {
+		return 1
+	}
+	end[len(end)-1]++
+	sel := h.fractionBelow(string(end)) - h.fractionBelow(prefix)
+	// a prefix within a single bucket matches at least one value in it
+	return max(sel, 1/float64(h.distinct))
+}
+
+// Estimate the selectivity of a LIKE pattern: an exact match if it has no
+// wildcards, or the fraction of values with its literal prefix.
+func (h *stringHistogram) likeSelectivity(pattern string) float64 {
+	prefix, exact := likePrefix(pattern, defaultLikeEscape)
+	switch {
+	case exact:
+		return 1 / float64(h.distinct)
+	case prefix == "":
+		return defaultMatchSelectivity
+	}
+	return h.prefixSelectivity(prefix)
+}
+
+// Collects a uniform sample of the values of a column as they are scanned.
+type columnSample struct {
+	values []string
+	seen   int
+	rand   *rand.Rand
+}
+
+func newColumnSample() *columnSample {
+	return &columnSample{rand: rand.New(rand.NewSource(1))}
+}
+
+func (s *columnSample) add(v string) {
+	s.seen++
+	if len(s.values) < maxHistogramSample {
+		s.values = append(s.values, v)
+	} else if i := s.rand.Intn(s.seen); i < maxHistogramSample {
+		s.values[i] = v
+	}
+}
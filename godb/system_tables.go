@@ -0,0 +1,246 @@
+package godb
+
+// System tables.  Each catalog has a few read-only tables that describe the
+// database, so that tools can introspect it with plain SELECTs:
+//
+//	godb_tables(table_name, table_id, num_columns, num_pages, file_name, storage)
+//	godb_columns(table_name, column_name, ordinal_position, data_type, not_null)
+//	godb_constraints(table_name, constraint_name, constraint_type, columns, definition)
+//	godb_stats(table_name, num_pages, num_tuples)
+//	godb_views(view_name, materialized, definition)
+//	godb_transactions(tid, phase, num_pages, num_dirty_pages)
+//
+// Their rows are computed from the catalog, the tables' statistics and the
+// buffer pool each time they are scanned.  System tables are found by name
+// like any other table, but they are not saved in the catalog file and
+// cannot be dropped, altered, or modified.  Columns that have no value (e.g.,
+// the number of tuples of a table whose statistics have not been computed)
+// are -1.
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// A read-only table whose rows are computed when it is scanned.
+type systemTable struct {
+	name string
+	desc TupleDesc
+	rows func() [][]DBValue
+}
+
+type systemPageKey struct {
+	name string
+	pgNo int
+}
+
+func (st *systemTable) NumPages() int {
+	return 0
+}
+
+func (st *systemTable) insertTuple(t *Tuple, tid TransactionID) error {
+	return GoDBError{IllegalOperationError, fmt.Sprintf("table %s is read-only", st.name)}
+}
+
+func (st *systemTable) deleteTuple(t *Tuple, tid TransactionID) error {
+	return GoDBError{IllegalOperationError, fmt.Sprintf("table %s is read-only", st.name)}
+}
+
+func (st *systemTable) readPage(pageNo int) (Page, error) {
+	return nil, GoDBError{IllegalOperationError, fmt.Sprintf("table %s has no pages", st.name)}
+}
+
+func (st *systemTable) flushPage(page Page) error {
+	return nil
+}
+
+func (st *systemTable) pageKey(pgNo int) any {
+	return systemPageKey{st.name, pgNo}
+}
+
+// Return a copy of the descriptor, since the planner sets its table alias.
+func (st *systemTable) Descriptor() *TupleDesc {
+	return st.desc.copy()
+}
+
+func (st *systemTable) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	rows := st.rows()
+	desc := st.desc.copy()
+	i := 0
+	return func() (*Tuple, error) {
+		if i >= len(rows) {
+			return nil, nil
+		}
+		t := &Tuple{Desc: *desc, Fields: rows[i], Rid: i}
+		i++
+		return t, nil
+	}, nil
+}
+
+func newSystemTable(name string, columns string, rows func() [][]DBValue) *Table {
+	var desc TupleDesc
+	for _, col := range strings.Split(columns, ",") {
+		fname, typ, _ := strings.Cut(strings.TrimSpace(col), " ")
+		ftype := IntType
+		if typ == "string" {
+			ftype = StringType
+		}
+		desc.Fields = append(desc.Fields, FieldType{fname, "", ftype})
+	}
+	return &Table{id: -1, name: name, desc: desc, file: &systemTable{name, desc, rows}}
+}
+
+// Make the system tables of catalog c.
+func (c *Catalog) makeSystemTables() map[string]*Table {
+	tables := []*Table{
+		newSystemTable("godb_tables",
+			"table_name string, table_id int, num_columns int, num_pages int, file_name string, storage string",
+			func() [][]DBValue {
+				var rows [][]DBValue
+				for _, t := range c.userTables() {
+					rows = append(rows, []DBValue{StringField{t.name}, IntField{int64(t.id)},
+						IntField{int64(len(t.desc.Fields))}, IntField{int64(t.file.NumPages())}, StringField{c.tableFileName(t)},
+						StringField{t.storage()}})
+				}
+				return rows
+			}),
+		newSystemTable("godb_columns",
+			"table_name string, column_name string, ordinal_position int, data_type string, not_null int",
+			func() [][]DBValue {
+				var rows [][]DBValue
+				for _, t := range c.userTables() {
+					for i, f := range t.desc.Fields {
+						notNull := int64(0)
+						for _, con := range t.constraints {
+							if (con.kind == notNullConstraint || con.kind == primaryKeyConstraint) && contains(con.columns, f.Fname) {
+								notNull = 1
+							}
+						}
+						rows = append(rows, []DBValue{StringField{t.name}, StringField{f.Fname},
+							IntField{int64(i + 1)}, StringField{f.Ftype.String()}, IntField{notNull}})
+					}
+				}
+				return rows
+			}),
+		newSystemTable("godb_constraints",
+			"table_name string, constraint_name string, constraint_type string, columns string, definition string",
+			func() [][]DBValue {
+				var rows [][]DBValue
+				for _, t := range c.userTables() {
+					for _, con := range t.constraints {
+						rows = append(rows, []DBValue{StringField{t.name}, StringField{con.name},
+							StringField{constraintTypes[con.kind]}, StringField{strings.Join(con.columns, ", ")}, StringField{con.definition()}})
+					}
+				}
+				return rows
+			}),
+		newSystemTable("godb_stats",
+			"table_name string, num_pages int, num_tuples int",
+			func() [][]DBValue {
+				var rows [][]DBValue
+				for _, t := range c.userTables() {
+					pages, tuples := int64(t.file.NumPages()), int64(-1)
+					if t.stats != nil {
+						pages, tuples = int64(t.stats.basePages), int64(t.stats.baseTups)
+					}
+					rows = append(rows, []DBValue{StringField{t.name}, IntField{pages}, IntField{tuples}})
+				}
+				return rows
+			}),
+		newSystemTable("godb_views",
+			"view_name string, materialized int, definition string",
+			func() [][]DBValue {
+				c.tablesMutex.RLock()
+				defer c.tablesMutex.RUnlock()
+				var rows [][]DBValue
+				for _, v := range c.views {
+					rows = append(rows, []DBValue{StringField{v.name}, IntField{0}, StringField{v.query}})
+				}
+				for _, t := range c.tableMap {
+					if t.view != nil {
+						rows = append(rows, []DBValue{StringField{t.name}, IntField{1}, StringField{t.view.query}})
+					}
+				}
+				sort.Slice(rows, func(i, j int) bool { return rows[i][0].(StringField).Value < rows[j][0].(StringField).Value })
+				return rows
+			}),
+		newSystemTable("godb_transactions",
+			"tid int, phase string, num_pages int, num_dirty_pages int",
+			func() [][]DBValue {
+				var rows [][]DBValue
+				for _, info := range c.bufferPool.transactionInfo() {
+					rows = append(rows, []DBValue{IntField{int64(info.tid)}, StringField{info.phase.String()},
+						IntField{int64(info.pages)}, IntField{int64(info.dirtyPages)}})
+				}
+				return rows
+			}),
+	}
+	m := make(map[string]*Table)
+	for _, t := range tables {
+		m[t.name] = t
+	}
+	return m
+}
+
+// Return the tables of the catalog, other than the system tables, ordered by
+// name.
+func (c *Catalog) userTables() []*Table {
+	c.tablesMutex.RLock()
+	defer c.tablesMutex.RUnlock()
+	tables := make([]*Table, 0, len(c.tableMap))
+	for _, t := range c.tableMap {
+		tables = append(tables, t)
+	}
+	sort.Slice(tables, func(i, j int) bool { return tables[i].name < tables[j].name })
+	return tables
+}
+
+func (c *Catalog) tableFileName(t *Table) string {
+	if f, ok := t.file.(tableFile); ok {
+		return f.BackingFile()
+	}
+	return ""
+}
+
+func (p TransactionPhase) String() string {
+	switch p {
+	case ValidationPhase:
+		return "validation"
+	case WritePhase:
+		return "write"
+	}
+	return "read"
+}
+
+// A summary of a running transaction.
+type transactionInfo struct {
+	tid        TransactionID
+	phase      TransactionPhase
+	pages      int
+	dirtyPages int
+}
+
+// Return a summary of each running transaction, ordered by transaction id.
+func (bp *BufferPool) transactionInfo() []transactionInfo {
+	bp.mutex.Lock()
+	defer bp.mutex.Unlock()
+	infos := make([]transactionInfo, 0, len(bp.runningTransactions))
+	for tid, phase := range bp.runningTransactions {
+		dirty := make(map[any]bool)
+		for _, key := range bp.dirtyPages[tid] {
+			dirty[key] = true
+		}
+		infos = append(infos, transactionInfo{tid, phase, len(bp.transactionPages[tid]), len(dirty)})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].tid < infos[j].tid })
+	return infos
+}
+
+func (st *systemTable) Explain() (string, string) {
+	return "System Table Scan", st.name
+}
+
+func (st *systemTable) Children() []Operator {
+	return nil
+}
@@ -0,0 +1,63 @@
+package godb
+
+import (
+	"testing"
+)
+
+func TestSystemTablesCatalog(t *testing.T) {
+	s, _ := makeConstraintTestSession(t, "create table people (id int primary key, name text not null)")
+	if _, err := s.Execute("create table pets (name text, owner int references people)"); err != nil {
+		t.Fatalf("create failed, %s", err.Error())
+	}
+	checkAlterQuery(t, s, "select table_name, num_columns from godb_tables order by table_name",
+		[]DBValue{StringField{"people"}, IntField{2}},
+		[]DBValue{StringField{"pets"}, IntField{2}})
+	checkAlterQuery(t, s, "select column_name, ordinal_position, data_type, not_null from godb_columns where table_name = 'people' order by ordinal_position",
+		[]DBValue{StringField{"id"}, IntField{1}, StringField{"int"}, IntField{1}},
+		[]DBValue{StringField{"name"}, IntField{2}, StringField{"string"}, IntField{1}})
+	checkAlterQuery(t, s, "select constraint_name, definition from godb_constraints where table_name = 'pets'",
+		[]DBValue{StringField{"pets_owner_fkey"}, StringField{"foreign key (owner) references people (id)"}})
+	checkAlterQuery(t, s, "select num_tuples from godb_stats where table_name = 'pets'", []DBValue{IntField{-1}})
+
+	// the system tables follow DDL
+	if _, err := s.Execute("drop table pets"); err != nil {
+		t.Fatalf("drop failed, %s", err.Error())
+	}
+	checkAlterQuery(t, s, "select table_name from godb_tables", []DBValue{StringField{"people"}})
+
+	for _, q := range []string{
+		"insert into godb_tables values ('x', 1, 1, 1, 'x')",
+		"delete from godb_columns",
+		"drop table godb_tables",
+		"create table godb_stats (a int)",
+		"alter table godb_tables add column a int",
+	} {
+		if _, err := s.Execute(q); err == nil {
+			t.Errorf("expected %s to fail", q)
+		}
+	}
+}
+
+func TestSystemTablesTransactions(t *testing.T) {
+	s, _ := makeConstraintTestSession(t, "create table t (a int)")
+	other := NewSession(s.catalog, s.bp)
+	for _, q := range []string{"begin", "insert into t values (1)"} {
+		if _, err := other.Execute(q); err != nil {
+			t.Fatalf("%s failed, %s", q, err.Error())
+		}
+	}
+	res, err := s.Execute("select phase, num_dirty_pages from godb_transactions")
+	if err != nil {
+		t.Fatalf("select failed, %s", err.Error())
+	}
+	// the open transaction and the one running the select
+	found := false
+	for _, tup := range res.Tuples {
+		if tup.Fields[0] == (StringField{"read"}) && tup.Fields[1] == (IntField{1}) {
+			found = true
+		}
+	}
+	if len(res.Tuples) != 2 || !found {
+		t.Errorf("expected the open transaction to be listed, got %v", res.Tuples)
+	}
+}
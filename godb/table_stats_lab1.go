@@ -1,5 +1,7 @@
 package godb
 
+import "math"
+
 /*
  TableStats represents statistics (e.g., histograms) about base tables in a
  query.
@@ -27,13 +29,42 @@ const CostPerPage = 1000
 // though our tests assume that you have at least 100 bins in your histograms.
 const NumHistBins = 100
 
-// Dummy Implementations to implement the interface
+// Estimate the cost of scanning the table, that of reading each of its pages.
 func (ts *TableStats) EstimateScanCost() float64 {
-	return 0
+	if ts == nil {
+		return 0
+	}
+	return float64(ts.basePages * CostPerPage)
 }
+
+// Estimate the number of rows of the table that satisfy predicates of the
+// given selectivity.
 func (ts *TableStats) EstimateCardinality(selectivity float64) int {
-	return 0
+	if ts == nil {
+		return 0
+	}
+	return int(math.Round(float64(ts.baseTups) * selectivity))
 }
+
 func (ts *TableStats) EstimateSelectivity(field string, op BoolOp, value DBValue) (float64, error) {
+	if isMatchOp(op) {
+		return ts.matchSelectivity(field, op, value), nil
+	}
 	return 0.5, nil
+}
+
+// Estimate the selectivity of a pattern match, using the histogram of the
+// field for the literal prefix of a case-sensitive LIKE.
+func (ts *TableStats) matchSelectivity(field string, op BoolOp, value DBValue) float64 {
+	sel := defaultMatchSelectivity
+	pattern, isString := value.(StringField)
+	if ts != nil && isString && (op == OpLike || op == OpNotLike) {
+		if h, ok := ts.histograms[field].(*stringHistogram); ok && h != nil {
+			sel = h.likeSelectivity(pattern.Value)
+		}
+	}
+	if matchModes[op].negate {
+		return 1 - sel
+	}
+	return sel
 }
\ No newline at end of file
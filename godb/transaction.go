@@ -1,6 +1,9 @@
 package godb
 
-import "sync"
+import (
+	"context"
+	"sync"
+)
 
 type TransactionID int
 
@@ -16,3 +19,44 @@ func NewTID() TransactionID {
 }
 
 //var tid TransactionID = NewTID()
+
+// The state of a running transaction that its statements' operators use,
+// other than the pages the buffer pool keeps for it.  It is created when
+// the transaction begins and dropped when it commits or aborts.
+type transaction struct {
+	mutex sync.Mutex
+	// the context of the statement the transaction is running, if it can
+	// be cancelled; see BindContext
+	ctx context.Context
+}
+
+var (
+	// the running transactions, by TransactionID
+	transactions      = make(map[TransactionID]*transaction)
+	transactionsMutex sync.RWMutex
+)
+
+// Create the state of transaction tid, which is beginning.
+func beginTransactionState(tid TransactionID) {
+	transactionsMutex.Lock()
+	defer transactionsMutex.Unlock()
+	transactions[tid] = &transaction{}
+}
+
+// Drop the state of transaction tid, which has committed or aborted.
+func endTransactionState(tid TransactionID) {
+	transactionsMutex.Lock()
+	txn := transactions[tid]
+	delete(transactions, tid)
+	transactionsMutex.Unlock()
+	if txn != nil {
+		txn.setContext(nil)
+	}
+}
+
+// Return the state of transaction tid, or nil if it is not running.
+func runningTransaction(tid TransactionID) *transaction {
+	transactionsMutex.RLock()
+	defer transactionsMutex.RUnlock()
+	return transactions[tid]
+}
@@ -7,6 +7,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
 )
@@ -180,12 +181,19 @@ type HeapRecordID struct {
 // example if StringLength is set to 5, the string 'mit' should be written as
 // 'm', 'i', 't', 0, 0
 //
+// A NULL field of a type known from the tuple's descriptor is written as
+// the value that stands for NULL in a table; see nullValue.
+//
 // May return an error if the buffer has insufficient capacity to store the
 // tuple.
 func (t *Tuple) writeTo(b *bytes.Buffer) error {
 	// TODO: some code goes here
 	for i := 0; i < len(t.Fields); i++ {
-		switch dbValue := t.Fields[i].(type) {
+		v := t.Fields[i]
+		if v == nil && i < len(t.Desc.Fields) {
+			v = nullValue(t.Desc.Fields[i].Ftype)
+		}
+		switch dbValue := v.(type) {
 		case IntField:
 			err := binary.Write(b, binary.LittleEndian, dbValue.Value)
 			if err != nil {
@@ -233,6 +241,9 @@ func readTupleFrom(b *bytes.Buffer, desc *TupleDesc) (*Tuple, error) {
 				return nil, fmt.Errorf("insufficient space")
 			}
 			fields[i] = IntField{Value: value}
+			if value == nullInt {
+				fields[i] = nil
+			}
 		case StringType:
 			strBytes := make([]byte, StringLength)
 			_, err := b.Read(strBytes)
@@ -247,6 +258,9 @@ func readTupleFrom(b *bytes.Buffer, desc *TupleDesc) (*Tuple, error) {
 				}
 			}
 			fields[i] = StringField{Value: value}
+			if value == nullString {
+				fields[i] = nil
+			}
 		}
 	}
 	return &Tuple{
@@ -255,6 +269,50 @@ func readTupleFrom(b *bytes.Buffer, desc *TupleDesc) (*Tuple, error) {
 	}, nil
 }
 
+// Tables store a NULL as a value of its column's type that other values
+// cannot take: the smallest int64, or a string of a single 0xff byte, which
+// is not valid UTF-8.  Tables reject those values; see checkStorable.
+const (
+	nullInt    = math.MinInt64
+	nullString = "\xff"
+)
+
+// Return the value that stands for NULL in a column of type t, or nil if
+// the type has none.
+func nullValue(t DBType) DBValue {
+	switch t {
+	case IntType:
+		return IntField{nullInt}
+	case StringType:
+		return StringField{nullString}
+	}
+	return nil
+}
+
+// Return the value that v, read from a table, stands for: nil if it is the
+// value that stands for NULL, and v otherwise.
+func loadedValue(v DBValue) DBValue {
+	if v == (IntField{nullInt}) || v == (StringField{nullString}) {
+		return nil
+	}
+	return v
+}
+
+// Return an error if one of the fields of t cannot be stored in a table,
+// because it is the value that stands for NULL.
+func checkStorable(t *Tuple) error {
+	for i, f := range t.Fields {
+		if f != nil && loadedValue(f) == nil {
+			col := fmt.Sprint(i)
+			if i < len(t.Desc.Fields) {
+				col = t.Desc.Fields[i].Fname
+			}
+			return GoDBError{IllegalOperationError, fmt.Sprintf("value %v of column %s is out of range", f, col)}
+		}
+	}
+	return nil
+}
+
 // Compare two tuples for equality.  Equality means that the TupleDescs are equal
 // and all of the fields are equal.  TupleDescs should be compared with
 // the [TupleDesc.equals] method, but fields can be compared directly with equality
@@ -334,6 +392,15 @@ func (t *Tuple) compareField(t2 *Tuple, field Expr) (orderByState, error) {
 	if err != nil {
 		return OrderedEqual, fmt.Errorf("unable to evaluate tuple")
 	}
+	// NULLs are greater than every other value, see lessOrNull
+	switch {
+	case val1 == nil && val2 == nil:
+		return OrderedEqual, nil
+	case val1 == nil:
+		return OrderedGreaterThan, nil
+	case val2 == nil:
+		return OrderedLessThan, nil
+	}
 	switch field1 := val1.(type) {
 	case IntField:
 		field2, _ := val2.(IntField)
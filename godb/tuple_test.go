@@ -3,6 +3,7 @@ package godb
 import (
 	"bytes"
 	"fmt"
+	"math"
 	"testing"
 )
 
@@ -113,6 +114,27 @@ func TestTupleSerialization(t *testing.T) {
 	}
 }
 
+// NULLs are stored as sentinel values, which can't be stored otherwise
+func TestTupleSerializationNull(t *testing.T) {
+	td, t1, _ := makeTupleTestVars()
+	t1.Fields = []DBValue{nil, nil}
+	b := new(bytes.Buffer)
+	if err := t1.writeTo(b); err != nil {
+		t.Fatalf("writeTo failed, %s", err.Error())
+	}
+	t3, err := readTupleFrom(b, &td)
+	if err != nil {
+		t.Fatalf("Error loading tuple from saved buffer: %v", err.Error())
+	}
+	if t3.Fields[0] != nil || t3.Fields[1] != nil {
+		t.Errorf("expected NULLs, got %v", t3.Fields)
+	}
+
+	if err := checkStorable(&Tuple{Desc: td, Fields: []DBValue{StringField{"a"}, IntField{math.MinInt64}}}); err == nil {
+		t.Errorf("expected an error storing the smallest int")
+	}
+}
+
 // Unit test for Tuple.compareField()
 func TestTupleExpr(t *testing.T) {
 	td, t1, t2 := makeTupleTestVars()
@@ -2,26 +2,27 @@ package godb
 
 import (
 	"fmt"
-	"regexp"
-	"strings"
 )
 
 type GoDBErrorCode int
 
 const (
-	TupleNotFoundError      GoDBErrorCode = iota
-	PageFullError           GoDBErrorCode = iota
-	IncompatibleTypesError  GoDBErrorCode = iota
-	TypeMismatchError       GoDBErrorCode = iota
-	MalformedDataError      GoDBErrorCode = iota
-	BufferPoolFullError     GoDBErrorCode = iota
-	ParseError              GoDBErrorCode = iota
-	DuplicateTableError     GoDBErrorCode = iota
-	NoSuchTableError        GoDBErrorCode = iota
-	AmbiguousNameError      GoDBErrorCode = iota
-	IllegalOperationError   GoDBErrorCode = iota
-	DeadlockError           GoDBErrorCode = iota
-	IllegalTransactionError GoDBErrorCode = iota
+	TupleNotFoundError       GoDBErrorCode = iota
+	PageFullError            GoDBErrorCode = iota
+	IncompatibleTypesError   GoDBErrorCode = iota
+	TypeMismatchError        GoDBErrorCode = iota
+	MalformedDataError       GoDBErrorCode = iota
+	BufferPoolFullError      GoDBErrorCode = iota
+	ParseError               GoDBErrorCode = iota
+	DuplicateTableError      GoDBErrorCode = iota
+	NoSuchTableError         GoDBErrorCode = iota
+	AmbiguousNameError       GoDBErrorCode = iota
+	IllegalOperationError    GoDBErrorCode = iota
+	DeadlockError            GoDBErrorCode = iota
+	IllegalTransactionError  GoDBErrorCode = iota
+	ConstraintViolationError GoDBErrorCode = iota
+	QueryCancelledError      GoDBErrorCode = iota
+	ResourceExhaustedError   GoDBErrorCode = iota
 )
 
 //go:generate stringer -type=GoDBErrorCode
@@ -76,6 +77,17 @@ const (
 	OpEq   BoolOp = iota
 	OpNeq  BoolOp = iota
 	OpLike BoolOp = iota
+
+	// pattern matching operators; see like.go
+	OpNotLike    BoolOp = iota
+	OpILike      BoolOp = iota
+	OpNotILike   BoolOp = iota
+	OpRegexp     BoolOp = iota // ~
+	OpNotRegexp  BoolOp = iota // !~
+	OpIRegexp    BoolOp = iota // ~*
+	OpNotIRegexp BoolOp = iota // !~*
+	OpSimilar    BoolOp = iota // SIMILAR TO
+	OpNotSimilar BoolOp = iota // NOT SIMILAR TO
 )
 
 var BoolOpMap = map[string]BoolOp{
@@ -87,6 +99,10 @@ var BoolOpMap = map[string]BoolOp{
 	"<>":   OpNeq,
 	"!=":   OpNeq,
 	"like": OpLike,
+
+	"not like":   OpNotLike,
+	"regexp":     OpRegexp,
+	"not regexp": OpNotRegexp,
 }
 
 func (i1 IntField) EvalPred(v2 DBValue, op BoolOp) bool {
@@ -134,20 +150,9 @@ func (i1 StringField) EvalPred(v2 DBValue, op BoolOp) bool {
 		return x1 < x2
 	case OpLe:
 		return x1 <= x2
-	case OpLike:
-		s1, ok := any(i1).(string)
-		if !ok {
-			return false
-		}
-		regex, ok := any(i2).(string)
-		if !ok {
-			return false
-		}
-		regex = "^" + regex + "$"
-		regex = strings.Replace(regex, "%", ".*?", -1)
-		match, _ := regexp.MatchString(regex, s1)
-		return match
 	default:
-		return false
+		// an invalid pattern matches nothing; a Filter reports it instead
+		match, _ := matchPattern(x1, x2, op, defaultLikeEscape)
+		return match
 	}
 }
@@ -0,0 +1,176 @@
+package godb
+
+// User-defined functions: scalar functions and aggregates implemented in Go
+// and registered by name, after which queries can call them like the
+// built-in ones.  Functions should be registered before the queries that use
+// them are planned; they are shared by all catalogs and sessions.
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// The implementation of a user-defined scalar function.  It is passed the
+// values of the arguments, each converted to the type declared for it, and
+// returns a value of the function's output type (or nil for NULL).  It is
+// not called if any argument is NULL, in which case the result is NULL.
+//
+// A function may be called from several goroutines at once.
+type ScalarFunction func(args []DBValue) (DBValue, error)
+
+// Guards funcs and aggregates, which may be read by queries running while a
+// function is registered.
+var funcsMutex sync.RWMutex
+
+var functionNameRegexp = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// Return the function with the given name.
+func lookupFunc(name string) (FuncType, bool) {
+	funcsMutex.RLock()
+	defer funcsMutex.RUnlock()
+	fType, ok := funcs[name]
+	return fType, ok
+}
+
+// Return a function returning new aggregation states for the aggregate with
+// the given name, or nil if there is no such aggregate.
+func lookupAggregate(name string) func() AggState {
+	funcsMutex.RLock()
+	defer funcsMutex.RUnlock()
+	return aggregates[name]
+}
+
+// Return the canonical (lowercase) form of the name of a function to be
+// registered, or an error if it is not a valid name or is already used by a
+// function or aggregate.  Must be called with funcsMutex held.
+func checkFunctionName(name string) (string, error) {
+	lower := strings.ToLower(name)
+	if !functionNameRegexp.MatchString(lower) {
+		return "", GoDBError{ParseError, fmt.Sprintf("invalid function name %q", name)}
+	}
+	_, isFunc := funcs[lower]
+	if isFunc || aggregates[lower] != nil {
+		return "", GoDBError{IllegalOperationError, fmt.Sprintf("function %s is already defined", lower)}
+	}
+	return lower, nil
+}
+
+// Register a scalar function that queries can call as name(args).  Each
+// argument has the corresponding type in argTypes, or UnknownType if it may be
+// of either type; ints are converted to strings for string arguments, and the
+// arguments of UnknownType are converted to their common type.  If outType is
+// UnknownType the result has that common type.
+//
+// Function names are case insensitive.  Returns an error if name is already
+// the name of a function or aggregate, including the built-in ones.
+func RegisterFunction(name string, argTypes []DBType, outType DBType, impl ScalarFunction) error {
+	if impl == nil {
+		return GoDBError{IllegalOperationError, fmt.Sprintf("no implementation supplied for function %s", name)}
+	}
+	for _, t := range append([]DBType{outType}, argTypes...) {
+		if t != IntType && t != StringType && t != UnknownType {
+			return GoDBError{TypeMismatchError, fmt.Sprintf("unsupported type %v in the signature of function %s", t, name)}
+		}
+	}
+	funcsMutex.Lock()
+	defer funcsMutex.Unlock()
+	name, err := checkFunctionName(name)
+	if err != nil {
+		return err
+	}
+	argTypes = append([]DBType(nil), argTypes...)
+	funcs[name] = FuncType{
+		argTypes: argTypes,
+		outType:  outType,
+		f:        userFunc(name, argTypes, outType, impl),
+		// it may not return the same result each time it is called
+		volatile: true,
+		doc:      "user-defined",
+	}
+	return nil
+}
+
+// Adapt a user-defined function to the calling convention of FuncType.f.
+func userFunc(name string, argTypes []DBType, outType DBType, impl ScalarFunction) func([]any) any {
+	return func(args []any) any {
+		toString := false
+		for i, arg := range args {
+			if _, ok := arg.(string); ok && argTypes[i] == UnknownType {
+				toString = true
+			}
+		}
+		vals := make([]DBValue, len(args))
+		for i, arg := range args {
+			switch arg := arg.(type) {
+			case int64:
+				if toString && argTypes[i] == UnknownType {
+					vals[i] = StringField{strconv.FormatInt(arg, 10)}
+				} else {
+					vals[i] = IntField{arg}
+				}
+			case string:
+				vals[i] = StringField{arg}
+			}
+		}
+		result, err := impl(vals)
+		if err != nil {
+			return err
+		}
+		switch result := result.(type) {
+		case IntField:
+			if outType == StringType {
+				return strconv.FormatInt(result.Value, 10)
+			}
+			return result.Value
+		case StringField:
+			if outType == IntType {
+				return GoDBError{TypeMismatchError, fmt.Sprintf("function %s returned the string %q, expected an int", name, result.Value)}
+			}
+			return result.Value
+		case nil:
+			return nil
+		}
+		return GoDBError{TypeMismatchError, fmt.Sprintf("function %s returned a value of unsupported type %T", name, result)}
+	}
+}
+
+// Register an aggregate that queries can use as name(expr), in the select list
+// of a query with or without a GROUP BY clause.  newState is called each time
+// the aggregate appears in a query, and must return a new aggregation state
+// to be initialized with [AggState.Init]; the state is copied for each group.
+//
+// Aggregate names are case insensitive.  Returns an error if name is already
+// the name of a function or aggregate, including the built-in ones.
+func RegisterAggregate(name string, newState func() AggState) error {
+	if newState == nil {
+		return GoDBError{IllegalOperationError, fmt.Sprintf("no implementation supplied for aggregate %s", name)}
+	}
+	funcsMutex.Lock()
+	defer funcsMutex.Unlock()
+	name, err := checkFunctionName(name)
+	if err != nil {
+		return err
+	}
+	aggregates[name] = func() AggState {
+		as := newState()
+		if as == nil {
+			return nil
+		}
+		return &namedAggState{name, as}
+	}
+	return nil
+}
+
+// The aggregation state of a user-defined aggregate, labelled with the name
+// the aggregate was registered under so that plans can show it.
+type namedAggState struct {
+	name string
+	AggState
+}
+
+func (a *namedAggState) Copy() AggState {
+	return &namedAggState{a.name, a.AggState.Copy()}
+}
@@ -0,0 +1,212 @@
+package godb
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// An aggregate computing the median of an int expression (the lower of the
+// two middle values for an even number of rows).
+type medianAggState struct {
+	alias  string
+	expr   Expr
+	values []int64
+}
+
+func (a *medianAggState) Init(alias string, expr Expr) error {
+	if expr.GetExprType().Ftype != IntType {
+		return GoDBError{TypeMismatchError, "median expects an int"}
+	}
+	a.alias, a.expr = alias, expr
+	return nil
+}
+
+func (a *medianAggState) Copy() AggState {
+	return &medianAggState{a.alias, a.expr, append([]int64(nil), a.values...)}
+}
+
+func (a *medianAggState) AddTuple(t *Tuple) {
+	if v, err := a.expr.EvalExpr(t); err == nil {
+		a.values = append(a.values, v.(IntField).Value)
+	}
+}
+
+func (a *medianAggState) Finalize() *Tuple {
+	sort.Slice(a.values, func(i, j int) bool { return a.values[i] < a.values[j] })
+	var median DBValue
+	if len(a.values) > 0 {
+		median = IntField{a.values[(len(a.values)-1)/2]}
+	}
+	return &Tuple{*a.GetTupleDesc(), []DBValue{median}, nil}
+}
+
+func (a *medianAggState) GetTupleDesc() *TupleDesc {
+	return &TupleDesc{Fields: []FieldType{{a.alias, "", IntType}}}
+}
+
+// Remove the functions and aggregates a test registered.
+func unregisterFunctions(t *testing.T, names ...string) {
+	t.Cleanup(func() {
+		funcsMutex.Lock()
+		defer funcsMutex.Unlock()
+		for _, name := range names {
+			delete(funcs, name)
+			delete(aggregates, name)
+		}
+	})
+}
+
+func TestRegisterFunction(t *testing.T) {
+	unregisterFunctions(t, "double_it", "initial", "checked_div", "bad_result", "join_all")
+	for _, f := range []struct {
+		name     string
+		argTypes []DBType
+		outType  DBType
+		impl     ScalarFunction
+	}{
+		{"double_it", []DBType{IntType}, IntType, func(args []DBValue) (DBValue, error) {
+			return IntField{2 * args[0].(IntField).Value}, nil
+		}},
+		{"Initial", []DBType{StringType}, StringType, func(args []DBValue) (DBValue, error) {
+			return StringField{strings.ToUpper(args[0].(StringField).Value[:1])}, nil
+		}},
+		{"checked_div", []DBType{IntType, IntType}, IntType, func(args []DBValue) (DBValue, error) {
+			if args[1].(IntField).Value == 0 {
+				return nil, fmt.Errorf("checked_div by zero")
+			}
+			return IntField{args[0].(IntField).Value / args[1].(IntField).Value}, nil
+		}},
+		{"bad_result", []DBType{UnknownType}, IntType, func(args []DBValue) (DBValue, error) {
+			return StringField{"x"}, nil
+		}},
+		{"join_all", []DBType{UnknownType, UnknownType}, UnknownType, func(args []DBValue) (DBValue, error) {
+			if a, ok := args[0].(IntField); ok {
+				return IntField{a.Value + args[1].(IntField).Value}, nil
+			}
+			return StringField{args[0].(StringField).Value + args[1].(StringField).Value}, nil
+		}},
+	} {
+		if err := RegisterFunction(f.name, f.argTypes, f.outType, f.impl); err != nil {
+			t.Fatalf("registering %s failed, %s", f.name, err.Error())
+		}
+	}
+
+	s := makeBatchTestSession(t, 20)
+	q := "select double_it(salary), INITIAL(name), checked_div(salary, 7), join_all(dept, 1), join_all(name, dept), double_it(nullif(dept, 3)) is null from emp where name = 'e3'"
+	if got, want := queryRow(t, s, q), "42,E,3,4,e33,1"; got != want {
+		t.Errorf("%s: expected %s, got %s", q, want, got)
+	}
+	res, err := s.Execute("select name from emp where double_it(dept) > 16")
+	if err != nil {
+		t.Fatalf("filtering on a user-defined function failed, %s", err.Error())
+	}
+	if len(res.Tuples) != 2 {
+		t.Errorf("expected 2 rows with double_it(dept) > 16, got %d", len(res.Tuples))
+	}
+	desc, err := s.Describe("select initial(name), join_all(name, 1), join_all(dept, 1) from emp")
+	if err != nil {
+		t.Fatalf("describe failed, %s", err.Error())
+	}
+	for i, want := range []DBType{StringType, StringType, IntType} {
+		if got := desc.Fields[i].Ftype; got != want {
+			t.Errorf("expected column %d to have type %v, got %v", i, want, got)
+		}
+	}
+	for _, q := range []string{
+		"select checked_div(salary, dept) from emp",
+		"select bad_result(dept) from emp",
+		"select double_it(name) from emp",
+		"select double_it(dept, dept) from emp",
+	} {
+		if _, err := s.Execute(q); err == nil {
+			t.Errorf("expected %s to fail", q)
+		}
+	}
+
+	if !strings.Contains(ListOfFunctions(), "\tdouble_it(int) -> int: user-defined\n") {
+		t.Errorf("expected the list of functions to include double_it")
+	}
+}
+
+func TestRegisterAggregate(t *testing.T) {
+	unregisterFunctions(t, "median")
+	if err := RegisterAggregate("median", func() AggState { return &medianAggState{} }); err != nil {
+		t.Fatalf("registering median failed, %s", err.Error())
+	}
+
+	s := makeBatchTestSession(t, 20)
+	// salaries are 7i for i < 20, so the median is 7*9, and dept d has
+	// salaries 7d and 7(d+10)
+	if got := queryRow(t, s, "select median(salary), count(*) from emp"); got != "63,20" {
+		t.Errorf("expected median 63 of 20 rows, got %s", got)
+	}
+	for _, workers := range []int{1, 4} {
+		if _, err := s.Execute(fmt.Sprintf("set parallel_workers = %d", workers)); err != nil {
+			t.Fatalf("set failed, %s", err.Error())
+		}
+		res, err := s.Execute("select dept, median(salary) as m, max(salary) from emp group by dept order by dept")
+		if err != nil {
+			t.Fatalf("grouped median failed, %s", err.Error())
+		}
+		if len(res.Tuples) != 10 {
+			t.Fatalf("expected 10 groups, got %d", len(res.Tuples))
+		}
+		for d, tup := range res.Tuples {
+			if got, want := tup.PrettyPrintString(false), fmt.Sprintf("%d,%d,%d", d, 7*d, 7*(d+10)); got != want {
+				t.Errorf("with %d workers, expected %s, got %s", workers, want, got)
+			}
+		}
+	}
+	if _, err := s.Execute("select median(name) from emp"); err == nil {
+		t.Errorf("expected median of a string to fail")
+	}
+	if _, err := s.Execute("select median(*) from emp"); err == nil {
+		t.Errorf("expected median(*) to fail")
+	}
+
+	_, plan, err := Parse(s.catalog, "select dept, median(salary) from emp group by dept")
+	if err != nil {
+		t.Fatalf("planning failed, %s", err.Error())
+	}
+	var out strings.Builder
+	OutputPhysicalPlan(func(format string, a ...any) { fmt.Fprintf(&out, format, a...) }, plan, "")
+	if !strings.Contains(out.String(), "Aggregate median(") {
+		t.Errorf("expected the plan to show the median aggregate, got %s", out.String())
+	}
+	if !strings.Contains(ListOfFunctions(), "\tmedian(any) -> aggregate: user-defined\n") {
+		t.Errorf("expected the list of functions to include median")
+	}
+}
+
+func TestRegisterNameCollisions(t *testing.T) {
+	unregisterFunctions(t, "my_func", "my_agg")
+	noop := func(args []DBValue) (DBValue, error) { return nil, nil }
+	newState := func() AggState { return &medianAggState{} }
+	if err := RegisterFunction("my_func", []DBType{IntType}, IntType, noop); err != nil {
+		t.Fatalf("registering my_func failed, %s", err.Error())
+	}
+	if err := RegisterAggregate("my_agg", newState); err != nil {
+		t.Fatalf("registering my_agg failed, %s", err.Error())
+	}
+	for _, name := range []string{"my_func", "MY_AGG", "upper", "coalesce", "count", "Sum"} {
+		if err := RegisterFunction(name, []DBType{IntType}, IntType, noop); err == nil {
+			t.Errorf("expected registering the function %s to fail", name)
+		}
+		if err := RegisterAggregate(name, newState); err == nil {
+			t.Errorf("expected registering the aggregate %s to fail", name)
+		}
+	}
+	for _, name := range []string{"", "1abc", "a-b", "a b"} {
+		if err := RegisterFunction(name, nil, IntType, noop); err == nil {
+			t.Errorf("expected registering a function named %q to fail", name)
+		}
+	}
+	if err := RegisterFunction("no_impl", nil, IntType, nil); err == nil {
+		t.Errorf("expected registering a function without an implementation to fail")
+	}
+	if err := RegisterFunction("bad_type", []DBType{DBType(42)}, IntType, noop); err == nil {
+		t.Errorf("expected registering a function with an unknown type to fail")
+	}
+}
@@ -4,6 +4,33 @@ import (
 	"testing"
 )
 
+// Set up a heap file of three pages that each hold a single row of
+// txn_test_300_3.csv, which is t2, so that the tests can insert into any of
+// the pages, and begin two transactions.  (transactionTestSetUp loads the
+// whole file, filling the first two pages.)
+func validationTestSetUp(t *testing.T) (*BufferPool, *HeapFile, TransactionID, TransactionID, Tuple) {
+	td, t1, t2, hf, bp, _ := makeTestVars(t)
+	for pgNo := 0; pgNo < 3; pgNo++ {
+		pg, err := newHeapPage(&td, pgNo, hf)
+		if err != nil {
+			t.Fatalf("error making test vars; %s", err.Error())
+		}
+		if _, err := pg.insertTuple(&t2); err != nil {
+			t.Fatalf("error making test vars; %s", err.Error())
+		}
+		if err := hf.flushPage(pg); err != nil {
+			t.Fatalf("error making test vars; %s", err.Error())
+		}
+	}
+	hf.numPages = 3
+
+	tid1 := NewTID()
+	bp.BeginTransaction(tid1)
+	tid2 := NewTID()
+	bp.BeginTransaction(tid2)
+	return bp, hf, tid1, tid2, t1
+}
+
 /**
 * Test to construct an invalidation situation.
 * tid1 writes t1 to page; tid2 writes t2 to same page; tid1 tries to commit; tid2 tries to commit
@@ -11,7 +38,7 @@ import (
  */
 func TestInvalidateWriteWrite(t *testing.T) {
 	_, t2, t1, _, _, _ := makeTestVars(t)
-	bp, hf, tid1, tid2, t2 := transactionTestSetUp(t)
+	bp, hf, tid1, tid2, t2 := validationTestSetUp(t)
 
 	pg, _ := bp.GetPage(hf, 2, tid1, WritePerm)
 	heapp := pg.(*heapPage)
@@ -58,7 +85,7 @@ func TestInvalidateWriteWrite(t *testing.T) {
  */
 func TestInvalidateWriteRead(t *testing.T) {
 	_, t1, t2, _, _, _ := makeTestVars(t)
-	bp, hf, tid1, tid2, t1 := transactionTestSetUp(t)
+	bp, hf, tid1, tid2, t1 := validationTestSetUp(t)
 
 	pg, _ := bp.GetPage(hf, 2, tid1, WritePerm)
 	heapp := pg.(*heapPage)
@@ -116,7 +143,7 @@ func TestInvalidateWriteRead(t *testing.T) {
  */
 func TestValidateReadWrite(t *testing.T) {
 	_, t1, t2, _, _, _ := makeTestVars(t)
-	bp, hf, tid1, tid2, t1 := transactionTestSetUp(t)
+	bp, hf, tid1, tid2, t1 := validationTestSetUp(t)
 
 	bp.GetPage(hf, 2, tid1, ReadPerm)
 	pg, _ := bp.GetPage(hf, 1, tid1, WritePerm)
@@ -174,7 +201,7 @@ func TestValidateReadWrite(t *testing.T) {
  */
 func TestValidateReadRead(t *testing.T) {
 	_, t1, t2, _, _, _ := makeTestVars(t)
-	bp, hf, tid1, tid2, t1 := transactionTestSetUp(t)
+	bp, hf, tid1, tid2, t1 := validationTestSetUp(t)
 
 	bp.GetPage(hf, 2, tid1, WritePerm)
 	pg, _ := bp.GetPage(hf, 1, tid1, WritePerm)
@@ -1,5 +1,7 @@
 package godb
 
+import "fmt"
+
 // Methods to expose an array of constant expressions as tuples to iterate
 // through (e.g., for insert statements or select from a constant list).
 
@@ -47,3 +49,11 @@ func (v *ValueOp) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
 		return &Tuple{*v.td, fields, nil}, nil
 	}, nil
 }
+
+func (v *ValueOp) Explain() (string, string) {
+	return "Values", fmt.Sprintf("%d rows", len(v.exprs))
+}
+
+func (v *ValueOp) Children() []Operator {
+	return nil
+}
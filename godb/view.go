@@ -0,0 +1,267 @@
+package godb
+
+// Views and materialized views.
+//
+//	CREATE [MATERIALIZED] VIEW [IF NOT EXISTS] name AS SELECT ...
+//	REFRESH MATERIALIZED VIEW name
+//	DROP [MATERIALIZED] VIEW [IF EXISTS] name
+//
+// A view saves the text of its query in the catalog.  A query that names a
+// view in its FROM clause is planned as if the view's query were written
+// there as a subquery, so the view's rows are recomputed each time it is
+// used.
+//
+// A materialized view is a table holding the result of its query when it was
+// created or last refreshed.  It is read like any other table, but it cannot
+// be modified except by REFRESH, which recomputes it into a new heap file
+// that replaces the old one.
+//
+// Tables and views that a view depends on cannot be dropped or renamed, and
+// their columns cannot be dropped or renamed, until the view is dropped.
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/xwb1989/sqlparser"
+)
+
+var createViewRegexp = regexp.MustCompile(`(?is)^\s*create\s+(materialized\s+)?view\s+(if\s+not\s+exists\s+)?([A-Za-z_][A-Za-z0-9_]*)\s+as\s+(.*?)[\s;]*$`)
+var refreshViewRegexp = regexp.MustCompile(`(?is)^\s*refresh\s+materialized\s+view\s+([A-Za-z_][A-Za-z0-9_]*)[\s;]*$`)
+var dropViewRegexp = regexp.MustCompile(`(?is)^\s*drop\s+(materialized\s+)?view\s+(if\s+exists\s+)?([A-Za-z_][A-Za-z0-9_]*)[\s;]*$`)
+
+// Matches the start of any view statement, so that malformed ones get a
+// useful error instead of being handed to sqlparser.
+var viewStatementRegexp = regexp.MustCompile(`(?is)^\s*(create\s+(materialized\s+)?view|refresh\s+materialized\s+view|drop\s+(materialized\s+)?view)\s`)
+
+type view struct {
+	name  string
+	query string
+	// the tables and views named in the query
+	deps []string
+}
+
+func newView(name string, query string) (*view, sqlparser.SelectStatement, error) {
+	stmt, err := parseSQL(query)
+	if err != nil {
+		return nil, nil, GoDBError{ParseError, fmt.Sprintf("invalid query for view %s: %s", name, err.Error())}
+	}
+	sel, ok := stmt.(sqlparser.SelectStatement)
+	if !ok {
+		return nil, nil, GoDBError{ParseError, fmt.Sprintf("the query of view %s must be a SELECT", name)}
+	}
+	v := &view{name: name, query: query}
+	sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		if t, ok := node.(*sqlparser.AliasedTableExpr); ok {
+			if tn, ok := t.Expr.(sqlparser.TableName); ok {
+				dep := strings.ToLower(tn.Name.CompliantName())
+				if !contains(v.deps, dep) {
+					v.deps = append(v.deps, dep)
+				}
+			}
+		}
+		return true, nil
+	}, statementNodes(sel)...)
+	if w, ok := sel.(*withQuery); ok {
+		// the names of its CTEs are not dependencies
+		for _, cte := range w.ctes {
+			for i, dep := range v.deps {
+				if dep == cte.name {
+					v.deps = append(v.deps[:i], v.deps[i+1:]...)
+					break
+				}
+			}
+		}
+	}
+	return v, sel, nil
+}
+
+// Plan the query of view v.
+func (c *Catalog) planView(v *view) (*LogicalPlan, error) {
+	_, sel, err := newView(v.name, v.query)
+	if err != nil {
+		return nil, err
+	}
+	// the CTEs of the query naming the view are not in scope in its query
+	return parseQuery(c, sel)
+}
+
+func parseViewStatement(c *Catalog, query string) (QueryType, error) {
+	if m := createViewRegexp.FindStringSubmatch(query); m != nil {
+		name := strings.ToLower(m[3])
+		if c.relationExists(name) {
+			if m[2] != "" {
+				return CreateViewQueryType, nil
+			}
+			return UnknownQueryType, GoDBError{DuplicateTableError, fmt.Sprintf("relation %s already exists", name)}
+		}
+		if m[1] != "" {
+			return CreateViewQueryType, c.createMaterializedView(name, m[4])
+		}
+		return CreateViewQueryType, c.createView(name, m[4])
+	}
+	if m := refreshViewRegexp.FindStringSubmatch(query); m != nil {
+		t, err := c.materializedView(strings.ToLower(m[1]))
+		if err != nil {
+			return UnknownQueryType, err
+		}
+		return RefreshViewQueryType, c.refreshMaterializedView(t)
+	}
+	if m := dropViewRegexp.FindStringSubmatch(query); m != nil {
+		name := strings.ToLower(m[3])
+		if !c.relationExists(name) && m[2] != "" {
+			return DropViewQueryType, nil
+		}
+		if m[1] != "" {
+			t, err := c.materializedView(name)
+			if err != nil {
+				return UnknownQueryType, err
+			}
+			return DropViewQueryType, c.dropTable(t.name)
+		}
+		return DropViewQueryType, c.dropView(name)
+	}
+	return UnknownQueryType, GoDBError{ParseError, "malformed view statement"}
+}
+
+// Return true if there is a table or view named name.
+func (c *Catalog) relationExists(name string) bool {
+	if _, ok := c.views[name]; ok {
+		return true
+	}
+	_, err := c.GetTableInfo(name)
+	return err == nil
+}
+
+func (c *Catalog) createView(name string, query string) error {
+	v, sel, err := newView(name, query)
+	if err != nil {
+		return err
+	}
+	plan, err := parseQuery(c, sel)
+	if err != nil {
+		return err
+	}
+	if _, err := makePhysicalPlan(c, plan); err != nil {
+		return err
+	}
+	c.tablesMutex.Lock()
+	defer c.tablesMutex.Unlock()
+	c.views[name] = v
+	c.version++
+	return nil
+}
+
+func (c *Catalog) dropView(name string) error {
+	if _, ok := c.views[name]; !ok {
+		if t, err := c.GetTableInfo(name); err == nil && t.view != nil {
+			return GoDBError{IllegalOperationError, fmt.Sprintf("%s is a materialized view; use DROP MATERIALIZED VIEW", name)}
+		}
+		return GoDBError{NoSuchTableError, fmt.Sprintf("no view '%s' found", name)}
+	}
+	if err := c.checkNoDependentViews(name, "drop"); err != nil {
+		return err
+	}
+	c.tablesMutex.Lock()
+	defer c.tablesMutex.Unlock()
+	delete(c.views, name)
+	c.version++
+	return nil
+}
+
+func (c *Catalog) createMaterializedView(name string, query string) error {
+	v, sel, err := newView(name, query)
+	if err != nil {
+		return err
+	}
+	plan, err := parseQuery(c, sel)
+	if err != nil {
+		return err
+	}
+	op, err := makePhysicalPlan(c, plan)
+	if err != nil {
+		return err
+	}
+	desc, err := queryColumns(name, op, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := c.addTable(name, desc); err != nil {
+		return err
+	}
+	t := c.tableMap[name]
+	c.tablesMutex.Lock()
+	t.view = v
+	c.tablesMutex.Unlock()
+	if err := c.fillTable(t, op); err != nil {
+		c.dropTable(name)
+		return err
+	}
+	return nil
+}
+
+// Return the materialized view named name.
+func (c *Catalog) materializedView(name string) (*Table, error) {
+	t, err := c.GetTableInfo(name)
+	if err != nil {
+		return nil, err
+	}
+	if t.view == nil {
+		return nil, GoDBError{IllegalOperationError, fmt.Sprintf("%s is not a materialized view", name)}
+	}
+	return t, nil
+}
+
+func (c *Catalog) refreshMaterializedView(t *Table) error {
+	plan, err := c.planView(t.view)
+	if err != nil {
+		return err
+	}
+	op, err := makePhysicalPlan(c, plan)
+	if err != nil {
+		return err
+	}
+	fields := op.Descriptor().Fields
+	if len(fields) != len(t.desc.Fields) {
+		return GoDBError{TypeMismatchError, fmt.Sprintf("the query of materialized view %s no longer matches its columns", t.name)}
+	}
+	for i, f := range fields {
+		if f.Ftype != t.desc.Fields[i].Ftype {
+			return GoDBError{TypeMismatchError, fmt.Sprintf("the query of materialized view %s no longer matches its columns", t.name)}
+		}
+	}
+	return c.fillTable(t, op)
+}
+
+// Return the table named name, or an error if it is a view or materialized
+// view, whose rows cannot be inserted or deleted.
+func (c *Catalog) writableTable(name string) (*Table, error) {
+	if _, ok := c.views[name]; ok {
+		return nil, GoDBError{IllegalOperationError, fmt.Sprintf("cannot change view %s", name)}
+	}
+	t, err := c.GetTableInfo(name)
+	if err != nil {
+		return nil, err
+	}
+	if t.view != nil {
+		return nil, GoDBError{IllegalOperationError, fmt.Sprintf("cannot change materialized view %s", name)}
+	}
+	return t, nil
+}
+
+// Return an error if a view depends on the table or view named name, which
+// is being changed by action (e.g., "drop").
+func (c *Catalog) checkNoDependentViews(name string, action string) error {
+	for _, v := range c.views {
+		if contains(v.deps, name) {
+			return GoDBError{IllegalOperationError, fmt.Sprintf("cannot %s %s because view %s depends on it", action, name, v.name)}
+		}
+	}
+	for _, t := range c.tableMap {
+		if t.view != nil && contains(t.view.deps, name) {
+			return GoDBError{IllegalOperationError, fmt.Sprintf("cannot %s %s because materialized view %s depends on it", action, name, t.name)}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package godb
+
+import (
+	"testing"
+)
+
+func makeViewTestSession(t *testing.T) (*Session, string) {
+	t.Helper()
+	s, dir := makeConstraintTestSession(t, "create table orders (id int primary key, customer int, amount int)")
+	if _, err := s.Execute("insert into orders values (1, 10, 5), (2, 10, 7), (3, 20, 1)"); err != nil {
+		t.Fatalf("insert failed, %s", err.Error())
+	}
+	return s, dir
+}
+
+func TestViewExpansion(t *testing.T) {
+	s, _ := makeViewTestSession(t)
+	if _, err := s.Execute("create view big as select id, customer from orders where amount > 2"); err != nil {
+		t.Fatalf("create view failed, %s", err.Error())
+	}
+	checkAlterQuery(t, s, "select id from big order by id", []DBValue{IntField{1}}, []DBValue{IntField{2}})
+
+	// views see later changes, and can be joined and aliased
+	if _, err := s.Execute("insert into orders values (4, 20, 9)"); err != nil {
+		t.Fatalf("insert failed, %s", err.Error())
+	}
+	checkAlterQuery(t, s, "select b.id from big b where b.customer = 20", []DBValue{IntField{4}})
+	checkAlterQuery(t, s, "select o.amount from big join orders o on big.id = o.id where big.customer = 20", []DBValue{IntField{9}})
+
+	for _, q := range []string{
+		"create view big as select id from orders",
+		"create view bad as select nope from orders",
+		"insert into big values (5, 5)",
+		"delete from big",
+		"drop table orders",
+		"alter table orders drop column amount",
+		"drop materialized view big",
+	} {
+		if _, err := s.Execute(q); err == nil {
+			t.Errorf("expected %s to fail", q)
+		}
+	}
+	if _, err := s.Execute("drop view big"); err != nil {
+		t.Fatalf("drop view failed, %s", err.Error())
+	}
+	if _, err := s.Execute("select id from big"); err == nil {
+		t.Errorf("expected the dropped view to be gone")
+	}
+	if _, err := s.Execute("drop view if exists big"); err != nil {
+		t.Errorf("drop view if exists failed, %s", err.Error())
+	}
+}
+
+func TestMaterializedView(t *testing.T) {
+	s, dir := makeViewTestSession(t)
+	if _, err := s.Execute("create materialized view totals as select customer, sum(amount) as total from orders group by customer"); err != nil {
+		t.Fatalf("create materialized view failed, %s", err.Error())
+	}
+	checkAlterQuery(t, s, "select customer, total from totals order by customer",
+		[]DBValue{IntField{10}, IntField{12}}, []DBValue{IntField{20}, IntField{1}})
+
+	// the view keeps its rows until it is refreshed
+	if _, err := s.Execute("insert into orders values (4, 20, 9)"); err != nil {
+		t.Fatalf("insert failed, %s", err.Error())
+	}
+	checkAlterQuery(t, s, "select total from totals where customer = 20", []DBValue{IntField{1}})
+	if _, err := s.Execute("refresh materialized view totals"); err != nil {
+		t.Fatalf("refresh failed, %s", err.Error())
+	}
+	checkAlterQuery(t, s, "select total from totals where customer = 20", []DBValue{IntField{10}})
+
+	for _, q := range []string{
+		"insert into totals values (30, 3)",
+		"delete from totals",
+		"drop table totals",
+		"drop view totals",
+		"refresh materialized view orders",
+		"alter table totals add column x int",
+	} {
+		if _, err := s.Execute(q); err == nil {
+			t.Errorf("expected %s to fail", q)
+		}
+	}
+
+	// views survive reloading the catalog
+	if _, err := s.Execute("create view small as select id from orders where amount < 5"); err != nil {
+		t.Fatalf("create view failed, %s", err.Error())
+	}
+	s.bp.FlushAllPages()
+	bp, _ := NewBufferPool(100)
+	c, err := NewCatalogFromFile("catalog.txt", bp, dir)
+	if err != nil {
+		t.Fatalf("failed to reload catalog, %s", err.Error())
+	}
+	s = NewSession(c, bp)
+	checkAlterQuery(t, s, "select total from totals where customer = 10", []DBValue{IntField{12}})
+	checkAlterQuery(t, s, "select id from small", []DBValue{IntField{3}})
+	checkAlterQuery(t, s, "select view_name, materialized from godb_views",
+		[]DBValue{StringField{"small"}, IntField{0}}, []DBValue{StringField{"totals"}, IntField{1}})
+	if _, err := s.Execute("drop table orders"); err == nil {
+		t.Errorf("expected dropping a table a materialized view depends on to fail")
+	}
+	if _, err := s.Execute("drop materialized view totals"); err != nil {
+		t.Fatalf("drop materialized view failed, %s", err.Error())
+	}
+}
@@ -0,0 +1,438 @@
+package godb
+
+// Window functions: calls such as "rank() OVER (PARTITION BY dept ORDER BY
+// salary DESC)" in the select list, computed by the [Window] operator.
+//
+// sqlparser does not know OVER, so before a query is parsed
+// [rewriteWindowSyntax] turns each such call into a call of the function
+// godb_window whose arguments are the window function call and the text of
+// its window specification, e.g. "`godb_window`(rank(), 'partition by dept
+// order by salary desc')".  [parseWindowExpr] parses the specification
+// separately.
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/xwb1989/sqlparser"
+)
+
+// The name of the function that rewriteWindowSyntax wraps window function
+// calls in.
+const windowFuncName = "godb_window"
+
+// The window functions other than aggregates, with the least and greatest
+// number of arguments they take.  Any aggregate may also be used as a window
+// function.
+var windowFuncs = map[string]struct{ minArgs, maxArgs int }{
+	"row_number":  {0, 0},
+	"rank":        {0, 0},
+	"dense_rank":  {0, 0},
+	"lag":         {1, 3},
+	"lead":        {1, 3},
+	"first_value": {1, 1},
+	"last_value":  {1, 1},
+}
+
+var overRegexp = regexp.MustCompile(`(?i)^over\s*\(`)
+
+var windowSpecEscaper = strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+
+// Rewrite the window function calls outside of quoted strings in query into
+// a form that sqlparser accepts.
+func rewriteWindowSyntax(query string) string {
+	var out []byte
+	var quote byte
+	// the positions in out of the open parentheses that are not yet closed
+	var opens []int
+	// the positions in out of the last closed parenthesized expression
+	callStart, callEnd := -1, -1
+	for i := 0; i < len(query); i++ {
+		ch := query[i]
+		if quote != 0 {
+			if ch == '\\' && quote == '\'' && i+1 < len(query) {
+				out = append(out, ch, query[i+1])
+				i++
+				continue
+			}
+			if ch == quote {
+				quote = 0
+			}
+			out = append(out, ch)
+			continue
+		}
+		switch {
+		case ch == '\'' || ch == '"' || ch == '`':
+			quote = ch
+		case ch == '(':
+			opens = append(opens, len(out))
+		case ch == ')' && len(opens) > 0:
+			callStart, callEnd = opens[len(opens)-1], len(out)+1
+			opens = opens[:len(opens)-1]
+		case (ch == 'o' || ch == 'O') && (i == 0 || !isIdentByte(query[i-1])) && len(strings.TrimRight(string(out), " \t\r\n")) == callEnd:
+			m := overRegexp.FindString(query[i:])
+			nameStart := callStart
+			for nameStart > 0 && (isIdentByte(out[nameStart-1]) || out[nameStart-1] == '`') {
+				nameStart--
+			}
+			if m == "" || nameStart == callStart {
+				break
+			}
+			specEnd := closingParen(query, i+len(m))
+			spec := query[i+len(m) : specEnd]
+			call := string(out[nameStart:callEnd])
+			out = append(out[:nameStart], "`"+windowFuncName+"`("+call+", '"+windowSpecEscaper.Replace(spec)+"')"...)
+			callStart, callEnd = -1, -1
+			i = specEnd
+			continue
+		}
+		out = append(out, ch)
+	}
+	return string(out)
+}
+
+// Return the position of the parenthesis that closes the one before start,
+// or the length of s if there is none.
+func closingParen(s string, start int) int {
+	depth := 1
+	var quote byte
+	for i := start; i < len(s); i++ {
+		ch := s[i]
+		switch {
+		case quote != 0:
+			if ch == '\\' && quote == '\'' {
+				i++
+			} else if ch == quote {
+				quote = 0
+			}
+		case ch == '\'' || ch == '"' || ch == '`':
+			quote = ch
+		case ch == '(':
+			depth++
+		case ch == ')':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return len(s)
+}
+
+type boundKind int
+
+const (
+	unboundedPreceding boundKind = iota
+	offsetPreceding
+	currentRow
+	offsetFollowing
+	unboundedFollowing
+)
+
+// One end of a window frame.
+type frameBound struct {
+	kind   boundKind
+	offset int // the number of rows, for offsetPreceding and offsetFollowing
+}
+
+// The rows of its partition that a window function is computed over, for
+// each row.
+type windowFrame struct {
+	// if false (RANGE), a bound at the current row includes its peers, the
+	// rows with the same ORDER BY values
+	rows       bool
+	start, end frameBound
+}
+
+// Return the first and last rows of the frame of row i of a partition of n
+// rows, where the peers of row i are rows peerStart[i] to peerEnd[i]-1.  The
+// frame is empty if the first is after the last.
+func (f windowFrame) bounds(i, n int, peerStart, peerEnd []int) (int, int) {
+	position := func(b frameBound, end bool) int {
+		switch b.kind {
+		case unboundedPreceding:
+			return 0
+		case offsetPreceding:
+			return i - b.offset
+		case offsetFollowing:
+			return i + b.offset
+		case unboundedFollowing:
+			return n - 1
+		}
+		switch {
+		case f.rows:
+			return i
+		case end:
+			return peerEnd[i] - 1
+		}
+		return peerStart[i]
+	}
+	return max(position(f.start, false), 0), min(position(f.end, true), n-1)
+}
+
+func (b frameBound) String() string {
+	switch b.kind {
+	case unboundedPreceding:
+		return "UNBOUNDED PRECEDING"
+	case offsetPreceding:
+		return fmt.Sprintf("%d PRECEDING", b.offset)
+	case offsetFollowing:
+		return fmt.Sprintf("%d FOLLOWING", b.offset)
+	case unboundedFollowing:
+		return "UNBOUNDED FOLLOWING"
+	}
+	return "CURRENT ROW"
+}
+
+func (f windowFrame) String() string {
+	unit := "RANGE"
+	if f.rows {
+		unit = "ROWS"
+	}
+	return fmt.Sprintf("%s BETWEEN %v AND %v", unit, f.start, f.end)
+}
+
+// The PARTITION BY, ORDER BY and frame clauses of a window function call.
+type windowSpec struct {
+	partitionBy []*LogicalSelectNode
+	orderBy     []*OrderByNode
+	frame       windowFrame
+}
+
+const frameBoundPattern = `(unbounded\s+preceding|unbounded\s+following|current\s+row|\d+\s+preceding|\d+\s+following)`
+
+var frameRegexp = regexp.MustCompile(`(?is)(?:^|\s)(rows|range)\s+(?:between\s+` + frameBoundPattern + `\s+and\s+` + frameBoundPattern + `|` + frameBoundPattern + `)\s*$`)
+
+var partitionByRegexp = regexp.MustCompile(`(?is)^\s*partition\s+by\s`)
+
+// Parse a frame bound matched by frameBoundPattern.
+func parseFrameBound(s string) frameBound {
+	fields := strings.Fields(strings.ToLower(s))
+	switch {
+	case fields[0] == "current":
+		return frameBound{kind: currentRow}
+	case fields[0] == "unbounded" && fields[1] == "preceding":
+		return frameBound{kind: unboundedPreceding}
+	case fields[0] == "unbounded":
+		return frameBound{kind: unboundedFollowing}
+	}
+	n, _ := strconv.Atoi(fields[0])
+	if fields[1] == "preceding" {
+		return frameBound{offsetPreceding, n}
+	}
+	return frameBound{offsetFollowing, n}
+}
+
+// Parse the text of a window specification, e.g. "partition by a order by b
+// rows between 1 preceding and current row".
+func parseWindowSpec(c *Catalog, text string) (*windowSpec, error) {
+	spec := &windowSpec{}
+	// by default the frame is the whole partition if it is not ordered, and
+	// otherwise the rows up to the current row and its peers
+	spec.frame = windowFrame{false, frameBound{kind: unboundedPreceding}, frameBound{kind: unboundedFollowing}}
+	m := frameRegexp.FindStringSubmatchIndex(text)
+	if m != nil {
+		sub := func(i int) string {
+			if m[2*i] < 0 {
+				return ""
+			}
+			return text[m[2*i]:m[2*i+1]]
+		}
+		spec.frame.rows = strings.EqualFold(sub(1), "rows")
+		if sub(4) != "" {
+			spec.frame.start, spec.frame.end = parseFrameBound(sub(4)), frameBound{kind: currentRow}
+		} else {
+			spec.frame.start, spec.frame.end = parseFrameBound(sub(2)), parseFrameBound(sub(3))
+		}
+		text = text[:m[0]]
+	}
+
+	query := "select 1 from dual " + partitionByRegexp.ReplaceAllString(text, "group by ")
+	stmt, err := parseSQL(query)
+	if err != nil {
+		return nil, GoDBError{ParseError, fmt.Sprintf("invalid window specification %q", text)}
+	}
+	sel, ok := stmt.(*sqlparser.Select)
+	if !ok || sel.Where != nil || sel.Having != nil || sel.Limit != nil || sel.Lock != "" {
+		return nil, GoDBError{ParseError, fmt.Sprintf("invalid window specification %q", text)}
+	}
+	for _, e := range sel.GroupBy {
+		expr, err := parseExpr(c, e, "")
+		if err != nil {
+			return nil, err
+		}
+		spec.partitionBy = append(spec.partitionBy, expr)
+	}
+	for _, o := range sel.OrderBy {
+		expr, err := parseExpr(c, o.Expr, "")
+		if err != nil {
+			return nil, err
+		}
+		spec.orderBy = append(spec.orderBy, &OrderByNode{expr, o.Direction == sqlparser.AscScr})
+	}
+	if m == nil && len(spec.orderBy) > 0 {
+		spec.frame.end = frameBound{kind: currentRow}
+	}
+
+	start, end := spec.frame.start.kind, spec.frame.end.kind
+	switch {
+	case start == unboundedFollowing:
+		return nil, GoDBError{ParseError, "frame start cannot be UNBOUNDED FOLLOWING"}
+	case end == unboundedPreceding:
+		return nil, GoDBError{ParseError, "frame end cannot be UNBOUNDED PRECEDING"}
+	case start > end:
+		return nil, GoDBError{ParseError, fmt.Sprintf("frame cannot start at %v and end at %v", spec.frame.start, spec.frame.end)}
+	case !spec.frame.rows && (start == offsetPreceding || start == offsetFollowing || end == offsetPreceding || end == offsetFollowing):
+		return nil, GoDBError{ParseError, "RANGE frames with offsets are not supported, use ROWS"}
+	}
+	return spec, nil
+}
+
+func NewWindowSelectNode(op string, args []*LogicalSelectNode, window *windowSpec, alias string) LogicalSelectNode {
+	lsn := LogicalSelectNode{}
+	lsn.exprType = ExprWindow
+	lsn.funcOp = &op
+	lsn.field = op
+	lsn.alias = alias
+	lsn.args = args
+	lsn.window = window
+	return lsn
+}
+
+// Parse a call of a window function, as rewritten by rewriteWindowSyntax.
+func parseWindowExpr(c *Catalog, expr *sqlparser.FuncExpr, alias string) (*LogicalSelectNode, error) {
+	var call *sqlparser.FuncExpr
+	var specText *sqlparser.SQLVal
+	if len(expr.Exprs) == 2 {
+		e0, ok0 := expr.Exprs[0].(*sqlparser.AliasedExpr)
+		e1, ok1 := expr.Exprs[1].(*sqlparser.AliasedExpr)
+		if ok0 && ok1 {
+			call, _ = e0.Expr.(*sqlparser.FuncExpr)
+			specText, _ = e1.Expr.(*sqlparser.SQLVal)
+		}
+	}
+	if call == nil || specText == nil || specText.Type != sqlparser.StrVal {
+		return nil, GoDBError{ParseError, fmt.Sprintf("invalid window function call %s", sqlparser.String(expr))}
+	}
+
+	name := strings.ToLower(call.Name.String())
+	arity, isWindowFunc := windowFuncs[name]
+	if !isWindowFunc && !isAgg(name) {
+		return nil, GoDBError{ParseError, fmt.Sprintf("%s is not a window function or aggregate", name)}
+	}
+	if !isWindowFunc {
+		arity.minArgs, arity.maxArgs = 1, 1
+	}
+	if call.Distinct {
+		return nil, GoDBError{ParseError, fmt.Sprintf("DISTINCT is not supported in window function %s", name)}
+	}
+	if len(call.Exprs) < arity.minArgs || len(call.Exprs) > arity.maxArgs {
+		return nil, GoDBError{ParseError, fmt.Sprintf("window function %s expected %d to %d args, got %d", name, arity.minArgs, arity.maxArgs, len(call.Exprs))}
+	}
+	var args []*LogicalSelectNode
+	for _, e := range call.Exprs {
+		if star, ok := e.(*sqlparser.StarExpr); ok {
+			if name != "count" {
+				return nil, GoDBError{ParseError, fmt.Sprintf("got * in window function %s", name)}
+			}
+			field := NewFieldSelectNode(strings.ToLower(sqlparser.String(star.TableName)), "*", "")
+			args = append(args, &field)
+			continue
+		}
+		arg, err := parseSelect(c, e)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+
+	spec, err := parseWindowSpec(c, string(specText.Val))
+	if err != nil {
+		return nil, err
+	}
+	node := NewWindowSelectNode(name, args, spec, alias)
+	return &node, nil
+}
+
+// Return the window function calls in the select list.
+func extractWindows(selects []*LogicalSelectNode) []*LogicalSelectNode {
+	var windows []*LogicalSelectNode
+	for _, s := range selects {
+		switch s.exprType {
+		case ExprWindow:
+			windows = append(windows, s)
+		case ExprFunc:
+			windows = append(windows, extractWindows(s.args)...)
+		}
+	}
+	return windows
+}
+
+// Make the operator that computes the window function calls in windows over
+// the tuples of child, and record the field of each in its cachedField.
+func planWindows(c *Catalog, windows []*LogicalSelectNode, child Operator, tableMap map[string]*PlanNode) (*Window, error) {
+	desc := child.Descriptor()
+	generate := func(s *LogicalSelectNode) (Expr, error) {
+		e, _, err := s.generateExpr(c, desc, tableMap)
+		return e, err
+	}
+	funcs := make([]*windowFunc, len(windows))
+	for i, w := range windows {
+		f := &windowFunc{name: *w.funcOp, frame: w.window.frame}
+		for _, arg := range w.args {
+			if arg.exprType == ExprField && arg.field == "*" {
+				// count(*) counts every row
+				f.args = append(f.args, &ConstExpr{IntField{1}, IntType})
+				continue
+			}
+			e, err := generate(arg)
+			if err != nil {
+				return nil, err
+			}
+			f.args = append(f.args, e)
+		}
+		for _, p := range w.window.partitionBy {
+			e, err := generate(p)
+			if err != nil {
+				return nil, err
+			}
+			f.partitionBy = append(f.partitionBy, e)
+		}
+		for _, o := range w.window.orderBy {
+			e, err := generate(o.expr)
+			if err != nil {
+				return nil, err
+			}
+			f.orderBy = append(f.orderBy, e)
+			f.ascending = append(f.ascending, o.ascending)
+		}
+
+		// name the field uniquely, as it is added to the fields of child
+		f.field = FieldType{fmt.Sprintf("%s#%d", f.name, i), "", IntType}
+		switch f.name {
+		case "row_number", "rank", "dense_rank":
+		case "lag", "lead", "first_value", "last_value":
+			f.field.Ftype = f.args[0].GetExprType().Ftype
+			if len(f.args) > 2 {
+				f.field.Ftype = commonType(f.field.Ftype, f.args[2].GetExprType().Ftype)
+			}
+		default:
+			newState := lookupAggregate(f.name)
+			if newState == nil {
+				return nil, GoDBError{IllegalOperationError, fmt.Sprintf("unknown aggregate function %s", f.name)}
+			}
+			if f.agg = newState(); f.agg == nil {
+				return nil, GoDBError{IllegalOperationError, fmt.Sprintf("aggregate function %s returned no aggregation state", f.name)}
+			}
+			if err := f.agg.Init(f.field.Fname, f.args[0]); err != nil {
+				return nil, err
+			}
+			f.field.Ftype = f.agg.GetTupleDesc().Fields[0].Ftype
+		}
+		funcs[i] = f
+		w.cachedField = &f.field
+	}
+	return newWindow(funcs, child), nil
+}
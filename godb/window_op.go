@@ -0,0 +1,291 @@
+package godb
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A Window computes window functions.  Each tuple of its child is returned
+// with the value of each function appended, computed over the tuple's
+// partition: the tuples with the same PARTITION BY values, sorted by the
+// function's ORDER BY expressions.
+//
+// Like [OrderBy], a Window is blocking: it reads all of its child's tuples
+// before returning any, and sorts them with a [multiSorter] for each function.
+// The tuples are returned in the order of the last sort.
+type Window struct {
+	funcs []*windowFunc
+	child Operator
+	desc  *TupleDesc
+}
+
+// A window function call.
+type windowFunc struct {
+	name        string // a window function or an aggregate
+	args        []Expr
+	partitionBy []Expr
+	orderBy     []Expr
+	ascending   []bool
+	frame       windowFrame
+	// for aggregates, an initialized state that is copied for each frame
+	agg AggState
+	// the field of the function's value in the output tuples
+	field FieldType
+}
+
+func newWindow(funcs []*windowFunc, child Operator) *Window {
+	desc := child.Descriptor().copy()
+	for _, f := range funcs {
+		desc.Fields = append(desc.Fields, f.field)
+	}
+	return &Window{funcs, child, desc}
+}
+
+func (w *Window) Descriptor() *TupleDesc {
+	return w.desc
+}
+
+// Return all of the expressions of the function.
+func (f *windowFunc) exprs() []Expr {
+	exprs := append(append([]Expr(nil), f.args...), f.partitionBy...)
+	return append(exprs, f.orderBy...)
+}
+
+func (f *windowFunc) String() string {
+	args := make([]string, len(f.args))
+	for i, a := range f.args {
+		args[i] = exprToStr(a)
+	}
+	var spec []string
+	if len(f.partitionBy) > 0 {
+		parts := make([]string, len(f.partitionBy))
+		for i, p := range f.partitionBy {
+			parts[i] = exprToStr(p)
+		}
+		spec = append(spec, "PARTITION BY "+strings.Join(parts, ", "))
+	}
+	if len(f.orderBy) > 0 {
+		orders := make([]string, len(f.orderBy))
+		for i, o := range f.orderBy {
+			orders[i] = exprToStr(o)
+			if !f.ascending[i] {
+				orders[i] += " DESC"
+			}
+		}
+		spec = append(spec, "ORDER BY "+strings.Join(orders, ", "))
+	}
+	spec = append(spec, f.frame.String())
+	return fmt.Sprintf("%s(%s) OVER (%s)", f.name, strings.Join(args, ","), strings.Join(spec, " "))
+}
+
+func (w *Window) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	childIter, err := planIterator(w.child, tid)
+	if err != nil {
+		return nil, err
+	}
+	var tuples []Tuple
+	mem := newMemoryReservation(tid)
+	for {
+		if err := checkCancelled(tid); err != nil {
+			return nil, err
+		}
+		t, err := childIter()
+		if err != nil {
+			return nil, err
+		}
+		if t == nil {
+			break
+		}
+		fields := make([]DBValue, len(w.desc.Fields))
+		copy(fields, t.Fields)
+		tuples = append(tuples, Tuple{*w.desc, fields, t.Rid})
+		// the window functions' values are not known yet, so are counted
+		// as integers
+		if err := mem.grow(tuples[len(tuples)-1].memorySize() + int64((valueOverhead+intSize)*len(w.funcs))); err != nil {
+			return nil, err
+		}
+	}
+
+	for i, f := range w.funcs {
+		var sortFuncs []lessFunc
+		for _, p := range f.partitionBy {
+			sortFuncs = append(sortFuncs, exprLessFunc(p, true))
+		}
+		for j, o := range f.orderBy {
+			sortFuncs = append(sortFuncs, exprLessFunc(o, f.ascending[j]))
+		}
+		if len(sortFuncs) > 0 {
+			OrderedBy(sortFuncs...).Sort(tuples)
+		}
+		col := len(w.desc.Fields) - len(w.funcs) + i
+		for lo := 0; lo < len(tuples); {
+			hi := lo + 1
+			for hi < len(tuples) && equalExprs(&tuples[lo], &tuples[hi], f.partitionBy) {
+				hi++
+			}
+			if err := f.computePartition(tuples[lo:hi], col); err != nil {
+				return nil, err
+			}
+			lo = hi
+		}
+	}
+
+	i := 0
+	return func() (*Tuple, error) {
+		if i >= len(tuples) {
+			mem.release()
+			return nil, nil
+		}
+		i++
+		return &tuples[i-1], nil
+	}, nil
+}
+
+// Return true if the expressions have the same values for both tuples.
+func equalExprs(t1, t2 *Tuple, exprs []Expr) bool {
+	for _, e := range exprs {
+		if cmp, err := t1.compareField(t2, e); err != nil || cmp != OrderedEqual {
+			return false
+		}
+	}
+	return true
+}
+
+// Compute the function for each of the (sorted) rows of a partition, storing
+// its value in field col of the row.
+func (f *windowFunc) computePartition(rows []Tuple, col int) error {
+	n := len(rows)
+	// rows peerStart[i] to peerEnd[i]-1 are the peers of row i
+	peerStart, peerEnd := make([]int, n), make([]int, n)
+	for i := 0; i < n; {
+		j := i + 1
+		for j < n && equalExprs(&rows[i], &rows[j], f.orderBy) {
+			j++
+		}
+		for k := i; k < j; k++ {
+			peerStart[k], peerEnd[k] = i, j
+		}
+		i = j
+	}
+
+	switch f.name {
+	case "row_number":
+		for i := range rows {
+			rows[i].Fields[col] = IntField{int64(i + 1)}
+		}
+	case "rank":
+		for i := range rows {
+			rows[i].Fields[col] = IntField{int64(peerStart[i] + 1)}
+		}
+	case "dense_rank":
+		rank := 0
+		for i := range rows {
+			if peerStart[i] == i {
+				rank++
+			}
+			rows[i].Fields[col] = IntField{int64(rank)}
+		}
+	case "lag", "lead":
+		for i := range rows {
+			offset := int64(1)
+			if len(f.args) > 1 {
+				v, err := f.args[1].EvalExpr(&rows[i])
+				if err != nil {
+					return err
+				}
+				o, ok := v.(IntField)
+				if !ok {
+					return GoDBError{TypeMismatchError, fmt.Sprintf("the offset of %s must be an int", f.name)}
+				}
+				offset = o.Value
+			}
+			if f.name == "lag" {
+				offset = -offset
+			}
+			var v DBValue
+			var err error
+			if j := int64(i) + offset; j >= 0 && j < int64(n) {
+				v, err = f.args[0].EvalExpr(&rows[j])
+			} else if len(f.args) > 2 {
+				v, err = f.args[2].EvalExpr(&rows[i])
+			}
+			if err != nil {
+				return err
+			}
+			rows[i].Fields[col] = convertValue(v, f.field.Ftype)
+		}
+	case "first_value", "last_value":
+		for i := range rows {
+			start, end := f.frame.bounds(i, n, peerStart, peerEnd)
+			if start > end {
+				rows[i].Fields[col] = nil
+				continue
+			}
+			row := start
+			if f.name == "last_value" {
+				row = end
+			}
+			v, err := f.args[0].EvalExpr(&rows[row])
+			if err != nil {
+				return err
+			}
+			rows[i].Fields[col] = v
+		}
+	default:
+		f.computeAggregate(rows, col, peerStart, peerEnd)
+	}
+	return nil
+}
+
+// Compute an aggregate over the frame of each row of a partition.  Frames
+// that start at the start of the partition only grow from row to row, so the
+// same aggregation state is used for all of them; otherwise each frame is
+// aggregated separately.  Aggregation states are only finalized after adding
+// a tuple, so the aggregate of an empty frame is NULL, or 0 for count.
+func (f *windowFunc) computeAggregate(rows []Tuple, col int, peerStart, peerEnd []int) {
+	n := len(rows)
+	running := f.agg.Copy()
+	added := 0
+	for i := range rows {
+		start, end := f.frame.bounds(i, n, peerStart, peerEnd)
+		if start > end {
+			rows[i].Fields[col] = nil
+			if f.name == "count" {
+				rows[i].Fields[col] = IntField{0}
+			}
+			continue
+		}
+		state := running
+		if f.frame.start.kind == unboundedPreceding {
+			for ; added <= end; added++ {
+				running.AddTuple(&rows[added])
+			}
+		} else {
+			state = f.agg.Copy()
+			for j := start; j <= end; j++ {
+				state.AddTuple(&rows[j])
+			}
+		}
+		rows[i].Fields[col] = state.Finalize().Fields[0]
+	}
+}
+
+// Convert an int value to a string if t is StringType.
+func convertValue(v DBValue, t DBType) DBValue {
+	if i, ok := v.(IntField); ok && t == StringType {
+		return StringField{fmt.Sprint(i.Value)}
+	}
+	return v
+}
+
+func (w *Window) Explain() (string, string) {
+	funcStrs := make([]string, len(w.funcs))
+	for i, f := range w.funcs {
+		funcStrs[i] = f.String()
+	}
+	return "Window", strings.Join(funcStrs, ", ")
+}
+
+func (w *Window) Children() []Operator {
+	return []Operator{w.child}
+}
@@ -0,0 +1,129 @@
+package godb
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// Return the tuples as comma separated strings.
+func rowStrings(tuples []*Tuple) []string {
+	rows := make([]string, len(tuples))
+	for i, tup := range tuples {
+		rows[i] = tup.PrettyPrintString(false)
+	}
+	return rows
+}
+
+func TestRewriteWindowSyntax(t *testing.T) {
+	for _, c := range []struct{ query, want string }{
+		{"select rank() over (order by a) from t", "select `godb_window`(rank(), 'order by a') from t"},
+		{"select coalesce(lag(a, 1) OVER(partition by b), 0) from t", "select coalesce(`godb_window`(lag(a, 1), 'partition by b'), 0) from t"},
+		{"select sum(a) over (order by f(a, 'x''s')) from t", "select `godb_window`(sum(a), 'order by f(a, \\'x\\'\\'s\\')') from t"},
+		{"select (a) over from t", "select (a) over from t"},
+		{"select 'rank() over (x)', a as over from t", "select 'rank() over (x)', a as over from t"},
+	} {
+		if got := rewriteWindowSyntax(c.query); got != c.want {
+			t.Errorf("rewriting %q: expected %q, got %q", c.query, c.want, got)
+		}
+	}
+}
+
+func TestWindowFunctions(t *testing.T) {
+	s := makeBatchTestSession(t, 30)
+	// dept d has names e<d>, e<d+10> and e<d+20>; ei has salary 7i
+	for _, c := range []struct {
+		query string
+		want  []string
+	}{
+		{"select name, row_number() over (partition by dept order by salary desc), rank() over (order by dept), dense_rank() over (order by dept) from emp where dept < 2",
+			[]string{"e0,3,1,1", "e10,2,1,1", "e20,1,1,1", "e1,3,4,2", "e11,2,4,2", "e21,1,4,2"}},
+		{"select name, lag(salary) over (order by salary), lead(name, 2, 'none') over (order by salary), lag(salary, 3, -1) over (order by salary) from emp where dept = 4",
+			[]string{"e4,,e24,-1", "e14,28,none,-1", "e24,98,none,-1"}},
+		{"select name, first_value(name) over (partition by dept order by salary desc), last_value(name) over (partition by dept order by salary), last_value(salary) over (partition by dept order by salary rows between unbounded preceding and unbounded following) from emp where dept = 2",
+			[]string{"e2,e22,e2,154", "e12,e22,e12,154", "e22,e22,e22,154"}},
+		{"select name, sum(salary) over (order by salary), count(*) over (), max(salary) over (partition by dept), avg(salary) over (order by salary rows between 1 preceding and 1 following) from emp where dept < 2",
+			[]string{"e0,0,6,140,3", "e1,7,6,147,25", "e10,77,6,140,51", "e11,154,6,147,95", "e20,294,6,140,121", "e21,441,6,147,143"}},
+		{"select name, sum(salary) over (order by salary rows between 2 preceding and 1 preceding), count(name) over (order by salary rows between 3 preceding and 3 preceding), min(name) over (order by salary rows 1 preceding) from emp where dept = 5",
+			[]string{"e5,,0,e5", "e15,35,0,e15", "e25,140,0,e15"}},
+		{"select dept, sum(salary) as total, rank() over (order by sum(salary) desc) as r from emp where dept > 6 group by dept",
+			[]string{"7,357,3", "8,378,2", "9,399,1"}},
+		{"select name, 10 * row_number() over (order by name desc) as n from emp where dept = 3 order by n",
+			[]string{"e3,10", "e23,20", "e13,30"}},
+		// the rows with the same dept are peers
+		{"select sum(salary) over (order by dept) from emp where dept < 3 and name < 'e2'",
+			[]string{"70", "70", "154", "154", "238"}},
+	} {
+		res, err := s.Execute(c.query)
+		if err != nil {
+			t.Fatalf("%s failed, %s", c.query, err.Error())
+		}
+		got := rowStrings(res.Tuples)
+		if !strings.Contains(c.query, "order by n") {
+			// the rows are returned in no particular order
+			sort.Strings(got)
+			sort.Strings(c.want)
+		}
+		if strings.Join(got, ";") != strings.Join(c.want, ";") {
+			t.Errorf("%s: expected %v, got %v", c.query, c.want, got)
+		}
+	}
+
+	for _, q := range []string{
+		"select rank(name) over (order by name) from emp",
+		"select upper(name) over (order by name) from emp",
+		"select lag() over (order by name) from emp",
+		"select sum(salary) over (order by salary range between 1 preceding and current row) from emp",
+		"select sum(salary) over (order by salary rows between current row and 1 preceding) from emp",
+		"select sum(salary) over (order by salary rows unbounded following) from emp",
+		"select sum(salary) over (order by salary limit 1) from emp",
+		"select rank(*) over () from emp",
+		"select lag(name, name) over (order by name) from emp",
+		"select name from emp order by rank() over (order by name)",
+	} {
+		if _, err := s.Execute(q); err == nil {
+			t.Errorf("expected %s to fail", q)
+		}
+	}
+}
+
+func TestWindowPlans(t *testing.T) {
+	s := makeBatchTestSession(t, 200)
+	q := "select name, rank() over (partition by dept order by salary desc) as r from emp"
+	want := rowStrings(runVectorized(t, s, q, false))
+	for _, workers := range []int{1, 4} {
+		if _, err := s.Execute(fmt.Sprintf("set parallel_workers = %d", workers)); err != nil {
+			t.Fatalf("set failed, %s", err.Error())
+		}
+		got := rowStrings(runVectorized(t, s, q, true))
+		sort.Strings(got)
+		sort.Strings(want)
+		if strings.Join(got, ";") != strings.Join(want, ";") {
+			t.Errorf("with %d workers, expected the same ranks as without batches", workers)
+		}
+	}
+
+	_, plan, err := Parse(s.catalog, q)
+	if err != nil {
+		t.Fatalf("planning failed, %s", err.Error())
+	}
+	var out strings.Builder
+	OutputPhysicalPlan(func(format string, a ...any) { fmt.Fprintf(&out, format, a...) }, plan, "")
+	if wantStr := "Window rank() OVER (PARTITION BY emp.dept ORDER BY emp.salary DESC RANGE BETWEEN UNBOUNDED PRECEDING AND CURRENT ROW)"; !strings.Contains(out.String(), wantStr) {
+		t.Errorf("expected the plan to contain %q, got\n%s", wantStr, out.String())
+	}
+
+	s = makeBatchTestSession(t, 30)
+	stmt, err := s.Prepare("select name, lag(salary, $1, 0) over (order by salary) from emp where dept = 1 and name < 'e3'")
+	if err != nil {
+		t.Fatalf("prepare failed, %s", err.Error())
+	}
+	res, err := s.ExecuteStmt(stmt, IntField{2})
+	if err != nil {
+		t.Fatalf("execute failed, %s", err.Error())
+	}
+	if got := rowStrings(res.Tuples); strings.Join(got, ";") != "e1,0;e11,0;e21,7" {
+		t.Errorf("expected lag by 2, got %v", got)
+	}
+}
@@ -1,10 +1,15 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"net"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"syscall"
@@ -24,14 +29,64 @@ Available shell commands:
 	\a : Toggle aligned vs csv output
     \o : Toggle query optimization
 	\l table path/to/file [sep] [hasHeader]: Append csv file to end of table.  Default to sep = ',', hasHeader = 'true'
-	\z : Compute statistics for the database`
+	\z : Compute statistics for the database
+	\p n : Run queries on n worker goroutines`
 
 func printCatalog(c *godb.Catalog) {
 	s := c.CatalogString()
 	fmt.Printf("\033[34m%s\n\033[0m", s)
 }
 
+// Run GoDB as a network server speaking the postgres wire protocol, e.g.:
+//
+//	godb serve -addr localhost:5432 -catalog godb/catalog.txt
+func serve(args []string) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := flags.String("addr", "localhost:5432", "address to listen on")
+	catalog := flags.String("catalog", "godb/catalog.txt", "path to the catalog file")
+	maxMemory := flags.Int64("max_memory", 0, "megabytes of memory all queries together may use, or 0 for no limit")
+	copyDir := flags.String("copy_dir", "", "directory clients may COPY files to and from; by default they may not")
+	flags.Parse(args)
+	if err := godb.SetGlobalMemoryLimit(*maxMemory << 20); err != nil {
+		log.Fatal(err.Error())
+	}
+
+	bp, err := godb.NewBufferPool(10000)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	catName := filepath.Base(*catalog)
+	catPath := filepath.Dir(*catalog)
+	c, err := godb.NewCatalogFromFile(catName, bp, catPath)
+	if err != nil {
+		log.Fatalf("failed load catalog, %s", err.Error())
+	}
+
+	srv := godb.NewServer(c, bp)
+	if *copyDir != "" {
+		if err := srv.SetCopyDirectory(*copyDir); err != nil {
+			log.Fatalf("bad copy directory, %s", err.Error())
+		}
+	}
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+		srv.Close()
+	}()
+	log.Printf("GoDB listening on %s", *addr)
+	if err := srv.ListenAndServe(*addr); err != nil && !errors.Is(err, net.ErrClosed) {
+		log.Fatal(err.Error())
+	}
+	bp.FlushAllPages()
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "serve" {
+		serve(os.Args[2:])
+		return
+	}
+
 	alarm := make(chan int, 1)
 
 	go func() {
@@ -85,8 +140,10 @@ func main() {
 [35;1mType \h for help`)
 	fmt.Printf("\033[0m\n")
 	query := ""
-	var autocommit bool = true
-	var tid godb.TransactionID
+	// statements run in a session, which keeps the open transaction and the
+	// settings made with SET
+	session := godb.NewSession(c, bp)
+	defer func() { session.Close() }()
 	aligned := true
 	for {
 		text, err := rl.Readline()
@@ -115,6 +172,8 @@ func main() {
 					fmt.Printf("failed load catalog, %s\n", err.Error())
 					continue
 				}
+				session.Close()
+				session = godb.NewSession(c, bp)
 				fmt.Printf("Loaded %s/%s\n", catPath, catName)
 				printCatalog(c)
 			case 'f':
@@ -134,6 +193,13 @@ func main() {
 				} else {
 					fmt.Println("\033[32;1mOptimization disabled\033[0m\n\n")
 				}
+			case 'p':
+				n, err := strconv.Atoi(strings.TrimSpace(text[2:]))
+				if err != nil || session.SetParallelWorkers(n) != nil {
+					fmt.Printf("\033[31;1mExpected a number of workers after \\p\033[0m\n")
+					continue
+				}
+				fmt.Printf("\033[32;1mRunning queries on %d workers\033[0m\n\n", n)
 			case 'z':
 				c.ComputeTableStats()
 				fmt.Printf("\033[32;1mAnalysis Complete\033[0m\n\n")
@@ -154,25 +220,18 @@ func main() {
 					hasHeader = splits[4] != "false"
 				}
 
-				//todo -- following code assumes data is in heap files
-				hf, err := c.GetTable(table)
-				if err != nil {
-					fmt.Printf("\033[31;1m%s\033[0m\n", err.Error())
-					continue
-				}
-				heapFile := hf.(*godb.HeapFile)
 				f, err := os.Open(path)
 				if err != nil {
 					fmt.Printf("\033[31;1m%s\033[0m\n", err.Error())
 					continue
 				}
-				err = heapFile.LoadFromCSV(f, hasHeader, sep, false)
+				res, err := c.BulkLoad(table, f, godb.BulkLoadOptions{HasHeader: hasHeader, Separator: sep})
+				f.Close()
 				if err != nil {
 					fmt.Printf("\033[31;1m%s\033[0m\n", err.Error())
 					continue
 				}
-				bp.FlushAllPages() //gross, if in a transaction, but oh well!
-				fmt.Printf("\033[32;1mLOAD\033[0m\n\n")
+				fmt.Printf("\033[32;1mLOAD %d\033[0m\n\n", res.Rows)
 			}
 
 			query = ""
@@ -183,17 +242,28 @@ func main() {
 			continue
 		}
 		query = strings.TrimSpace(query + " " + text[0:len(text)-1])
+		start := time.Now()
 
-		explain := false
-		if strings.HasPrefix(strings.ToLower(query), "explain") {
-			queryParts := strings.Split(query, " ")
-			query = strings.Join(queryParts[1:], " ")
-			explain = true
+		// ^C cancels the statement, even while an operator such as an ORDER
+		// BY is reading its whole input
+		select {
+		case <-alarm:
+		default:
 		}
-
-		queryType, plan, err := godb.Parse(c, query)
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			select {
+			case <-alarm:
+				fmt.Println("Aborting")
+				cancel()
+			case <-done:
+			}
+		}()
+		res, err := session.ExecuteContext(ctx, query)
+		close(done)
+		cancel()
 		query = ""
-		nresults := 0
 
 		if err != nil {
 			errStr := err.Error()
@@ -214,108 +284,26 @@ func main() {
 				}
 			}
 			fmt.Printf("\033[31;1mInvalid query (%s)\033[0m\n", err.Error())
+			if session.TransactionFailed() {
+				fmt.Printf("\033[31;1mThe transaction has failed; end it with COMMIT or ROLLBACK\033[0m\n")
+			}
 			continue
 		}
 
-		switch queryType {
-		case godb.UnknownQueryType:
-			fmt.Printf("\033[31;1mUnknown query type\033[0m\n")
-			continue
-
-		case godb.IteratorType:
-			if explain {
-				fmt.Printf("\033[32m")
-				godb.PrintPhysicalPlan(plan, "")
-				fmt.Printf("\033[0m\n")
-				break
-			}
-			if autocommit {
-				tid = godb.NewTID()
-				err := bp.BeginTransaction(tid)
-				if err != nil {
-					fmt.Printf("\033[31;1m%s\033[0m\n", err.Error())
-					continue
-				}
-			}
-			start := time.Now()
-
-			iter, err := plan.Iterator(tid)
-			if err != nil {
-				fmt.Printf("\033[31;1m%s\033[0m\n", err.Error())
-				continue
-			}
-
-			fmt.Printf("\033[32;4m%s\033[0m\n", plan.Descriptor().HeaderString(aligned))
-
-			for {
-				tup, err := iter()
-				if err != nil {
-					fmt.Printf("%s\n", err.Error())
-					break
-				}
-				if tup == nil {
-					break
-				} else {
-					fmt.Printf("\033[32m%s\033[0m\n", tup.PrettyPrintString(aligned))
-				}
-				nresults++
-				select {
-				case <-alarm:
-					fmt.Println("Aborting")
-					goto outer
-				default:
-				}
-			}
-			if autocommit {
-				bp.CommitTransaction(tid)
-			}
-		outer:
-			fmt.Printf("\033[32;1m(%d results)\033[0m\n", nresults)
-			duration := time.Since(start)
-			fmt.Printf("\033[32;1m%v\033[0m\n\n", duration)
-
-		case godb.BeginXactionType:
-			if !autocommit {
-				fmt.Printf("\033[31;1m%s\033[0m\n", "Cannot start transaction while in transaction")
-				continue
-			}
-			tid = godb.NewTID()
-			err := bp.BeginTransaction(tid)
-			if err != nil {
-				fmt.Printf("\033[31;1m%s\033[0m\n", err.Error())
-				continue
-			}
-			autocommit = false
-			fmt.Printf("\033[32;1mBEGIN\033[0m\n\n")
-
-		case godb.AbortXactionType:
-			if autocommit {
-				fmt.Printf("\033[31;1m%s\033[0m\n", "Cannot abort transaction unless in transaction")
-				continue
-			}
-			bp.AbortTransaction(tid)
-			autocommit = true
-			fmt.Printf("\033[32;1mABORT\033[0m\n\n")
-		case godb.CommitXactionType:
-			if autocommit {
-				fmt.Printf("\033[31;1m%s\033[0m\n", "Cannot commit transaction unless in transaction")
-				continue
-			}
-			bp.CommitTransaction(tid)
-			autocommit = true
-			fmt.Printf("\033[32;1mCOMMIT\033[0m\n\n")
-		case godb.CreateTableQueryType:
-			fmt.Printf("\033[32;1mCREATE\033[0m\n\n")
-			err := c.SaveToFile(catName, catPath)
-			if err != nil {
-				fmt.Printf("\033[31;1m%s\033[0m\n", err.Error())
+		switch {
+		case res.Type == godb.ExplainQueryType:
+			for _, tup := range res.Tuples {
+				fmt.Printf("\033[32m%s\033[0m\n", tup.Fields[0].(godb.StringField).Value)
 			}
-		case godb.DropTableQueryType:
-			fmt.Printf("\033[32;1mDROP\033[0m\n\n")
-			err := c.SaveToFile(catName, catPath)
-			if err != nil {
-				fmt.Printf("\033[31;1m%s\033[0m\n", err.Error())
+		case res.Desc != nil:
+			fmt.Printf("\033[32;4m%s\033[0m\n", res.Desc.HeaderString(aligned))
+			for _, tup := range res.Tuples {
+				fmt.Printf("\033[32m%s\033[0m\n", tup.PrettyPrintString(aligned))
 			}
+			fmt.Printf("\033[32;1m(%d results)\033[0m\n", len(res.Tuples))
+			fmt.Printf("\033[32;1m%v\033[0m\n\n", time.Since(start))
+		default:
+			fmt.Printf("\033[32;1m%s\033[0m\n\n", res.Tag)
 		}
 	}
 }